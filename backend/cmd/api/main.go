@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 
@@ -20,9 +21,49 @@ func main() {
 	store := gcp.NewMemoryStore()
 	defer store.Close()
 
-	runtime := agent.NewRuntime(cfg.GeminiModel, store)
+	client, err := agent.NewModelClient(context.Background(), cfg.ModelProvider, agent.GeminiConfig{
+		APIKey:          cfg.GoogleAPIKey,
+		Project:         cfg.ProjectID,
+		Location:        cfg.Region,
+		BaseURL:         cfg.GeminiBaseURL,
+		MaxOutputTokens: cfg.MaxOutputTokens,
+	})
+	if err != nil {
+		log.Fatalf("model client: %v", err)
+	}
+	providers := []agent.RouteProvider{{Name: cfg.ModelProvider, Client: client, Timeout: cfg.ModelTimeout}}
+	if cfg.FallbackModel != "" {
+		providers = append(providers, agent.RouteProvider{Name: cfg.ModelProvider + "-fallback", Client: client, Model: cfg.FallbackModel, Timeout: cfg.ModelTimeout})
+	}
+	if cfg.ModelProvider != agent.ModelProviderTemplate {
+		// The template responder answers from menu data when every model fails.
+		providers = append(providers, agent.RouteProvider{Name: agent.ModelProviderTemplate, Client: agent.NewTemplateResponder()})
+	}
+	router, err := agent.NewRouterClient(providers, agent.RouterConfig{MaxRetries: cfg.ModelRetries})
+	if err != nil {
+		log.Fatalf("model router: %v", err)
+	}
+	runtime := agent.NewRuntimeWithClient(cfg.GeminiModel, store, router)
+	runtime.SetTokenBudget(agent.TokenBudget{MaxInputTokens: cfg.MaxInputTokens, MaxOutputTokens: cfg.MaxOutputTokens, SessionTokenLimit: cfg.SessionTokenLimit})
+	if cfg.PromptVersion != "" {
+		if err := runtime.SetPromptVersion(cfg.PromptVersion); err != nil {
+			log.Fatalf("prompt version: %v (available: %v)", err, agent.PromptVersions())
+		}
+	}
+	runtime.SetResponseCache(agent.NewLRUCache(cfg.ResponseCacheSize, cfg.ResponseCacheTTL))
 	concierge := agent.NewConciergeService(store, gcp.NewMemoryImageStore(), runtime)
-	app := service.NewConciergeApp(concierge)
+	if cfg.ProfileExtractor == "model" {
+		concierge.SetProfileExtractor(agent.NewModelProfileExtractor(runtime))
+	}
+	if cfg.MenuRetriever == "hybrid" {
+		concierge.SetMenuRetriever(agent.NewHybridRetriever(agent.NewHashEmbedder(0)))
+	}
+	restaurants, closeRestaurants, err := newRestaurantStore(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("restaurant store: %v", err)
+	}
+	defer closeRestaurants()
+	app := service.NewConciergeAppWithRestaurants(concierge, restaurants)
 	handler := httphandler.NewHandler(app)
 
 	log.Printf("backend listening on :%s", cfg.Port)
@@ -0,0 +1,20 @@
+//go:build gcp
+
+package main
+
+import (
+	"context"
+
+	"github.com/gourmet-guide/backend/internal/config"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+// newRestaurantStore serves restaurants from the Firestore collection the seed
+// tool writes to.
+func newRestaurantStore(ctx context.Context, cfg config.Config) (gcp.RestaurantStore, func() error, error) {
+	store, err := gcp.NewFirestoreRestaurantStore(ctx, cfg.ProjectID, cfg.RestaurantCollection)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, store.Close, nil
+}
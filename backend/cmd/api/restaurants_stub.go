@@ -0,0 +1,16 @@
+//go:build !gcp
+
+package main
+
+import (
+	"context"
+
+	"github.com/gourmet-guide/backend/internal/config"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+// newRestaurantStore keeps restaurants in memory; build with -tags gcp to
+// serve them from Firestore.
+func newRestaurantStore(context.Context, config.Config) (gcp.RestaurantStore, func() error, error) {
+	return gcp.NewMemoryRestaurantStore(), func() error { return nil }, nil
+}
@@ -1,10 +1,25 @@
 package agent
 
-import "context"
+import (
+	"context"
+	"strings"
+)
 
 // Client describes LLM generation behavior used by runtime.
 type Client interface {
 	Generate(ctx context.Context, modelName, prompt string) (string, error)
+	// GenerateStream calls onDelta with each chunk as it is produced and returns
+	// the full reply. An error from onDelta aborts generation.
+	GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(delta string) error) (string, error)
+}
+
+// ToolClient is a Client that can also ask for tool calls. Given the
+// conversation so far and the declared tools, it returns either final text or
+// the calls it wants made; the runtime executes them and asks again. Clients
+// without it answer in plain text and never see tools.
+type ToolClient interface {
+	Client
+	GenerateWithTools(ctx context.Context, modelName string, conversation []ToolMessage, tools []ToolDeclaration) (ModelTurn, error)
 }
 
 type echoClient struct{}
@@ -13,6 +28,22 @@ func (e *echoClient) Generate(_ context.Context, _, prompt string) (string, erro
 	return "received and processed with Gemini: " + prompt, nil
 }
 
+func (e *echoClient) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(delta string) error) (string, error) {
+	reply, err := e.Generate(ctx, modelName, prompt)
+	if err != nil {
+		return "", err
+	}
+	for _, word := range strings.SplitAfter(reply, " ") {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := onDelta(word); err != nil {
+			return "", err
+		}
+	}
+	return reply, nil
+}
+
 func newDefaultClient() Client {
 	return &echoClient{}
 }
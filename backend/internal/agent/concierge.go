@@ -6,7 +6,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"sort"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -15,209 +15,635 @@ import (
 	"github.com/gourmet-guide/backend/internal/gcp"
 )
 
+// ErrSessionCompleted is returned when a lifecycle or message operation targets
+// a session that has already ended; completed sessions are never reopened.
+var ErrSessionCompleted = errors.New("session already completed")
+
+// ErrSafetyRefusal is returned when the concierge refuses a request because it
+// would serve the guest a dish their profile rules out.
+var ErrSafetyRefusal = errors.New("refused for the guest's safety")
+
+// errNoSessionChange aborts a session update that would leave it unchanged.
+var errNoSessionChange = errors.New("session unchanged")
+
+// degradedPicks is how many safe dishes a reply without a model lists.
+const degradedPicks = 5
+
 const highRiskDisclaimer = "I cannot confidently guarantee safety for that request. Please confirm ingredients and cross-contamination policy with restaurant staff before ordering."
 
+// SettingsSource looks up a restaurant's concierge settings.
+type SettingsSource interface {
+	ConciergeSettings(ctx context.Context, restaurantID string) (domain.ConciergeSettings, error)
+}
+
+type noSettings struct{}
+
+func (noSettings) ConciergeSettings(context.Context, string) (domain.ConciergeSettings, error) {
+	return domain.ConciergeSettings{}, nil
+}
+
 type ConciergeService struct {
 	store         gcp.SessionStore
 	imageStore    gcp.ImageStore
 	menuExtractor MenuExtractor
+	profiles      ProfileExtractor
+	retriever     MenuRetriever
+	combos        ComboSource
+	settings      SettingsSource
+	experiments   ExperimentSource
+	tools         *ToolRegistry
 	runtime       *Runtime
+	events        EventPublisher
 
 	mu      sync.Mutex
 	ongoing map[string]context.CancelFunc
 }
 
 func NewConciergeService(store gcp.SessionStore, imageStore gcp.ImageStore, runtime *Runtime) *ConciergeService {
-	return &ConciergeService{
+	s := &ConciergeService{
 		store:         store,
 		imageStore:    imageStore,
 		menuExtractor: &HeuristicMenuExtractor{},
+		profiles:      LexiconProfileExtractor{},
+		retriever:     NewBM25Retriever(),
+		combos:        noCombos{},
+		settings:      noSettings{},
+		experiments:   noExperiments{},
 		runtime:       runtime,
+		events:        noopPublisher{},
 		ongoing:       map[string]context.CancelFunc{},
 	}
+	s.tools = NewConciergeTools(s)
+	return s
+}
+
+// SetEventPublisher routes session and menu events to publisher.
+func (s *ConciergeService) SetEventPublisher(publisher EventPublisher) {
+	s.events = publisher
+}
+
+// SetProfileExtractor replaces the lexicon that finds allergies and diets stated in chat.
+func (s *ConciergeService) SetProfileExtractor(extractor ProfileExtractor) {
+	s.profiles = extractor
+}
+
+// SetMenuRetriever replaces the BM25 ranking that picks which safe dishes the
+// model sees.
+func (s *ConciergeService) SetMenuRetriever(retriever MenuRetriever) {
+	s.retriever = retriever
+}
+
+// SetComboSource sets where the suggest_combo tool finds restaurant combos;
+// by default there are none.
+func (s *ConciergeService) SetComboSource(combos ComboSource) {
+	s.combos = combos
+}
+
+// SetSettingsSource sets where restaurants' concierge settings come from; by
+// default every restaurant uses the service defaults.
+func (s *ConciergeService) SetSettingsSource(settings SettingsSource) {
+	s.settings = settings
+}
+
+// SetToolRegistry replaces the tools offered to a tool-calling model; nil
+// offers none.
+func (s *ConciergeService) SetToolRegistry(tools *ToolRegistry) {
+	s.tools = tools
+}
+
+// CacheStats reports the runtime's reply cache counters.
+func (s *ConciergeService) CacheStats() CacheStats {
+	return s.runtime.CacheStats()
+}
+
+// ProviderStats reports the runtime's model provider counters.
+func (s *ConciergeService) ProviderStats() []ProviderStats {
+	return s.runtime.ProviderStats()
+}
+
+// VoiceInstruction returns the live voice system instruction for restaurantID
+// and its prompt version. An empty restaurantID gets the defaults.
+func (s *ConciergeService) VoiceInstruction(ctx context.Context, restaurantID string) (string, string, error) {
+	var settings domain.ConciergeSettings
+	if restaurantID != "" {
+		var err error
+		if settings, err = s.settings.ConciergeSettings(ctx, restaurantID); err != nil {
+			return "", "", err
+		}
+	}
+	return s.runtime.VoiceInstruction(PromptOverridesFrom(settings))
 }
 
+// SaveMenuItems tags items and publishes them as a new live menu version in one
+// step. Admin edits, tagging and extraction go through SaveMenuDraft instead.
 func (s *ConciergeService) SaveMenuItems(ctx context.Context, restaurantID string, items []domain.MenuItem) ([]domain.MenuItem, error) {
-	enriched := EnrichMenuItemsWithSuggestedTags(items)
-	if err := s.store.SaveMenuSafetyMetadata(ctx, restaurantID, enriched); err != nil {
+	draft, err := s.SaveMenuDraft(ctx, restaurantID, items, MenuSourceDirect)
+	if err != nil {
+		return nil, err
+	}
+	published, err := s.PublishMenuVersion(ctx, restaurantID, draft.Version)
+	if err != nil {
 		return nil, err
 	}
-	return enriched, nil
+	return published.MenuItems, nil
 }
 
-func (s *ConciergeService) StartSession(ctx context.Context, restaurantID string, hardAllergens []domain.Allergen, preferenceTags []string) (domain.ConciergeSession, error) {
+// LoadMenuItems returns the live menu of a restaurant.
+func (s *ConciergeService) LoadMenuItems(ctx context.Context, restaurantID string) ([]domain.MenuItem, error) {
+	live, err := s.store.LoadLiveMenu(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	return live.MenuItems, nil
+}
+
+// StartSession opens a session pinned to the restaurant's live menu version.
+// Anaphylaxis entries of allergies are mirrored into HardAllergens.
+func (s *ConciergeService) StartSession(ctx context.Context, restaurantID string, allergies []domain.Allergy, preferenceTags []string) (domain.ConciergeSession, error) {
+	return s.StartSessionOnMenu(ctx, restaurantID, 0, allergies, preferenceTags)
+}
+
+// StartSessionOnMenu opens a session pinned to menuVersion, which may be a
+// draft such as a SaveSessionMenu version. Zero pins the live version.
+func (s *ConciergeService) StartSessionOnMenu(ctx context.Context, restaurantID string, menuVersion int, allergies []domain.Allergy, preferenceTags []string) (domain.ConciergeSession, error) {
 	now := time.Now().UTC()
+	var hardAllergens []domain.Allergen
+	for _, allergy := range allergies {
+		if allergy.Severity == domain.SeverityAnaphylaxis {
+			hardAllergens = append(hardAllergens, allergy.Allergen)
+		}
+	}
 	session := domain.ConciergeSession{
 		ID:             newSessionID(),
 		RestaurantID:   restaurantID,
 		HardAllergens:  hardAllergens,
+		Allergies:      allergies,
 		PreferenceTags: preferenceTags,
 		Status:         domain.SessionStatusActive,
 		CreatedAt:      now,
 		UpdatedAt:      now,
 	}
-	return session, s.store.SaveSession(ctx, session)
+	if menuVersion > 0 {
+		if _, err := s.store.LoadMenuVersion(ctx, restaurantID, menuVersion); err != nil {
+			return domain.ConciergeSession{}, err
+		}
+		session.MenuVersion = menuVersion
+	} else {
+		live, err := s.store.LoadLiveMenu(ctx, restaurantID)
+		switch {
+		case err == nil:
+			session.MenuVersion = live.Version
+		case !errors.Is(err, gcp.ErrRestaurantNotFound):
+			return domain.ConciergeSession{}, err
+		}
+	}
+	if err := s.assignExperiment(ctx, &session); err != nil {
+		return domain.ConciergeSession{}, err
+	}
+	if err := s.store.SaveSession(ctx, session); err != nil {
+		return domain.ConciergeSession{}, err
+	}
+	s.recordOutcome(ctx, session, domain.OutcomeSessionStarted)
+	s.publishSessionEvent(domain.SessionEventStarted, session, "", "")
+	return session, nil
 }
 
-func (s *ConciergeService) SendMessage(ctx context.Context, sessionID, prompt string) (string, error) {
-	session, err := s.store.LoadSession(ctx, sessionID)
+// SendMessage answers a guest message. Allergies and diets the message states
+// or retracts update the session profile first and are echoed at the start of
+// the reply. The reply carries the verdict of every dish on the session menu so
+// clients can show per-dish safety badges.
+func (s *ConciergeService) SendMessage(ctx context.Context, sessionID, prompt string) (domain.AssistantReply, error) {
+	return s.StreamMessage(ctx, sessionID, prompt, nil)
+}
+
+// StreamMessage answers prompt like SendMessage while forwarding reply chunks to
+// onPartial. Any safety note is delivered as the last chunk, so it always reaches
+// the caller before the returned full reply completes the turn. onPartial may be nil.
+func (s *ConciergeService) StreamMessage(ctx context.Context, sessionID, prompt string, onPartial func(delta string) error) (domain.AssistantReply, error) {
+	session, err := s.loadOpenSession(ctx, sessionID)
 	if err != nil {
-		return "", err
+		return domain.AssistantReply{}, err
+	}
+	if session.Experiment != nil {
+		ctx = WithExperimentVariant(ctx, session.Experiment.Variant)
 	}
-	if session.ID == "" {
-		return "", errors.New("session not found")
+	userTurn := domain.ConversationTurn{Role: domain.TurnRoleUser, Text: strings.TrimSpace(prompt), CreatedAt: time.Now().UTC()}
+	var pendingRemovals []domain.ProfileChange
+	if userTurn.ProfileChanges, pendingRemovals, err = s.updateProfile(ctx, &session, prompt); err != nil {
+		return domain.AssistantReply{}, err
 	}
 
-	items, err := s.store.LoadMenuSafetyMetadata(ctx, session.RestaurantID)
+	// A restaurant without menu data yields no safe items, so the reply fails closed.
+	items, err := s.sessionMenu(ctx, session)
 	if err != nil {
-		return "", err
+		return domain.AssistantReply{}, err
+	}
+
+	var streamed strings.Builder
+	emit := func(delta string) error {
+		if onPartial == nil {
+			return nil
+		}
+		streamed.WriteString(delta)
+		return onPartial(delta)
+	}
+	// The profile echo opens the reply, before anything filtered by the new profile.
+	echo := profileNote(userTurn.ProfileChanges, pendingRemovals)
+	if echo != "" {
+		echo += "\n\n"
+		if err := emit(echo); err != nil {
+			return domain.AssistantReply{}, err
+		}
+		streamed.Reset()
 	}
 
-	safeItems, warning := applySafetyPolicies(items, session.HardAllergens, session.PreferenceTags)
+	engine, err := s.safetyEngine(ctx, session.RestaurantID)
+	if err != nil {
+		return domain.AssistantReply{}, err
+	}
+	policyVersion := engine.Policy().Version
+	safeItems, verdicts, warning := engine.Apply(items, session.AllergyProfile(), session.PreferenceTags)
 	if len(safeItems) == 0 {
-		return highRiskDisclaimer, nil
+		if err := emit(highRiskDisclaimer); err != nil {
+			return domain.AssistantReply{}, err
+		}
+		if err := s.recordTurns(ctx, sessionID, userTurn, domain.ConversationTurn{Role: domain.TurnRoleAssistant, Text: highRiskDisclaimer, PolicyVersion: policyVersion}); err != nil {
+			return domain.AssistantReply{}, err
+		}
+		return domain.AssistantReply{Text: echo + highRiskDisclaimer, SafetyNote: warning, Safety: verdicts, ProfileChanges: userTurn.ProfileChanges, PendingRemovals: pendingRemovals, PolicyVersion: policyVersion}, nil
 	}
 
-	menuNames := make([]string, 0, len(safeItems))
-	for _, item := range safeItems {
-		menuNames = append(menuNames, item.Name)
+	relevant, err := s.retriever.Retrieve(ctx, prompt, retrievalCandidates(engine, safeItems, verdicts, session.PreferenceTags), s.runtime.menuLimit(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return domain.AssistantReply{}, ctx.Err()
+		}
+		// Ranking only narrows what the model sees; fall back to policy order.
+		relevant = safeItems
+	}
+	settings, err := s.settings.ConciergeSettings(ctx, session.RestaurantID)
+	if err != nil {
+		return domain.AssistantReply{}, err
 	}
 
-	turnCtx, cancel := context.WithCancel(ctx)
+	overrides := PromptOverridesFrom(settings)
+	if session.Experiment != nil && session.Experiment.Variant.PromptVersion != "" {
+		overrides.Version = session.Experiment.Variant.PromptVersion
+	}
+	turnCtx, cancel := context.WithCancel(WithPromptOverrides(WithMaxOutputTokens(ctx, settings.MaxOutputTokens), overrides))
 	s.setOngoingCancel(sessionID, cancel)
 	defer s.clearOngoingCancel(sessionID)
 
-	reply, err := s.runtime.Respond(turnCtx, sessionID, prompt, menuNames)
+	// The model reply only reaches the guest sentence by sentence through the
+	// reply guard, which drops excluded and off-menu dishes.
+	guarded := newGuardedStream(NewReplyGuard(items, safeItems), emit)
+	// Tools see the session as it changes; a profile update re-applies the
+	// policy so the guard and the safety note follow the new profile.
+	toolCtx := &ToolContext{Session: &session, Menu: items, Engine: engine, OnProfileChange: func() {
+		safeItems, verdicts, warning = engine.Apply(items, session.AllergyProfile(), session.PreferenceTags)
+		guarded.guard = NewReplyGuard(items, safeItems)
+	}}
+	var onDelta func(delta string) error
+	if onPartial != nil {
+		onDelta = guarded.Write
+	}
+	degraded := false
+	result, err := s.runtime.RespondWithTools(turnCtx, sessionID, prompt, relevant, s.tools, toolCtx, onDelta)
+	toolCalls := result.ToolCalls
+	pendingRemovals = append(pendingRemovals, toolCtx.PendingRemovals...)
+	if err == nil && onDelta == nil {
+		err = guarded.Write(result.Text)
+	}
 	if err != nil {
 		if errors.Is(err, context.Canceled) {
-			return "response interrupted, ready for your next request", nil
+			// The caller's context may be the one that was canceled; keep the record anyway.
+			interrupted := domain.ConversationTurn{Role: domain.TurnRoleAssistant, Text: streamed.String(), Interrupted: true, Interventions: guarded.interventions, ToolCalls: toolCalls, PromptVersion: result.PromptVersion, PolicyVersion: policyVersion}
+			if err := s.recordTurns(context.WithoutCancel(ctx), sessionID, userTurn, interrupted); err != nil {
+				return domain.AssistantReply{}, err
+			}
+			s.publishInterventions(session, prompt, streamed.String(), guarded.interventions)
+			return domain.AssistantReply{Text: "response interrupted, ready for your next request", Safety: verdicts, ProfileChanges: userTurn.ProfileChanges, PendingRemovals: pendingRemovals, Interventions: guarded.interventions, ToolCalls: toolCalls, PolicyVersion: policyVersion}, nil
+		}
+		if !errors.Is(err, ErrModelUnavailable) || ctx.Err() != nil {
+			return domain.AssistantReply{}, err
+		}
+		guarded.Discard()
+		if streamed.Len() > 0 {
+			// The model failed after part of its reply reached the guest. A
+			// fallback list would not match what they saw, so the turn ends
+			// interrupted with the part they got.
+			interrupted := domain.ConversationTurn{Role: domain.TurnRoleAssistant, Text: streamed.String(), Interrupted: true, Interventions: guarded.interventions, ToolCalls: toolCalls, TokenUsage: result.Usage, PromptVersion: result.PromptVersion, PolicyVersion: policyVersion}
+			if err := s.recordTurns(ctx, sessionID, userTurn, interrupted); err != nil {
+				return domain.AssistantReply{}, err
+			}
+			s.publishInterventions(session, prompt, streamed.String(), guarded.interventions)
+			return domain.AssistantReply{}, err
 		}
-		return "", err
+		// No model answered: fall back to the policy's own ranking so the guest
+		// still gets a safe list.
+		degraded = true
+		if err := guarded.Write(degradedReply(relevant)); err != nil {
+			return domain.AssistantReply{}, err
+		}
+	}
+	reply, err := guarded.Close()
+	if err != nil {
+		return domain.AssistantReply{}, err
 	}
+	// A session past its token limit gets a menu-only answer, like a turn no
+	// model could answer.
+	degraded = degraded || result.OverBudget
+	if err := s.recordTurns(ctx, sessionID, userTurn, domain.ConversationTurn{Role: domain.TurnRoleAssistant, Text: reply, SafetyNote: warning, Degraded: degraded, Interventions: guarded.interventions, ToolCalls: toolCalls, TokenUsage: result.Usage, PromptVersion: result.PromptVersion, PolicyVersion: policyVersion}); err != nil {
+		return domain.AssistantReply{}, err
+	}
+	s.publishInterventions(session, prompt, reply, guarded.interventions)
 	if warning != "" {
-		reply = fmt.Sprintf("%s\n\nSafety note: %s", reply, warning)
+		note := fmt.Sprintf("\n\nSafety note: %s", warning)
+		if err := emit(note); err != nil {
+			return domain.AssistantReply{}, err
+		}
+		reply += note
 	}
 
-	session.Status = domain.SessionStatusActive
-	session.LastAssistantMsg = reply
-	session.UpdatedAt = time.Now().UTC()
-	if err := s.store.SaveSession(ctx, session); err != nil {
-		return "", err
+	reply = echo + reply
+
+	// Only this turn's fields change, so an order, confirmation or end saved
+	// while the model was answering is kept.
+	session, err = s.store.UpdateSession(ctx, sessionID, func(session *domain.ConciergeSession) error {
+		if session.Status != domain.SessionStatusCompleted {
+			session.Status = domain.SessionStatusActive
+		}
+		session.TokenUsage = session.TokenUsage.Add(result.Usage)
+		session.LastPrompt = userTurn.Text
+		session.LastAssistantMsg = reply
+		session.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		return domain.AssistantReply{}, err
+	}
+	s.publishSessionEvent(domain.SessionEventMessage, session, prompt, reply)
+	return domain.AssistantReply{Text: reply, SafetyNote: warning, Safety: verdicts, ProfileChanges: userTurn.ProfileChanges, PendingRemovals: pendingRemovals, Interventions: guarded.interventions, ToolCalls: toolCalls, Degraded: degraded, PolicyVersion: policyVersion}, nil
+}
+
+// degradedReply lists the top safe dishes, in the order the policy and
+// retrieval ranked them, for turns no model could answer.
+func degradedReply(items []domain.MenuItem) string {
+	names := make([]string, 0, min(len(items), degradedPicks))
+	for _, item := range items[:min(len(items), degradedPicks)] {
+		names = append(names, item.Name)
+	}
+	return fmt.Sprintf("Our concierge is unavailable right now, but these dishes fit your profile: %s. Please confirm ingredients with restaurant staff before ordering.", strings.Join(names, ", "))
+}
+
+// updateProfile applies the allergies and diets stated in prompt to the
+// session, saves it and announces the change. It returns the changes applied
+// and the removals left waiting for the guest's confirmation.
+func (s *ConciergeService) updateProfile(ctx context.Context, session *domain.ConciergeSession, prompt string) (applied, pending []domain.ProfileChange, err error) {
+	changes, err := s.profiles.ExtractProfileChanges(ctx, prompt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil, nil
+	}
+	updated, err := s.updateOpenSession(ctx, session.ID, func(session *domain.ConciergeSession) error {
+		before := slices.Clone(session.PendingRemovals)
+		if applied, pending = applyProfileChanges(session, changes); len(applied) == 0 && slices.Equal(before, session.PendingRemovals) {
+			return errNoSessionChange
+		}
+		session.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if errors.Is(err, errNoSessionChange) {
+		return nil, pending, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	*session = updated
+	if len(applied) > 0 {
+		s.publishSessionEvent(domain.SessionEventProfileUpdated, *session, prompt, "")
+	}
+	return applied, pending, nil
+}
+
+// ConfirmProfileRemovals applies the retractions the guest stated in chat that
+// wait for their confirmation, and returns the updated session.
+func (s *ConciergeService) ConfirmProfileRemovals(ctx context.Context, sessionID string) (domain.ConciergeSession, error) {
+	var applied []domain.ProfileChange
+	session, err := s.updateOpenSession(ctx, sessionID, func(session *domain.ConciergeSession) error {
+		if len(session.PendingRemovals) == 0 {
+			return fmt.Errorf("%w: no profile removals are waiting for confirmation", ErrInvalidInput)
+		}
+		applied = confirmPendingRemovals(session)
+		session.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		return domain.ConciergeSession{}, err
+	}
+	if len(applied) > 0 {
+		s.publishSessionEvent(domain.SessionEventProfileUpdated, session, "", "")
+	}
+	return session, nil
+}
+
+// CheckMenuSafety returns a verdict for each item against the session's allergy
+// profile and dietary tags under the restaurant's current safety policy. Without items the session menu is checked,
+// optionally narrowed to itemIDs. Completed sessions can still be checked.
+func (s *ConciergeService) CheckMenuSafety(ctx context.Context, sessionID string, items []domain.MenuItem, itemIDs []string) ([]domain.ItemSafety, error) {
+	session, err := s.store.LoadSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		if items, err = s.sessionMenu(ctx, session); err != nil {
+			return nil, err
+		}
+		if items, err = selectMenuItems(items, itemIDs); err != nil {
+			return nil, err
+		}
 	}
-	return reply, nil
+	engine, err := s.safetyEngine(ctx, session.RestaurantID)
+	if err != nil {
+		return nil, err
+	}
+	return engine.Evaluate(items, session.AllergyProfile(), session.PreferenceTags), nil
+}
+
+// selectMenuItems narrows items to ids, in the order given; no ids selects all.
+func selectMenuItems(items []domain.MenuItem, ids []string) ([]domain.MenuItem, error) {
+	if len(ids) == 0 {
+		return items, nil
+	}
+	byID := make(map[string]domain.MenuItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	selected := make([]domain.MenuItem, 0, len(ids))
+	for _, id := range ids {
+		item, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: menu item %q is not on the session menu", ErrInvalidInput, id)
+		}
+		selected = append(selected, item)
+	}
+	return selected, nil
 }
 
 func (s *ConciergeService) InterruptSession(ctx context.Context, sessionID string) error {
+	session, err := s.updateOpenSession(ctx, sessionID, func(session *domain.ConciergeSession) error {
+		session.Status = domain.SessionStatusInterrupted
+		session.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 	s.mu.Lock()
 	cancel := s.ongoing[sessionID]
 	s.mu.Unlock()
 	if cancel != nil {
 		cancel()
 	}
-	session, err := s.store.LoadSession(ctx, sessionID)
-	if err != nil {
-		return err
-	}
-	session.Status = domain.SessionStatusInterrupted
-	session.UpdatedAt = time.Now().UTC()
-	return s.store.SaveSession(ctx, session)
+	s.publishSessionEvent(domain.SessionEventInterrupted, session, "", "")
+	return nil
 }
 
+// EndSession completes the session. A session in an experiment that ends
+// without a confirmed order counts as abandoned. Sessions never ended, such as
+// a guest closing the page, do not expire and are not counted, so
+// session_abandoned is a lower bound.
 func (s *ConciergeService) EndSession(ctx context.Context, sessionID string) error {
-	session, err := s.store.LoadSession(ctx, sessionID)
+	session, err := s.updateOpenSession(ctx, sessionID, func(session *domain.ConciergeSession) error {
+		session.Status = domain.SessionStatusCompleted
+		session.UpdatedAt = time.Now().UTC()
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	session.Status = domain.SessionStatusCompleted
-	session.UpdatedAt = time.Now().UTC()
-	return s.store.SaveSession(ctx, session)
+	if session.OrderConfirmedAt == nil {
+		s.recordOutcome(ctx, session, domain.OutcomeSessionAbandoned)
+	}
+	s.publishSessionEvent(domain.SessionEventEnded, session, "", "")
+	return nil
 }
 
-func (s *ConciergeService) AutoExtractMenuFromImage(ctx context.Context, restaurantID, fileName string, content []byte) ([]domain.MenuItem, string, error) {
+// AutoExtractMenuFromImage saves extracted items as a draft menu version; an
+// admin must review and publish it before guests see it.
+func (s *ConciergeService) AutoExtractMenuFromImage(ctx context.Context, restaurantID, fileName string, content []byte) (domain.MenuVersion, string, error) {
 	imagePath, err := s.imageStore.SaveSessionImage(ctx, restaurantID, fileName, content)
 	if err != nil {
-		return nil, "", err
+		return domain.MenuVersion{}, "", err
 	}
 	items, err := s.menuExtractor.ExtractMenuItems(ctx, content)
 	if err != nil {
-		return nil, "", err
+		return domain.MenuVersion{}, "", err
 	}
-	enriched, err := s.SaveMenuItems(ctx, restaurantID, items)
+	draft, err := s.SaveMenuDraft(ctx, restaurantID, items, MenuSourceExtraction)
 	if err != nil {
-		return nil, "", err
+		return domain.MenuVersion{}, "", err
 	}
-	return enriched, imagePath, nil
+	return draft, imagePath, nil
 }
 func (s *ConciergeService) GetSession(ctx context.Context, sessionID string) (domain.ConciergeSession, error) {
 	return s.store.LoadSession(ctx, sessionID)
 }
 
-func (s *ConciergeService) setOngoingCancel(sessionID string, cancel context.CancelFunc) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.ongoing[sessionID] = cancel
+// loadOpenSession loads a session that can still change state.
+func (s *ConciergeService) loadOpenSession(ctx context.Context, sessionID string) (domain.ConciergeSession, error) {
+	session, err := s.store.LoadSession(ctx, sessionID)
+	if err != nil {
+		return domain.ConciergeSession{}, err
+	}
+	if session.Status == domain.SessionStatusCompleted {
+		return domain.ConciergeSession{}, ErrSessionCompleted
+	}
+	return session, nil
 }
 
-func (s *ConciergeService) clearOngoingCancel(sessionID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.ongoing, sessionID)
+// updateOpenSession applies update to a session that can still change state,
+// atomically with the completed check.
+func (s *ConciergeService) updateOpenSession(ctx context.Context, sessionID string, update func(*domain.ConciergeSession) error) (domain.ConciergeSession, error) {
+	return s.store.UpdateSession(ctx, sessionID, func(session *domain.ConciergeSession) error {
+		if session.Status == domain.SessionStatusCompleted {
+			return ErrSessionCompleted
+		}
+		return update(session)
+	})
 }
 
-func applySafetyPolicies(items []domain.MenuItem, hardAllergens []domain.Allergen, preferenceTags []string) ([]domain.MenuItem, string) {
-	allergenSet := map[domain.Allergen]struct{}{}
-	for _, allergen := range hardAllergens {
-		allergenSet[allergen] = struct{}{}
-	}
-	filtered := make([]domain.MenuItem, 0, len(items))
-	crossContaminationWarning := false
-	for _, item := range items {
-		if containsAnyAllergen(item.Allergens, allergenSet) {
-			continue
-		}
-		if containsAnyAllergen(item.CrossContaminationRisk, allergenSet) {
-			crossContaminationWarning = true
-			continue
+// GetTranscript returns the recorded conversation turns of a session in order.
+func (s *ConciergeService) GetTranscript(ctx context.Context, sessionID string) ([]domain.ConversationTurn, error) {
+	return s.store.LoadTranscript(ctx, sessionID)
+}
+
+// recordTurns appends a guest prompt and the assistant's answer to the transcript.
+func (s *ConciergeService) recordTurns(ctx context.Context, sessionID string, userTurn, assistantTurn domain.ConversationTurn) error {
+	assistantTurn.CreatedAt = time.Now().UTC()
+	return s.store.AppendTranscript(ctx, sessionID, userTurn, assistantTurn)
+}
+
+func (s *ConciergeService) publishSessionEvent(eventType domain.SessionEventType, session domain.ConciergeSession, prompt, reply string) {
+	s.events.Publish(domain.SessionEvent{
+		Type:         eventType,
+		SessionID:    session.ID,
+		RestaurantID: session.RestaurantID,
+		Session:      &session,
+		Prompt:       prompt,
+		Reply:        reply,
+		CreatedAt:    session.UpdatedAt,
+	})
+}
+
+// retrievalCandidates pairs safe items with the engine's preference score,
+// counting deprioritized items one point lower.
+func retrievalCandidates(engine *SafetyEngine, safeItems []domain.MenuItem, verdicts []domain.ItemSafety, guestTags []string) []RetrievalCandidate {
+	deprioritized := map[string]bool{}
+	for _, verdict := range verdicts {
+		if verdict.Deprioritized {
+			deprioritized[verdict.ItemID+"\x00"+verdict.Name] = true
 		}
-		filtered = append(filtered, item)
-	}
-
-	dietaryFiltered := false
-	if len(preferenceTags) > 0 {
-		strictFiltered := make([]domain.MenuItem, 0, len(filtered))
-		// Dietary constraints are treated as hard requirements in-session for safety.
-		for _, item := range filtered {
-			if hasAllRequiredTags(item, preferenceTags) {
-				strictFiltered = append(strictFiltered, item)
-			} else {
-				dietaryFiltered = true
-			}
+	}
+	candidates := make([]RetrievalCandidate, len(safeItems))
+	for i, item := range safeItems {
+		preference := float64(engine.preferenceScore(item, guestTags))
+		if deprioritized[item.ID+"\x00"+item.Name] {
+			preference--
 		}
-		filtered = strictFiltered
-		sort.SliceStable(filtered, func(i, j int) bool {
-			return preferenceScore(filtered[i], preferenceTags) > preferenceScore(filtered[j], preferenceTags)
-		})
+		candidates[i] = RetrievalCandidate{Item: item, Preference: preference}
 	}
+	return candidates
+}
 
-	if crossContaminationWarning {
-		return filtered, "Some items were excluded due to cross-contamination risk."
-	}
-	if dietaryFiltered {
-		return filtered, "Some menu items were excluded because they did not satisfy required dietary tags."
+// publishInterventions reports what the reply guard changed in a reply, if anything.
+func (s *ConciergeService) publishInterventions(session domain.ConciergeSession, prompt, reply string, interventions []domain.SafetyIntervention) {
+	if len(interventions) == 0 {
+		return
 	}
-	if len(filtered) < len(items) {
-		return filtered, "Some menu items were removed by hard allergen filters."
-	}
-	return filtered, ""
+	s.events.Publish(domain.SessionEvent{
+		Type:          domain.SessionEventSafetyIntervention,
+		SessionID:     session.ID,
+		RestaurantID:  session.RestaurantID,
+		Prompt:        prompt,
+		Reply:         reply,
+		Interventions: interventions,
+		CreatedAt:     time.Now().UTC(),
+	})
 }
 
-func containsAnyAllergen(itemAllergens []domain.Allergen, restricted map[domain.Allergen]struct{}) bool {
-	for _, allergen := range itemAllergens {
-		if _, blocked := restricted[allergen]; blocked {
-			return true
-		}
-	}
-	return false
+func (s *ConciergeService) setOngoingCancel(sessionID string, cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ongoing[sessionID] = cancel
+}
+
+func (s *ConciergeService) clearOngoingCancel(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ongoing, sessionID)
 }
 
 func hasAllRequiredTags(item domain.MenuItem, requiredTags []string) bool {
@@ -237,18 +663,6 @@ func hasAllRequiredTags(item domain.MenuItem, requiredTags []string) bool {
 	return true
 }
 
-func preferenceScore(item domain.MenuItem, preferenceTags []string) int {
-	score := 0
-	for _, preference := range preferenceTags {
-		for _, tag := range item.Tags {
-			if strings.EqualFold(preference, tag) {
-				score++
-			}
-		}
-	}
-	return score
-}
-
 func newSessionID() string {
 	buf := make([]byte, 8)
 	_, _ = rand.Read(buf)
@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -20,6 +21,10 @@ func (b *blockingClient) Generate(ctx context.Context, _, _ string) (string, err
 	return "", ctx.Err()
 }
 
+func (b *blockingClient) GenerateStream(ctx context.Context, modelName, prompt string, _ func(string) error) (string, error) {
+	return b.Generate(ctx, modelName, prompt)
+}
+
 func TestSendMessageReturnsInterruptionNoticeWhenRuntimeIsCanceled(t *testing.T) {
 	t.Parallel()
 
@@ -46,7 +51,7 @@ func TestSendMessageReturnsInterruptionNoticeWhenRuntimeIsCanceled(t *testing.T)
 			errCh <- callErr
 			return
 		}
-		replyCh <- reply
+		replyCh <- reply.Text
 	}()
 
 	select {
@@ -77,11 +82,19 @@ func TestSendMessageReturnsInterruptionNoticeWhenRuntimeIsCanceled(t *testing.T)
 	if updated.Status != domain.SessionStatusInterrupted {
 		t.Fatalf("expected interrupted session status, got %s", updated.Status)
 	}
+
+	turns, err := service.GetTranscript(context.Background(), session.ID)
+	if err != nil {
+		t.Fatalf("load transcript: %v", err)
+	}
+	if len(turns) != 2 || !turns[1].Interrupted {
+		t.Fatalf("expected guest turn and interrupted assistant turn, got %+v", turns)
+	}
 }
 
 func TestRuntimeRespondPropagatesCanceledContext(t *testing.T) {
 	t.Parallel()
-	store := gcp.NewMemoryStore()
+	store := storeWithSessions(t, "session-1")
 	runtime := NewRuntime("gemini", store)
 	runtime.client = &blockingClient{started: make(chan struct{})}
 
@@ -93,3 +106,130 @@ func TestRuntimeRespondPropagatesCanceledContext(t *testing.T) {
 		t.Fatalf("expected context canceled error, got %v", err)
 	}
 }
+
+// gatedClient answers once release is closed, after signalling started.
+type gatedClient struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *gatedClient) Generate(ctx context.Context, _, _ string) (string, error) {
+	close(g.started)
+	select {
+	case <-g.release:
+		return "Try the Safe Bowl.", nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (g *gatedClient) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(string) error) (string, error) {
+	reply, err := g.Generate(ctx, modelName, prompt)
+	if err != nil {
+		return "", err
+	}
+	return reply, onDelta(reply)
+}
+
+func TestFinishingTurnKeepsChangesSavedWhileTheModelAnswered(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	client := &gatedClient{started: make(chan struct{}), release: make(chan struct{})}
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, client))
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{{ID: "bowl", Name: "Safe Bowl", Tags: []string{"vegan"}}}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	session, err := service.StartSession(ctx, "rest-1", nil, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if _, err := store.UpdateSession(ctx, session.ID, func(session *domain.ConciergeSession) error {
+		session.Order = []domain.OrderLine{{ItemID: "bowl", Name: "Safe Bowl", Quantity: 1}}
+		return nil
+	}); err != nil {
+		t.Fatalf("add order line: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := service.SendMessage(ctx, session.ID, "anything vegan?")
+		errCh <- err
+	}()
+	select {
+	case <-client.started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("runtime client did not start")
+	}
+	if _, err := service.ConfirmOrder(ctx, session.ID); err != nil {
+		t.Fatalf("confirm order: %v", err)
+	}
+	if err := service.EndSession(ctx, session.ID); err != nil {
+		t.Fatalf("end session: %v", err)
+	}
+	close(client.release)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("send message: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the reply")
+	}
+
+	updated, err := service.GetSession(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("load session: %v", err)
+	}
+	if updated.Status != domain.SessionStatusCompleted || updated.OrderConfirmedAt == nil || len(updated.Order) != 1 {
+		t.Fatalf("expected the completed session to keep its confirmed order, got %+v", updated)
+	}
+	if updated.LastAssistantMsg == "" {
+		t.Fatal("expected the turn to record its reply")
+	}
+}
+
+// midStreamFailingClient streams one sentence and then fails.
+type midStreamFailingClient struct{}
+
+func (midStreamFailingClient) Generate(context.Context, string, string) (string, error) {
+	return "", errors.New("provider reset the connection")
+}
+
+func (midStreamFailingClient) GenerateStream(_ context.Context, _, _ string, onDelta func(string) error) (string, error) {
+	if err := onDelta("The Safe Bowl is a good pick. It comes with "); err != nil {
+		return "", err
+	}
+	return "", errors.New("provider reset the connection")
+}
+
+func TestModelFailingMidStreamEndsTheTurnInterrupted(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, midStreamFailingClient{}))
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{{ID: "bowl", Name: "Safe Bowl"}}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	session, err := service.StartSession(ctx, "rest-1", nil, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+
+	var streamed strings.Builder
+	_, err = service.StreamMessage(ctx, session.ID, "What is good?", func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	if !errors.Is(err, ErrModelUnavailable) {
+		t.Fatalf("expected the model failure, got %v", err)
+	}
+	if streamed.String() != "The Safe Bowl is a good pick. " {
+		t.Fatalf("expected only the sentence sent before the failure, got %q", streamed.String())
+	}
+	transcript, _ := store.LoadTranscript(ctx, session.ID)
+	last := transcript[len(transcript)-1]
+	if last.Role != domain.TurnRoleAssistant || !last.Interrupted || last.Degraded || last.Text != streamed.String() {
+		t.Fatalf("expected an interrupted turn holding what the guest saw, got %+v", last)
+	}
+}
@@ -2,6 +2,8 @@ package agent
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/gourmet-guide/backend/internal/domain"
@@ -16,7 +18,7 @@ func TestApplySafetyPoliciesFiltersHardAllergensAndCrossContamination(t *testing
 		{Name: "Fries", CrossContaminationRisk: []domain.Allergen{domain.AllergenPeanut}, Tags: []string{"vegan"}},
 	}
 
-	safe, warning := applySafetyPolicies(items, []domain.Allergen{domain.AllergenPeanut}, []string{"vegan"})
+	safe, _, warning := applySafetyPolicies(items, anaphylaxis(domain.AllergenPeanut), []string{"vegan"})
 	if len(safe) != 1 {
 		t.Fatalf("expected 1 safe item, got %d", len(safe))
 	}
@@ -28,6 +30,14 @@ func TestApplySafetyPoliciesFiltersHardAllergensAndCrossContamination(t *testing
 	}
 }
 
+func anaphylaxis(allergens ...domain.Allergen) []domain.Allergy {
+	allergies := make([]domain.Allergy, 0, len(allergens))
+	for _, allergen := range allergens {
+		allergies = append(allergies, domain.Allergy{Allergen: allergen, Severity: domain.SeverityAnaphylaxis})
+	}
+	return allergies
+}
+
 func TestInterruptSessionUpdatesStatus(t *testing.T) {
 	t.Parallel()
 	store := gcp.NewMemoryStore()
@@ -50,6 +60,30 @@ func TestInterruptSessionUpdatesStatus(t *testing.T) {
 	}
 }
 
+func TestLifecycleOperationsReportMissingAndCompletedSessions(t *testing.T) {
+	t.Parallel()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntime("gemini", store))
+	ctx := context.Background()
+
+	if err := service.InterruptSession(ctx, "missing"); !errors.Is(err, gcp.ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+	session, err := service.StartSession(ctx, "rest-1", nil, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if err := service.EndSession(ctx, session.ID); err != nil {
+		t.Fatalf("end session: %v", err)
+	}
+	if err := service.InterruptSession(ctx, session.ID); !errors.Is(err, ErrSessionCompleted) {
+		t.Fatalf("expected ErrSessionCompleted on interrupt, got %v", err)
+	}
+	if _, err := service.SendMessage(ctx, session.ID, "hello"); !errors.Is(err, ErrSessionCompleted) {
+		t.Fatalf("expected ErrSessionCompleted on message, got %v", err)
+	}
+}
+
 func TestApplySafetyPoliciesTreatsDietaryTagsAsHardRequirement(t *testing.T) {
 	t.Parallel()
 	items := []domain.MenuItem{
@@ -57,7 +91,7 @@ func TestApplySafetyPoliciesTreatsDietaryTagsAsHardRequirement(t *testing.T) {
 		{Name: "Pork Ramen", Tags: []string{"spicy"}},
 	}
 
-	safe, warning := applySafetyPolicies(items, nil, []string{"halal", "no-pork"})
+	safe, _, warning := applySafetyPolicies(items, nil, []string{"halal", "no-pork"})
 	if len(safe) != 1 {
 		t.Fatalf("expected 1 dietary-safe item, got %d", len(safe))
 	}
@@ -79,3 +113,92 @@ func TestHasAllRequiredTags(t *testing.T) {
 		t.Fatal("expected false when missing required dietary tag")
 	}
 }
+
+func TestStreamMessageDeliversSafetyNoteAsFinalPartial(t *testing.T) {
+	t.Parallel()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntime("gemini", store))
+
+	_, err := service.SaveMenuItems(context.Background(), "rest-1", []domain.MenuItem{
+		{Name: "House Salad"},
+		{Name: "Peanut Curry", Allergens: []domain.Allergen{domain.AllergenPeanut}},
+	})
+	if err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	session, err := service.StartSession(context.Background(), "rest-1", anaphylaxis(domain.AllergenPeanut), nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+
+	var partials []string
+	reply, err := service.StreamMessage(context.Background(), session.ID, "dinner ideas?", func(delta string) error {
+		partials = append(partials, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream message: %v", err)
+	}
+	if len(partials) < 2 {
+		t.Fatalf("expected several partials, got %#v", partials)
+	}
+	if strings.Join(partials, "") != reply.Text {
+		t.Fatalf("expected partials to add up to reply %q, got %#v", reply.Text, partials)
+	}
+	if !strings.HasPrefix(partials[len(partials)-1], "\n\nSafety note:") {
+		t.Fatalf("expected safety note as final partial, got %q", partials[len(partials)-1])
+	}
+}
+
+func TestSendMessageRecordsTranscriptWithSafetyNotes(t *testing.T) {
+	t.Parallel()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntime("gemini", store))
+
+	_, err := service.SaveMenuItems(context.Background(), "rest-1", []domain.MenuItem{
+		{Name: "House Salad"},
+		{Name: "Peanut Curry", Allergens: []domain.Allergen{domain.AllergenPeanut}},
+	})
+	if err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	session, err := service.StartSession(context.Background(), "rest-1", anaphylaxis(domain.AllergenPeanut), nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if _, err := service.SendMessage(context.Background(), session.ID, "  first question  "); err != nil {
+		t.Fatalf("send first message: %v", err)
+	}
+	if _, err := service.SendMessage(context.Background(), session.ID, "second question"); err != nil {
+		t.Fatalf("send second message: %v", err)
+	}
+
+	turns, err := service.GetTranscript(context.Background(), session.ID)
+	if err != nil {
+		t.Fatalf("load transcript: %v", err)
+	}
+	if len(turns) != 4 {
+		t.Fatalf("expected 4 turns, got %d", len(turns))
+	}
+	if turns[0].Role != domain.TurnRoleUser || turns[0].Text != "first question" {
+		t.Fatalf("unexpected first turn: %+v", turns[0])
+	}
+	assistant := turns[3]
+	if assistant.Role != domain.TurnRoleAssistant || assistant.SafetyNote == "" || strings.Contains(assistant.Text, "Safety note:") {
+		t.Fatalf("expected assistant turn with separate safety note, got %+v", assistant)
+	}
+	if !strings.Contains(assistant.Text, "Guest: first question") {
+		t.Fatalf("expected second model call to see the first exchange, got %q", assistant.Text)
+	}
+	if assistant.CreatedAt.Before(turns[2].CreatedAt) {
+		t.Fatal("expected assistant turn to be timestamped after the guest turn")
+	}
+
+	updated, err := service.GetSession(context.Background(), session.ID)
+	if err != nil {
+		t.Fatalf("load session: %v", err)
+	}
+	if updated.LastPrompt != "second question" {
+		t.Fatalf("expected last prompt to be stored, got %q", updated.LastPrompt)
+	}
+}
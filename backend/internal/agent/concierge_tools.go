@@ -0,0 +1,348 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+const (
+	searchMenuLimitMax = 20
+	orderQuantityMax   = 20
+)
+
+// ComboSource lists a restaurant's curated combos for the suggest_combo tool.
+type ComboSource interface {
+	Combos(ctx context.Context, restaurantID string) ([]domain.Combo, error)
+}
+
+type noCombos struct{}
+
+func (noCombos) Combos(context.Context, string) ([]domain.Combo, error) {
+	return nil, nil
+}
+
+// toolMenuItem is how tools describe a dish to the model.
+type toolMenuItem struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Verdict     domain.SafetyVerdict `json:"verdict"`
+	Reason      string               `json:"reason,omitempty"`
+}
+
+type toolCombo struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Items       []toolMenuItem `json:"items"`
+}
+
+// NewConciergeTools returns the tools the concierge offers a tool-calling
+// model. Every tool works on the session menu under the restaurant's safety
+// policy: search and combos only return dishes the policy lets the guest see,
+// and add_to_order refuses the others.
+func NewConciergeTools(s *ConciergeService) *ToolRegistry {
+	registry := NewToolRegistry()
+	for _, tool := range []Tool{
+		{
+			Declaration: ToolDeclaration{
+				Name:        "search_menu",
+				Description: "Search the dishes that are safe for the guest, best match first.",
+				Parameters: json.RawMessage(`{"type":"object","properties":{` +
+					`"query":{"type":"string","description":"What the guest is looking for."},` +
+					`"limit":{"type":"integer","minimum":1,"maximum":20}},"required":["query"]}`),
+			},
+			Run: s.searchMenuTool,
+		},
+		{
+			Declaration: ToolDeclaration{
+				Name:        "check_item_safety",
+				Description: "Check menu items, given by ID or by name, against the guest's allergies and dietary needs.",
+				Parameters: json.RawMessage(`{"type":"object","properties":{` +
+					`"itemIds":{"type":"array","items":{"type":"string"}},` +
+					`"names":{"type":"array","items":{"type":"string"},"description":"Dish names as the guest said them."}}}`),
+			},
+			Run: s.checkItemSafetyTool,
+		},
+		{
+			Declaration: ToolDeclaration{
+				Name:        "add_to_order",
+				Description: "Add a dish that is safe for the guest to their order.",
+				Parameters: json.RawMessage(`{"type":"object","properties":{` +
+					`"itemId":{"type":"string"},` +
+					`"quantity":{"type":"integer","minimum":1,"maximum":20},` +
+					`"notes":{"type":"string"}},"required":["itemId"]}`),
+			},
+			Run: s.addToOrderTool,
+		},
+		{
+			Declaration: ToolDeclaration{
+				Name:        "suggest_combo",
+				Description: "List the restaurant's combos whose dishes are all safe for the guest, optionally only those including itemId.",
+				Parameters: json.RawMessage(`{"type":"object","properties":{` +
+					`"itemId":{"type":"string"}}}`),
+			},
+			Run: s.suggestComboTool,
+		},
+		{
+			Declaration: ToolDeclaration{
+				Name:        "update_allergy_profile",
+				Description: "Add an allergy or dietary requirement the guest stated. A removal is only proposed: ask the guest to confirm it, it does not apply until they do.",
+				Parameters: json.RawMessage(`{"type":"object","properties":{` +
+					`"action":{"type":"string","enum":["add","remove"]},` +
+					`"allergen":{"type":"string"},` +
+					`"severity":{"type":"string","enum":["preference","intolerance","allergy","anaphylaxis"]},` +
+					`"tag":{"type":"string"}},"required":["action"]}`),
+			},
+			Run: s.updateAllergyProfileTool,
+		},
+	} {
+		if err := registry.Register(tool); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
+
+func (s *ConciergeService) searchMenuTool(ctx context.Context, toolCtx *ToolContext, args json.RawMessage) (any, error) {
+	var input struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+	if input.Limit <= 0 {
+		input.Limit = s.runtime.menuLimit(ctx)
+	}
+	input.Limit = min(input.Limit, searchMenuLimitMax)
+
+	session := toolCtx.Session
+	safeItems, verdicts, _ := toolCtx.Engine.Apply(toolCtx.Menu, session.AllergyProfile(), session.PreferenceTags)
+	if len(safeItems) == 0 {
+		return nil, fmt.Errorf("%w: no dish on the menu is safe for this guest", ErrSafetyRefusal)
+	}
+	found, err := s.retriever.Retrieve(ctx, input.Query, retrievalCandidates(toolCtx.Engine, safeItems, verdicts, session.PreferenceTags), input.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"items": toolMenuItems(found, verdicts)}, nil
+}
+
+func (s *ConciergeService) checkItemSafetyTool(_ context.Context, toolCtx *ToolContext, args json.RawMessage) (any, error) {
+	var input struct {
+		ItemIDs []string `json:"itemIds"`
+		Names   []string `json:"names"`
+	}
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+	if len(input.ItemIDs) == 0 && len(input.Names) == 0 {
+		return nil, errors.New("itemIds or names is required")
+	}
+	var items []domain.MenuItem
+	if len(input.ItemIDs) > 0 {
+		var err error
+		if items, err = selectMenuItems(toolCtx.Menu, input.ItemIDs); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range input.Names {
+		named, err := menuItemsNamed(toolCtx.Menu, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range named {
+			if !slices.ContainsFunc(items, func(other domain.MenuItem) bool { return other.ID == item.ID }) {
+				items = append(items, item)
+			}
+		}
+	}
+	session := toolCtx.Session
+	return map[string]any{"safety": toolCtx.Engine.Evaluate(items, session.AllergyProfile(), session.PreferenceTags)}, nil
+}
+
+func (s *ConciergeService) addToOrderTool(ctx context.Context, toolCtx *ToolContext, args json.RawMessage) (any, error) {
+	var input struct {
+		ItemID   string `json:"itemId"`
+		Quantity int    `json:"quantity"`
+		Notes    string `json:"notes"`
+	}
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+	if input.Quantity == 0 {
+		input.Quantity = 1
+	}
+	if input.Quantity < 0 || input.Quantity > orderQuantityMax {
+		return nil, fmt.Errorf("quantity must be between 1 and %d", orderQuantityMax)
+	}
+	items, err := selectMenuItems(toolCtx.Menu, []string{input.ItemID})
+	if err != nil {
+		return nil, err
+	}
+	item := items[0]
+	session := toolCtx.Session
+	safeItems, verdicts, _ := toolCtx.Engine.Apply(items, session.AllergyProfile(), session.PreferenceTags)
+	if len(safeItems) == 0 {
+		return nil, fmt.Errorf("%w: %s is %s for this guest and cannot be ordered: %s", ErrSafetyRefusal, item.Name, verdicts[0].Verdict, verdicts[0].Explanation)
+	}
+
+	notes := strings.TrimSpace(input.Notes)
+	updated, err := s.updateOpenSession(ctx, session.ID, func(session *domain.ConciergeSession) error {
+		if i := slices.IndexFunc(session.Order, func(line domain.OrderLine) bool { return line.ItemID == item.ID }); i >= 0 {
+			session.Order[i].Quantity += input.Quantity
+			if notes != "" {
+				session.Order[i].Notes = notes
+			}
+		} else {
+			session.Order = append(session.Order, domain.OrderLine{ItemID: item.ID, Name: item.Name, Quantity: input.Quantity, Notes: notes})
+		}
+		session.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	*session = updated
+	s.recordOutcome(ctx, updated, domain.OutcomeItemAdded)
+	s.publishSessionEvent(domain.SessionEventItemAdded, updated, "", "")
+	return map[string]any{"order": updated.Order}, nil
+}
+
+func (s *ConciergeService) suggestComboTool(ctx context.Context, toolCtx *ToolContext, args json.RawMessage) (any, error) {
+	var input struct {
+		ItemID string `json:"itemId"`
+	}
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+	combos, err := s.combos.Combos(ctx, toolCtx.Session.RestaurantID)
+	if err != nil {
+		return nil, err
+	}
+	session := toolCtx.Session
+	safeItems, verdicts, _ := toolCtx.Engine.Apply(toolCtx.Menu, session.AllergyProfile(), session.PreferenceTags)
+	safe := make(map[string]domain.MenuItem, len(safeItems))
+	for _, item := range safeItems {
+		safe[item.ID] = item
+	}
+
+	suggestions := []toolCombo{}
+	for _, combo := range combos {
+		if input.ItemID != "" && !slices.Contains(combo.ItemIDs, input.ItemID) {
+			continue
+		}
+		items := make([]domain.MenuItem, 0, len(combo.ItemIDs))
+		for _, id := range combo.ItemIDs {
+			item, ok := safe[id]
+			if !ok {
+				break
+			}
+			items = append(items, item)
+		}
+		if len(items) == 0 || len(items) < len(combo.ItemIDs) {
+			continue
+		}
+		suggestions = append(suggestions, toolCombo{ID: combo.ID, Name: combo.Name, Description: combo.Description, Items: toolMenuItems(items, verdicts)})
+	}
+	return map[string]any{"combos": suggestions}, nil
+}
+
+func (s *ConciergeService) updateAllergyProfileTool(ctx context.Context, toolCtx *ToolContext, args json.RawMessage) (any, error) {
+	var input domain.ProfileChange
+	if err := decodeToolArgs(args, &input); err != nil {
+		return nil, err
+	}
+	change, ok := validProfileChange(input)
+	if !ok {
+		return nil, errors.New("expected action add or remove with exactly one known allergen or diet tag")
+	}
+	change.Source = ProfileSourceTool
+
+	var applied, pending []domain.ProfileChange
+	updated, err := s.updateOpenSession(ctx, toolCtx.Session.ID, func(session *domain.ConciergeSession) error {
+		before := slices.Clone(session.PendingRemovals)
+		if applied, pending = applyProfileChanges(session, []domain.ProfileChange{change}); len(applied) == 0 && slices.Equal(before, session.PendingRemovals) {
+			return errNoSessionChange
+		}
+		session.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	switch {
+	case errors.Is(err, errNoSessionChange):
+		updated = *toolCtx.Session
+	case err != nil:
+		return nil, err
+	case len(applied) == 0:
+		*toolCtx.Session = updated
+	default:
+		*toolCtx.Session = updated
+		s.publishSessionEvent(domain.SessionEventProfileUpdated, updated, "", "")
+		if toolCtx.OnProfileChange != nil {
+			toolCtx.OnProfileChange()
+		}
+	}
+	toolCtx.PendingRemovals = append(toolCtx.PendingRemovals, pending...)
+	return map[string]any{
+		"applied":         applied,
+		"pendingRemovals": pending,
+		"allergies":       updated.AllergyProfile(),
+		"preferenceTags":  updated.PreferenceTags,
+	}, nil
+}
+
+// toolMenuItems pairs items with their verdicts and the reason for each.
+func toolMenuItems(items []domain.MenuItem, verdicts []domain.ItemSafety) []toolMenuItem {
+	byID := make(map[string]domain.ItemSafety, len(verdicts))
+	for _, verdict := range verdicts {
+		byID[verdict.ItemID] = verdict
+	}
+	described := make([]toolMenuItem, len(items))
+	for i, item := range items {
+		verdict := byID[item.ID]
+		described[i] = toolMenuItem{ID: item.ID, Name: item.Name, Description: item.Description, Tags: item.Tags, Verdict: verdict.Verdict, Reason: verdict.Explanation}
+	}
+	return described
+}
+
+// menuItemsNamed finds the dishes a guest means by name: the dish with exactly
+// that name, or else every dish whose name contains it or is contained in it.
+func menuItemsNamed(menu []domain.MenuItem, name string) ([]domain.MenuItem, error) {
+	wanted := normalizeDishName(name)
+	if wanted == "" {
+		return nil, errors.New("dish names must not be empty")
+	}
+	var partial []domain.MenuItem
+	for _, item := range menu {
+		have := normalizeDishName(item.Name)
+		if have == wanted {
+			return []domain.MenuItem{item}, nil
+		}
+		if have != "" && (strings.Contains(have, wanted) || strings.Contains(wanted, have)) {
+			partial = append(partial, item)
+		}
+	}
+	if len(partial) == 0 {
+		return nil, fmt.Errorf("no dish called %q is on the menu", name)
+	}
+	return partial, nil
+}
+
+// normalizeDishName lower-cases name, drops a leading article and joins the
+// words with single spaces.
+func normalizeDishName(name string) string {
+	words := strings.Fields(strings.ToLower(strings.Trim(name, " \t\"'?!.,")))
+	if len(words) > 1 && (words[0] == "the" || words[0] == "a" || words[0] == "an") {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
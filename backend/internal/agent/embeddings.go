@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+const (
+	hashEmbedderDimensionsDefault = 256
+	// embeddingWeight scales cosine similarity against the normalized lexical score.
+	embeddingWeight = 0.5
+	// maxEmbeddingCache bounds cached item vectors; the cache starts over when full.
+	maxEmbeddingCache = 4096
+)
+
+// Embedder turns texts into vectors whose cosine similarity reflects meaning.
+// It returns one vector per text, all of the same length.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// HashEmbedder is a deterministic local embedder that hashes words and
+// character trigrams into a fixed number of buckets. It needs no model, so
+// tests and offline development get stable rankings, and trigrams still match
+// near spellings such as "tiramisu" and "tiramisù".
+type HashEmbedder struct {
+	dimensions int
+}
+
+// NewHashEmbedder returns a hash embedder with dimensions buckets, or a default
+// size when dimensions is not positive.
+func NewHashEmbedder(dimensions int) *HashEmbedder {
+	if dimensions <= 0 {
+		dimensions = hashEmbedderDimensionsDefault
+	}
+	return &HashEmbedder{dimensions: dimensions}
+}
+
+func (e *HashEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, e.dimensions)
+		for _, token := range retrievalTokens(text) {
+			e.add(vector, token, 1)
+			padded := []rune(" " + token + " ")
+			for j := 0; j+3 <= len(padded); j++ {
+				e.add(vector, string(padded[j:j+3]), 0.5)
+			}
+		}
+		normalize(vector)
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// add hashes feature into a bucket, using one hash bit as the sign so
+// collisions cancel out instead of piling up.
+func (e *HashEmbedder) add(vector []float32, feature string, weight float32) {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(feature))
+	sum := hash.Sum64()
+	if sum&1 == 1 {
+		weight = -weight
+	}
+	vector[(sum>>1)%uint64(len(vector))] += weight
+}
+
+// HybridRetriever adds embedding similarity to BM25, so a prompt can find
+// dishes that share no exact word with it. Item vectors are cached by item
+// text, so only new or edited dishes are embedded on later turns.
+type HybridRetriever struct {
+	embedder Embedder
+
+	mu    sync.Mutex
+	cache map[string][]float32
+}
+
+func NewHybridRetriever(embedder Embedder) *HybridRetriever {
+	return &HybridRetriever{embedder: embedder, cache: map[string][]float32{}}
+}
+
+func (r *HybridRetriever) Retrieve(ctx context.Context, query string, candidates []RetrievalCandidate, k int) ([]domain.MenuItem, error) {
+	relevance := bm25Scores(query, candidates)
+	vectors, err := r.itemVectors(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	queryVectors, err := r.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(queryVectors) != 1 {
+		return nil, fmt.Errorf("embedder returned %d vectors for 1 text", len(queryVectors))
+	}
+	preference, embedding := rankingWeights(ctx)
+	for i, vector := range vectors {
+		relevance[i] += embedding * math.Max(cosine(queryVectors[0], vector), 0)
+	}
+	return rankCandidates(candidates, relevance, preference, k), nil
+}
+
+// itemVectors returns one vector per candidate, embedding the uncached ones in
+// a single batch.
+func (r *HybridRetriever) itemVectors(ctx context.Context, candidates []RetrievalCandidate) ([][]float32, error) {
+	texts := make([]string, len(candidates))
+	vectors := make([][]float32, len(candidates))
+	var missing []string
+
+	r.mu.Lock()
+	for i, candidate := range candidates {
+		texts[i] = menuItemText(candidate.Item)
+		if vector, ok := r.cache[texts[i]]; ok {
+			vectors[i] = vector
+		} else {
+			missing = append(missing, texts[i])
+		}
+	}
+	r.mu.Unlock()
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	embedded, err := r.embedder.Embed(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	if len(embedded) != len(missing) {
+		return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(embedded), len(missing))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cache)+len(missing) > maxEmbeddingCache {
+		r.cache = map[string][]float32{}
+	}
+	for i, text := range missing {
+		r.cache[text] = embedded[i]
+	}
+	for i := range vectors {
+		if vectors[i] == nil {
+			vectors[i] = r.cache[texts[i]]
+		}
+	}
+	return vectors, nil
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+func normalize(vector []float32) {
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vector {
+		vector[i] *= scale
+	}
+}
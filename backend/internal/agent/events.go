@@ -0,0 +1,12 @@
+package agent
+
+import "github.com/gourmet-guide/backend/internal/domain"
+
+// EventPublisher receives session lifecycle, message and menu events.
+type EventPublisher interface {
+	Publish(event domain.SessionEvent) domain.SessionEvent
+}
+
+type noopPublisher struct{}
+
+func (noopPublisher) Publish(event domain.SessionEvent) domain.SessionEvent { return event }
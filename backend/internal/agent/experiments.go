@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+// ExperimentSource finds the experiment new sessions join and records what
+// sessions in an experiment do.
+type ExperimentSource interface {
+	// RunningExperiment returns the experiment new sessions of restaurantID
+	// join; ok is false when there is none.
+	RunningExperiment(ctx context.Context, restaurantID string) (experiment domain.Experiment, ok bool, err error)
+	RecordOutcome(ctx context.Context, assignment domain.ExperimentAssignment, outcome domain.ExperimentOutcome) error
+}
+
+type noExperiments struct{}
+
+func (noExperiments) RunningExperiment(context.Context, string) (domain.Experiment, bool, error) {
+	return domain.Experiment{}, false, nil
+}
+
+func (noExperiments) RecordOutcome(context.Context, domain.ExperimentAssignment, domain.ExperimentOutcome) error {
+	return nil
+}
+
+// AssignVariant buckets sessionID into one of experiment's variants by a hash
+// of the experiment and session IDs, so a session always lands in the same
+// variant and different experiments split sessions independently. Variants
+// get sessions in proportion to their weights. ok is false when no variant
+// has a positive weight.
+func AssignVariant(experiment domain.Experiment, sessionID string) (domain.ExperimentVariant, bool) {
+	total := 0
+	for _, variant := range experiment.Variants {
+		total += max(variant.Weight, 0)
+	}
+	if total == 0 {
+		return domain.ExperimentVariant{}, false
+	}
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s:%s", experiment.ID, sessionID)
+	bucket := int(hash.Sum64() % uint64(total))
+	for _, variant := range experiment.Variants {
+		if bucket < max(variant.Weight, 0) {
+			return variant, true
+		}
+		bucket -= max(variant.Weight, 0)
+	}
+	return domain.ExperimentVariant{}, false
+}
+
+type experimentVariantKey struct{}
+
+// WithExperimentVariant returns a context whose turns use variant's model,
+// retrieval K, prompt version and ranking weights where it sets them.
+func WithExperimentVariant(ctx context.Context, variant domain.ExperimentVariant) context.Context {
+	return context.WithValue(ctx, experimentVariantKey{}, variant)
+}
+
+func experimentVariant(ctx context.Context) domain.ExperimentVariant {
+	variant, _ := ctx.Value(experimentVariantKey{}).(domain.ExperimentVariant)
+	return variant
+}
+
+// rankingWeights returns the preference and embedding weights of ctx's
+// variant, or the defaults.
+func rankingWeights(ctx context.Context) (preference, embedding float64) {
+	weights := experimentVariant(ctx).Ranking
+	preference, embedding = preferenceWeight, embeddingWeight
+	if weights.Preference != nil {
+		preference = *weights.Preference
+	}
+	if weights.Embedding != nil {
+		embedding = *weights.Embedding
+	}
+	return preference, embedding
+}
+
+// model returns the model of ctx's variant, or the runtime's.
+func (r *Runtime) model(ctx context.Context) string {
+	if model := experimentVariant(ctx).Model; model != "" {
+		return model
+	}
+	return r.modelName
+}
+
+// menuLimit returns how many ranked dishes the model sees: the retrieval K of
+// ctx's variant, or the runtime's.
+func (r *Runtime) menuLimit(ctx context.Context) int {
+	if k := experimentVariant(ctx).RetrievalK; k > 0 {
+		return k
+	}
+	return r.maxMenuItems
+}
+
+// SetExperimentSource sets where experiments come from and where their
+// outcomes go; by default no session is in an experiment.
+func (s *ConciergeService) SetExperimentSource(experiments ExperimentSource) {
+	s.experiments = experiments
+}
+
+// assignExperiment puts a new session into a variant of the running
+// experiment of its restaurant, if there is one.
+func (s *ConciergeService) assignExperiment(ctx context.Context, session *domain.ConciergeSession) error {
+	experiment, ok, err := s.experiments.RunningExperiment(ctx, session.RestaurantID)
+	if err != nil || !ok {
+		return err
+	}
+	if variant, ok := AssignVariant(experiment, session.ID); ok {
+		session.Experiment = &domain.ExperimentAssignment{ExperimentID: experiment.ID, Variant: variant}
+	}
+	return nil
+}
+
+// recordOutcome counts outcome for the session's variant. A failing count
+// never fails the guest's action, so its error is ignored.
+func (s *ConciergeService) recordOutcome(ctx context.Context, session domain.ConciergeSession, outcome domain.ExperimentOutcome) {
+	if session.Experiment != nil {
+		_ = s.experiments.RecordOutcome(context.WithoutCancel(ctx), *session.Experiment, outcome)
+	}
+}
+
+// ConfirmOrder records that the guest confirmed the order built so far. The
+// order cannot be empty, and is refused with ErrSafetyRefusal when a dish on it
+// is no longer safe for the guest, e.g. after they stated a new allergy.
+// Confirming again after adding dishes updates the confirmation time but counts
+// once for the session's experiment.
+func (s *ConciergeService) ConfirmOrder(ctx context.Context, sessionID string) (domain.ConciergeSession, error) {
+	current, err := s.loadOpenSession(ctx, sessionID)
+	if err != nil {
+		return domain.ConciergeSession{}, err
+	}
+	menu, err := s.sessionMenu(ctx, current)
+	if err != nil {
+		return domain.ConciergeSession{}, err
+	}
+	engine, err := s.safetyEngine(ctx, current.RestaurantID)
+	if err != nil {
+		return domain.ConciergeSession{}, err
+	}
+	var first bool
+	session, err := s.updateOpenSession(ctx, sessionID, func(session *domain.ConciergeSession) error {
+		if !slices.ContainsFunc(session.Order, func(line domain.OrderLine) bool { return line.Quantity > 0 }) {
+			return fmt.Errorf("%w: the order is empty", ErrInvalidInput)
+		}
+		if err := checkOrderSafety(session, menu, engine); err != nil {
+			return err
+		}
+		first = session.OrderConfirmedAt == nil
+		now := time.Now().UTC()
+		session.OrderConfirmedAt = &now
+		session.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		return domain.ConciergeSession{}, err
+	}
+	if first {
+		s.recordOutcome(ctx, session, domain.OutcomeOrderConfirmed)
+	}
+	s.publishSessionEvent(domain.SessionEventOrderConfirmed, session, "", "")
+	return session, nil
+}
+
+// checkOrderSafety refuses an order holding a dish the safety policy keeps
+// from the guest, or one no longer on the menu and so impossible to check.
+func checkOrderSafety(session *domain.ConciergeSession, menu []domain.MenuItem, engine *SafetyEngine) error {
+	safeItems, _, _ := engine.Apply(menu, session.AllergyProfile(), session.PreferenceTags)
+	var refused []string
+	for _, line := range session.Order {
+		if line.Quantity > 0 && !slices.ContainsFunc(safeItems, func(item domain.MenuItem) bool { return item.ID == line.ItemID }) {
+			refused = append(refused, line.Name)
+		}
+	}
+	if len(refused) > 0 {
+		return fmt.Errorf("%w: %s cannot be confirmed for this guest's profile", ErrSafetyRefusal, strings.Join(refused, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+type fakeExperiments struct {
+	experiment domain.Experiment
+	mu         sync.Mutex
+	outcomes   []domain.ExperimentOutcome
+}
+
+func (f *fakeExperiments) RunningExperiment(context.Context, string) (domain.Experiment, bool, error) {
+	return f.experiment, f.experiment.ID != "", nil
+}
+
+func (f *fakeExperiments) RecordOutcome(_ context.Context, assignment domain.ExperimentAssignment, outcome domain.ExperimentOutcome) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if assignment.ExperimentID != f.experiment.ID {
+		return fmt.Errorf("unexpected experiment %q", assignment.ExperimentID)
+	}
+	f.outcomes = append(f.outcomes, outcome)
+	return nil
+}
+
+func (f *fakeExperiments) recorded() []domain.ExperimentOutcome {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]domain.ExperimentOutcome(nil), f.outcomes...)
+}
+
+// modelRecordingClient echoes the model input like fakeClient and remembers
+// the model each call asked for.
+type modelRecordingClient struct {
+	mu     sync.Mutex
+	models []string
+}
+
+func (c *modelRecordingClient) Generate(_ context.Context, modelName, prompt string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models = append(c.models, modelName)
+	return "ok: " + prompt, nil
+}
+
+func (c *modelRecordingClient) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(string) error) (string, error) {
+	reply, err := c.Generate(ctx, modelName, prompt)
+	if err != nil {
+		return "", err
+	}
+	return reply, onDelta(reply)
+}
+
+func newExperimentTestService(t *testing.T, client Client, experiments ExperimentSource) (*ConciergeService, gcp.SessionStore) {
+	t.Helper()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, client))
+	service.SetExperimentSource(experiments)
+	if _, err := service.SaveMenuItems(context.Background(), "rest-1", []domain.MenuItem{
+		{ID: "wrap", Name: "Veggie Wrap", Tags: []string{"vegetarian"}},
+		{ID: "tacos", Name: "Shrimp Tacos", Allergens: []domain.Allergen{domain.AllergenCrustacean}},
+		{ID: "burger", Name: "Burger"},
+	}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	return service, store
+}
+
+func TestAssignVariantIsDeterministicAndFollowsWeights(t *testing.T) {
+	t.Parallel()
+	experiment := domain.Experiment{ID: "exp-1", Variants: []domain.ExperimentVariant{
+		{Name: "control", Weight: 3},
+		{Name: "treatment", Weight: 1},
+		{Name: "off", Weight: 0},
+	}}
+	counts := map[string]int{}
+	for i := range 4000 {
+		sessionID := fmt.Sprintf("session-%d", i)
+		variant, ok := AssignVariant(experiment, sessionID)
+		if !ok {
+			t.Fatalf("expected %s to get a variant", sessionID)
+		}
+		if again, _ := AssignVariant(experiment, sessionID); again.Name != variant.Name {
+			t.Fatalf("expected %s to keep variant %s, got %s", sessionID, variant.Name, again.Name)
+		}
+		counts[variant.Name]++
+	}
+	if counts["off"] != 0 || counts["control"] < 2800 || counts["control"] > 3200 {
+		t.Fatalf("expected a 3:1 split without the zero-weight variant, got %v", counts)
+	}
+	if _, ok := AssignVariant(domain.Experiment{ID: "exp-2", Variants: []domain.ExperimentVariant{{Name: "off"}}}, "session-1"); ok {
+		t.Fatal("expected no variant without positive weights")
+	}
+}
+
+func TestSessionsUseTheSettingsOfTheirVariant(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := &modelRecordingClient{}
+	experiments := &fakeExperiments{experiment: domain.Experiment{ID: "exp-1", Variants: []domain.ExperimentVariant{
+		{Name: "control"},
+		{Name: "short-menu", Weight: 1, PromptVersion: "concierge-v1", RetrievalK: 1, Model: "gemini-experimental"},
+	}}}
+	service, store := newExperimentTestService(t, client, experiments)
+
+	session, err := service.StartSession(ctx, "rest-1", shellfishAllergy, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if session.Experiment == nil || session.Experiment.ExperimentID != "exp-1" || session.Experiment.Variant.Name != "short-menu" {
+		t.Fatalf("expected the session to be assigned, got %+v", session.Experiment)
+	}
+	stored, _ := service.GetSession(ctx, session.ID)
+	if stored.Experiment == nil || stored.Experiment.Variant.Model != "gemini-experimental" {
+		t.Fatalf("expected the assignment to be stored, got %+v", stored.Experiment)
+	}
+
+	// The client echoes the model input.
+	reply, err := service.SendMessage(ctx, session.ID, "Something light")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if options := menuOptions(reply.Text); len(options) != 1 {
+		t.Fatalf("expected the variant's retrieval K, got %v", options)
+	}
+	if len(client.models) != 1 || client.models[0] != "gemini-experimental" {
+		t.Fatalf("expected the variant's model, got %v", client.models)
+	}
+	transcript, _ := store.LoadTranscript(ctx, session.ID)
+	if len(transcript) != 2 || transcript[1].PromptVersion != "concierge-v1" {
+		t.Fatalf("expected the variant's prompt version, got %+v", transcript)
+	}
+}
+
+func TestExperimentOutcomesAreRecordedPerSession(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := &scriptedToolClient{steps: []ModelTurn{
+		{Calls: []ToolCall{toolCall("add_to_order", `{"itemId":"wrap"}`)}},
+		{Text: "I added a Veggie Wrap."},
+	}}
+	experiments := &fakeExperiments{experiment: domain.Experiment{ID: "exp-1", Variants: []domain.ExperimentVariant{{Name: "control", Weight: 1}}}}
+	service, _ := newExperimentTestService(t, client, experiments)
+
+	ordered, err := service.StartSession(ctx, "rest-1", shellfishAllergy, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if _, err := service.ConfirmOrder(ctx, ordered.ID); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected an empty order to be rejected, got %v", err)
+	}
+	if _, err := service.SendMessage(ctx, ordered.ID, "Add a wrap"); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	for range 2 {
+		confirmed, err := service.ConfirmOrder(ctx, ordered.ID)
+		if err != nil || confirmed.OrderConfirmedAt == nil {
+			t.Fatalf("expected the order to be confirmed, got %+v (%v)", confirmed, err)
+		}
+	}
+	if err := service.EndSession(ctx, ordered.ID); err != nil {
+		t.Fatalf("end session: %v", err)
+	}
+
+	abandoned, err := service.StartSession(ctx, "rest-1", nil, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if err := service.EndSession(ctx, abandoned.ID); err != nil {
+		t.Fatalf("end session: %v", err)
+	}
+
+	want := []domain.ExperimentOutcome{
+		domain.OutcomeSessionStarted, domain.OutcomeItemAdded, domain.OutcomeOrderConfirmed,
+		domain.OutcomeSessionStarted, domain.OutcomeSessionAbandoned,
+	}
+	if got := experiments.recorded(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected outcomes %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,382 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Model providers selectable from configuration.
+const (
+	ModelProviderEcho     = "echo"
+	ModelProviderTemplate = "template"
+	ModelProviderGemini   = "gemini"
+	ModelProviderVertex   = "vertex"
+)
+
+const (
+	geminiAPIBaseURL         = "https://generativelanguage.googleapis.com"
+	geminiMaxOutputTokens    = 256
+	geminiRequestTimeout     = 60 * time.Second
+	geminiMaxStreamLineBytes = 1 << 20
+)
+
+// GeminiBackend selects the API a GeminiClient talks to.
+type GeminiBackend string
+
+const (
+	// GeminiBackendAPIKey is the Gemini Developer API, authenticated with an API key.
+	GeminiBackendAPIKey GeminiBackend = "apikey"
+	// GeminiBackendVertex is Vertex AI, authenticated by the HTTP client.
+	GeminiBackendVertex GeminiBackend = "vertex"
+)
+
+// GeminiConfig configures a GeminiClient. BaseURL replaces the public endpoint,
+// for example with a local fake server; HTTPClient carries Vertex credentials.
+type GeminiConfig struct {
+	Backend         GeminiBackend
+	APIKey          string
+	Project         string
+	Location        string
+	BaseURL         string
+	HTTPClient      *http.Client
+	MaxOutputTokens int
+}
+
+// GeminiAPIError is a non-2xx answer from the Gemini API.
+type GeminiAPIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *GeminiAPIError) Error() string {
+	if e.Status != "" {
+		return fmt.Sprintf("gemini api status %d (%s): %s", e.StatusCode, e.Status, e.Message)
+	}
+	return fmt.Sprintf("gemini api status %d: %s", e.StatusCode, e.Message)
+}
+
+// GeminiClient calls the generateContent REST API directly, so the same client
+// works against the Developer API, Vertex AI and a fake server in tests. It
+// supports streaming and tool calling.
+type GeminiClient struct {
+	backend         GeminiBackend
+	apiKey          string
+	project         string
+	location        string
+	baseURL         string
+	http            *http.Client
+	maxOutputTokens int
+}
+
+func NewGeminiClient(cfg GeminiConfig) (*GeminiClient, error) {
+	client := &GeminiClient{
+		backend:         cfg.Backend,
+		apiKey:          cfg.APIKey,
+		project:         cfg.Project,
+		location:        cfg.Location,
+		baseURL:         strings.TrimRight(cfg.BaseURL, "/"),
+		http:            cfg.HTTPClient,
+		maxOutputTokens: cfg.MaxOutputTokens,
+	}
+	switch cfg.Backend {
+	case GeminiBackendAPIKey:
+		if strings.TrimSpace(cfg.APIKey) == "" {
+			return nil, errors.New("gemini: the API key backend needs GOOGLE_API_KEY")
+		}
+		if client.baseURL == "" {
+			client.baseURL = geminiAPIBaseURL
+		}
+	case GeminiBackendVertex:
+		if strings.TrimSpace(cfg.Project) == "" || strings.TrimSpace(cfg.Location) == "" {
+			return nil, errors.New("gemini: the Vertex backend needs a project and location")
+		}
+		if client.baseURL == "" {
+			client.baseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com", cfg.Location)
+		}
+	default:
+		return nil, fmt.Errorf("gemini: unknown backend %q", cfg.Backend)
+	}
+	if client.http == nil {
+		client.http = &http.Client{Timeout: geminiRequestTimeout}
+	}
+	if client.maxOutputTokens <= 0 {
+		client.maxOutputTokens = geminiMaxOutputTokens
+	}
+	return client, nil
+}
+
+// NewModelClient returns the client for provider: "echo" for the offline stub,
+// "template" for the offline TemplateResponder, "gemini" for the Developer API
+// or "vertex" for Vertex AI. Vertex uses Application Default Credentials
+// unless gemini carries an HTTP client, and needs the gcp build tag for them.
+func NewModelClient(ctx context.Context, provider string, gemini GeminiConfig) (Client, error) {
+	switch provider {
+	case "", ModelProviderEcho:
+		return newDefaultClient(), nil
+	case ModelProviderTemplate:
+		return NewTemplateResponder(), nil
+	case ModelProviderGemini:
+		gemini.Backend = GeminiBackendAPIKey
+		return NewGeminiClient(gemini)
+	case ModelProviderVertex:
+		gemini.Backend = GeminiBackendVertex
+		if gemini.HTTPClient == nil {
+			httpClient, err := vertexHTTPClient(ctx)
+			if err != nil {
+				return nil, err
+			}
+			gemini.HTTPClient = httpClient
+		}
+		return NewGeminiClient(gemini)
+	default:
+		return nil, fmt.Errorf("unknown model provider %q", provider)
+	}
+}
+
+// geminiContent, geminiPart and the types below mirror the generateContent
+// JSON schema, keeping only the fields the client uses.
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []ToolDeclaration `json:"functionDeclarations"`
+}
+
+type geminiRequest struct {
+	Contents         []geminiContent `json:"contents"`
+	Tools            []geminiTool    `json:"tools,omitempty"`
+	GenerationConfig struct {
+		MaxOutputTokens int `json:"maxOutputTokens"`
+	} `json:"generationConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason,omitempty"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason,omitempty"`
+	} `json:"promptFeedback"`
+}
+
+func (g *GeminiClient) Generate(ctx context.Context, modelName, prompt string) (string, error) {
+	turn, err := g.generate(ctx, modelName, g.request(ctx, textContents(prompt), nil))
+	if err != nil {
+		return "", err
+	}
+	return turn.Text, nil
+}
+
+func (g *GeminiClient) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(delta string) error) (string, error) {
+	body, err := json.Marshal(g.request(ctx, textContents(prompt), nil))
+	if err != nil {
+		return "", err
+	}
+	resp, err := g.post(ctx, modelName, "streamGenerateContent?alt=sse", body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var reply strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), geminiMaxStreamLineBytes)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+			return "", fmt.Errorf("decode gemini stream chunk: %w", err)
+		}
+		turn, err := chunk.turn()
+		if err != nil {
+			return "", err
+		}
+		if turn.Text == "" {
+			continue
+		}
+		if err := onDelta(turn.Text); err != nil {
+			return "", err
+		}
+		reply.WriteString(turn.Text)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return reply.String(), nil
+}
+
+func (g *GeminiClient) GenerateWithTools(ctx context.Context, modelName string, conversation []ToolMessage, tools []ToolDeclaration) (ModelTurn, error) {
+	contents := make([]geminiContent, 0, len(conversation))
+	for _, message := range conversation {
+		content, err := toolMessageContent(message)
+		if err != nil {
+			return ModelTurn{}, err
+		}
+		contents = append(contents, content)
+	}
+	return g.generate(ctx, modelName, g.request(ctx, contents, tools))
+}
+
+// request builds a generateContent body. The output cap set on ctx with
+// WithMaxOutputTokens wins over the client's own.
+func (g *GeminiClient) request(ctx context.Context, contents []geminiContent, tools []ToolDeclaration) geminiRequest {
+	request := geminiRequest{Contents: contents}
+	request.GenerationConfig.MaxOutputTokens = maxOutputTokens(ctx, g.maxOutputTokens)
+	if len(tools) > 0 {
+		request.Tools = []geminiTool{{FunctionDeclarations: tools}}
+	}
+	return request
+}
+
+func (g *GeminiClient) generate(ctx context.Context, modelName string, request geminiRequest) (ModelTurn, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return ModelTurn{}, err
+	}
+	resp, err := g.post(ctx, modelName, "generateContent", body)
+	if err != nil {
+		return ModelTurn{}, err
+	}
+	defer resp.Body.Close()
+
+	var response geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return ModelTurn{}, fmt.Errorf("decode gemini response: %w", err)
+	}
+	return response.turn()
+}
+
+// post sends body to method on modelName and returns the response once it has
+// a 2xx status; other statuses become a *GeminiAPIError.
+func (g *GeminiClient) post(ctx context.Context, modelName, method string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.modelURL(modelName)+":"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.backend == GeminiBackendAPIKey {
+		req.Header.Set("x-goog-api-key", g.apiKey)
+	}
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	apiErr := &GeminiAPIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(respBody))}
+	var envelope struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(respBody, &envelope) == nil && envelope.Error.Message != "" {
+		apiErr.Message, apiErr.Status = envelope.Error.Message, envelope.Error.Status
+	}
+	return nil, apiErr
+}
+
+func (g *GeminiClient) modelURL(modelName string) string {
+	if g.backend == GeminiBackendVertex {
+		return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/google/models/%s", g.baseURL, g.project, g.location, modelName)
+	}
+	return fmt.Sprintf("%s/v1beta/models/%s", g.baseURL, modelName)
+}
+
+// turn reads the first candidate: its text, skipping thought parts, and any
+// function calls.
+func (r geminiResponse) turn() (ModelTurn, error) {
+	if len(r.Candidates) == 0 {
+		if r.PromptFeedback.BlockReason != "" {
+			return ModelTurn{}, fmt.Errorf("gemini blocked the prompt: %s", r.PromptFeedback.BlockReason)
+		}
+		return ModelTurn{}, nil
+	}
+	var turn ModelTurn
+	var text strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			turn.Calls = append(turn.Calls, ToolCall{ID: part.FunctionCall.ID, Name: part.FunctionCall.Name, Arguments: part.FunctionCall.Args})
+		case !part.Thought:
+			text.WriteString(part.Text)
+		}
+	}
+	turn.Text = text.String()
+	return turn, nil
+}
+
+func textContents(prompt string) []geminiContent {
+	return []geminiContent{{Role: "user", Parts: []geminiPart{{Text: prompt}}}}
+}
+
+// toolMessageContent maps a tool conversation entry onto Gemini's roles: tool
+// results go back as user function responses, which must be JSON objects.
+func toolMessageContent(message ToolMessage) (geminiContent, error) {
+	switch message.Role {
+	case ToolRoleUser:
+		return geminiContent{Role: "user", Parts: []geminiPart{{Text: message.Text}}}, nil
+	case ToolRoleModel:
+		content := geminiContent{Role: "model"}
+		if message.Text != "" {
+			content.Parts = append(content.Parts, geminiPart{Text: message.Text})
+		}
+		for _, call := range message.Calls {
+			content.Parts = append(content.Parts, geminiPart{FunctionCall: &geminiFunctionCall{ID: call.ID, Name: call.Name, Args: call.Arguments}})
+		}
+		return content, nil
+	case ToolRoleTool:
+		content := geminiContent{Role: "user"}
+		for _, result := range message.Results {
+			response := result.Content
+			if !bytes.HasPrefix(bytes.TrimSpace(response), []byte("{")) {
+				wrapped, err := json.Marshal(map[string]json.RawMessage{"result": response})
+				if err != nil {
+					return geminiContent{}, err
+				}
+				response = wrapped
+			}
+			content.Parts = append(content.Parts, geminiPart{FunctionResponse: &geminiFunctionResponse{ID: result.CallID, Name: result.Name, Response: response}})
+		}
+		return content, nil
+	default:
+		return geminiContent{}, fmt.Errorf("unknown tool message role %q", message.Role)
+	}
+}
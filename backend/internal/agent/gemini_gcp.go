@@ -4,28 +4,13 @@ package agent
 
 import (
 	"context"
+	"net/http"
 
-	"github.com/google/generative-ai-go/genai"
+	"golang.org/x/oauth2/google"
 )
 
-type geminiClient struct {
-	client *genai.Client
-}
-
-// newGeminiClient configures Google GenAI SDK to route traffic through Vertex AI.
-func newGeminiClient(ctx context.Context) (Client, error) {
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{Backend: genai.BackendVertexAI})
-	if err != nil {
-		return nil, err
-	}
-	return &geminiClient{client: client}, nil
-}
-
-func (g *geminiClient) Generate(ctx context.Context, modelName, prompt string) (string, error) {
-	config := &genai.GenerateContentConfig{MaxOutputTokens: 256}
-	res, err := g.client.Models.GenerateContent(ctx, modelName, genai.Text(prompt), config)
-	if err != nil {
-		return "", err
-	}
-	return res.Text(), nil
+// vertexHTTPClient authenticates Vertex AI calls with Application Default
+// Credentials.
+func vertexHTTPClient(ctx context.Context) (*http.Client, error) {
+	return google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
 }
@@ -0,0 +1,15 @@
+//go:build !gcp
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// vertexHTTPClient is unavailable without the gcp build tag, which brings in
+// Application Default Credentials.
+func vertexHTTPClient(_ context.Context) (*http.Client, error) {
+	return nil, errors.New("gcp build tag required for Vertex AI credentials")
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/agent/geminitest"
+)
+
+func newFakeGemini(t *testing.T, recordings ...geminitest.Recording) (*geminitest.Server, *GeminiClient) {
+	t.Helper()
+	server := geminitest.NewServer(recordings...)
+	t.Cleanup(server.Close)
+	client, err := NewGeminiClient(GeminiConfig{Backend: GeminiBackendAPIKey, APIKey: "test-key", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("new gemini client: %v", err)
+	}
+	return server, client
+}
+
+func TestGeminiClientGeneratesAndStreams(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client := newFakeGemini(t, geminitest.Text("Try the ", "Veggie Wrap."), geminitest.Text("Try the ", "Veggie Wrap."))
+
+	reply, err := client.Generate(ctx, "gemini-2.5-flash", "What is light?")
+	if err != nil || reply != "Try the Veggie Wrap." {
+		t.Fatalf("expected the merged reply, got %q (%v)", reply, err)
+	}
+	var deltas []string
+	reply, err = client.GenerateStream(ctx, "gemini-2.5-flash", "What is light?", func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil || reply != "Try the Veggie Wrap." || len(deltas) != 2 || deltas[0] != "Try the " {
+		t.Fatalf("expected two streamed chunks, got %q (%v)", deltas, err)
+	}
+
+	requests := server.Requests()
+	if requests[0].Path != "/v1beta/models/gemini-2.5-flash:generateContent" || requests[1].Path != "/v1beta/models/gemini-2.5-flash:streamGenerateContent" || requests[1].Query != "alt=sse" {
+		t.Fatalf("unexpected request paths: %+v", requests)
+	}
+	if requests[0].Header.Get("x-goog-api-key") != "test-key" || strings.Contains(requests[0].Query, "test-key") {
+		t.Fatalf("expected the API key in a header only, got %+v", requests[0])
+	}
+	var body geminiRequest
+	if err := json.Unmarshal(requests[0].Body, &body); err != nil {
+		t.Fatalf("decode request: %v", err)
+	}
+	if body.Contents[0].Role != "user" || body.Contents[0].Parts[0].Text != "What is light?" || body.GenerationConfig.MaxOutputTokens != geminiMaxOutputTokens || body.Tools != nil {
+		t.Fatalf("unexpected request body: %s", requests[0].Body)
+	}
+}
+
+func TestGeminiClientUsesVertexPaths(t *testing.T) {
+	t.Parallel()
+	server := geminitest.NewServer(geminitest.Text("ok"))
+	t.Cleanup(server.Close)
+	client, err := NewModelClient(context.Background(), ModelProviderVertex, GeminiConfig{Project: "demo", Location: "us-central1", BaseURL: server.URL, HTTPClient: server.Client()})
+	if err != nil {
+		t.Fatalf("new model client: %v", err)
+	}
+	if _, err := client.Generate(context.Background(), "gemini-2.5-flash", "hi"); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	request := server.Requests()[0]
+	if request.Path != "/v1/projects/demo/locations/us-central1/publishers/google/models/gemini-2.5-flash:generateContent" || request.Header.Get("x-goog-api-key") != "" {
+		t.Fatalf("unexpected vertex request: %+v", request)
+	}
+}
+
+func TestGeminiClientReportsAPIErrors(t *testing.T) {
+	t.Parallel()
+	_, client := newFakeGemini(t, geminitest.Error(http.StatusTooManyRequests, "Resource has been exhausted"))
+
+	_, err := client.Generate(context.Background(), "gemini-2.5-flash", "hi")
+	var apiErr *GeminiAPIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Message != "Resource has been exhausted" {
+		t.Fatalf("expected a 429 GeminiAPIError, got %v", err)
+	}
+	if _, err := client.Generate(context.Background(), "gemini-2.5-flash", "hi"); err == nil {
+		t.Fatal("expected an error once the recordings run out")
+	}
+}
+
+func TestNewModelClientSelectsProviders(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	if client, err := NewModelClient(ctx, ModelProviderEcho, GeminiConfig{}); err != nil || client == nil {
+		t.Fatalf("expected the echo client, got %v", err)
+	}
+	if _, err := NewModelClient(ctx, ModelProviderGemini, GeminiConfig{}); err == nil {
+		t.Fatal("expected the gemini provider to require an API key")
+	}
+	if _, err := NewModelClient(ctx, ModelProviderVertex, GeminiConfig{Location: "us-central1", HTTPClient: http.DefaultClient}); err == nil {
+		t.Fatal("expected the vertex provider to require a project")
+	}
+	if _, err := NewModelClient(ctx, "openai", GeminiConfig{}); err == nil {
+		t.Fatal("expected unknown providers to be rejected")
+	}
+	client, err := NewModelClient(ctx, ModelProviderGemini, GeminiConfig{APIKey: "key"})
+	if err != nil {
+		t.Fatalf("new model client: %v", err)
+	}
+	if _, ok := client.(ToolClient); !ok {
+		t.Fatal("expected the gemini client to support tool calling")
+	}
+}
+
+func TestConciergeRunsRecordedGeminiToolSession(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	recordings, err := geminitest.Load(filepath.Join("testdata", "gemini", "order_wrap.json"))
+	if err != nil {
+		t.Fatalf("load recordings: %v", err)
+	}
+	server, client := newFakeGemini(t, recordings...)
+	service, session, _ := newToolTestService(t, client, shellfishAllergy)
+
+	reply, err := service.SendMessage(ctx, session.ID, "Order me something light")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !strings.HasPrefix(reply.Text, "I added a Veggie Wrap to your order. Enjoy your meal!") || strings.Contains(reply.Text, "guest wants") {
+		t.Fatalf("expected the final answer without thoughts, got %q", reply.Text)
+	}
+	if len(reply.ToolCalls) != 2 || reply.ToolCalls[1].Error != "" {
+		t.Fatalf("expected two successful tool calls, got %+v", reply.ToolCalls)
+	}
+	updated, _ := service.GetSession(ctx, session.ID)
+	if len(updated.Order) != 1 || updated.Order[0].ItemID != "wrap" {
+		t.Fatalf("expected the wrap on the order, got %+v", updated.Order)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("expected three model calls, got %d", len(requests))
+	}
+	var first, last geminiRequest
+	_ = json.Unmarshal(requests[0].Body, &first)
+	_ = json.Unmarshal(requests[2].Body, &last)
+	if len(first.Tools) != 1 || len(first.Tools[0].FunctionDeclarations) != 5 {
+		t.Fatalf("expected the tool declarations, got %s", requests[0].Body)
+	}
+	if len(last.Contents) != 5 || last.Contents[3].Parts[0].FunctionCall.Name != "add_to_order" {
+		t.Fatalf("expected the call history to be replayed, got %s", requests[2].Body)
+	}
+	response := last.Contents[4].Parts[0].FunctionResponse
+	if last.Contents[4].Role != "user" || response == nil || response.Name != "add_to_order" || !strings.Contains(string(response.Response), `"order"`) {
+		t.Fatalf("expected the order result as a function response, got %s", requests[2].Body)
+	}
+}
@@ -0,0 +1,196 @@
+// Package geminitest runs an in-process fake of the Gemini generateContent
+// API that replays recorded responses, so clients can be tested offline.
+package geminitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Recording is one recorded answer. Chunks are generateContent response
+// bodies: a streaming request gets each as a server-sent event, and a plain
+// request gets them merged into one response. A Status of 400 or above is sent
+// with the first chunk as the error body instead.
+type Recording struct {
+	Status int               `json:"status,omitempty"`
+	Chunks []json.RawMessage `json:"chunks"`
+}
+
+// Request is what the server received for one call.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   json.RawMessage
+}
+
+// Server replays its recordings in order, one per request. Requests past the
+// last recording get a 500 error.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	recordings []Recording
+	requests   []Request
+}
+
+// NewServer starts a server replaying recordings. Close it when done.
+func NewServer(recordings ...Recording) *Server {
+	s := &Server{recordings: recordings}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Load reads recordings from a JSON file holding an array of Recording.
+func Load(path string) ([]Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var recordings []Recording
+	if err := json.Unmarshal(data, &recordings); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return recordings, nil
+}
+
+// Text returns a recording of a single text reply, split into chunks when
+// more than one piece is given.
+func Text(pieces ...string) Recording {
+	recording := Recording{}
+	for _, piece := range pieces {
+		recording.Chunks = append(recording.Chunks, response(map[string]any{"text": piece}))
+	}
+	return recording
+}
+
+// FunctionCall returns a recording of the model calling name with args.
+func FunctionCall(name string, args any) Recording {
+	return Recording{Chunks: []json.RawMessage{response(map[string]any{"functionCall": map[string]any{"name": name, "args": args}})}}
+}
+
+// Error returns a recording of an API error with status and message.
+func Error(status int, message string) Recording {
+	body, _ := json.Marshal(map[string]any{"error": map[string]any{"code": status, "message": message, "status": http.StatusText(status)}})
+	return Recording{Status: status, Chunks: []json.RawMessage{body}}
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header.Clone(), Body: body})
+	var recording Recording
+	ok := len(s.recordings) > 0
+	if ok {
+		recording, s.recordings = s.recordings[0], s.recordings[1:]
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeJSON(w, http.StatusInternalServerError, Error(http.StatusInternalServerError, "geminitest: no recorded response left").Chunks[0])
+	case recording.Status >= http.StatusBadRequest:
+		var errorBody json.RawMessage
+		if len(recording.Chunks) > 0 {
+			errorBody = recording.Chunks[0]
+		}
+		writeJSON(w, recording.Status, errorBody)
+	case strings.HasSuffix(r.URL.Path, ":streamGenerateContent"):
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher, _ := w.(http.Flusher)
+		for _, chunk := range recording.Chunks {
+			fmt.Fprintf(w, "data: %s\r\n\r\n", compact(chunk))
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	default:
+		merged, err := merge(recording.Chunks)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, Error(http.StatusInternalServerError, err.Error()).Chunks[0])
+			return
+		}
+		writeJSON(w, http.StatusOK, merged)
+	}
+}
+
+// candidateChunk is the slice of a response the merge needs; other fields of
+// the first chunk are dropped.
+type candidateChunk struct {
+	Candidates []struct {
+		Content struct {
+			Role  string            `json:"role,omitempty"`
+			Parts []json.RawMessage `json:"parts"`
+		} `json:"content"`
+		FinishReason string `json:"finishReason,omitempty"`
+	} `json:"candidates"`
+	UsageMetadata json.RawMessage `json:"usageMetadata,omitempty"`
+}
+
+// merge joins the parts of each chunk's first candidate, the way a client
+// reading the whole stream would see them.
+func merge(chunks []json.RawMessage) (json.RawMessage, error) {
+	if len(chunks) == 1 {
+		return chunks[0], nil
+	}
+	var merged candidateChunk
+	for i, raw := range chunks {
+		var chunk candidateChunk
+		if err := json.Unmarshal(raw, &chunk); err != nil {
+			return nil, fmt.Errorf("geminitest: chunk %d: %w", i, err)
+		}
+		if len(chunk.Candidates) == 0 {
+			continue
+		}
+		parts := chunk.Candidates[0].Content.Parts
+		if len(merged.Candidates) == 0 {
+			merged.Candidates = chunk.Candidates[:1]
+			merged.Candidates[0].Content.Parts = nil
+		}
+		merged.Candidates[0].Content.Parts = append(merged.Candidates[0].Content.Parts, parts...)
+		if chunk.Candidates[0].FinishReason != "" {
+			merged.Candidates[0].FinishReason = chunk.Candidates[0].FinishReason
+		}
+		if len(chunk.UsageMetadata) > 0 {
+			merged.UsageMetadata = chunk.UsageMetadata
+		}
+	}
+	return json.Marshal(merged)
+}
+
+func response(part map[string]any) json.RawMessage {
+	body, _ := json.Marshal(map[string]any{
+		"candidates": []any{map[string]any{"content": map[string]any{"role": "model", "parts": []any{part}}}},
+	})
+	return body
+}
+
+// compact puts a recorded chunk on one line, as each event must be.
+func compact(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+func writeJSON(w http.ResponseWriter, status int, body json.RawMessage) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
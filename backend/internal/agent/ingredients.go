@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+// allergenKeywords maps words that commonly reveal an allergen in a dish's name
+// or description. Matches feed the mismatch report and the mentioned
+// exposure, which holds a dish back from guests with that allergy, so a
+// keyword that is too loose hides safe dishes.
+var allergenKeywords = map[domain.Allergen][]string{
+	domain.AllergenPeanut:     {"peanut", "satay", "groundnut"},
+	domain.AllergenTreeNut:    {"almond", "cashew", "walnut", "pecan", "pistachio", "hazelnut", "macadamia"},
+	domain.AllergenDairy:      {"milk", "buttermilk", "cheese", "butter", "cream", "yogurt", "yoghurt", "ghee", "paneer"},
+	domain.AllergenEgg:        {"egg", "mayonnaise", "aioli", "meringue"},
+	domain.AllergenFish:       {"fish", "salmon", "tuna", "anchovy", "anchovies", "cod"},
+	domain.AllergenShellfish:  {"shellfish"},
+	domain.AllergenCrustacean: {"shrimp", "prawn", "crab", "lobster", "crayfish", "langoustine"},
+	domain.AllergenMollusc:    {"mussel", "clam", "oyster", "scallop", "squid", "calamari", "octopus", "snail"},
+	domain.AllergenSoy:        {"soy", "tofu", "miso", "edamame", "tempeh"},
+	domain.AllergenWheat:      {"wheat", "flour", "bread", "breadcrumb", "pasta", "noodle", "seitan", "couscous"},
+	domain.AllergenBarley:     {"barley", "malt"},
+	domain.AllergenRye:        {"rye bread", "rye flour", "pumpernickel"},
+	domain.AllergenOats:       {"oat", "oatmeal", "porridge", "granola"},
+	domain.AllergenSesame:     {"sesame", "tahini", "hummus", "furikake"},
+	domain.AllergenMustard:    {"mustard", "dijon"},
+	domain.AllergenCelery:     {"celery", "celeriac"},
+	domain.AllergenLupin:      {"lupin"},
+	domain.AllergenSulphites:  {"wine", "sulphite", "sulfite", "dried apricot"},
+}
+
+// allergenKeywordPatterns matches each keyword as a whole word with an
+// optional plural, so "egg" finds "eggs" but not "eggplant".
+var allergenKeywordPatterns = func() map[domain.Allergen][]*regexp.Regexp {
+	patterns := make(map[domain.Allergen][]*regexp.Regexp, len(allergenKeywords))
+	for allergen, keywords := range allergenKeywords {
+		for _, keyword := range keywords {
+			patterns[allergen] = append(patterns[allergen], dishNamePattern(keyword))
+		}
+	}
+	return patterns
+}()
+
+// DeriveMenuAllergens computes each item's allergens from its ingredients,
+// following sub-ingredients recursively. Unknown ingredient IDs and cyclic
+// sub-ingredients are reported as invalid input.
+func DeriveMenuAllergens(items []domain.MenuItem, catalog []domain.Ingredient) ([]domain.MenuItem, error) {
+	byID := make(map[string]domain.Ingredient, len(catalog))
+	for _, ingredient := range catalog {
+		byID[ingredient.ID] = ingredient
+	}
+	derived := make([]domain.MenuItem, len(items))
+	for i, item := range items {
+		contains := map[domain.Allergen]struct{}{}
+		mayContain := map[domain.Allergen]struct{}{}
+		for _, id := range item.IngredientIDs {
+			if err := collectIngredientAllergens(id, byID, contains, mayContain, map[string]bool{}); err != nil {
+				return nil, fmt.Errorf("%w: menu item %q: %v", ErrInvalidInput, item.ID, err)
+			}
+		}
+		for allergen := range contains {
+			delete(mayContain, allergen)
+		}
+		item.DerivedAllergens = sortedAllergens(contains)
+		item.DerivedCrossContaminationRisk = sortedAllergens(mayContain)
+		derived[i] = item
+	}
+	return derived, nil
+}
+
+// ValidateIngredientCatalog checks that every sub-ingredient exists and that
+// no ingredient contains itself.
+func ValidateIngredientCatalog(catalog []domain.Ingredient) error {
+	byID := make(map[string]domain.Ingredient, len(catalog))
+	for _, ingredient := range catalog {
+		byID[ingredient.ID] = ingredient
+	}
+	for _, ingredient := range catalog {
+		if err := collectIngredientAllergens(ingredient.ID, byID, map[domain.Allergen]struct{}{}, map[domain.Allergen]struct{}{}, map[string]bool{}); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+	}
+	return nil
+}
+
+func collectIngredientAllergens(id string, byID map[string]domain.Ingredient, contains, mayContain map[domain.Allergen]struct{}, visiting map[string]bool) error {
+	ingredient, ok := byID[id]
+	if !ok {
+		return fmt.Errorf("unknown ingredient %q", id)
+	}
+	if visiting[id] {
+		return fmt.Errorf("ingredient %q contains itself", id)
+	}
+	visiting[id] = true
+	defer delete(visiting, id)
+	for _, allergen := range ingredient.Allergens {
+		contains[allergen] = struct{}{}
+	}
+	for _, allergen := range ingredient.MayContain {
+		mayContain[allergen] = struct{}{}
+	}
+	for _, sub := range ingredient.SubIngredientIDs {
+		if err := collectIngredientAllergens(sub, byID, contains, mayContain, visiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindAllergenMismatches lists items whose declaration disagrees with their
+// ingredients or with allergens their name or description mentions. Related
+// allergens count as agreeing (declared shellfish covers a shrimp ingredient),
+// and text mentions are only reported for allergens regulated in jurisdiction.
+func FindAllergenMismatches(items []domain.MenuItem, jurisdiction domain.Jurisdiction) []domain.AllergenMismatch {
+	var mismatches []domain.AllergenMismatch
+	for _, item := range items {
+		declared := allergenSetOf(item.Allergens)
+		derived := allergenSetOf(item.DerivedAllergens)
+		mismatch := domain.AllergenMismatch{ItemID: item.ID, Name: item.Name}
+		for allergen := range derived {
+			if !coveredBy(allergen, declared) {
+				mismatch.Undeclared = append(mismatch.Undeclared, allergen)
+			}
+		}
+		if len(item.IngredientIDs) > 0 {
+			for allergen := range declared {
+				if !coveredBy(allergen, derived) {
+					mismatch.Unsupported = append(mismatch.Unsupported, allergen)
+				}
+			}
+		}
+		for _, allergen := range mentionedAllergens(item) {
+			if definition, ok := domain.LookupAllergen(allergen); ok && !definition.RegulatedIn(jurisdiction) {
+				continue
+			}
+			if !coveredBy(allergen, declared) && !coveredBy(allergen, derived) {
+				mismatch.Mentioned = append(mismatch.Mentioned, allergen)
+			}
+		}
+		if len(mismatch.Undeclared)+len(mismatch.Unsupported)+len(mismatch.Mentioned) == 0 {
+			continue
+		}
+		sortAllergens(mismatch.Undeclared)
+		sortAllergens(mismatch.Unsupported)
+		sortAllergens(mismatch.Mentioned)
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches
+}
+
+// effectiveAllergens is what safety filtering relies on: declared and derived
+// allergens together, so a missing declaration never makes a dish look safe.
+func effectiveAllergens(item domain.MenuItem) []domain.Allergen {
+	return mergeAllergens(item.Allergens, item.DerivedAllergens)
+}
+
+func effectiveCrossContaminationRisk(item domain.MenuItem) []domain.Allergen {
+	return mergeAllergens(item.CrossContaminationRisk, item.DerivedCrossContaminationRisk)
+}
+
+func coveredBy(allergen domain.Allergen, set map[domain.Allergen]struct{}) bool {
+	for _, related := range domain.RelatedAllergens(allergen) {
+		if _, ok := set[related]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func mentionedAllergens(item domain.MenuItem) []domain.Allergen {
+	text := item.Name + " " + item.Description
+	mentioned := map[domain.Allergen]struct{}{}
+	for allergen, patterns := range allergenKeywordPatterns {
+		for _, pattern := range patterns {
+			if pattern.MatchString(text) {
+				mentioned[allergen] = struct{}{}
+				break
+			}
+		}
+	}
+	return sortedAllergens(mentioned)
+}
+
+func mergeAllergens(lists ...[]domain.Allergen) []domain.Allergen {
+	set := map[domain.Allergen]struct{}{}
+	for _, list := range lists {
+		for _, allergen := range list {
+			set[allergen] = struct{}{}
+		}
+	}
+	return sortedAllergens(set)
+}
+
+func allergenSetOf(list []domain.Allergen) map[domain.Allergen]struct{} {
+	set := make(map[domain.Allergen]struct{}, len(list))
+	for _, allergen := range list {
+		set[allergen] = struct{}{}
+	}
+	return set
+}
+
+func sortedAllergens(set map[domain.Allergen]struct{}) []domain.Allergen {
+	if len(set) == 0 {
+		return nil
+	}
+	list := make([]domain.Allergen, 0, len(set))
+	for allergen := range set {
+		list = append(list, allergen)
+	}
+	sortAllergens(list)
+	return list
+}
+
+func sortAllergens(list []domain.Allergen) {
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+}
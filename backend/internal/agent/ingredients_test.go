@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+func TestDeriveMenuAllergensFollowsSubIngredients(t *testing.T) {
+	t.Parallel()
+	catalog := []domain.Ingredient{
+		{ID: "satay", Name: "Satay sauce", SubIngredientIDs: []string{"peanut-butter", "soy-sauce"}},
+		{ID: "peanut-butter", Name: "Peanut butter", Allergens: []domain.Allergen{domain.AllergenPeanut}},
+		{ID: "soy-sauce", Name: "Soy sauce", Allergens: []domain.Allergen{domain.AllergenSoy, domain.AllergenWheat}},
+		{ID: "rice", Name: "Rice", MayContain: []domain.Allergen{domain.AllergenSoy, domain.AllergenFish}},
+	}
+	items, err := DeriveMenuAllergens([]domain.MenuItem{{ID: "bowl", Name: "Bowl", IngredientIDs: []string{"satay", "rice"}}}, catalog)
+	if err != nil {
+		t.Fatalf("derive: %v", err)
+	}
+	want := []domain.Allergen{domain.AllergenPeanut, domain.AllergenSoy, domain.AllergenWheat}
+	if !reflect.DeepEqual(items[0].DerivedAllergens, want) {
+		t.Fatalf("expected derived %v, got %v", want, items[0].DerivedAllergens)
+	}
+	if !reflect.DeepEqual(items[0].DerivedCrossContaminationRisk, []domain.Allergen{domain.AllergenFish}) {
+		t.Fatalf("expected cross-contact without allergens already contained, got %v", items[0].DerivedCrossContaminationRisk)
+	}
+}
+
+func TestDeriveMenuAllergensRejectsUnknownAndCyclicIngredients(t *testing.T) {
+	t.Parallel()
+	if _, err := DeriveMenuAllergens([]domain.MenuItem{{ID: "a", IngredientIDs: []string{"missing"}}}, nil); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input for unknown ingredient, got %v", err)
+	}
+	cyclic := []domain.Ingredient{
+		{ID: "a", Name: "A", SubIngredientIDs: []string{"b"}},
+		{ID: "b", Name: "B", SubIngredientIDs: []string{"a"}},
+	}
+	if err := ValidateIngredientCatalog(cyclic); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected invalid input for cyclic catalog, got %v", err)
+	}
+}
+
+func TestFindAllergenMismatchesFlagsUndeclaredAndMentionedAllergens(t *testing.T) {
+	t.Parallel()
+	items := []domain.MenuItem{
+		{ID: "bowl", Name: "Rice bowl", Description: "With peanut sauce"},
+		{
+			ID:               "curry",
+			Name:             "Curry",
+			IngredientIDs:    []string{"paste"},
+			Allergens:        []domain.Allergen{domain.AllergenDairy},
+			DerivedAllergens: []domain.Allergen{domain.AllergenFish},
+		},
+		{ID: "salad", Name: "Green salad"},
+	}
+	mismatches := FindAllergenMismatches(items, domain.JurisdictionUS)
+	if len(mismatches) != 2 {
+		t.Fatalf("expected two mismatches, got %+v", mismatches)
+	}
+	if mismatches[0].ItemID != "bowl" || !reflect.DeepEqual(mismatches[0].Mentioned, []domain.Allergen{domain.AllergenPeanut}) {
+		t.Fatalf("expected peanut mentioned in the bowl, got %+v", mismatches[0])
+	}
+	curry := mismatches[1]
+	if !reflect.DeepEqual(curry.Undeclared, []domain.Allergen{domain.AllergenFish}) || !reflect.DeepEqual(curry.Unsupported, []domain.Allergen{domain.AllergenDairy}) {
+		t.Fatalf("expected undeclared fish and unsupported dairy, got %+v", curry)
+	}
+}
+
+func TestApplySafetyPoliciesUsesDerivedAllergens(t *testing.T) {
+	t.Parallel()
+	items := []domain.MenuItem{
+		{Name: "Satay Skewers", DerivedAllergens: []domain.Allergen{domain.AllergenPeanut}},
+		{Name: "Fried Rice", DerivedCrossContaminationRisk: []domain.Allergen{domain.AllergenPeanut}},
+		{Name: "Garden Salad"},
+	}
+	safe, _, _ := applySafetyPolicies(items, anaphylaxis(domain.AllergenPeanut), nil)
+	if len(safe) != 1 || safe[0].Name != "Garden Salad" {
+		t.Fatalf("expected only the salad to remain, got %+v", safe)
+	}
+}
+
+func TestApplySafetyPoliciesFiltersRelatedAllergens(t *testing.T) {
+	t.Parallel()
+	items := []domain.MenuItem{
+		{ID: "mussels", Name: "Mussels", Allergens: []domain.Allergen{domain.AllergenMollusc}},
+		{ID: "platter", Name: "Seafood platter", Allergens: []domain.Allergen{domain.AllergenShellfish}},
+		{ID: "rye", Name: "Rye toast", Allergens: []domain.Allergen{domain.AllergenRye}},
+		{ID: "salad", Name: "Green salad"},
+	}
+
+	safe, _, _ := applySafetyPolicies(items, anaphylaxis(domain.AllergenShellfish), nil)
+	if len(safe) != 2 || safe[0].ID != "rye" || safe[1].ID != "salad" {
+		t.Fatalf("expected shellfish avoidance to drop molluscs, got %+v", safe)
+	}
+	safe, _, _ = applySafetyPolicies(items, anaphylaxis(domain.AllergenCrustacean, domain.AllergenGluten), nil)
+	if len(safe) != 2 || safe[0].ID != "mussels" || safe[1].ID != "salad" {
+		t.Fatalf("expected crustacean and gluten avoidance to drop shellfish and rye dishes, got %+v", safe)
+	}
+}
+
+func TestMentionedAllergensMatchWholeWords(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		item domain.MenuItem
+		want []domain.Allergen
+	}{
+		{domain.MenuItem{Name: "Grilled Eggplant"}, nil},
+		{domain.MenuItem{Name: "Beet salad", Description: "with goats cheese"}, []domain.Allergen{domain.AllergenDairy}},
+		{domain.MenuItem{Name: "Roasted butternut squash soup"}, nil},
+		{domain.MenuItem{Name: "Deviled eggs"}, []domain.Allergen{domain.AllergenEgg}},
+		{domain.MenuItem{Name: "Overnight oats", Description: "with peanuts"}, []domain.Allergen{domain.AllergenOats, domain.AllergenPeanut}},
+	}
+	for _, tc := range cases {
+		if got := mentionedAllergens(tc.item); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%s %q: expected %v, got %v", tc.item.Name, tc.item.Description, tc.want, got)
+		}
+	}
+}
+
+func TestWordsContainingAnAllergenDoNotHoldDishes(t *testing.T) {
+	t.Parallel()
+	items := []domain.MenuItem{
+		{ID: "eggplant", Name: "Eggplant parmigiana"},
+		{ID: "squash", Name: "Butternut squash risotto"},
+		{ID: "omelette", Name: "Egg white omelette"},
+	}
+	safe, _, _ := applySafetyPolicies(items, anaphylaxis(domain.AllergenEgg), nil)
+	if len(safe) != 2 || safe[0].ID != "eggplant" || safe[1].ID != "squash" {
+		t.Fatalf("expected only the omelette to be held back, got %+v", safe)
+	}
+}
+
+func TestFindAllergenMismatchesHonorsJurisdictionAndRelations(t *testing.T) {
+	t.Parallel()
+	items := []domain.MenuItem{
+		{ID: "soup", Name: "Celery soup"},
+		{ID: "prawns", Name: "Garlic prawns", Allergens: []domain.Allergen{domain.AllergenShellfish}},
+	}
+	if mismatches := FindAllergenMismatches(items, domain.JurisdictionUS); len(mismatches) != 0 {
+		t.Fatalf("expected celery to be unregulated in the US and shellfish to cover prawns, got %+v", mismatches)
+	}
+	mismatches := FindAllergenMismatches(items, domain.JurisdictionEU)
+	if len(mismatches) != 1 || mismatches[0].ItemID != "soup" || !reflect.DeepEqual(mismatches[0].Mentioned, []domain.Allergen{domain.AllergenCelery}) {
+		t.Fatalf("expected celery flagged in the EU, got %+v", mismatches)
+	}
+}
@@ -1,6 +1,8 @@
 package agent
 
 import (
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/gourmet-guide/backend/internal/domain"
@@ -37,22 +39,19 @@ func SuggestTags(item domain.MenuItem) []string {
 			}
 		}
 	}
-	for _, allergen := range item.Allergens {
-		switch allergen {
-		case domain.AllergenPeanut, domain.AllergenTreeNut:
+	for _, allergen := range effectiveAllergens(item) {
+		switch {
+		case allergen == domain.AllergenPeanut, allergen == domain.AllergenTreeNut:
 			delete(candidates, "nut-free")
-		case domain.AllergenDairy:
+		case allergen == domain.AllergenDairy:
 			delete(candidates, "dairy-free")
-		case domain.AllergenWheat:
+		case allergen.IsKindOf(domain.AllergenGluten):
 			delete(candidates, "gluten-free")
 		}
 	}
 
-	result := make([]string, 0, len(candidates))
-	for tag := range candidates {
-		result = append(result, tag)
-	}
-	return result
+	// Sorted, so the same item always gets the same tags in the same order.
+	return slices.Sorted(maps.Keys(candidates))
 }
 
 func EnrichMenuItemsWithSuggestedTags(items []domain.MenuItem) []domain.MenuItem {
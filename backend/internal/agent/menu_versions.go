@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+// Sources recorded on menu versions.
+const (
+	MenuSourceDirect     = "direct"
+	MenuSourceTagging    = "tagging"
+	MenuSourceExtraction = "extraction"
+	MenuSourceRestaurant = "restaurant"
+	MenuSourceSeed       = "seed"
+	// MenuSourceSession marks menus posted with a session start. They stay
+	// drafts and only the sessions started on them see them.
+	MenuSourceSession = "session"
+)
+
+// ErrNoRollbackTarget is returned when a rollback has no previously published version to restore.
+var ErrNoRollbackTarget = errors.New("no previously published menu version to roll back to")
+
+// SaveMenuDraft tags items and stores them as a new draft version. Drafts do not
+// affect guests until published. Every draft but a session draft drops the
+// restaurant's cached replies.
+func (s *ConciergeService) SaveMenuDraft(ctx context.Context, restaurantID string, items []domain.MenuItem, source string) (domain.MenuVersion, error) {
+	draft, err := s.store.SaveMenuVersion(ctx, restaurantID, EnrichMenuItemsWithSuggestedTags(items), source)
+	if err != nil {
+		return domain.MenuVersion{}, err
+	}
+	// Restaurant and ingredient edits reach the menu as drafts, so a saved draft
+	// is the signal that cached replies may describe stale dishes. Session
+	// drafts are only seen by the session that posted them.
+	if source != MenuSourceSession {
+		s.invalidateReplies(ctx, restaurantID)
+	}
+	return draft, nil
+}
+
+// SaveSessionMenu stores items posted with a session start as a draft without
+// publishing it. Posting the same items as the newest session draft reuses
+// that draft instead of adding a version.
+func (s *ConciergeService) SaveSessionMenu(ctx context.Context, restaurantID string, items []domain.MenuItem) (domain.MenuVersion, error) {
+	enriched := EnrichMenuItemsWithSuggestedTags(items)
+	versions, err := s.store.ListMenuVersions(ctx, restaurantID)
+	if err != nil && !errors.Is(err, gcp.ErrRestaurantNotFound) {
+		return domain.MenuVersion{}, err
+	}
+	if n := len(versions); n > 0 {
+		if newest := versions[n-1]; newest.Status == domain.MenuVersionDraft && newest.Source == MenuSourceSession && menuItemsHash(newest.MenuItems) == menuItemsHash(enriched) {
+			return newest, nil
+		}
+	}
+	return s.SaveMenuDraft(ctx, restaurantID, items, MenuSourceSession)
+}
+
+// menuItemsHash summarizes items so that copies read back from a store
+// compare equal. Firestore returns empty lists where nil ones were saved, and
+// fmt prints both the same way.
+func menuItemsHash(items []domain.MenuItem) string {
+	hash := sha256.New()
+	for _, item := range items {
+		fmt.Fprintf(hash, "%q %q %q %q %q %q %q %q %q %q %q\n",
+			item.ID, item.Name, item.Description, item.IngredientIDs, item.Allergens, item.CrossContaminationRisk,
+			item.DerivedAllergens, item.DerivedCrossContaminationRisk, item.Tags, item.Stations, item.ImageURL)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// PublishMenuVersion makes version the live menu for sessions started from now on.
+func (s *ConciergeService) PublishMenuVersion(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	published, err := s.store.PublishMenuVersion(ctx, restaurantID, version)
+	if err != nil {
+		return domain.MenuVersion{}, err
+	}
+	s.invalidateReplies(ctx, restaurantID)
+	s.events.Publish(domain.SessionEvent{
+		Type:         domain.SessionEventMenuUpdated,
+		RestaurantID: restaurantID,
+		MenuItems:    published.MenuItems,
+		MenuVersion:  published.Version,
+		CreatedAt:    time.Now().UTC(),
+	})
+	return published, nil
+}
+
+// RollbackMenu republishes a previously published version. With version zero it
+// picks the newest published version older than the live one.
+func (s *ConciergeService) RollbackMenu(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	versions, err := s.store.ListMenuVersions(ctx, restaurantID)
+	if err != nil {
+		return domain.MenuVersion{}, err
+	}
+	live := 0
+	for _, v := range versions {
+		if v.Live {
+			live = v.Version
+		}
+	}
+	if version == 0 {
+		for _, v := range versions {
+			if v.Status == domain.MenuVersionPublished && v.Version < live {
+				version = v.Version
+			}
+		}
+		if version == 0 {
+			return domain.MenuVersion{}, ErrNoRollbackTarget
+		}
+	}
+	if version < 1 || version > len(versions) {
+		return domain.MenuVersion{}, gcp.ErrMenuVersionNotFound
+	}
+	if versions[version-1].Status != domain.MenuVersionPublished {
+		return domain.MenuVersion{}, fmt.Errorf("%w: version %d was never published", ErrNoRollbackTarget, version)
+	}
+	return s.PublishMenuVersion(ctx, restaurantID, version)
+}
+
+func (s *ConciergeService) ListMenuVersions(ctx context.Context, restaurantID string) ([]domain.MenuVersion, error) {
+	return s.store.ListMenuVersions(ctx, restaurantID)
+}
+
+func (s *ConciergeService) GetMenuVersion(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	return s.store.LoadMenuVersion(ctx, restaurantID, version)
+}
+
+// LiveMenu returns the version guests currently see.
+func (s *ConciergeService) LiveMenu(ctx context.Context, restaurantID string) (domain.MenuVersion, error) {
+	return s.store.LoadLiveMenu(ctx, restaurantID)
+}
+
+// invalidateReplies drops cached model replies for restaurantID. The change is
+// already saved, so a cache that cannot be reached is left to expire entries by
+// TTL rather than failing the save.
+func (s *ConciergeService) invalidateReplies(ctx context.Context, restaurantID string) {
+	_ = s.runtime.InvalidateRestaurant(ctx, restaurantID)
+}
+
+// sessionMenu returns the items of the version the session is pinned to, so
+// answers stay reproducible after later publishes. Sessions started without a
+// live menu follow the live menu; none at all yields no items.
+func (s *ConciergeService) sessionMenu(ctx context.Context, session domain.ConciergeSession) ([]domain.MenuItem, error) {
+	var (
+		menu domain.MenuVersion
+		err  error
+	)
+	if session.MenuVersion > 0 {
+		menu, err = s.store.LoadMenuVersion(ctx, session.RestaurantID, session.MenuVersion)
+	} else {
+		menu, err = s.store.LoadLiveMenu(ctx, session.RestaurantID)
+	}
+	if errors.Is(err, gcp.ErrRestaurantNotFound) || errors.Is(err, gcp.ErrMenuVersionNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return menu.MenuItems, nil
+}
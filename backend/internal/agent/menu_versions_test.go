@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+func TestSessionsStayPinnedToTheMenuVersionLiveAtStart(t *testing.T) {
+	t.Parallel()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntime("gemini", store))
+	ctx := context.Background()
+
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{{Name: "Garden Salad"}}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	pinned, err := service.StartSession(ctx, "rest-1", nil, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if pinned.MenuVersion != 1 {
+		t.Fatalf("expected session pinned to version 1, got %d", pinned.MenuVersion)
+	}
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{{Name: "Lobster Roll"}}); err != nil {
+		t.Fatalf("save second menu: %v", err)
+	}
+
+	items, err := service.sessionMenu(ctx, pinned)
+	if err != nil {
+		t.Fatalf("session menu: %v", err)
+	}
+	if len(items) != 1 || items[0].Name != "Garden Salad" {
+		t.Fatalf("expected pinned session to keep version 1, got %+v", items)
+	}
+	fresh, err := service.StartSession(ctx, "rest-1", nil, nil)
+	if err != nil {
+		t.Fatalf("start second session: %v", err)
+	}
+	if fresh.MenuVersion != 2 {
+		t.Fatalf("expected new session on version 2, got %d", fresh.MenuVersion)
+	}
+}
+
+func TestExtractedMenuStaysDraftUntilPublished(t *testing.T) {
+	t.Parallel()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntime("gemini", store))
+	ctx := context.Background()
+
+	draft, _, err := service.AutoExtractMenuFromImage(ctx, "rest-1", "menu.txt", []byte("Spicy Tofu Bowl\nGarden Salad\n"))
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if draft.Status != domain.MenuVersionDraft || draft.Source != MenuSourceExtraction {
+		t.Fatalf("expected extraction draft, got %+v", draft)
+	}
+	if _, err := service.LoadMenuItems(ctx, "rest-1"); !errors.Is(err, gcp.ErrRestaurantNotFound) {
+		t.Fatalf("expected no live menu before publish, got %v", err)
+	}
+	session, err := service.StartSession(ctx, "rest-1", nil, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	reply, err := service.SendMessage(ctx, session.ID, "what is good?")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !strings.Contains(reply.Text, highRiskDisclaimer) {
+		t.Fatalf("expected fail-closed reply while menu is draft, got %q", reply.Text)
+	}
+
+	if _, err := service.PublishMenuVersion(ctx, "rest-1", draft.Version); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	items, err := service.LoadMenuItems(ctx, "rest-1")
+	if err != nil || len(items) != 2 {
+		t.Fatalf("expected published extraction to be live, got %+v (%v)", items, err)
+	}
+}
+
+func TestRollbackMenuRestoresPreviouslyPublishedVersion(t *testing.T) {
+	t.Parallel()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntime("gemini", store))
+	ctx := context.Background()
+
+	if _, err := service.RollbackMenu(ctx, "rest-1", 0); !errors.Is(err, gcp.ErrRestaurantNotFound) {
+		t.Fatalf("expected not found without versions, got %v", err)
+	}
+	for _, name := range []string{"First", "Second"} {
+		if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{{Name: name}}); err != nil {
+			t.Fatalf("save %s: %v", name, err)
+		}
+	}
+	draft, err := service.SaveMenuDraft(ctx, "rest-1", []domain.MenuItem{{Name: "Unreviewed"}}, MenuSourceTagging)
+	if err != nil {
+		t.Fatalf("save draft: %v", err)
+	}
+	if _, err := service.RollbackMenu(ctx, "rest-1", draft.Version); !errors.Is(err, ErrNoRollbackTarget) {
+		t.Fatalf("expected drafts to be rejected as rollback targets, got %v", err)
+	}
+
+	live, err := service.RollbackMenu(ctx, "rest-1", 0)
+	if err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if live.Version != 1 || !live.Live || live.MenuItems[0].Name != "First" {
+		t.Fatalf("expected version 1 live after rollback, got %+v", live)
+	}
+	if _, err := service.RollbackMenu(ctx, "rest-1", 0); !errors.Is(err, ErrNoRollbackTarget) {
+		t.Fatalf("expected nothing older to roll back to, got %v", err)
+	}
+}
+
+// roundTripMenuStore returns menu versions the way Firestore does, with empty
+// lists where nil ones were saved.
+type roundTripMenuStore struct {
+	*gcp.MemoryStore
+}
+
+func (s roundTripMenuStore) ListMenuVersions(ctx context.Context, restaurantID string) ([]domain.MenuVersion, error) {
+	versions, err := s.MemoryStore.ListMenuVersions(ctx, restaurantID)
+	for i := range versions {
+		for j := range versions[i].MenuItems {
+			item := &versions[i].MenuItems[j]
+			for _, list := range []*[]string{&item.IngredientIDs, &item.Tags, &item.Stations} {
+				if *list == nil {
+					*list = []string{}
+				}
+			}
+			for _, list := range []*[]domain.Allergen{&item.Allergens, &item.CrossContaminationRisk, &item.DerivedAllergens, &item.DerivedCrossContaminationRisk} {
+				if *list == nil {
+					*list = []domain.Allergen{}
+				}
+			}
+		}
+	}
+	return versions, err
+}
+
+func TestSessionMenuReusesTheDraftReadBackWithEmptyLists(t *testing.T) {
+	t.Parallel()
+	store := roundTripMenuStore{MemoryStore: gcp.NewMemoryStore()}
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntime("gemini", store))
+	ctx := context.Background()
+	// Several suggested tags, so the test sees any change in their order.
+	items := []domain.MenuItem{
+		{ID: "salad", Name: "Garden Salad"},
+		{ID: "bowl", Name: "Vegan Bowl", Description: "Halal, gluten-free, dairy-free and nut-free"},
+	}
+
+	first, err := service.SaveSessionMenu(ctx, "rest-1", items)
+	if err != nil {
+		t.Fatalf("save session menu: %v", err)
+	}
+	if tags := first.MenuItems[1].Tags; len(tags) < 2 {
+		t.Fatalf("expected several suggested tags, got %v", tags)
+	}
+	for range 30 {
+		again, err := service.SaveSessionMenu(ctx, "rest-1", items)
+		if err != nil {
+			t.Fatalf("save session menu again: %v", err)
+		}
+		if again.Version != first.Version {
+			t.Fatalf("expected the draft to be reused, got versions %d and %d", first.Version, again.Version)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+// SaveSafetyPolicy validates policy and stores it as the restaurant's newest
+// version. It applies to every session from the next message on; each
+// assistant turn records the version it was answered under.
+func (s *ConciergeService) SaveSafetyPolicy(ctx context.Context, restaurantID string, policy domain.SafetyPolicy) (domain.SafetyPolicy, error) {
+	engine, err := NewSafetyEngine(policy)
+	if err != nil {
+		return domain.SafetyPolicy{}, err
+	}
+	saved, err := s.store.SaveSafetyPolicy(ctx, restaurantID, engine.Policy())
+	if err != nil {
+		return domain.SafetyPolicy{}, err
+	}
+	s.invalidateReplies(ctx, restaurantID)
+	return saved, nil
+}
+
+// SafetyPolicy returns a saved policy version. Version zero returns the policy
+// in force, which is the built-in version 0 until the restaurant saves one.
+func (s *ConciergeService) SafetyPolicy(ctx context.Context, restaurantID string, version int) (domain.SafetyPolicy, error) {
+	policy, err := s.store.LoadSafetyPolicy(ctx, restaurantID, version)
+	if version == 0 && errors.Is(err, gcp.ErrPolicyNotFound) {
+		return DefaultSafetyPolicy(restaurantID), nil
+	}
+	return policy, err
+}
+
+func (s *ConciergeService) ListSafetyPolicies(ctx context.Context, restaurantID string) ([]domain.SafetyPolicy, error) {
+	return s.store.ListSafetyPolicies(ctx, restaurantID)
+}
+
+// DryRunSafetyPolicy compares candidate with the policy in force on the live
+// menu and reports every dish whose verdict changes for one of profiles.
+// Without profiles it probes each menu allergen at each severity and each
+// dietary tag either policy or the menu knows about.
+func (s *ConciergeService) DryRunSafetyPolicy(ctx context.Context, restaurantID string, candidate domain.SafetyPolicy, profiles []domain.PolicyProfile) (domain.PolicyDryRun, error) {
+	after, err := NewSafetyEngine(candidate)
+	if err != nil {
+		return domain.PolicyDryRun{}, err
+	}
+	before, err := s.safetyEngine(ctx, restaurantID)
+	if err != nil {
+		return domain.PolicyDryRun{}, err
+	}
+	live, err := s.store.LoadLiveMenu(ctx, restaurantID)
+	if err != nil {
+		return domain.PolicyDryRun{}, err
+	}
+	if len(profiles) == 0 {
+		profiles = ProbeProfiles(live.MenuItems, before, after)
+	}
+	return domain.PolicyDryRun{
+		RestaurantID:    restaurantID,
+		BaseVersion:     before.Policy().Version,
+		MenuVersion:     live.Version,
+		ProfilesChecked: len(profiles),
+		Impacts:         DiffSafetyPolicies(before, after, live.MenuItems, profiles),
+	}, nil
+}
+
+// safetyEngine compiles the policy in force for a restaurant.
+func (s *ConciergeService) safetyEngine(ctx context.Context, restaurantID string) (*SafetyEngine, error) {
+	policy, err := s.store.LoadSafetyPolicy(ctx, restaurantID, 0)
+	if errors.Is(err, gcp.ErrPolicyNotFound) {
+		return defaultSafetyEngine, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSafetyEngine(policy)
+}
@@ -0,0 +1,731 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+// Sources recorded on profile changes.
+const (
+	ProfileSourceLexicon = "lexicon"
+	ProfileSourceModel   = "model"
+	ProfileSourceTool    = "tool"
+)
+
+// ProfileExtractor finds allergy and dietary declarations, and retractions of
+// earlier ones, in a guest message.
+type ProfileExtractor interface {
+	ExtractProfileChanges(ctx context.Context, prompt string) ([]domain.ProfileChange, error)
+}
+
+var (
+	sentencePattern     = regexp.MustCompile(`[^.!?;\n]+[.!?;\n]*`)
+	clauseSplitPattern  = regexp.MustCompile(`\bbut\b`)
+	profileTokenPattern = regexp.MustCompile(`[a-z0-9']+(?:-[a-z0-9']+)*|,`)
+)
+
+// allergyTrigger is a phrase that introduces the allergens it applies to.
+// Negatable triggers turn into retractions right after "not", "no longer" and
+// the like.
+type allergyTrigger struct {
+	words     []string
+	severity  domain.AllergySeverity
+	remove    bool
+	negatable bool
+}
+
+// allergyPrefixTriggers precede their allergens ("allergic to peanuts"). A bare
+// "allergic to" or "can't eat" is treated as anaphylaxis, like an allergy
+// posted without a severity.
+var allergyPrefixTriggers = []allergyTrigger{
+	{words: []string{"allergic", "to"}, severity: domain.SeverityAnaphylaxis, negatable: true},
+	{words: []string{"anaphylactic", "to"}, severity: domain.SeverityAnaphylaxis, negatable: true},
+	{words: []string{"allergy", "to"}, severity: domain.SeverityAnaphylaxis, negatable: true},
+	{words: []string{"allergies", "to"}, severity: domain.SeverityAnaphylaxis, negatable: true},
+	{words: []string{"intolerant", "to"}, severity: domain.SeverityIntolerance, negatable: true},
+	{words: []string{"intolerant", "of"}, severity: domain.SeverityIntolerance, negatable: true},
+	{words: []string{"intolerance", "to"}, severity: domain.SeverityIntolerance, negatable: true},
+	{words: []string{"sensitive", "to"}, severity: domain.SeverityIntolerance, negatable: true},
+	{words: []string{"can", "not", "eat"}, severity: domain.SeverityAnaphylaxis},
+	{words: []string{"can", "not", "have"}, severity: domain.SeverityAnaphylaxis},
+	{words: []string{"can't", "eat"}, severity: domain.SeverityAnaphylaxis},
+	{words: []string{"cannot", "eat"}, severity: domain.SeverityAnaphylaxis},
+	{words: []string{"can't", "have"}, severity: domain.SeverityAnaphylaxis},
+	{words: []string{"cannot", "have"}, severity: domain.SeverityAnaphylaxis},
+	{words: []string{"avoid"}, severity: domain.SeverityAnaphylaxis},
+	{words: []string{"avoiding"}, severity: domain.SeverityAnaphylaxis},
+	{words: []string{"don't", "eat"}, severity: domain.SeverityPreference},
+	{words: []string{"do", "not", "eat"}, severity: domain.SeverityPreference},
+	{words: []string{"don't", "like"}, severity: domain.SeverityPreference},
+	{words: []string{"do", "not", "like"}, severity: domain.SeverityPreference},
+	{words: []string{"dislike"}, severity: domain.SeverityPreference},
+	{words: []string{"i", "can", "eat"}, remove: true},
+	{words: []string{"i", "can", "have"}, remove: true},
+	{words: []string{"we", "can", "eat"}, remove: true},
+}
+
+// allergyPostfixTriggers follow their allergens ("peanut allergy").
+var allergyPostfixTriggers = map[string]domain.AllergySeverity{
+	"allergy":       domain.SeverityAnaphylaxis,
+	"allergies":     domain.SeverityAnaphylaxis,
+	"allergic":      domain.SeverityAnaphylaxis,
+	"intolerance":   domain.SeverityIntolerance,
+	"intolerances":  domain.SeverityIntolerance,
+	"intolerant":    domain.SeverityIntolerance,
+	"sensitivity":   domain.SeverityIntolerance,
+	"sensitivities": domain.SeverityIntolerance,
+}
+
+// severityModifiers adjust the severity of the trigger they precede.
+var severityModifiers = map[string]domain.AllergySeverity{
+	"severe":    domain.SeverityAnaphylaxis,
+	"severely":  domain.SeverityAnaphylaxis,
+	"deathly":   domain.SeverityAnaphylaxis,
+	"extremely": domain.SeverityAnaphylaxis,
+	"mild":      domain.SeverityAllergy,
+	"mildly":    domain.SeverityAllergy,
+	"slight":    domain.SeverityAllergy,
+	"slightly":  domain.SeverityAllergy,
+}
+
+// guestAllergenTerms are words guests use for allergens that the registry does
+// not list as synonyms.
+var guestAllergenTerms = map[string]domain.Allergen{
+	"shrimp":   domain.AllergenCrustacean,
+	"prawn":    domain.AllergenCrustacean,
+	"prawns":   domain.AllergenCrustacean,
+	"crab":     domain.AllergenCrustacean,
+	"lobster":  domain.AllergenCrustacean,
+	"clam":     domain.AllergenMollusc,
+	"clams":    domain.AllergenMollusc,
+	"mussels":  domain.AllergenMollusc,
+	"oysters":  domain.AllergenMollusc,
+	"squid":    domain.AllergenMollusc,
+	"celiac":   domain.AllergenGluten,
+	"coeliac":  domain.AllergenGluten,
+	"lactose":  domain.AllergenDairy,
+	"sulfite":  domain.AllergenSulphites,
+	"sulphite": domain.AllergenSulphites,
+}
+
+// dietTerms map diet words to the menu tags they require.
+var dietTerms = map[string]string{
+	"vegetarian":  "vegetarian",
+	"veggie":      "vegetarian",
+	"vegan":       "vegan",
+	"plant-based": "vegan",
+	"plant based": "vegan",
+	"gluten-free": "gluten-free",
+	"gluten free": "gluten-free",
+	"dairy-free":  "dairy-free",
+	"dairy free":  "dairy-free",
+	"nut-free":    "nut-free",
+	"nut free":    "nut-free",
+	"halal":       "halal",
+}
+
+// dietObjects are foods whose avoidance is a dietary tag rather than an allergy.
+var dietObjects = map[string]string{
+	"pork": "no-pork",
+	"beef": "no-beef",
+	"lard": "no-lard",
+}
+
+// dietSubjects open a diet declaration ("i'm vegan", "we eat halal").
+var dietSubjects = [][]string{
+	{"i", "am"}, {"we", "are"}, {"i", "only", "eat"}, {"i", "eat"}, {"we", "eat"},
+	{"i", "keep"}, {"i", "follow"}, {"my", "diet", "is"},
+	{"i'm"}, {"im"}, {"we're"},
+}
+
+// listConnectors may appear between the allergens or diets of one statement.
+var listConnectors = map[string]bool{
+	",": true, "and": true, "or": true, "nor": true, "also": true, "both": true, "all": true,
+	"any": true, "kinds": true, "kind": true, "types": true, "of": true, "the": true,
+	"a": true, "an": true, "to": true,
+}
+
+// dietFillers may sit between a diet subject and the diet. Negators turn the
+// declaration into a retraction. A bare "no" is not a negator: "No, I'm
+// allergic to eggs" declares the allergy.
+var (
+	dietFillers = map[string]bool{
+		"a": true, "an": true, "on": true, "strictly": true, "strict": true, "fully": true,
+		"also": true, "now": true, "actually": true, "currently": true, "completely": true,
+		"mostly": true, "still": true, "totally": true,
+	}
+	negators = map[string]bool{
+		"not": true, "never": true, "longer": true, "isn't": true, "aren't": true,
+		"don't": true, "doesn't": true, "didn't": true,
+	}
+	// negationFillers may sit between a negator and a postfix allergy
+	// ("don't have a peanut allergy").
+	negationFillers = map[string]bool{"a": true, "an": true, "any": true, "have": true}
+)
+
+// LexiconProfileExtractor recognizes declarations with a fixed phrase lexicon.
+// It is deterministic and the default extractor.
+type LexiconProfileExtractor struct{}
+
+func (LexiconProfileExtractor) ExtractProfileChanges(_ context.Context, prompt string) ([]domain.ProfileChange, error) {
+	return extractProfileChanges(prompt), nil
+}
+
+// extractProfileChanges reads the declarations in prompt clause by clause. A
+// question can declare an allergy or diet but never retracts one: "I can eat
+// peanuts as long as they're roasted?" asks rather than states.
+func extractProfileChanges(prompt string) []domain.ProfileChange {
+	text := strings.ToLower(strings.NewReplacer("’", "'", "‘", "'").Replace(prompt))
+	var changes []domain.ProfileChange
+	for _, sentence := range sentencePattern.FindAllString(text, -1) {
+		question := strings.Contains(sentence, "?")
+		for _, clause := range clauseSplitPattern.Split(strings.TrimRight(sentence, ".!?;\n"), -1) {
+			tokens := profileTokenPattern.FindAllString(clause, -1)
+			if len(tokens) == 0 {
+				continue
+			}
+			phrase := strings.Join(strings.Fields(clause), " ")
+			phrase = strings.Trim(phrase, " ,")
+			for _, change := range append(allergyChanges(tokens), dietChanges(tokens)...) {
+				if question && change.Action == domain.ProfileChangeRemove {
+					continue
+				}
+				change.Source, change.Phrase = ProfileSourceLexicon, phrase
+				changes = append(changes, change)
+			}
+		}
+	}
+	return latestProfileChanges(changes)
+}
+
+// allergyChanges finds prefix and postfix allergy statements in one sentence.
+func allergyChanges(tokens []string) []domain.ProfileChange {
+	var changes []domain.ProfileChange
+	for i := 0; i < len(tokens); i++ {
+		if trigger, ok := matchAllergyTrigger(tokens, i); ok {
+			remove := trigger.remove || (trigger.negatable && negatedBefore(tokens, i))
+			severity := modifiedSeverity(tokens, i, trigger.severity)
+			allergens, tags, end := objectList(tokens, i+len(trigger.words))
+			for _, allergen := range allergens {
+				changes = append(changes, allergyChange(allergen, severity, remove))
+			}
+			for _, tag := range tags {
+				// "don't eat pork" declares a diet; "can eat pork" retracts it.
+				changes = append(changes, dietChange(tag, trigger.remove))
+			}
+			if end > i+len(trigger.words) {
+				i = end - 1
+			}
+			continue
+		}
+		severity, ok := allergyPostfixTriggers[tokens[i]]
+		if !ok {
+			continue
+		}
+		allergens, start := allergenListBefore(tokens, i)
+		if len(allergens) == 0 {
+			continue
+		}
+		remove := negatedBefore(tokens, start)
+		severity = modifiedSeverity(tokens, start, severity)
+		for _, allergen := range allergens {
+			changes = append(changes, allergyChange(allergen, severity, remove))
+		}
+	}
+	for i, token := range tokens {
+		// "I have celiac disease" is a gluten allergy on its own.
+		if (token == "celiac" || token == "coeliac") && (i+1 == len(tokens) || allergyPostfixTriggers[tokens[i+1]] == "") {
+			changes = append(changes, allergyChange(domain.AllergenGluten, domain.SeverityAnaphylaxis, negatedBefore(tokens, i)))
+		}
+	}
+	return changes
+}
+
+func matchAllergyTrigger(tokens []string, i int) (allergyTrigger, bool) {
+	for _, trigger := range allergyPrefixTriggers {
+		if hasWordsAt(tokens, i, trigger.words) {
+			return trigger, true
+		}
+	}
+	return allergyTrigger{}, false
+}
+
+// objectList reads the allergens and diet objects that follow a trigger, up to
+// the first word that is neither, and returns where it stopped.
+func objectList(tokens []string, start int) ([]domain.Allergen, []string, int) {
+	var (
+		allergens []domain.Allergen
+		tags      []string
+	)
+	i := start
+	for i < len(tokens) {
+		if matched, n, ok := allergenAt(tokens, i); ok {
+			allergens = append(allergens, matched...)
+			i += n
+			continue
+		}
+		if tag, ok := dietObjects[tokens[i]]; ok {
+			tags = append(tags, tag)
+			i++
+			continue
+		}
+		if !listConnectors[tokens[i]] {
+			break
+		}
+		i++
+	}
+	return allergens, tags, i
+}
+
+// allergenListBefore reads the allergens preceding a postfix trigger at end
+// and returns them with the index the list starts at.
+func allergenListBefore(tokens []string, end int) ([]domain.Allergen, int) {
+	var allergens []domain.Allergen
+	i := end
+	for i > 0 {
+		found := false
+		for n := 3; n >= 1; n-- {
+			if i-n < 0 {
+				continue
+			}
+			if matched, length, ok := allergenAt(tokens, i-n); ok && length == n {
+				allergens = append(slices.Clone(matched), allergens...)
+				i -= n
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if !listConnectors[tokens[i-1]] || len(allergens) == 0 {
+			break
+		}
+		i--
+	}
+	for i < end && listConnectors[tokens[i]] {
+		i++
+	}
+	return allergens, i
+}
+
+// allergenAt matches the longest allergen phrase, of up to three words,
+// starting at tokens[i]. An ambiguous word such as "nut" matches every
+// allergen it may mean.
+func allergenAt(tokens []string, i int) ([]domain.Allergen, int, bool) {
+	for n := 3; n >= 1; n-- {
+		if i+n > len(tokens) {
+			continue
+		}
+		phrase := strings.Join(tokens[i:i+n], " ")
+		if allergen, ok := guestAllergenTerms[phrase]; ok {
+			return []domain.Allergen{allergen}, n, true
+		}
+		if allergens, ok := domain.ParseAllergens(phrase); ok {
+			return allergens, n, true
+		}
+		if singular := strings.TrimSuffix(phrase, "s"); singular != phrase {
+			if allergens, ok := domain.ParseAllergens(singular); ok {
+				return allergens, n, true
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// dietChanges finds diet declarations ("i'm vegan and gluten-free") in one sentence.
+func dietChanges(tokens []string) []domain.ProfileChange {
+	var changes []domain.ProfileChange
+	for i := 0; i < len(tokens); i++ {
+		subject := dietSubjectAt(tokens, i)
+		if subject == 0 {
+			continue
+		}
+		j, remove := i+subject, false
+		for j < len(tokens) && (dietFillers[tokens[j]] || negators[tokens[j]] || hasWordsAt(tokens, j, []string{"no", "longer"})) {
+			remove = remove || negators[tokens[j]]
+			j++
+		}
+		for j < len(tokens) {
+			if tag, n, ok := dietAt(tokens, j); ok {
+				changes = append(changes, dietChange(tag, remove))
+				j += n
+				continue
+			}
+			if !listConnectors[tokens[j]] {
+				break
+			}
+			j++
+		}
+		i = j - 1
+	}
+	return changes
+}
+
+func dietSubjectAt(tokens []string, i int) int {
+	for _, words := range dietSubjects {
+		if hasWordsAt(tokens, i, words) {
+			return len(words)
+		}
+	}
+	return 0
+}
+
+func dietAt(tokens []string, i int) (string, int, bool) {
+	if i+1 < len(tokens) {
+		if tag, ok := dietTerms[tokens[i]+" "+tokens[i+1]]; ok {
+			return tag, 2, true
+		}
+	}
+	tag, ok := dietTerms[tokens[i]]
+	return tag, 1, ok
+}
+
+func hasWordsAt(tokens []string, i int, words []string) bool {
+	if i+len(words) > len(tokens) {
+		return false
+	}
+	for k, word := range words {
+		if tokens[i+k] != word {
+			return false
+		}
+	}
+	return true
+}
+
+// negatedBefore reports a negator right before index i, allowing only
+// negation fillers in between. "I'm not only allergic to peanuts" and "No I'm
+// allergic to peanuts" are not negated.
+func negatedBefore(tokens []string, i int) bool {
+	k := i - 1
+	for k >= 0 && negationFillers[tokens[k]] {
+		k--
+	}
+	return k >= 0 && (negators[tokens[k]] || strings.HasSuffix(tokens[k], "n't"))
+}
+
+// modifiedSeverity applies a severity modifier among the two words before index i.
+func modifiedSeverity(tokens []string, i int, severity domain.AllergySeverity) domain.AllergySeverity {
+	if severity != domain.SeverityAnaphylaxis {
+		return severity
+	}
+	for k := max(0, i-2); k < i; k++ {
+		if modified, ok := severityModifiers[tokens[k]]; ok {
+			return modified
+		}
+	}
+	return severity
+}
+
+func allergyChange(allergen domain.Allergen, severity domain.AllergySeverity, remove bool) domain.ProfileChange {
+	if remove {
+		return domain.ProfileChange{Action: domain.ProfileChangeRemove, Allergen: allergen}
+	}
+	return domain.ProfileChange{Action: domain.ProfileChangeAdd, Allergen: allergen, Severity: severity}
+}
+
+func dietChange(tag string, remove bool) domain.ProfileChange {
+	if remove {
+		return domain.ProfileChange{Action: domain.ProfileChangeRemove, Tag: tag}
+	}
+	return domain.ProfileChange{Action: domain.ProfileChangeAdd, Tag: tag}
+}
+
+// latestProfileChanges keeps the last statement about each allergen or tag, in
+// the order the statements were made.
+func latestProfileChanges(changes []domain.ProfileChange) []domain.ProfileChange {
+	last := map[string]int{}
+	for i, change := range changes {
+		last[profileChangeKey(change)] = i
+	}
+	var latest []domain.ProfileChange
+	for i, change := range changes {
+		if last[profileChangeKey(change)] == i {
+			latest = append(latest, change)
+		}
+	}
+	return latest
+}
+
+func profileChangeKey(change domain.ProfileChange) string {
+	if change.Tag != "" {
+		return "tag:" + change.Tag
+	}
+	return "allergen:" + string(change.Allergen)
+}
+
+// profileCuePattern spots messages that might declare an allergy or diet, so the
+// model is only asked about those.
+var profileCuePattern = regexp.MustCompile(`(?i)allerg|intoleran|anaphyla|sensitiv|celiac|coeliac|diet|vegan|vegetarian|halal|can'?t eat|cannot eat|don'?t eat|avoid`)
+
+const profileExtractionPrompt = `Extract the allergies and dietary needs the guest declares or retracts in the message below.
+Answer with a JSON array only. Each element is {"action":"add"|"remove","allergen":"<allergen>","severity":"anaphylaxis"|"allergy"|"intolerance"|"preference"} or {"action":"add"|"remove","tag":"<diet tag>"}.
+Allergens: %s.
+Diet tags: %s.
+Answer [] when the message declares nothing.
+
+Message: %s`
+
+// ModelProfileExtractor runs the lexicon first and asks the model only when the
+// lexicon finds nothing in a message that looks like a declaration. Model
+// answers are checked against the allergen registry and the diet tags; a model
+// failure or an unreadable answer yields no changes rather than an error.
+type ModelProfileExtractor struct {
+	client    Client
+	modelName string
+}
+
+// NewModelProfileExtractor builds an extractor that uses the runtime's model client.
+func NewModelProfileExtractor(runtime *Runtime) *ModelProfileExtractor {
+	return &ModelProfileExtractor{client: runtime.client, modelName: runtime.modelName}
+}
+
+func (m *ModelProfileExtractor) ExtractProfileChanges(ctx context.Context, prompt string) ([]domain.ProfileChange, error) {
+	if changes := extractProfileChanges(prompt); len(changes) > 0 || !profileCuePattern.MatchString(prompt) {
+		return changes, nil
+	}
+	allergens := make([]string, 0, len(domain.AllergenDefinitions("")))
+	for _, definition := range domain.AllergenDefinitions("") {
+		allergens = append(allergens, string(definition.ID))
+	}
+	reply, err := m.client.Generate(ctx, m.modelName, fmt.Sprintf(profileExtractionPrompt, strings.Join(allergens, ", "), strings.Join(dietTags(), ", "), prompt))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	}
+	return parseModelProfileChanges(reply, prompt), nil
+}
+
+// parseModelProfileChanges reads the JSON array in a model reply and drops
+// entries that do not name a known allergen or diet tag.
+func parseModelProfileChanges(reply, prompt string) []domain.ProfileChange {
+	start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]")
+	if start < 0 || end < start {
+		return nil
+	}
+	var proposed []domain.ProfileChange
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &proposed); err != nil {
+		return nil
+	}
+	phrase := strings.Join(strings.Fields(strings.ToLower(prompt)), " ")
+	var changes []domain.ProfileChange
+	for _, change := range proposed {
+		accepted, ok := validProfileChange(change)
+		if !ok {
+			continue
+		}
+		accepted.Source, accepted.Phrase = ProfileSourceModel, phrase
+		changes = append(changes, accepted)
+	}
+	return latestProfileChanges(changes)
+}
+
+// validProfileChange normalizes a proposed change, rejecting unknown actions,
+// allergens and diet tags. A missing severity counts as anaphylaxis.
+func validProfileChange(change domain.ProfileChange) (domain.ProfileChange, bool) {
+	remove := change.Action == domain.ProfileChangeRemove
+	if !remove && change.Action != domain.ProfileChangeAdd {
+		return domain.ProfileChange{}, false
+	}
+	switch {
+	case change.Tag != "" && change.Allergen == "":
+		tag := normalizeTag(change.Tag)
+		if !isDietTag(tag) {
+			return domain.ProfileChange{}, false
+		}
+		return dietChange(tag, remove), true
+	case change.Allergen != "" && change.Tag == "":
+		allergen, ok := domain.ParseAllergen(string(change.Allergen))
+		if !ok {
+			return domain.ProfileChange{}, false
+		}
+		severity, ok := domain.ParseAllergySeverity(string(change.Severity))
+		if !ok {
+			severity = domain.SeverityAnaphylaxis
+		}
+		return allergyChange(allergen, severity, remove), true
+	default:
+		return domain.ProfileChange{}, false
+	}
+}
+
+func dietTags() []string {
+	seen := map[string]bool{}
+	var tags []string
+	for _, rule := range tagRules {
+		if !seen[rule.tag] {
+			seen[rule.tag] = true
+			tags = append(tags, rule.tag)
+		}
+	}
+	return tags
+}
+
+func isDietTag(tag string) bool {
+	for _, known := range dietTags() {
+		if known == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// applyProfileChanges updates the session's allergies and dietary tags and
+// returns the changes that made a difference. A declaration never lowers the
+// severity already on file, and anaphylaxis entries stay mirrored into
+// HardAllergens. Retractions that need the guest's confirmation are held in
+// PendingRemovals instead and returned as pending; any later statement about
+// the same entry replaces a pending removal.
+func applyProfileChanges(session *domain.ConciergeSession, changes []domain.ProfileChange) (applied, pending []domain.ProfileChange) {
+	allergies := session.AllergyProfile()
+	tags := append([]string{}, session.PreferenceTags...)
+	pendingRemovals := slices.Clone(session.PendingRemovals)
+	for _, change := range changes {
+		key := profileChangeKey(change)
+		sameEntry := func(pending domain.ProfileChange) bool { return profileChangeKey(pending) == key }
+		if severity, ok := removalNeedsConfirmation(change, allergies, tags); ok {
+			change.Severity = severity
+			if !slices.ContainsFunc(pendingRemovals, sameEntry) {
+				pendingRemovals = append(pendingRemovals, change)
+			}
+			pending = append(pending, change)
+			continue
+		}
+		pendingRemovals = slices.DeleteFunc(pendingRemovals, sameEntry)
+		if applyProfileChange(&allergies, &tags, change) {
+			applied = append(applied, change)
+		}
+	}
+	if len(applied) == 0 && slices.Equal(pendingRemovals, session.PendingRemovals) {
+		return nil, pending
+	}
+	setProfile(session, allergies, tags)
+	session.PendingRemovals = pendingRemovals
+	return applied, pending
+}
+
+// confirmPendingRemovals applies the removals waiting for the guest's
+// confirmation and returns the ones that made a difference.
+func confirmPendingRemovals(session *domain.ConciergeSession) []domain.ProfileChange {
+	allergies := session.AllergyProfile()
+	tags := append([]string{}, session.PreferenceTags...)
+	var applied []domain.ProfileChange
+	for _, change := range session.PendingRemovals {
+		if applyProfileChange(&allergies, &tags, change) {
+			applied = append(applied, change)
+		}
+	}
+	setProfile(session, allergies, tags)
+	session.PendingRemovals = nil
+	return applied
+}
+
+// removalNeedsConfirmation reports whether change retracts an entry on file
+// that only the guest may remove: any entry when the model or a tool proposed
+// the removal, and anaphylaxis or allergy entries when chat stated it. It
+// returns the entry's severity. Neither a misread message nor a model can
+// switch off an exclusion by itself.
+func removalNeedsConfirmation(change domain.ProfileChange, allergies []domain.Allergy, tags []string) (domain.AllergySeverity, bool) {
+	if change.Action != domain.ProfileChangeRemove {
+		return "", false
+	}
+	fromGuest := change.Source == ProfileSourceLexicon
+	if change.Tag != "" {
+		return "", !fromGuest && slices.ContainsFunc(tags, func(tag string) bool { return strings.EqualFold(strings.TrimSpace(tag), change.Tag) })
+	}
+	i := slices.IndexFunc(allergies, func(allergy domain.Allergy) bool { return allergy.Allergen == change.Allergen })
+	if i < 0 {
+		return "", false
+	}
+	severity := allergies[i].Severity
+	return severity, !fromGuest || severity == domain.SeverityAnaphylaxis || severity == domain.SeverityAllergy
+}
+
+// applyProfileChange applies one change to allergies and tags and reports
+// whether it made a difference.
+func applyProfileChange(allergies *[]domain.Allergy, tags *[]string, change domain.ProfileChange) bool {
+	switch {
+	case change.Tag != "":
+		i := slices.IndexFunc(*tags, func(tag string) bool { return strings.EqualFold(strings.TrimSpace(tag), change.Tag) })
+		switch {
+		case change.Action == domain.ProfileChangeAdd && i < 0:
+			*tags = append(*tags, change.Tag)
+		case change.Action == domain.ProfileChangeRemove && i >= 0:
+			*tags = slices.Delete(*tags, i, i+1)
+		default:
+			return false
+		}
+	case change.Action == domain.ProfileChangeAdd:
+		i := slices.IndexFunc(*allergies, func(allergy domain.Allergy) bool { return allergy.Allergen == change.Allergen })
+		if i >= 0 && !change.Severity.MoreSevereThan((*allergies)[i].Severity) {
+			return false
+		}
+		*allergies = domain.MergeAllergies(*allergies, []domain.Allergy{{Allergen: change.Allergen, Severity: change.Severity}})
+	default:
+		i := slices.IndexFunc(*allergies, func(allergy domain.Allergy) bool { return allergy.Allergen == change.Allergen })
+		if i < 0 {
+			return false
+		}
+		*allergies = slices.Delete(*allergies, i, i+1)
+	}
+	return true
+}
+
+// setProfile stores allergies and tags on the session, mirroring anaphylaxis
+// entries into HardAllergens.
+func setProfile(session *domain.ConciergeSession, allergies []domain.Allergy, tags []string) {
+	session.Allergies = allergies
+	session.HardAllergens = nil
+	for _, allergy := range allergies {
+		if allergy.Severity == domain.SeverityAnaphylaxis {
+			session.HardAllergens = append(session.HardAllergens, allergy.Allergen)
+		}
+	}
+	session.PreferenceTags = tags
+}
+
+// profileNote echoes applied profile changes back to the guest and asks them
+// to confirm pending removals.
+func profileNote(changes, pending []domain.ProfileChange) string {
+	var notes []string
+	if len(changes) > 0 {
+		notes = append(notes, appliedNote(changes))
+	}
+	if len(pending) > 0 {
+		parts := make([]string, 0, len(pending))
+		for _, change := range pending {
+			if change.Tag != "" {
+				parts = append(parts, fmt.Sprintf("the %s requirement", change.Tag))
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%s (%s)", change.Allergen, change.Severity))
+		}
+		notes = append(notes, fmt.Sprintf("Please confirm that I should remove %s from your profile; until then your profile stays as it is.", strings.Join(parts, ", ")))
+	}
+	return strings.Join(notes, " ")
+}
+
+func appliedNote(changes []domain.ProfileChange) string {
+	parts := make([]string, 0, len(changes))
+	for _, change := range changes {
+		switch {
+		case change.Tag != "" && change.Action == domain.ProfileChangeAdd:
+			parts = append(parts, fmt.Sprintf("added the %s requirement", change.Tag))
+		case change.Tag != "":
+			parts = append(parts, fmt.Sprintf("removed the %s requirement", change.Tag))
+		case change.Action == domain.ProfileChangeAdd:
+			parts = append(parts, fmt.Sprintf("added %s (%s)", change.Allergen, change.Severity))
+		default:
+			parts = append(parts, fmt.Sprintf("removed %s", change.Allergen))
+		}
+	}
+	return fmt.Sprintf("I've updated your profile: %s.", strings.Join(parts, ", "))
+}
@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+func TestLexiconProfileExtractorFindsDeclarationsAndRetractions(t *testing.T) {
+	t.Parallel()
+	add := func(allergen domain.Allergen, severity domain.AllergySeverity) domain.ProfileChange {
+		return domain.ProfileChange{Action: domain.ProfileChangeAdd, Allergen: allergen, Severity: severity}
+	}
+	remove := func(allergen domain.Allergen) domain.ProfileChange {
+		return domain.ProfileChange{Action: domain.ProfileChangeRemove, Allergen: allergen}
+	}
+	diet := func(action domain.ProfileChangeAction, tag string) domain.ProfileChange {
+		return domain.ProfileChange{Action: action, Tag: tag}
+	}
+
+	tests := []struct {
+		prompt string
+		want   []domain.ProfileChange
+	}{
+		{"I'm allergic to shellfish and I'm vegetarian", []domain.ProfileChange{add(domain.AllergenShellfish, domain.SeverityAnaphylaxis), diet(domain.ProfileChangeAdd, "vegetarian")}},
+		{"I'm mildly allergic to peanuts, tree nuts and sesame. What do you recommend?", []domain.ProfileChange{
+			add(domain.AllergenPeanut, domain.SeverityAllergy), add(domain.AllergenTreeNut, domain.SeverityAllergy), add(domain.AllergenSesame, domain.SeverityAllergy),
+		}},
+		{"I have a severe shrimp allergy and I'm lactose intolerant", []domain.ProfileChange{add(domain.AllergenCrustacean, domain.SeverityAnaphylaxis), add(domain.AllergenDairy, domain.SeverityIntolerance)}},
+		{"Actually I'm not allergic to peanuts anymore", []domain.ProfileChange{remove(domain.AllergenPeanut)}},
+		{"I'm no longer vegan, but I don't eat pork", []domain.ProfileChange{diet(domain.ProfileChangeRemove, "vegan"), diet(domain.ProfileChangeAdd, "no-pork")}},
+		{"I don't have a nut allergy", []domain.ProfileChange{remove(domain.AllergenPeanut), remove(domain.AllergenTreeNut)}},
+		{"I have a nut allergy", []domain.ProfileChange{add(domain.AllergenPeanut, domain.SeverityAnaphylaxis), add(domain.AllergenTreeNut, domain.SeverityAnaphylaxis)}},
+		{"I'm allergic to nuts and tree nuts", []domain.ProfileChange{add(domain.AllergenPeanut, domain.SeverityAnaphylaxis), add(domain.AllergenTreeNut, domain.SeverityAnaphylaxis)}},
+		{"I have celiac disease, so I'm strictly gluten-free", []domain.ProfileChange{add(domain.AllergenGluten, domain.SeverityAnaphylaxis), diet(domain.ProfileChangeAdd, "gluten-free")}},
+		{"I can eat fish", []domain.ProfileChange{remove(domain.AllergenFish)}},
+		{"No, I'm allergic to eggs", []domain.ProfileChange{add(domain.AllergenEgg, domain.SeverityAnaphylaxis)}},
+		{"I'm allergic to eggs. Sorry, I'm not allergic to eggs", []domain.ProfileChange{remove(domain.AllergenEgg)}},
+		{"No I'm allergic to peanuts", []domain.ProfileChange{add(domain.AllergenPeanut, domain.SeverityAnaphylaxis)}},
+		{"I'm not only allergic to peanuts, also eggs", []domain.ProfileChange{add(domain.AllergenPeanut, domain.SeverityAnaphylaxis), add(domain.AllergenEgg, domain.SeverityAnaphylaxis)}},
+		{"I can eat peanuts as long as they're roasted?", nil},
+		{"Can I eat fish? I'm allergic to sesame", []domain.ProfileChange{add(domain.AllergenSesame, domain.SeverityAnaphylaxis)}},
+		{"Do you have vegan options with fish?", nil},
+		{"What can I eat here?", nil},
+	}
+	for _, tc := range tests {
+		changes, err := LexiconProfileExtractor{}.ExtractProfileChanges(context.Background(), tc.prompt)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.prompt, err)
+		}
+		for i := range changes {
+			if changes[i].Source != ProfileSourceLexicon || changes[i].Phrase == "" {
+				t.Fatalf("%q: expected source and phrase on %+v", tc.prompt, changes[i])
+			}
+			changes[i].Source, changes[i].Phrase = "", ""
+		}
+		if !reflect.DeepEqual(changes, tc.want) {
+			t.Errorf("%q: expected %+v, got %+v", tc.prompt, tc.want, changes)
+		}
+	}
+}
+
+func TestChatRetractionsOfAllergiesWaitForConfirmation(t *testing.T) {
+	t.Parallel()
+	session := domain.ConciergeSession{
+		HardAllergens: []domain.Allergen{domain.AllergenPeanut},
+		Allergies:     []domain.Allergy{{Allergen: domain.AllergenDairy, Severity: domain.SeverityIntolerance}, {Allergen: domain.AllergenEgg, Severity: domain.SeverityAllergy}},
+	}
+	applied, pending := applyProfileChanges(&session, extractProfileChanges("I'm not allergic to peanuts or eggs. I'm not lactose intolerant"))
+	if len(applied) != 1 || applied[0].Allergen != domain.AllergenDairy {
+		t.Fatalf("expected only the intolerance retraction to apply, got %+v", applied)
+	}
+	if len(pending) != 2 || pending[0].Severity != domain.SeverityAnaphylaxis || pending[1].Severity != domain.SeverityAllergy {
+		t.Fatalf("expected the peanut and egg retractions to wait, got %+v", pending)
+	}
+	if len(session.HardAllergens) != 1 || len(session.Allergies) != 2 || len(session.PendingRemovals) != 2 {
+		t.Fatalf("expected the session-start allergies to stay on file, got %+v", session)
+	}
+
+	if applied, _ := applyProfileChanges(&session, extractProfileChanges("Sorry, I am mildly allergic to eggs")); applied != nil || len(session.PendingRemovals) != 1 {
+		t.Fatalf("expected restating eggs to drop its pending removal, got %+v (%+v)", applied, session.PendingRemovals)
+	}
+	if applied := confirmPendingRemovals(&session); len(applied) != 1 || applied[0].Allergen != domain.AllergenPeanut {
+		t.Fatalf("expected the confirmation to remove peanut, got %+v", applied)
+	}
+	if len(session.HardAllergens) != 0 || len(session.Allergies) != 1 || session.PendingRemovals != nil {
+		t.Fatalf("expected only the egg allergy left, got %+v", session)
+	}
+}
+
+type scriptedClient struct {
+	reply string
+	err   error
+	calls int
+}
+
+func (c *scriptedClient) Generate(context.Context, string, string) (string, error) {
+	c.calls++
+	return c.reply, c.err
+}
+
+func (c *scriptedClient) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(string) error) (string, error) {
+	reply, err := c.Generate(ctx, modelName, prompt)
+	if err == nil {
+		err = onDelta(reply)
+	}
+	return reply, err
+}
+
+func TestModelProfileExtractorFallsBackToModelAndValidatesAnswers(t *testing.T) {
+	t.Parallel()
+	client := &scriptedClient{reply: `Sure: [{"action":"add","allergen":"Groundnuts","severity":"allergy"},{"action":"add","tag":"keto"},{"action":"add","allergen":"unicorn"},{"action":"remove","tag":"Halal"}]`}
+	extractor := NewModelProfileExtractor(NewRuntimeWithClient("test-model", nil, client))
+
+	changes, err := extractor.ExtractProfileChanges(context.Background(), "My diet rules out groundnuts, and halal no longer matters")
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	want := []domain.ProfileChange{
+		{Action: domain.ProfileChangeAdd, Allergen: domain.AllergenPeanut, Severity: domain.SeverityAllergy},
+		{Action: domain.ProfileChangeRemove, Tag: "halal"},
+	}
+	for i := range changes {
+		if changes[i].Source != ProfileSourceModel {
+			t.Fatalf("expected model source, got %+v", changes[i])
+		}
+		changes[i].Source, changes[i].Phrase = "", ""
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("expected only registry allergens and known tags, got %+v", changes)
+	}
+
+	if _, err := extractor.ExtractProfileChanges(context.Background(), "I'm allergic to sesame"); err != nil || client.calls != 1 {
+		t.Fatalf("expected the lexicon to answer without the model, got %d calls (%v)", client.calls, err)
+	}
+	if _, err := extractor.ExtractProfileChanges(context.Background(), "Two glasses of water please"); err != nil || client.calls != 1 {
+		t.Fatalf("expected no model call without a cue, got %d calls (%v)", client.calls, err)
+	}
+
+	client.err = errors.New("model down")
+	if changes, err := extractor.ExtractProfileChanges(context.Background(), "my diet is complicated"); err != nil || changes != nil {
+		t.Fatalf("expected a model failure to yield no changes, got %+v (%v)", changes, err)
+	}
+}
+
+func TestStreamMessageUpdatesProfileFromChat(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntime("gemini", store))
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{
+		{ID: "wrap", Name: "Veggie Wrap", Tags: []string{"vegetarian"}},
+		{ID: "tacos", Name: "Shrimp Tacos", Allergens: []domain.Allergen{domain.AllergenCrustacean}, Tags: []string{"vegetarian"}},
+		{ID: "burger", Name: "Burger"},
+	}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	session, err := service.StartSession(ctx, "rest-1", nil, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+
+	var partials []string
+	reply, err := service.StreamMessage(ctx, session.ID, "I'm allergic to shellfish and I'm vegetarian", func(delta string) error {
+		partials = append(partials, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream message: %v", err)
+	}
+	wantEcho := "I've updated your profile: added shellfish (anaphylaxis), added the vegetarian requirement."
+	if len(partials) == 0 || !strings.HasPrefix(partials[0], wantEcho) || !strings.HasPrefix(reply.Text, wantEcho) || len(reply.ProfileChanges) != 2 {
+		t.Fatalf("expected the reply to open with the profile echo, got %q (%+v)", reply.Text, reply.ProfileChanges)
+	}
+	if verdicts := reply.Safety; verdicts[1].Verdict != domain.VerdictUnsafe || verdicts[2].Verdict != domain.VerdictUnsafe || verdicts[0].Verdict != domain.VerdictSafe {
+		t.Fatalf("expected the new profile to apply to this turn, got %+v", verdicts)
+	}
+	updated, err := service.GetSession(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("load session: %v", err)
+	}
+	if len(updated.HardAllergens) != 1 || updated.HardAllergens[0] != domain.AllergenShellfish || len(updated.PreferenceTags) != 1 || updated.PreferenceTags[0] != "vegetarian" {
+		t.Fatalf("expected the persisted profile to change, got %+v", updated)
+	}
+
+	reply, err = service.SendMessage(ctx, session.ID, "Sorry, I'm vegetarian but not allergic to shellfish")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if reply.ProfileChanges != nil || len(reply.PendingRemovals) != 1 || !strings.HasPrefix(reply.Text, "Please confirm that I should remove shellfish (anaphylaxis) from your profile") {
+		t.Fatalf("expected the retraction to wait for confirmation, got %q (%+v)", reply.Text, reply.PendingRemovals)
+	}
+	if pending, _ := service.GetSession(ctx, session.ID); len(pending.HardAllergens) != 1 || len(pending.PendingRemovals) != 1 {
+		t.Fatalf("expected the shellfish allergy to stay until confirmed, got %+v", pending)
+	}
+	if _, err := service.ConfirmProfileRemovals(ctx, session.ID); err != nil {
+		t.Fatalf("confirm removals: %v", err)
+	}
+	if _, err := service.ConfirmProfileRemovals(ctx, session.ID); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("expected nothing left to confirm, got %v", err)
+	}
+	if reply, err = service.SendMessage(ctx, session.ID, "what do you recommend?"); err != nil || reply.ProfileChanges != nil || strings.Contains(reply.Text, "updated your profile") {
+		t.Fatalf("expected no profile echo without a declaration, got %q (%v)", reply.Text, err)
+	}
+
+	turns, err := service.GetTranscript(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("load transcript: %v", err)
+	}
+	if len(turns[0].ProfileChanges) != 2 || turns[2].ProfileChanges != nil || turns[4].ProfileChanges != nil {
+		t.Fatalf("expected guest turns to record their profile changes, got %+v", turns)
+	}
+	if updated, _ := service.GetSession(ctx, session.ID); len(updated.Allergies) != 0 || len(updated.HardAllergens) != 0 {
+		t.Fatalf("expected the shellfish allergy to be removed, got %+v", updated)
+	}
+}
@@ -0,0 +1,299 @@
+package agent
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+// sentenceEnd finds the end of a complete sentence in streamed text, including
+// the whitespace after it. Text ending in "." without whitespace may still be a
+// price or an abbreviation, so it waits for more input.
+var sentenceEnd = regexp.MustCompile(`[.!?]+["')\]]*\s+|\n+`)
+
+// recommendationCue finds a dish the reply recommends by name, for example
+// "try the lobster bisque". The capture is trimmed at guardStopWords.
+var recommendationCue = regexp.MustCompile(`(?i)\b(?:recommend|suggest|try|order|get|go with|pick|choose)\s+(?:the|our)\s+([a-z][a-z'-]*(?:\s+[a-z][a-z'-]*){0,4})`)
+
+// guardStopWords end the dish phrase captured after a recommendation cue.
+var guardStopWords = map[string]bool{
+	"and": true, "or": true, "with": true, "which": true, "that": true, "for": true, "if": true,
+	"since": true, "because": true, "as": true, "instead": true, "today": true, "tonight": true,
+	"it": true, "is": true, "to": true, "from": true, "on": true, "at": true, "here": true,
+}
+
+// genericDishWords name menu sections rather than dishes, so "try the daily
+// special" is not an unknown dish.
+var genericDishWords = map[string]bool{
+	"menu": true, "special": true, "specials": true, "dish": true, "dishes": true, "option": true,
+	"options": true, "selection": true, "chef's": true, "chef": true, "house": true, "kitchen": true,
+	"daily": true, "dessert": true, "desserts": true, "drinks": true, "starters": true, "mains": true,
+	"sides": true, "staff": true, "server": true, "one": true, "safe": true, "other": true,
+}
+
+// guardedItem is one menu item the reply guard looks for.
+type guardedItem struct {
+	item    domain.MenuItem
+	name    *regexp.Regexp
+	words   []string
+	allowed bool
+}
+
+// ReplyGuard checks model replies against the menu before guests see them.
+// A sentence that names a dish the safety policy excluded, or recommends a dish
+// that is not on the menu, is removed. Mentions count even when the model warns
+// against the dish; the safety note already explains exclusions.
+type ReplyGuard struct {
+	items []guardedItem
+}
+
+// NewReplyGuard builds a guard for a reply that may recommend allowed, out of
+// the full menu. Items on the menu but not in allowed are excluded.
+func NewReplyGuard(menu, allowed []domain.MenuItem) *ReplyGuard {
+	allowedKeys := map[string]bool{}
+	for _, item := range allowed {
+		allowedKeys[guardKey(item)] = true
+	}
+	guard := &ReplyGuard{}
+	for _, item := range menu {
+		pattern := dishNamePattern(item.Name)
+		if pattern == nil {
+			continue
+		}
+		guard.items = append(guard.items, guardedItem{
+			item:    item,
+			name:    pattern,
+			words:   dishWords(item.Name),
+			allowed: allowedKeys[guardKey(item)],
+		})
+	}
+	// Longer names match first so "Veggie Pad Thai" is not read as "Pad Thai".
+	sort.SliceStable(guard.items, func(i, j int) bool {
+		return len(guard.items[i].item.Name) > len(guard.items[j].item.Name)
+	})
+	return guard
+}
+
+// Check returns the interventions that remove sentence, or nil to keep it.
+func (g *ReplyGuard) Check(sentence string) []domain.SafetyIntervention {
+	var interventions []domain.SafetyIntervention
+	remove := func(reason domain.InterventionReason, item domain.MenuItem, phrase string) {
+		name := item.Name
+		if name == "" {
+			name = phrase
+		}
+		interventions = append(interventions, domain.SafetyIntervention{
+			Action:   domain.InterventionRemoved,
+			Reason:   reason,
+			ItemID:   item.ID,
+			Item:     name,
+			Sentence: strings.TrimSpace(sentence),
+		})
+	}
+
+	masked := sentence
+	for _, candidate := range g.items {
+		if !candidate.name.MatchString(masked) {
+			continue
+		}
+		if !candidate.allowed {
+			remove(domain.InterventionExcluded, candidate.item, "")
+		}
+		masked = candidate.name.ReplaceAllStringFunc(masked, func(match string) string {
+			return strings.Repeat(" ", len(match))
+		})
+	}
+
+	for _, match := range recommendationCue.FindAllStringSubmatch(masked, -1) {
+		phrase := dishPhrase(match[1])
+		if phrase == "" {
+			continue
+		}
+		if item, known, allowed := g.matchWords(phrase); !known {
+			remove(domain.InterventionUnknown, domain.MenuItem{}, phrase)
+		} else if !allowed {
+			remove(domain.InterventionExcluded, item, "")
+		}
+	}
+	return interventions
+}
+
+// matchWords reports whether a recommended phrase shares a word with a menu
+// item name, preferring allowed items. Phrases made only of generic words such
+// as "daily special" count as allowed.
+func (g *ReplyGuard) matchWords(phrase string) (domain.MenuItem, bool, bool) {
+	words := dishWords(phrase)
+	if len(words) == 0 {
+		return domain.MenuItem{}, true, true
+	}
+	var excluded *domain.MenuItem
+	for i, candidate := range g.items {
+		if !sharesWord(candidate.words, words) {
+			continue
+		}
+		if candidate.allowed {
+			return candidate.item, true, true
+		}
+		if excluded == nil {
+			excluded = &g.items[i].item
+		}
+	}
+	if excluded != nil {
+		return *excluded, true, false
+	}
+	return domain.MenuItem{}, false, false
+}
+
+// guardedStream passes model output through a ReplyGuard one complete sentence
+// at a time, so a removed sentence never reaches the guest. Emit may be nil.
+type guardedStream struct {
+	guard         *ReplyGuard
+	emit          func(delta string) error
+	pending       strings.Builder
+	kept          strings.Builder
+	interventions []domain.SafetyIntervention
+}
+
+func newGuardedStream(guard *ReplyGuard, emit func(delta string) error) *guardedStream {
+	return &guardedStream{guard: guard, emit: emit}
+}
+
+// Write buffers delta and forwards every sentence it completes.
+func (s *guardedStream) Write(delta string) error {
+	s.pending.WriteString(delta)
+	text := s.pending.String()
+	consumed := 0
+	for {
+		loc := sentenceEnd.FindStringIndex(text[consumed:])
+		if loc == nil {
+			break
+		}
+		end := consumed + loc[1]
+		if err := s.forward(text[consumed:end]); err != nil {
+			return err
+		}
+		consumed = end
+	}
+	if consumed > 0 {
+		s.pending.Reset()
+		s.pending.WriteString(text[consumed:])
+	}
+	return nil
+}
+
+// Discard drops the buffered, not yet forwarded text of a reply the model
+// did not finish.
+func (s *guardedStream) Discard() {
+	s.pending.Reset()
+}
+
+// Close forwards the last, unterminated sentence. When every sentence was
+// removed, it emits the high-risk disclaimer instead. It returns the reply the
+// guest saw.
+func (s *guardedStream) Close() (string, error) {
+	if rest := s.pending.String(); rest != "" {
+		s.pending.Reset()
+		if err := s.forward(rest); err != nil {
+			return "", err
+		}
+	}
+	if len(s.interventions) > 0 && strings.TrimSpace(s.kept.String()) == "" {
+		s.interventions = append(s.interventions, domain.SafetyIntervention{
+			Action: domain.InterventionReplaced,
+			Reason: domain.InterventionUnrepairable,
+		})
+		s.kept.Reset()
+		s.kept.WriteString(highRiskDisclaimer)
+		if s.emit != nil {
+			if err := s.emit(highRiskDisclaimer); err != nil {
+				return "", err
+			}
+		}
+	}
+	return strings.TrimRightFunc(s.kept.String(), unicode.IsSpace), nil
+}
+
+func (s *guardedStream) forward(sentence string) error {
+	if interventions := s.guard.Check(sentence); len(interventions) > 0 {
+		s.interventions = append(s.interventions, interventions...)
+		return nil
+	}
+	s.kept.WriteString(sentence)
+	if s.emit == nil {
+		return nil
+	}
+	return s.emit(sentence)
+}
+
+// dishNamePattern matches name as whole words, case-insensitively, allowing a
+// plural ending and any run of whitespace between words.
+func dishNamePattern(name string) *regexp.Regexp {
+	fields := strings.Fields(strings.ToLower(name))
+	if len(fields) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = regexp.QuoteMeta(field)
+	}
+	pattern := strings.Join(quoted, `\s+`)
+	if isWordByte(fields[0][0]) {
+		pattern = `\b` + pattern
+	}
+	if last := fields[len(fields)-1]; isWordByte(last[len(last)-1]) {
+		pattern += `(?:e?s)?\b`
+	}
+	return regexp.MustCompile(`(?i)` + pattern)
+}
+
+// dishPhrase trims a recommendation capture at the first stop word.
+func dishPhrase(capture string) string {
+	var words []string
+	for _, word := range strings.Fields(strings.ToLower(capture)) {
+		if guardStopWords[word] {
+			break
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
+
+// dishWords returns the distinctive lower-case words of a dish name, without
+// generic words or a plural "s".
+func dishWords(name string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '\'')
+	}) {
+		if len(word) < 3 || genericDishWords[word] || guardStopWords[word] {
+			continue
+		}
+		words = append(words, strings.TrimSuffix(word, "s"))
+	}
+	return words
+}
+
+func sharesWord(a, b []string) bool {
+	for _, left := range a {
+		for _, right := range b {
+			if left == right {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func guardKey(item domain.MenuItem) string {
+	if item.ID != "" {
+		return "id:" + item.ID
+	}
+	return "name:" + strings.ToLower(strings.TrimSpace(item.Name))
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
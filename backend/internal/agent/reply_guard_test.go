@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+func guardTestMenu() (menu, allowed []domain.MenuItem) {
+	menu = []domain.MenuItem{
+		{ID: "satay", Name: "Satay"},
+		{ID: "veggie-pad-thai", Name: "Veggie Pad Thai"},
+		{ID: "pad-thai", Name: "Pad Thai"},
+		{ID: "salad", Name: "House Salad"},
+		{ID: "tacos", Name: "Shrimp Tacos"},
+	}
+	return menu, []domain.MenuItem{menu[1], menu[3]}
+}
+
+func TestReplyGuardFlagsExcludedAndUnknownDishes(t *testing.T) {
+	t.Parallel()
+	guard := NewReplyGuard(guardTestMenu())
+	cases := []struct {
+		sentence string
+		reason   domain.InterventionReason
+		item     string
+	}{
+		{"Try the House Salad.", "", ""},
+		{"I'd recommend the veggie pad thai tonight.", "", ""},
+		{"Our salads are fresh today.", "", ""},
+		{"You could try the daily special as well.", "", ""},
+		{"The Satay is lovely.", domain.InterventionExcluded, "Satay"},
+		{"Pad Thai is a classic here.", domain.InterventionExcluded, "Pad Thai"},
+		{"I suggest the tacos.", domain.InterventionExcluded, "Shrimp Tacos"},
+		{"You could try the lobster bisque with a side of bread.", domain.InterventionUnknown, "lobster bisque"},
+	}
+	for _, tc := range cases {
+		interventions := guard.Check(tc.sentence)
+		if tc.reason == "" {
+			if interventions != nil {
+				t.Errorf("%q: expected the sentence to be kept, got %+v", tc.sentence, interventions)
+			}
+			continue
+		}
+		if len(interventions) != 1 || interventions[0].Reason != tc.reason || interventions[0].Item != tc.item || interventions[0].Sentence != tc.sentence {
+			t.Errorf("%q: expected %s %q, got %+v", tc.sentence, tc.reason, tc.item, interventions)
+		}
+	}
+}
+
+func TestGuardedStreamForwardsOnlyKeptSentences(t *testing.T) {
+	t.Parallel()
+	guard := NewReplyGuard(guardTestMenu())
+
+	var deltas []string
+	stream := newGuardedStream(guard, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	for _, word := range strings.SplitAfter("Try the House Salad. The Satay is great too! Enjoy your meal", " ") {
+		if err := stream.Write(word); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	reply, err := stream.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if want := []string{"Try the House Salad. ", "Enjoy your meal"}; !reflect.DeepEqual(deltas, want) {
+		t.Fatalf("expected only kept sentences to stream, got %q", deltas)
+	}
+	if reply != "Try the House Salad. Enjoy your meal" || len(stream.interventions) != 1 {
+		t.Fatalf("unexpected guarded reply %q (%+v)", reply, stream.interventions)
+	}
+
+	stream = newGuardedStream(guard, nil)
+	if err := stream.Write("The Satay is great. Or order the lobster roll.\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if reply, err = stream.Close(); err != nil || reply != highRiskDisclaimer {
+		t.Fatalf("expected the disclaimer when nothing can be kept, got %q (%v)", reply, err)
+	}
+	if last := stream.interventions[len(stream.interventions)-1]; len(stream.interventions) != 3 || last.Action != domain.InterventionReplaced {
+		t.Fatalf("expected two removals and a replacement, got %+v", stream.interventions)
+	}
+}
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []domain.SessionEvent
+}
+
+func (p *recordingPublisher) Publish(event domain.SessionEvent) domain.SessionEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return event
+}
+
+func TestSendMessageGuardsModelReplies(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	client := &scriptedClient{reply: "The House Salad is a great pick. You might also love the Peanut Curry. Ask about the lobster special."}
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, client))
+	events := &recordingPublisher{}
+	service.SetEventPublisher(events)
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{
+		{ID: "salad", Name: "House Salad"},
+		{ID: "curry", Name: "Peanut Curry", Allergens: []domain.Allergen{domain.AllergenPeanut}},
+	}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	session, err := service.StartSession(ctx, "rest-1", anaphylaxis(domain.AllergenPeanut), nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+
+	reply, err := service.SendMessage(ctx, session.ID, "what should I eat?")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !strings.HasPrefix(reply.Text, "The House Salad is a great pick. Ask about the lobster special.\n\nSafety note:") {
+		t.Fatalf("expected the curry sentence to be removed, got %q", reply.Text)
+	}
+	if len(reply.Interventions) != 1 || reply.Interventions[0].ItemID != "curry" || reply.Interventions[0].Reason != domain.InterventionExcluded {
+		t.Fatalf("expected one excluded-dish intervention, got %+v", reply.Interventions)
+	}
+
+	turns, err := service.GetTranscript(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("load transcript: %v", err)
+	}
+	if got := turns[len(turns)-1]; strings.Contains(got.Text, "Curry") || len(got.Interventions) != 1 {
+		t.Fatalf("expected the transcript to keep the guarded reply and its interventions, got %+v", got)
+	}
+
+	client.reply = "Try the Peanut Curry."
+	if reply, err = service.SendMessage(ctx, session.ID, "anything else?"); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !strings.HasPrefix(reply.Text, highRiskDisclaimer) || len(reply.Interventions) != 2 {
+		t.Fatalf("expected an unrepairable reply to fall back to the disclaimer, got %q (%+v)", reply.Text, reply.Interventions)
+	}
+
+	var logged int
+	for _, event := range events.events {
+		if event.Type == domain.SessionEventSafetyIntervention {
+			logged += len(event.Interventions)
+		}
+	}
+	if logged != 3 {
+		t.Fatalf("expected every intervention to be published as a safety event, got %d", logged)
+	}
+}
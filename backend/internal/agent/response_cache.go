@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+const (
+	responseCacheSizeDefault = 1024
+	responseCacheTTLDefault  = 10 * time.Minute
+)
+
+// CacheKey identifies a cached model reply. Replies are only reused for the
+// same model, menu version and safety profile, and RestaurantID lets a cache
+// drop every reply for a restaurant whose menu changed.
+type CacheKey struct {
+	Model        string
+	RestaurantID string
+	MenuVersion  int
+	ProfileHash  string
+	Input        string
+}
+
+// Digest returns a fixed-length hash of every field, suitable as a storage key.
+func (k CacheKey) Digest() string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00%d\x00%s\x00%s", k.Model, k.RestaurantID, k.MenuVersion, k.ProfileHash, k.Input))
+	return hex.EncodeToString(sum[:])
+}
+
+// CacheStats counts cache outcomes since the cache was created.
+type CacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Expirations   uint64 `json:"expirations"`
+	Invalidations uint64 `json:"invalidations"`
+	Size          int    `json:"size"`
+	Capacity      int    `json:"capacity"`
+}
+
+// ResponseCache stores model replies between turns. The runtime treats errors
+// as misses, so a shared backend that is unreachable only costs model calls.
+// Implementations must be safe for concurrent use; one backed by a shared store
+// lets Cloud Run replicas reuse each other's replies.
+type ResponseCache interface {
+	Get(ctx context.Context, key CacheKey) (string, bool, error)
+	Set(ctx context.Context, key CacheKey, reply string) error
+	// InvalidateRestaurant drops every reply cached for restaurantID.
+	InvalidateRestaurant(ctx context.Context, restaurantID string) error
+	Stats() CacheStats
+}
+
+// LRUCache is an in-process ResponseCache holding at most capacity replies,
+// each for at most ttl. The least recently used reply is evicted first.
+type LRUCache struct {
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu           sync.Mutex
+	order        *list.List
+	entries      map[string]*list.Element
+	byRestaurant map[string]map[string]struct{}
+	stats        CacheStats
+}
+
+type lruEntry struct {
+	digest       string
+	restaurantID string
+	reply        string
+	expires      time.Time
+}
+
+// NewLRUCache returns an empty cache. A capacity or ttl that is not positive
+// falls back to the defaults.
+func NewLRUCache(capacity int, ttl time.Duration) *LRUCache {
+	if capacity <= 0 {
+		capacity = responseCacheSizeDefault
+	}
+	if ttl <= 0 {
+		ttl = responseCacheTTLDefault
+	}
+	return &LRUCache{
+		capacity:     capacity,
+		ttl:          ttl,
+		now:          time.Now,
+		order:        list.New(),
+		entries:      map[string]*list.Element{},
+		byRestaurant: map[string]map[string]struct{}{},
+	}
+}
+
+func (c *LRUCache) Get(_ context.Context, key CacheKey) (string, bool, error) {
+	digest := key.Digest()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[digest]
+	if !ok {
+		c.stats.Misses++
+		return "", false, nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(element)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return "", false, nil
+	}
+	c.order.MoveToFront(element)
+	c.stats.Hits++
+	return entry.reply, true, nil
+}
+
+func (c *LRUCache) Set(_ context.Context, key CacheKey, reply string) error {
+	digest := key.Digest()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[digest]; ok {
+		c.remove(element)
+	}
+	entry := &lruEntry{digest: digest, restaurantID: key.RestaurantID, reply: reply, expires: c.now().Add(c.ttl)}
+	c.entries[digest] = c.order.PushFront(entry)
+	if c.byRestaurant[key.RestaurantID] == nil {
+		c.byRestaurant[key.RestaurantID] = map[string]struct{}{}
+	}
+	c.byRestaurant[key.RestaurantID][digest] = struct{}{}
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+	return nil
+}
+
+func (c *LRUCache) InvalidateRestaurant(_ context.Context, restaurantID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for digest := range c.byRestaurant[restaurantID] {
+		c.remove(c.entries[digest])
+		c.stats.Invalidations++
+	}
+	return nil
+}
+
+func (c *LRUCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.order.Len()
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// remove drops element from every index. The caller holds c.mu.
+func (c *LRUCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*lruEntry)
+	delete(c.entries, entry.digest)
+	if digests := c.byRestaurant[entry.restaurantID]; digests != nil {
+		delete(digests, entry.digest)
+		if len(digests) == 0 {
+			delete(c.byRestaurant, entry.restaurantID)
+		}
+	}
+}
+
+// profileHash summarizes what the safety policy filters on for a session, so
+// guests with different allergies never share a cached reply.
+func profileHash(session domain.ConciergeSession) string {
+	parts := make([]string, 0, len(session.Allergies)+len(session.PreferenceTags))
+	for _, allergy := range session.AllergyProfile() {
+		parts = append(parts, "allergy:"+string(allergy.Allergen)+"="+string(allergy.Severity))
+	}
+	for _, tag := range session.PreferenceTags {
+		parts = append(parts, "tag:"+normalizeTag(tag))
+	}
+	slices.Sort(parts)
+	parts = slices.Compact(parts)
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
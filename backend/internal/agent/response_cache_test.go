@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+func TestLRUCacheEvictsExpiresAndInvalidates(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewLRUCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+	key := func(restaurantID, input string) CacheKey {
+		return CacheKey{Model: "gemini", RestaurantID: restaurantID, MenuVersion: 1, ProfileHash: "p", Input: input}
+	}
+
+	_ = cache.Set(ctx, key("r1", "a"), "reply a")
+	_ = cache.Set(ctx, key("r1", "b"), "reply b")
+	if reply, ok, _ := cache.Get(ctx, key("r1", "a")); !ok || reply != "reply a" {
+		t.Fatalf("expected a hit for a, got %q %v", reply, ok)
+	}
+	_ = cache.Set(ctx, key("r2", "c"), "reply c")
+	if _, ok, _ := cache.Get(ctx, key("r1", "b")); ok {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+
+	variants := []CacheKey{
+		{Model: "other", RestaurantID: "r1", MenuVersion: 1, ProfileHash: "p", Input: "a"},
+		{Model: "gemini", RestaurantID: "r1", MenuVersion: 2, ProfileHash: "p", Input: "a"},
+		{Model: "gemini", RestaurantID: "r1", MenuVersion: 1, ProfileHash: "q", Input: "a"},
+	}
+	for _, variant := range variants {
+		if _, ok, _ := cache.Get(ctx, variant); ok {
+			t.Fatalf("expected %+v not to share the cached reply", variant)
+		}
+	}
+
+	if err := cache.InvalidateRestaurant(ctx, "r1"); err != nil {
+		t.Fatalf("invalidate: %v", err)
+	}
+	if _, ok, _ := cache.Get(ctx, key("r1", "a")); ok {
+		t.Fatal("expected invalidation to drop the restaurant's replies")
+	}
+	if _, ok, _ := cache.Get(ctx, key("r2", "c")); !ok {
+		t.Fatal("expected other restaurants to keep their replies")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok, _ := cache.Get(ctx, key("r2", "c")); ok {
+		t.Fatal("expected the reply to expire after the TTL")
+	}
+
+	want := CacheStats{Hits: 2, Misses: 6, Evictions: 1, Expirations: 1, Invalidations: 1, Size: 0, Capacity: 2}
+	if got := cache.Stats(); got != want {
+		t.Fatalf("expected stats %+v, got %+v", want, got)
+	}
+}
+
+func TestRuntimeCacheKeysOnProfileAndIsInvalidatedByMenuSaves(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	client := &fakeClient{}
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, client))
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{{ID: "salad", Name: "House Salad"}}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	ask := func(allergies []domain.Allergy) {
+		t.Helper()
+		session, err := service.StartSession(ctx, "rest-1", allergies, nil)
+		if err != nil {
+			t.Fatalf("start session: %v", err)
+		}
+		if _, err := service.SendMessage(ctx, session.ID, "what is good?"); err != nil {
+			t.Fatalf("send message: %v", err)
+		}
+	}
+
+	ask(nil)
+	ask(nil)
+	if client.calls != 1 {
+		t.Fatalf("expected the second identical turn to be served from cache, got %d model calls", client.calls)
+	}
+	ask(anaphylaxis(domain.AllergenSesame))
+	if client.calls != 2 {
+		t.Fatalf("expected a different allergy profile to miss the cache, got %d model calls", client.calls)
+	}
+
+	if _, err := service.SaveMenuDraft(ctx, "rest-1", []domain.MenuItem{{ID: "salad", Name: "House Salad", Description: "now with croutons"}}, MenuSourceDirect); err != nil {
+		t.Fatalf("save draft: %v", err)
+	}
+	if stats := service.CacheStats(); stats.Size != 0 || stats.Invalidations != 2 || stats.Hits != 1 {
+		t.Fatalf("expected the menu save to invalidate both cached replies, got %+v", stats)
+	}
+}
+
+func TestRuntimeCachesOnlyFirstTurns(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	client := &fakeClient{}
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, client))
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{{ID: "salad", Name: "House Salad"}}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	for range 3 {
+		session, err := service.StartSession(ctx, "rest-1", nil, nil)
+		if err != nil {
+			t.Fatalf("start session: %v", err)
+		}
+		for range 2 {
+			if _, err := service.SendMessage(ctx, session.ID, "what is good?"); err != nil {
+				t.Fatalf("send message: %v", err)
+			}
+		}
+	}
+	if client.calls != 4 {
+		t.Fatalf("expected one first-turn call and three follow-up calls, got %d model calls", client.calls)
+	}
+	if stats := service.CacheStats(); stats.Hits != 2 || stats.Misses != 1 || stats.Size != 1 {
+		t.Fatalf("expected first turns to hit after the first and follow-ups to skip the cache, got %+v", stats)
+	}
+}
+
+func TestSessionDraftsKeepTheRestaurantsCachedReplies(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	client := &fakeClient{}
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, client))
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{{ID: "salad", Name: "House Salad"}}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	ask := func() {
+		t.Helper()
+		session, err := service.StartSession(ctx, "rest-1", nil, nil)
+		if err != nil {
+			t.Fatalf("start session: %v", err)
+		}
+		if _, err := service.SendMessage(ctx, session.ID, "what is good?"); err != nil {
+			t.Fatalf("send message: %v", err)
+		}
+	}
+
+	ask()
+	if _, err := service.SaveSessionMenu(ctx, "rest-1", []domain.MenuItem{{ID: "soup", Name: "Tomato Soup"}}); err != nil {
+		t.Fatalf("save session menu: %v", err)
+	}
+	ask()
+	if stats := service.CacheStats(); client.calls != 1 || stats.Hits != 1 || stats.Invalidations != 0 {
+		t.Fatalf("expected the session draft to keep the cached reply, got %d model calls and %+v", client.calls, stats)
+	}
+}
@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// nameFieldWeight repeats name tokens so a dish named for the query outranks
+	// one that only mentions it in its description.
+	nameFieldWeight = 2
+	// preferenceWeight scales the safety engine's preference score against a
+	// normalized lexical score of at most 1.
+	preferenceWeight = 0.5
+)
+
+// retrievalStopWords carry no signal about which dish a guest wants.
+var retrievalStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "any": true, "are": true, "can": true, "do": true, "for": true,
+	"have": true, "i": true, "i'd": true, "i'm": true, "is": true, "it": true, "like": true, "me": true,
+	"my": true, "of": true, "on": true, "or": true, "please": true, "some": true, "something": true,
+	"the": true, "to": true, "want": true, "what": true, "which": true, "with": true, "would": true,
+	"you": true, "your": true,
+}
+
+// RetrievalCandidate is a safe menu item with the preference score the safety
+// engine gave it for this guest. Deprioritized items score lower.
+type RetrievalCandidate struct {
+	Item       domain.MenuItem
+	Preference float64
+}
+
+// MenuRetriever picks the safe menu items most relevant to a prompt, so the
+// model sees the dishes a guest is asking about rather than the first few on
+// the menu. Retrieve returns at most k items, best first.
+type MenuRetriever interface {
+	Retrieve(ctx context.Context, query string, candidates []RetrievalCandidate, k int) ([]domain.MenuItem, error)
+}
+
+// BM25Retriever ranks candidates by lexical BM25 over name, description and
+// tags plus their preference score. Ties keep candidate order, so a prompt that
+// matches nothing gets the safety engine's ordering. An experiment variant in
+// the context may change the preference weight.
+type BM25Retriever struct{}
+
+func NewBM25Retriever() *BM25Retriever {
+	return &BM25Retriever{}
+}
+
+func (r *BM25Retriever) Retrieve(ctx context.Context, query string, candidates []RetrievalCandidate, k int) ([]domain.MenuItem, error) {
+	preference, _ := rankingWeights(ctx)
+	return rankCandidates(candidates, bm25Scores(query, candidates), preference, k), nil
+}
+
+// bm25Scores scores each candidate against query, normalized so the best match
+// scores 1. Every score is 0 when nothing matches.
+func bm25Scores(query string, candidates []RetrievalCandidate) []float64 {
+	scores := make([]float64, len(candidates))
+	terms := retrievalTokens(query)
+	if len(terms) == 0 || len(candidates) == 0 {
+		return scores
+	}
+
+	docs := make([]map[string]int, len(candidates))
+	lengths := make([]int, len(candidates))
+	frequency := map[string]int{}
+	total := 0
+	for i, candidate := range candidates {
+		docs[i] = map[string]int{}
+		for _, token := range menuItemTokens(candidate.Item) {
+			docs[i][token]++
+			lengths[i]++
+		}
+		for token := range docs[i] {
+			frequency[token]++
+		}
+		total += lengths[i]
+	}
+	averageLength := math.Max(float64(total)/float64(len(candidates)), 1)
+
+	best := 0.0
+	for i, doc := range docs {
+		for _, term := range terms {
+			tf := float64(doc[term])
+			if tf == 0 {
+				continue
+			}
+			df := float64(frequency[term])
+			idf := math.Log(1 + (float64(len(candidates))-df+0.5)/(df+0.5))
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(lengths[i])/averageLength))
+		}
+		best = math.Max(best, scores[i])
+	}
+	if best > 0 {
+		for i := range scores {
+			scores[i] /= best
+		}
+	}
+	return scores
+}
+
+// rankCandidates orders candidates by relevance plus preference scaled by
+// weight and returns the top k items.
+func rankCandidates(candidates []RetrievalCandidate, relevance []float64, weight float64, k int) []domain.MenuItem {
+	order := make([]int, len(candidates))
+	bestPreference := 0.0
+	for i, candidate := range candidates {
+		order[i] = i
+		bestPreference = math.Max(bestPreference, math.Abs(candidate.Preference))
+	}
+	score := func(i int) float64 {
+		if bestPreference == 0 {
+			return relevance[i]
+		}
+		return relevance[i] + weight*candidates[i].Preference/bestPreference
+	}
+	sort.SliceStable(order, func(a, b int) bool { return score(order[a]) > score(order[b]) })
+
+	if k <= 0 || k > len(order) {
+		k = len(order)
+	}
+	items := make([]domain.MenuItem, k)
+	for i := range items {
+		items[i] = candidates[order[i]].Item
+	}
+	return items
+}
+
+// menuItemTokens returns the searchable tokens of an item, name tokens weighted
+// by nameFieldWeight.
+func menuItemTokens(item domain.MenuItem) []string {
+	var tokens []string
+	name := retrievalTokens(item.Name)
+	for range nameFieldWeight {
+		tokens = append(tokens, name...)
+	}
+	tokens = append(tokens, retrievalTokens(item.Description)...)
+	for _, tag := range item.Tags {
+		tokens = append(tokens, retrievalTokens(tag)...)
+	}
+	return tokens
+}
+
+// menuItemText is the text an embedder sees for an item.
+func menuItemText(item domain.MenuItem) string {
+	return strings.Join(append([]string{item.Name, item.Description}, item.Tags...), " ")
+}
+
+// retrievalTokens lower-cases text, splits it into words, drops stop words and
+// strips a plural "s" so "tacos" matches "taco".
+func retrievalTokens(text string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		word = strings.Trim(word, "'")
+		if word == "" || retrievalStopWords[word] {
+			continue
+		}
+		if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+			word = strings.TrimSuffix(word, "s")
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
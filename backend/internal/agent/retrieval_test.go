@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+// largeMenu returns 60 filler dishes with a few distinctive ones near the end,
+// past the first-N window the model used to see.
+func largeMenu() []domain.MenuItem {
+	items := make([]domain.MenuItem, 0, 60)
+	for i := range 56 {
+		items = append(items, domain.MenuItem{ID: fmt.Sprintf("dish-%d", i), Name: fmt.Sprintf("Chef Plate %d", i), Description: "Seasonal vegetables and grains"})
+	}
+	return append(items,
+		domain.MenuItem{ID: "risotto", Name: "Mushroom Risotto", Description: "Arborio rice with porcini mushrooms", Tags: []string{"vegetarian"}},
+		domain.MenuItem{ID: "tacos", Name: "Fish Tacos", Description: "Grilled cod with lime slaw"},
+		domain.MenuItem{ID: "soup", Name: "Wild Mushroom Soup", Description: "Creamy and earthy", Tags: []string{"vegetarian"}},
+		domain.MenuItem{ID: "tiramisu", Name: "Tiramisu", Description: "Espresso-soaked ladyfingers"},
+	)
+}
+
+func candidatesFor(items []domain.MenuItem) []RetrievalCandidate {
+	candidates := make([]RetrievalCandidate, len(items))
+	for i, item := range items {
+		candidates[i] = RetrievalCandidate{Item: item}
+	}
+	return candidates
+}
+
+func itemIDs(items []domain.MenuItem) []string {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return ids
+}
+
+func TestBM25RetrieverRanksMatchingDishesFirst(t *testing.T) {
+	t.Parallel()
+	retriever := NewBM25Retriever()
+	candidates := candidatesFor(largeMenu())
+
+	items, err := retriever.Retrieve(context.Background(), "Do you have any mushroom risotto?", candidates, 8)
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if len(items) != 8 || items[0].ID != "risotto" || items[1].ID != "soup" {
+		t.Fatalf("expected the risotto then the soup, got %v", itemIDs(items))
+	}
+
+	items, _ = retriever.Retrieve(context.Background(), "fish taco", candidates, 3)
+	if items[0].ID != "tacos" {
+		t.Fatalf("expected plural-insensitive name matches, got %v", itemIDs(items))
+	}
+
+	items, _ = retriever.Retrieve(context.Background(), "surprise me", candidates, 3)
+	if want := []string{"dish-0", "dish-1", "dish-2"}; !reflect.DeepEqual(itemIDs(items), want) {
+		t.Fatalf("expected candidate order when nothing matches, got %v", itemIDs(items))
+	}
+
+	candidates[58].Preference = 1
+	items, _ = retriever.Retrieve(context.Background(), "mushroom", candidates, 2)
+	if items[0].ID != "soup" {
+		t.Fatalf("expected the preference score to lift a close match, got %v", itemIDs(items))
+	}
+}
+
+// countingEmbedder records how many texts reach the wrapped embedder.
+type countingEmbedder struct {
+	Embedder
+	texts int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.texts += len(texts)
+	return e.Embedder.Embed(ctx, texts)
+}
+
+func TestHybridRetrieverMatchesNearSpellingsAndCachesItems(t *testing.T) {
+	t.Parallel()
+	embedder := &countingEmbedder{Embedder: NewHashEmbedder(0)}
+	retriever := NewHybridRetriever(embedder)
+	candidates := candidatesFor(largeMenu())
+
+	items, err := retriever.Retrieve(context.Background(), "tiramisù", candidates, 3)
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if items[0].ID != "tiramisu" {
+		t.Fatalf("expected embeddings to match the accented spelling, got %v", itemIDs(items))
+	}
+	if embedder.texts != len(candidates)+1 {
+		t.Fatalf("expected every item and the query to be embedded once, got %d", embedder.texts)
+	}
+
+	if _, err := retriever.Retrieve(context.Background(), "espresso dessert", candidates, 3); err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if embedder.texts != len(candidates)+2 {
+		t.Fatalf("expected cached item vectors on the second call, got %d embedded texts", embedder.texts)
+	}
+
+	first, _ := NewHashEmbedder(32).Embed(context.Background(), []string{"Mushroom Risotto"})
+	second, _ := NewHashEmbedder(32).Embed(context.Background(), []string{"Mushroom Risotto"})
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("expected the hash embedder to be deterministic")
+	}
+}
+
+func TestSelectRelevantMenuItemsKeepsRunesWhole(t *testing.T) {
+	t.Parallel()
+	long := strings.Repeat("a", maxItemLength-1) + "é and more"
+	selected := selectRelevantMenuItems(namedMenuItems([]string{long}), 1)
+	if !utf8.ValidString(selected[0].Name) || selected[0].Name != strings.Repeat("a", maxItemLength-1) {
+		t.Fatalf("expected truncation before the split rune, got %q", selected[0].Name)
+	}
+}
+
+func TestSendMessageShowsTheModelRelevantDishesFromLargeMenus(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, &fakeClient{}))
+	if _, err := service.SaveMenuItems(ctx, "rest-1", largeMenu()); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	session, err := service.StartSession(ctx, "rest-1", nil, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+
+	// The fake client echoes the model input, menu list included.
+	reply, err := service.SendMessage(ctx, session.ID, "Is the tiramisu good?")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !strings.Contains(reply.Text, "- Tiramisu") || len(menuOptions(reply.Text)) != maxMenuItemsDefault {
+		t.Fatalf("expected the model to see the tiramisu among %d dishes, got %q", maxMenuItemsDefault, reply.Text)
+	}
+}
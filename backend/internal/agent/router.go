@@ -0,0 +1,320 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	routeTimeoutDefault            = 20 * time.Second
+	routeMaxRetriesDefault         = 2
+	routeBaseBackoffDefault        = 200 * time.Millisecond
+	routeMaxBackoffDefault         = 2 * time.Second
+	breakerFailureThresholdDefault = 3
+	breakerCooldownDefault         = 30 * time.Second
+)
+
+// ErrNoProviderAvailable is returned when every provider of a RouterClient
+// failed or had its circuit open.
+var ErrNoProviderAvailable = errors.New("no model provider available")
+
+// Circuit breaker states reported in ProviderStats.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// RouteProvider is one client a RouterClient can send a request to. Model, if
+// set, replaces the runtime's model name, so one Gemini client can serve both
+// the primary model and a cheaper fallback. Timeout bounds each attempt.
+type RouteProvider struct {
+	Name    string
+	Client  Client
+	Model   string
+	Timeout time.Duration
+}
+
+// RouterConfig tunes retries and circuit breaking. Zero values use defaults,
+// except MaxRetries: zero disables retries and a negative value uses the
+// default.
+type RouterConfig struct {
+	MaxRetries       int
+	BaseBackoff      time.Duration
+	MaxBackoff       time.Duration
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// ProviderStats reports one provider's circuit and call counters.
+type ProviderStats struct {
+	Name                string `json:"name"`
+	Model               string `json:"model,omitempty"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	Requests            uint64 `json:"requests"`
+	Failures            uint64 `json:"failures"`
+	Retries             uint64 `json:"retries"`
+	ShortCircuits       uint64 `json:"shortCircuits"`
+}
+
+// RouterClient sends each request to its providers in order. Transient
+// failures (rate limits, 5xx answers, timeouts and network errors) are retried
+// with jittered exponential backoff; any failure moves on to the next provider.
+// Consecutive transient failures open a provider's circuit, which skips it
+// until a cooldown passes and one trial request succeeds.
+//
+// A stream that fails after delivering text is not retried elsewhere, since the
+// guest has already seen part of the reply.
+type RouterClient struct {
+	providers []*routeState
+	config    RouterConfig
+	now       func() time.Time
+	sleep     func(ctx context.Context, d time.Duration) error
+}
+
+type routeState struct {
+	RouteProvider
+
+	mu          sync.Mutex
+	state       string
+	failures    int
+	openUntil   time.Time
+	trialActive bool
+	stats       ProviderStats
+}
+
+func NewRouterClient(providers []RouteProvider, config RouterConfig) (*RouterClient, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("%w: a router needs at least one provider", ErrInvalidInput)
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = routeMaxRetriesDefault
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = routeBaseBackoffDefault
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = routeMaxBackoffDefault
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = breakerFailureThresholdDefault
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = breakerCooldownDefault
+	}
+	router := &RouterClient{config: config, now: time.Now, sleep: sleepContext}
+	for i, provider := range providers {
+		if provider.Client == nil {
+			return nil, fmt.Errorf("%w: provider %d has no client", ErrInvalidInput, i)
+		}
+		if provider.Name == "" {
+			provider.Name = fmt.Sprintf("provider-%d", i+1)
+		}
+		if provider.Timeout <= 0 {
+			provider.Timeout = routeTimeoutDefault
+		}
+		router.providers = append(router.providers, &routeState{RouteProvider: provider, state: CircuitClosed})
+	}
+	return router, nil
+}
+
+func (r *RouterClient) Generate(ctx context.Context, modelName, prompt string) (string, error) {
+	var reply string
+	err := r.route(ctx, func(ctx context.Context, provider *routeState) (bool, error) {
+		var err error
+		reply, err = provider.Client.Generate(ctx, provider.model(modelName), prompt)
+		return false, err
+	})
+	return reply, err
+}
+
+func (r *RouterClient) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(delta string) error) (string, error) {
+	var reply string
+	err := r.route(ctx, func(ctx context.Context, provider *routeState) (bool, error) {
+		streamed := false
+		var err error
+		reply, err = provider.Client.GenerateStream(ctx, provider.model(modelName), prompt, func(delta string) error {
+			streamed = true
+			return onDelta(delta)
+		})
+		return streamed, err
+	})
+	return reply, err
+}
+
+// GenerateWithTools routes a tool-calling request. Providers without tool
+// support answer the guest's original input in plain text.
+func (r *RouterClient) GenerateWithTools(ctx context.Context, modelName string, conversation []ToolMessage, tools []ToolDeclaration) (ModelTurn, error) {
+	var turn ModelTurn
+	err := r.route(ctx, func(ctx context.Context, provider *routeState) (bool, error) {
+		var err error
+		if toolClient, ok := provider.Client.(ToolClient); ok {
+			turn, err = toolClient.GenerateWithTools(ctx, provider.model(modelName), conversation, tools)
+			return false, err
+		}
+		var input string
+		if len(conversation) > 0 {
+			input = conversation[0].Text
+		}
+		turn = ModelTurn{}
+		turn.Text, err = provider.Client.Generate(ctx, provider.model(modelName), input)
+		return false, err
+	})
+	return turn, err
+}
+
+// Stats returns each provider's counters in routing order.
+func (r *RouterClient) Stats() []ProviderStats {
+	stats := make([]ProviderStats, len(r.providers))
+	for i, provider := range r.providers {
+		provider.mu.Lock()
+		stats[i] = provider.stats
+		stats[i].Name, stats[i].Model = provider.Name, provider.Model
+		stats[i].State, stats[i].ConsecutiveFailures = provider.state, provider.failures
+		if provider.state == CircuitOpen && !r.now().Before(provider.openUntil) {
+			stats[i].State = CircuitHalfOpen
+		}
+		provider.mu.Unlock()
+	}
+	return stats
+}
+
+// route tries call on each provider in turn. call reports whether output
+// already reached the caller, which stops any further attempt.
+func (r *RouterClient) route(ctx context.Context, call func(ctx context.Context, provider *routeState) (bool, error)) error {
+	var failures []error
+	for _, provider := range r.providers {
+		if !provider.allow(r.now()) {
+			failures = append(failures, fmt.Errorf("%s: circuit open", provider.Name))
+			continue
+		}
+		for attempt := 0; ; attempt++ {
+			attemptCtx, cancel := context.WithTimeout(ctx, provider.Timeout)
+			delivered, err := call(attemptCtx, provider)
+			cancel()
+			if err == nil {
+				provider.succeed()
+				return nil
+			}
+			if ctx.Err() != nil {
+				provider.release()
+				return err
+			}
+			transient := isTransient(err)
+			if transient && !delivered && attempt < r.config.MaxRetries {
+				provider.retry()
+				if err := r.sleep(ctx, r.backoff(attempt)); err != nil {
+					provider.release()
+					return err
+				}
+				continue
+			}
+			provider.fail(r.now(), transient, r.config)
+			if delivered {
+				return err
+			}
+			failures = append(failures, fmt.Errorf("%s: %w", provider.Name, err))
+			break
+		}
+	}
+	return fmt.Errorf("%w: %w", ErrNoProviderAvailable, errors.Join(failures...))
+}
+
+// backoff returns a delay between half and all of the capped exponential
+// backoff for attempt, so retrying replicas spread out.
+func (r *RouterClient) backoff(attempt int) time.Duration {
+	delay := min(r.config.BaseBackoff<<attempt, r.config.MaxBackoff)
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(delay-half)+1))
+}
+
+func (p *routeState) model(modelName string) string {
+	if p.Model != "" {
+		return p.Model
+	}
+	return modelName
+}
+
+// allow reports whether a request may go to p. After the cooldown an open
+// circuit lets a single trial request through.
+func (p *routeState) allow(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.state == CircuitClosed:
+	case p.state == CircuitOpen && !now.Before(p.openUntil):
+		p.state, p.trialActive = CircuitHalfOpen, true
+	case p.state == CircuitHalfOpen && !p.trialActive:
+		p.trialActive = true
+	default:
+		p.stats.ShortCircuits++
+		return false
+	}
+	p.stats.Requests++
+	return true
+}
+
+func (p *routeState) succeed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state, p.failures, p.trialActive = CircuitClosed, 0, false
+}
+
+func (p *routeState) retry() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Retries++
+}
+
+// fail records a failed request. Only transient failures count toward opening
+// the circuit; a rejected request says nothing about the provider's health.
+func (p *routeState) fail(now time.Time, transient bool, config RouterConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Failures++
+	p.trialActive = false
+	if !transient {
+		return
+	}
+	p.failures++
+	if p.state == CircuitHalfOpen || p.failures >= config.FailureThreshold {
+		p.state, p.openUntil = CircuitOpen, now.Add(config.Cooldown)
+	}
+}
+
+// release ends a request the caller abandoned without judging the provider.
+func (p *routeState) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.trialActive = false
+}
+
+// isTransient reports whether err may succeed on retry: rate limits, server
+// errors, timeouts and dropped connections.
+func isTransient(err error) bool {
+	var apiErr *GeminiAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+// funcClient answers with generate and counts calls and the models asked for.
+type funcClient struct {
+	calls    int
+	models   []string
+	generate func(ctx context.Context) (string, error)
+}
+
+func (c *funcClient) Generate(ctx context.Context, modelName, _ string) (string, error) {
+	c.calls++
+	c.models = append(c.models, modelName)
+	return c.generate(ctx)
+}
+
+func (c *funcClient) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(string) error) (string, error) {
+	reply, err := c.Generate(ctx, modelName, prompt)
+	if err != nil {
+		return "", err
+	}
+	return reply, onDelta(reply)
+}
+
+func failingClient(status int) *funcClient {
+	return &funcClient{generate: func(context.Context) (string, error) {
+		return "", &GeminiAPIError{StatusCode: status, Message: http.StatusText(status)}
+	}}
+}
+
+func answeringClient(reply string) *funcClient {
+	return &funcClient{generate: func(context.Context) (string, error) { return reply, nil }}
+}
+
+// newTestRouter returns a router that records backoff delays instead of sleeping.
+func newTestRouter(t *testing.T, config RouterConfig, providers ...RouteProvider) (*RouterClient, *[]time.Duration) {
+	t.Helper()
+	router, err := NewRouterClient(providers, config)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+	var delays []time.Duration
+	router.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return router, &delays
+}
+
+func TestRouterRetriesTransientErrorsThenFallsBack(t *testing.T) {
+	t.Parallel()
+	primary, fallback := failingClient(http.StatusServiceUnavailable), answeringClient("from the fallback")
+	router, delays := newTestRouter(t, RouterConfig{MaxRetries: -1},
+		RouteProvider{Name: "primary", Client: primary},
+		RouteProvider{Name: "lite", Client: fallback, Model: "gemini-2.5-flash-lite"},
+	)
+
+	reply, err := router.Generate(context.Background(), "gemini-2.5-flash", "hi")
+	if err != nil || reply != "from the fallback" {
+		t.Fatalf("expected the fallback reply, got %q (%v)", reply, err)
+	}
+	if primary.calls != 1+routeMaxRetriesDefault || fallback.models[0] != "gemini-2.5-flash-lite" {
+		t.Fatalf("expected %d primary attempts and the fallback model, got %d and %v", 1+routeMaxRetriesDefault, primary.calls, fallback.models)
+	}
+	for i, delay := range *delays {
+		full := min(routeBaseBackoffDefault<<i, routeMaxBackoffDefault)
+		if delay < full/2 || delay > full {
+			t.Fatalf("expected jittered backoff %d within [%v, %v], got %v", i, full/2, full, delay)
+		}
+	}
+	stats := router.Stats()
+	if stats[0].Retries != routeMaxRetriesDefault || stats[0].Failures != 1 || stats[0].ConsecutiveFailures != 1 || stats[1].Requests != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	bad := failingClient(http.StatusBadRequest)
+	router, _ = newTestRouter(t, RouterConfig{}, RouteProvider{Client: bad}, RouteProvider{Client: answeringClient("ok")})
+	if _, err := router.Generate(context.Background(), "m", "hi"); err != nil || bad.calls != 1 {
+		t.Fatalf("expected no retry for a rejected request, got %d calls (%v)", bad.calls, err)
+	}
+	if stats := router.Stats(); stats[0].ConsecutiveFailures != 0 {
+		t.Fatalf("expected rejected requests not to count toward the breaker, got %+v", stats[0])
+	}
+}
+
+func TestRouterCircuitOpensAndRecovers(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	primary := failingClient(http.StatusInternalServerError)
+	router, _ := newTestRouter(t, RouterConfig{FailureThreshold: 2, Cooldown: time.Minute},
+		RouteProvider{Name: "primary", Client: primary},
+		RouteProvider{Name: "backup", Client: answeringClient("backup")},
+	)
+	router.now = func() time.Time { return now }
+
+	for range 3 {
+		if _, err := router.Generate(context.Background(), "m", "hi"); err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+	}
+	if primary.calls != 2 {
+		t.Fatalf("expected the open circuit to skip the primary, got %d calls", primary.calls)
+	}
+	if stats := router.Stats(); stats[0].State != CircuitOpen || stats[0].ShortCircuits != 1 {
+		t.Fatalf("expected an open circuit, got %+v", stats[0])
+	}
+
+	now = now.Add(time.Minute)
+	primary.generate = func(context.Context) (string, error) { return "primary", nil }
+	if reply, _ := router.Generate(context.Background(), "m", "hi"); reply != "primary" {
+		t.Fatalf("expected a trial request after the cooldown, got %q", reply)
+	}
+	if stats := router.Stats(); stats[0].State != CircuitClosed || stats[0].ConsecutiveFailures != 0 {
+		t.Fatalf("expected the circuit to close, got %+v", stats[0])
+	}
+}
+
+func TestRouterTimesOutSlowProviders(t *testing.T) {
+	t.Parallel()
+	slow := &funcClient{generate: func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}}
+	router, _ := newTestRouter(t, RouterConfig{},
+		RouteProvider{Client: slow, Timeout: 10 * time.Millisecond},
+		RouteProvider{Client: answeringClient("fast")},
+	)
+	if reply, err := router.Generate(context.Background(), "m", "hi"); err != nil || reply != "fast" {
+		t.Fatalf("expected the slow provider to time out, got %q (%v)", reply, err)
+	}
+
+	failing := failingClient(http.StatusBadGateway)
+	router, _ = newTestRouter(t, RouterConfig{}, RouteProvider{Client: failing})
+	if _, err := router.Generate(context.Background(), "m", "hi"); !errors.Is(err, ErrNoProviderAvailable) {
+		t.Fatalf("expected ErrNoProviderAvailable, got %v", err)
+	}
+	if failing.calls != 1 {
+		t.Fatalf("expected zero retries to make one attempt, got %d", failing.calls)
+	}
+}
+
+// partialStreamClient sends one chunk and then fails.
+type partialStreamClient struct{ funcClient }
+
+func (c *partialStreamClient) GenerateStream(_ context.Context, _, _ string, onDelta func(string) error) (string, error) {
+	if err := onDelta("Half a "); err != nil {
+		return "", err
+	}
+	return "", &GeminiAPIError{StatusCode: http.StatusServiceUnavailable}
+}
+
+func TestRouterDoesNotReplayPartialStreams(t *testing.T) {
+	t.Parallel()
+	fallback := answeringClient("whole reply")
+	router, _ := newTestRouter(t, RouterConfig{MaxRetries: -1}, RouteProvider{Client: &partialStreamClient{}}, RouteProvider{Client: fallback})
+
+	var streamed strings.Builder
+	_, err := router.GenerateStream(context.Background(), "m", "hi", func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	if err == nil || fallback.calls != 0 || streamed.String() != "Half a " {
+		t.Fatalf("expected the partial stream to fail without a fallback, got %q (%v)", streamed.String(), err)
+	}
+}
+
+func TestSendMessageAnswersFromThePolicyWhenNoModelAnswers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	router, _ := newTestRouter(t, RouterConfig{}, RouteProvider{Client: failingClient(http.StatusServiceUnavailable)})
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, router))
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{
+		{ID: "wrap", Name: "Veggie Wrap"},
+		{ID: "tacos", Name: "Shrimp Tacos", Allergens: []domain.Allergen{domain.AllergenCrustacean}},
+	}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	session, err := service.StartSession(ctx, "rest-1", shellfishAllergy, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+
+	reply, err := service.SendMessage(ctx, session.ID, "What can I eat?")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !reply.Degraded || !strings.Contains(reply.Text, "fit your profile: Veggie Wrap.") || strings.Contains(reply.Text, "fit your profile: Shrimp") {
+		t.Fatalf("expected a degraded safe list, got %+v", reply)
+	}
+	transcript, _ := store.LoadTranscript(ctx, session.ID)
+	if last := transcript[len(transcript)-1]; !last.Degraded {
+		t.Fatalf("expected the transcript to record the degraded reply, got %+v", last)
+	}
+}
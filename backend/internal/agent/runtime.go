@@ -2,106 +2,377 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
-	"sync"
+	"unicode/utf8"
 
+	"github.com/gourmet-guide/backend/internal/domain"
 	"github.com/gourmet-guide/backend/internal/gcp"
 )
 
 const (
-	maxMenuItemsDefault = 8
-	maxItemLength       = 80
+	maxMenuItemsDefault    = 8
+	maxItemLength          = 80
+	maxDescriptionLength   = 120
+	maxHistoryTurnsDefault = 6
 )
 
+// ErrModelUnavailable wraps a failure of the model client itself, as opposed to
+// invalid input, storage errors or the caller going away.
+var ErrModelUnavailable = errors.New("model unavailable")
+
 // Runtime uses Gemini-model-compatible clients and persists session activity.
 type Runtime struct {
-	modelName    string
-	client       Client
-	store        gcp.SessionStore
-	maxMenuItems int
+	modelName         string
+	client            Client
+	store             gcp.SessionStore
+	maxMenuItems      int
+	maxHistoryTurns   int
+	maxToolIterations int
+	budget            TokenBudget
+	promptVersion     string
+	cache             ResponseCache
+}
 
-	mu    sync.RWMutex
-	cache map[string]string
+// ModelReply is the runtime's answer to one guest turn. Usage estimates the
+// model calls it took; cached and over-budget replies took none. OverBudget
+// marks the menu-only answer of a session past its token limit.
+// PromptVersion is the system instruction version of the model input.
+type ModelReply struct {
+	Text          string
+	ToolCalls     []domain.ToolInvocation
+	Usage         domain.TokenUsage
+	OverBudget    bool
+	PromptVersion string
 }
 
 func NewRuntime(modelName string, store gcp.SessionStore) *Runtime {
+	return NewRuntimeWithClient(modelName, store, newDefaultClient())
+}
+
+// NewRuntimeWithClient builds a runtime that generates replies with client.
+func NewRuntimeWithClient(modelName string, store gcp.SessionStore, client Client) *Runtime {
 	return &Runtime{
-		modelName:    modelName,
-		client:       newDefaultClient(),
-		store:        store,
-		maxMenuItems: maxMenuItemsDefault,
-		cache:        map[string]string{},
+		modelName:         modelName,
+		client:            client,
+		store:             store,
+		maxMenuItems:      maxMenuItemsDefault,
+		maxHistoryTurns:   maxHistoryTurnsDefault,
+		maxToolIterations: maxToolIterationsDefault,
+		budget:            TokenBudget{MaxInputTokens: maxInputTokensDefault, MaxOutputTokens: maxOutputTokensDefault},
+		promptVersion:     PromptVersionDefault,
+		cache:             NewLRUCache(0, 0),
+	}
+}
+
+// SetTokenBudget replaces the input, output and per-session token limits.
+// Input and output limits of zero or less keep their defaults.
+func (r *Runtime) SetTokenBudget(budget TokenBudget) {
+	if budget.MaxInputTokens <= 0 {
+		budget.MaxInputTokens = maxInputTokensDefault
+	}
+	if budget.MaxOutputTokens <= 0 {
+		budget.MaxOutputTokens = maxOutputTokensDefault
 	}
+	r.budget = budget
+}
+
+// SetPromptVersion picks the system instruction version used unless a
+// restaurant picks its own. See PromptVersions for the available ones.
+func (r *Runtime) SetPromptVersion(version string) error {
+	if _, ok := promptTemplates[version]; !ok {
+		return fmt.Errorf("%w: unknown prompt version %q", ErrInvalidInput, version)
+	}
+	r.promptVersion = version
+	return nil
+}
+
+// SetResponseCache replaces the in-process reply cache, for example with one
+// shared between replicas or sized from configuration.
+func (r *Runtime) SetResponseCache(cache ResponseCache) {
+	r.cache = cache
+}
+
+// CacheStats reports the reply cache counters.
+func (r *Runtime) CacheStats() CacheStats {
+	return r.cache.Stats()
+}
+
+// ProviderStats reports the circuit and call counters of each model provider
+// when the client is a RouterClient, and nil otherwise.
+func (r *Runtime) ProviderStats() []ProviderStats {
+	if router, ok := r.client.(*RouterClient); ok {
+		return router.Stats()
+	}
+	return nil
+}
+
+// InvalidateRestaurant drops every cached reply for restaurantID, after its
+// menu or safety policy changed.
+func (r *Runtime) InvalidateRestaurant(ctx context.Context, restaurantID string) error {
+	return r.cache.InvalidateRestaurant(ctx, restaurantID)
 }
 
 func (r *Runtime) Respond(ctx context.Context, sessionID, prompt string, menuItems []string) (string, error) {
+	reply, err := r.respond(ctx, sessionID, prompt, namedMenuItems(menuItems), nil, nil, nil)
+	return reply.Text, err
+}
+
+// RespondStream behaves like Respond but forwards reply chunks to onDelta as the
+// model produces them. Cached replies are delivered as a single chunk.
+func (r *Runtime) RespondStream(ctx context.Context, sessionID, prompt string, menuItems []string, onDelta func(delta string) error) (string, error) {
+	reply, err := r.respond(ctx, sessionID, prompt, namedMenuItems(menuItems), onDelta, nil, nil)
+	return reply.Text, err
+}
+
+// RespondWithTools behaves like RespondStream but lets a ToolClient call tools
+// before it answers. menu holds the dishes ranked for the turn, whose names and
+// descriptions the model sees. The answer is delivered to onDelta, which may be nil,
+// as a single chunk once the tool loop ends. With a text-only client it is the
+// same as RespondStream. Replies from turns that called tools are not cached,
+// since tools such as add_to_order have effects.
+func (r *Runtime) RespondWithTools(ctx context.Context, sessionID, prompt string, menu []domain.MenuItem, tools *ToolRegistry, toolCtx *ToolContext, onDelta func(delta string) error) (ModelReply, error) {
+	return r.respond(ctx, sessionID, prompt, menu, onDelta, tools, toolCtx)
+}
+
+func (r *Runtime) respond(ctx context.Context, sessionID, prompt string, menu []domain.MenuItem, onDelta func(delta string) error, tools *ToolRegistry, toolCtx *ToolContext) (ModelReply, error) {
 	cleanPrompt, err := validatePrompt(prompt)
 	if err != nil {
-		return "", err
+		return ModelReply{}, err
 	}
 
-	modelInput := r.buildModelInput(cleanPrompt, menuItems)
-	if cachedReply, ok := r.cachedReply(modelInput); ok {
+	session, err := r.store.LoadSession(ctx, sessionID)
+	if err != nil {
+		return ModelReply{}, err
+	}
+	transcript, err := r.store.LoadTranscript(ctx, sessionID)
+	if err != nil {
+		return ModelReply{}, err
+	}
+	overrides := promptOverrides(ctx)
+	promptVersion := overrides.promptVersion(r.promptVersion)
+	history := recentTurns(transcript, r.maxHistoryTurns)
+	modelInput, err := r.buildModelInput(promptVersion, overrides, cleanPrompt, selectRelevantMenuItems(menu, r.menuLimit(ctx)), history)
+	if err != nil {
+		return ModelReply{}, err
+	}
+	if r.budget.SessionTokenLimit > 0 && session.TokenUsage.Total() >= r.budget.SessionTokenLimit {
+		reply, err := r.respondOverBudget(ctx, sessionID, cleanPrompt, modelInput, onDelta)
+		reply.PromptVersion = promptVersion
+		return reply, err
+	}
+	key := CacheKey{
+		Model:        r.model(ctx),
+		RestaurantID: session.RestaurantID,
+		MenuVersion:  session.MenuVersion,
+		ProfileHash:  profileHash(session),
+		Input:        modelInput,
+	}
+	// The model input carries the conversation so far, so only turns without
+	// history can repeat across sessions; later turns skip the cache entirely.
+	cacheable := len(history) == 0
+	// A failing cache only costs a model call, so its errors are ignored.
+	if cachedReply, ok := r.cachedReply(ctx, key, cacheable); ok {
+		if onDelta != nil {
+			if err := onDelta(cachedReply); err != nil {
+				return ModelReply{}, err
+			}
+		}
 		if err := r.store.SavePrompt(ctx, sessionID, cleanPrompt); err != nil {
-			return "", err
+			return ModelReply{}, err
 		}
-		return cachedReply, nil
+		return ModelReply{Text: cachedReply, PromptVersion: promptVersion}, nil
 	}
 
-	reply, err := r.client.Generate(ctx, r.modelName, modelInput)
+	// A restaurant's cap, set by the caller, wins over the runtime default.
+	ctx = WithMaxOutputTokens(ctx, maxOutputTokens(ctx, r.budget.MaxOutputTokens))
+	reply := ModelReply{PromptVersion: promptVersion}
+	// Errors from onDelta belong to the caller; only the model's own failures
+	// are reported as ErrModelUnavailable.
+	var deliveryErr error
+	deliver := onDelta
+	if onDelta != nil {
+		deliver = func(delta string) error {
+			deliveryErr = onDelta(delta)
+			return deliveryErr
+		}
+	}
+	toolClient, canCallTools := r.client.(ToolClient)
+	switch {
+	case canCallTools && tools != nil:
+		reply, err = r.runTools(ctx, toolClient, modelInput, tools, toolCtx)
+		if err == nil && deliver != nil {
+			err = deliver(reply.Text)
+		}
+	case deliver != nil:
+		reply.Text, err = r.client.GenerateStream(ctx, r.model(ctx), modelInput, deliver)
+	default:
+		reply.Text, err = r.client.Generate(ctx, r.model(ctx), modelInput)
+	}
 	if err != nil {
-		return "", err
+		if deliveryErr == nil && ctx.Err() == nil {
+			err = fmt.Errorf("%w: %w", ErrModelUnavailable, err)
+		}
+		return ModelReply{ToolCalls: reply.ToolCalls, Usage: reply.Usage, PromptVersion: promptVersion}, err
+	}
+	if !canCallTools || tools == nil {
+		reply.Usage = domain.TokenUsage{InputTokens: EstimateTokens(modelInput), OutputTokens: EstimateTokens(reply.Text), ModelCalls: 1}
 	}
 
-	r.cacheReply(modelInput, reply)
+	if cacheable && len(reply.ToolCalls) == 0 {
+		_ = r.cache.Set(ctx, key, reply.Text)
+	}
 	if err := r.store.SavePrompt(ctx, sessionID, cleanPrompt); err != nil {
-		return "", err
+		return ModelReply{}, err
 	}
 
 	return reply, nil
 }
 
-func (r *Runtime) buildModelInput(prompt string, menuItems []string) string {
-	relevantMenuItems := selectRelevantMenuItems(menuItems, r.maxMenuItems)
-	if len(relevantMenuItems) == 0 {
-		return prompt
+// cachedReply looks key up when the turn is cacheable, so turns that cannot
+// repeat do not count as cache misses.
+func (r *Runtime) cachedReply(ctx context.Context, key CacheKey, cacheable bool) (string, bool) {
+	if !cacheable {
+		return "", false
 	}
+	reply, ok, _ := r.cache.Get(ctx, key)
+	return reply, ok
+}
 
-	return fmt.Sprintf(
-		"%s\n\nOnly use these relevant menu options for reasoning:\n- %s",
-		prompt,
-		strings.Join(relevantMenuItems, "\n- "),
-	)
+// respondOverBudget answers a session that used up its token limit with the
+// menu options of modelInput, without calling the model.
+func (r *Runtime) respondOverBudget(ctx context.Context, sessionID, prompt, modelInput string, onDelta func(delta string) error) (ModelReply, error) {
+	reply := overBudgetNotice + " " + NewTemplateResponder().answerFromInput(modelInput)
+	if onDelta != nil {
+		if err := onDelta(reply); err != nil {
+			return ModelReply{}, err
+		}
+	}
+	if err := r.store.SavePrompt(ctx, sessionID, prompt); err != nil {
+		return ModelReply{}, err
+	}
+	return ModelReply{Text: reply, OverBudget: true}, nil
 }
 
-func selectRelevantMenuItems(menuItems []string, maxItems int) []string {
-	result := make([]string, 0, maxItems)
-	for _, menuItem := range menuItems {
+// buildModelInput renders the model input for prompt with the version's
+// template, trimmed to the input budget. menu holds the selected dishes, best
+// first. See fitModelInput for what gives way first.
+func (r *Runtime) buildModelInput(version string, overrides PromptOverrides, prompt string, menu []domain.MenuItem, history []domain.ConversationTurn) (string, error) {
+	return fitModelInput(modelInput{
+		version:   version,
+		overrides: overrides,
+		prompt:    prompt,
+		history:   history,
+		menu:      menu,
+	}, r.budget.MaxInputTokens).render()
+}
+
+// modelInput holds the parts of one turn's model input.
+type modelInput struct {
+	version   string
+	overrides PromptOverrides
+	prompt    string
+	history   []domain.ConversationTurn
+	menu      []domain.MenuItem
+}
+
+func (in modelInput) render() (string, error) {
+	data := promptData{
+		Tone:       in.overrides.Tone,
+		Language:   in.overrides.Language,
+		HouseRules: in.overrides.HouseRules,
+		Prompt:     in.prompt,
+		Menu:       in.menu,
+	}
+	for _, turn := range in.history {
+		speaker := "Guest"
+		if turn.Role == domain.TurnRoleAssistant {
+			speaker = "Concierge"
+		}
+		text := turn.Text
+		if turn.Interrupted {
+			text += " [interrupted]"
+		}
+		data.History = append(data.History, promptTurn{Speaker: speaker, Text: text})
+	}
+	return renderPrompt(in.version, in.version, data)
+}
+
+// fitModelInput trims in until its estimated size fits maxTokens. Dish
+// descriptions go first, least relevant dish first, then the oldest history
+// turns, then the least relevant dishes. The prompt and the top dish always
+// stay, so an oversized prompt is still sent. An input that fails to render is
+// left for render to report.
+func fitModelInput(in modelInput, maxTokens int) modelInput {
+	over := func() bool {
+		text, err := in.render()
+		return err == nil && EstimateTokens(text) > maxTokens
+	}
+	for i := len(in.menu) - 1; i >= 0 && over(); i-- {
+		in.menu[i].Description = ""
+	}
+	for len(in.history) > 0 && over() {
+		in.history = in.history[1:]
+	}
+	for len(in.menu) > 1 && over() {
+		in.menu = in.menu[:len(in.menu)-1]
+	}
+	return in
+}
+
+// recentTurns returns the last maxTurns non-empty turns of a transcript.
+func recentTurns(transcript []domain.ConversationTurn, maxTurns int) []domain.ConversationTurn {
+	result := make([]domain.ConversationTurn, 0, maxTurns)
+	for i := len(transcript) - 1; i >= 0 && len(result) < maxTurns; i-- {
+		if strings.TrimSpace(transcript[i].Text) == "" {
+			continue
+		}
+		result = append(result, transcript[i])
+	}
+	slices.Reverse(result)
+	return result
+}
+
+// selectRelevantMenuItems returns the names and descriptions of the first
+// maxItems named dishes, cut to maxItemLength and maxDescriptionLength.
+func selectRelevantMenuItems(menu []domain.MenuItem, maxItems int) []domain.MenuItem {
+	result := make([]domain.MenuItem, 0, maxItems)
+	for _, item := range menu {
 		if len(result) == maxItems {
 			break
 		}
-		trimmed := strings.TrimSpace(menuItem)
-		if trimmed == "" {
+		name := strings.TrimSpace(item.Name)
+		if name == "" {
 			continue
 		}
-		if len(trimmed) > maxItemLength {
-			trimmed = trimmed[:maxItemLength]
-		}
-		result = append(result, trimmed)
+		result = append(result, domain.MenuItem{
+			Name:        TruncateUTF8(name, maxItemLength),
+			Description: TruncateUTF8(strings.Join(strings.Fields(item.Description), " "), maxDescriptionLength),
+		})
 	}
 	return result
 }
 
-func (r *Runtime) cachedReply(modelInput string) (string, bool) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	reply, ok := r.cache[modelInput]
-	return reply, ok
+// TruncateUTF8 cuts text to at most maxBytes bytes at a rune boundary, so a
+// multi-byte character is never split.
+func TruncateUTF8(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
 }
 
-func (r *Runtime) cacheReply(modelInput, reply string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.cache[modelInput] = reply
+// namedMenuItems wraps dish names as menu items.
+func namedMenuItems(names []string) []domain.MenuItem {
+	items := make([]domain.MenuItem, len(names))
+	for i, name := range names {
+		items[i] = domain.MenuItem{Name: name}
+	}
+	return items
 }
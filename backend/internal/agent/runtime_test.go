@@ -2,8 +2,10 @@ package agent
 
 import (
 	"context"
+	"strings"
 	"testing"
 
+	"github.com/gourmet-guide/backend/internal/domain"
 	"github.com/gourmet-guide/backend/internal/gcp"
 )
 
@@ -16,6 +18,14 @@ func (f *fakeClient) Generate(_ context.Context, _, prompt string) (string, erro
 	return "ok: " + prompt, nil
 }
 
+func (f *fakeClient) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(string) error) (string, error) {
+	reply, err := f.Generate(ctx, modelName, prompt)
+	if err != nil {
+		return "", err
+	}
+	return reply, onDelta(reply)
+}
+
 func TestPromptValidation(t *testing.T) {
 	t.Parallel()
 
@@ -35,19 +45,19 @@ func TestPromptValidation(t *testing.T) {
 
 func TestSelectRelevantMenuItemsLimitsItems(t *testing.T) {
 	t.Parallel()
-	items := []string{" one ", "", "two", "three", "four"}
+	items := namedMenuItems([]string{" one ", "", "two", "three", "four"})
 	selected := selectRelevantMenuItems(items, 2)
 	if len(selected) != 2 {
 		t.Fatalf("expected 2 items, got %d", len(selected))
 	}
-	if selected[0] != "one" || selected[1] != "two" {
+	if selected[0].Name != "one" || selected[1].Name != "two" {
 		t.Fatalf("unexpected selection: %#v", selected)
 	}
 }
 
 func TestRespondUsesCacheToReduceModelCalls(t *testing.T) {
 	t.Parallel()
-	store := gcp.NewMemoryStore()
+	store := storeWithSessions(t, "session-1")
 	runtime := NewRuntime("gemini-2.0-flash-live-001", store)
 	fake := &fakeClient{}
 	runtime.client = fake
@@ -65,3 +75,45 @@ func TestRespondUsesCacheToReduceModelCalls(t *testing.T) {
 		t.Fatalf("expected one model call due to cache, got %d", fake.calls)
 	}
 }
+
+func TestRespondIncludesBoundedWindowOfRecentTurns(t *testing.T) {
+	t.Parallel()
+	store := storeWithSessions(t, "session-1")
+	runtime := NewRuntimeWithClient("gemini", store, &fakeClient{})
+	runtime.maxHistoryTurns = 2
+
+	err := store.AppendTranscript(context.Background(), "session-1",
+		domain.ConversationTurn{Role: domain.TurnRoleUser, Text: "I love mushrooms"},
+		domain.ConversationTurn{Role: domain.TurnRoleUser, Text: "no spicy food please"},
+		domain.ConversationTurn{Role: domain.TurnRoleAssistant, Text: "Try the garden risotto", Interrupted: true},
+	)
+	if err != nil {
+		t.Fatalf("append transcript: %v", err)
+	}
+
+	reply, err := runtime.Respond(context.Background(), "session-1", "what about dessert?", []string{"Sorbet"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(reply, "mushrooms") {
+		t.Fatalf("expected oldest turn outside the history window, got %q", reply)
+	}
+	for _, expected := range []string{"Guest: no spicy food please", "Concierge: Try the garden risotto [interrupted]", "what about dessert?"} {
+		if !strings.Contains(reply, expected) {
+			t.Fatalf("expected model input to contain %q, got %q", expected, reply)
+		}
+	}
+}
+
+// storeWithSessions returns a memory store that already holds the given sessions,
+// since stores refuse transcript and prompt writes for unknown sessions.
+func storeWithSessions(t *testing.T, sessionIDs ...string) *gcp.MemoryStore {
+	t.Helper()
+	store := gcp.NewMemoryStore()
+	for _, id := range sessionIDs {
+		if err := store.SaveSession(context.Background(), domain.ConciergeSession{ID: id, Status: domain.SessionStatusActive}); err != nil {
+			t.Fatalf("save session %s: %v", id, err)
+		}
+	}
+	return store
+}
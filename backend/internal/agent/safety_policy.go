@@ -0,0 +1,618 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+// safetyAction is what a policy does with a menu item matching an allergy.
+// Higher actions win when several allergies match the same item.
+type safetyAction int
+
+const (
+	actionNone safetyAction = iota
+	actionDeprioritize
+	actionWarn
+	// actionHold keeps a dish out of recommendations because its data cannot
+	// rule the allergen out.
+	actionHold
+	actionExclude
+)
+
+// verdict maps an action to the verdict it gives a dish.
+func (a safetyAction) verdict() domain.SafetyVerdict {
+	switch a {
+	case actionExclude:
+		return domain.VerdictUnsafe
+	case actionHold:
+		return domain.VerdictUnknown
+	case actionWarn, actionDeprioritize:
+		return domain.VerdictCaution
+	default:
+		return domain.VerdictSafe
+	}
+}
+
+var policyActions = map[domain.PolicyAction]safetyAction{
+	domain.PolicyActionExclude:      actionExclude,
+	domain.PolicyActionHold:         actionHold,
+	domain.PolicyActionWarn:         actionWarn,
+	domain.PolicyActionDeprioritize: actionDeprioritize,
+	domain.PolicyActionIgnore:       actionNone,
+}
+
+// defaultSeverityActions grades the response to each severity. Anaphylaxis
+// excludes direct and cross-contact risks; milder severities keep the item and
+// either warn about it or list it after everything else. Dishes that mention an
+// allergen without declaring it are held back for the serious severities.
+var defaultSeverityActions = []struct {
+	severity                        domain.AllergySeverity
+	direct, crossContact, mentioned domain.PolicyAction
+}{
+	{domain.SeverityAnaphylaxis, domain.PolicyActionExclude, domain.PolicyActionExclude, domain.PolicyActionHold},
+	{domain.SeverityAllergy, domain.PolicyActionExclude, domain.PolicyActionWarn, domain.PolicyActionHold},
+	{domain.SeverityIntolerance, domain.PolicyActionWarn, domain.PolicyActionDeprioritize, domain.PolicyActionWarn},
+	{domain.SeverityPreference, domain.PolicyActionDeprioritize, domain.PolicyActionIgnore, domain.PolicyActionIgnore},
+}
+
+// safetyFloor lists the cells of the severity matrix a policy may tighten but
+// never relax: anything weaker than exclude could serve a guest a dish that
+// contains their allergen, and anything weaker than hold would recommend a dish
+// whose description names an anaphylaxis allergen it does not declare.
+var safetyFloor = map[severityKey]domain.PolicyAction{
+	{domain.SeverityAnaphylaxis, domain.ExposureDirect}:       domain.PolicyActionExclude,
+	{domain.SeverityAnaphylaxis, domain.ExposureCrossContact}: domain.PolicyActionExclude,
+	{domain.SeverityAnaphylaxis, domain.ExposureMentioned}:    domain.PolicyActionHold,
+	{domain.SeverityAllergy, domain.ExposureDirect}:           domain.PolicyActionExclude,
+}
+
+// ruleRequireUnmatchedTag is the implicit rule that fires when a guest tag
+// without a tag rule is a hard requirement.
+const ruleRequireUnmatchedTag = "default.require-tag"
+
+// DefaultSafetyPolicy returns the built-in policy every restaurant starts with:
+// the severity matrix spelled out as rules, and guest dietary tags treated as
+// hard requirements.
+func DefaultSafetyPolicy(restaurantID string) domain.SafetyPolicy {
+	policy := domain.SafetyPolicy{RestaurantID: restaurantID, UnmatchedTags: domain.TagModeRequire}
+	for _, row := range defaultSeverityActions {
+		for _, cell := range []struct {
+			exposure domain.SafetyExposure
+			action   domain.PolicyAction
+		}{
+			{domain.ExposureDirect, row.direct},
+			{domain.ExposureCrossContact, row.crossContact},
+			{domain.ExposureMentioned, row.mentioned},
+		} {
+			policy.Rules = append(policy.Rules, domain.PolicyRule{
+				ID:       fmt.Sprintf("default.%s.%s", row.severity, cell.exposure),
+				Type:     domain.PolicyRuleSeverityAction,
+				Severity: row.severity,
+				Exposure: cell.exposure,
+				Action:   cell.action,
+			})
+		}
+	}
+	return policy
+}
+
+// ruledAction is an action together with the rule that set it.
+type ruledAction struct {
+	action safetyAction
+	rule   string
+}
+
+type severityKey struct {
+	severity domain.AllergySeverity
+	exposure domain.SafetyExposure
+}
+
+// SafetyEngine evaluates dishes against a compiled safety policy. Evaluation is
+// deterministic: the same policy, menu and profile always give the same result.
+type SafetyEngine struct {
+	policy   domain.SafetyPolicy
+	actions  map[severityKey]ruledAction
+	tagRules map[string][]domain.PolicyRule
+	stations []domain.PolicyRule
+}
+
+var defaultSafetyEngine = mustSafetyEngine(DefaultSafetyPolicy(""))
+
+func mustSafetyEngine(policy domain.SafetyPolicy) *SafetyEngine {
+	engine, err := NewSafetyEngine(policy)
+	if err != nil {
+		panic(err)
+	}
+	return engine
+}
+
+// NewSafetyEngine validates and compiles policy. Its rules are layered over
+// the built-in severity matrix, so a policy only lists what it changes.
+// Validation errors wrap ErrInvalidInput.
+func NewSafetyEngine(policy domain.SafetyPolicy) (*SafetyEngine, error) {
+	policy, err := NormalizeSafetyPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	engine := &SafetyEngine{
+		policy:   policy,
+		actions:  map[severityKey]ruledAction{},
+		tagRules: map[string][]domain.PolicyRule{},
+	}
+	for _, rule := range DefaultSafetyPolicy("").Rules {
+		engine.actions[severityKey{rule.Severity, rule.Exposure}] = ruledAction{policyActions[rule.Action], rule.ID}
+	}
+	for _, rule := range policy.Rules {
+		switch rule.Type {
+		case domain.PolicyRuleSeverityAction:
+			engine.actions[severityKey{rule.Severity, rule.Exposure}] = ruledAction{policyActions[rule.Action], rule.ID}
+		case domain.PolicyRuleStationExposure:
+			engine.stations = append(engine.stations, rule)
+		default:
+			engine.tagRules[rule.GuestTag] = append(engine.tagRules[rule.GuestTag], rule)
+		}
+	}
+	return engine, nil
+}
+
+// NormalizeSafetyPolicy validates policy and returns it with tags and stations
+// lower-cased, allergens resolved through the registry and defaults filled in.
+func NormalizeSafetyPolicy(policy domain.SafetyPolicy) (domain.SafetyPolicy, error) {
+	switch policy.UnmatchedTags {
+	case "":
+		policy.UnmatchedTags = domain.TagModeRequire
+	case domain.TagModeRequire, domain.TagModePrefer:
+	default:
+		return domain.SafetyPolicy{}, fmt.Errorf("%w: unmatchedTags must be %q or %q", ErrInvalidInput, domain.TagModeRequire, domain.TagModePrefer)
+	}
+
+	seen := map[string]struct{}{}
+	rules := make([]domain.PolicyRule, 0, len(policy.Rules))
+	for i, rule := range policy.Rules {
+		rule.ID = strings.TrimSpace(rule.ID)
+		if rule.ID == "" {
+			return domain.SafetyPolicy{}, fmt.Errorf("%w: rule %d has no id", ErrInvalidInput, i+1)
+		}
+		if _, ok := seen[rule.ID]; ok {
+			return domain.SafetyPolicy{}, fmt.Errorf("%w: rule id %q is used twice", ErrInvalidInput, rule.ID)
+		}
+		seen[rule.ID] = struct{}{}
+		normalized, err := normalizePolicyRule(rule)
+		if err != nil {
+			return domain.SafetyPolicy{}, fmt.Errorf("%w: rule %q: %s", ErrInvalidInput, rule.ID, err)
+		}
+		rules = append(rules, normalized)
+	}
+	policy.Rules = rules
+	return policy, nil
+}
+
+func normalizePolicyRule(rule domain.PolicyRule) (domain.PolicyRule, error) {
+	rule.GuestTag = normalizeTag(rule.GuestTag)
+	rule.Station = normalizeTag(rule.Station)
+	var itemTags []string
+	for _, tag := range rule.ItemTags {
+		if tag = normalizeTag(tag); tag != "" {
+			itemTags = append(itemTags, tag)
+		}
+	}
+	rule.ItemTags = itemTags
+	if rule.Severity != "" {
+		severity, ok := domain.ParseAllergySeverity(string(rule.Severity))
+		if !ok {
+			return rule, fmt.Errorf("unknown severity %q", rule.Severity)
+		}
+		rule.Severity = severity
+	}
+
+	switch rule.Type {
+	case domain.PolicyRuleSeverityAction:
+		if rule.Severity == "" {
+			return rule, fmt.Errorf("severity is required")
+		}
+		switch rule.Exposure {
+		case domain.ExposureDirect, domain.ExposureCrossContact, domain.ExposureMentioned:
+		default:
+			return rule, fmt.Errorf("exposure must be %s, %s or %s", domain.ExposureDirect, domain.ExposureCrossContact, domain.ExposureMentioned)
+		}
+		action, ok := policyActions[rule.Action]
+		if !ok {
+			return rule, fmt.Errorf("unknown action %q", rule.Action)
+		}
+		if floor, ok := safetyFloor[severityKey{rule.Severity, rule.Exposure}]; ok && action < policyActions[floor] {
+			return rule, fmt.Errorf("%s %s exposure must stay %s", rule.Severity, rule.Exposure, floor)
+		}
+	case domain.PolicyRuleRequireTag, domain.PolicyRulePreferTag:
+		if rule.GuestTag == "" {
+			return rule, fmt.Errorf("guestTag is required")
+		}
+		if len(rule.ItemTags) == 0 {
+			rule.ItemTags = []string{rule.GuestTag}
+		}
+	case domain.PolicyRuleExcludeAllergens:
+		if rule.GuestTag == "" {
+			return rule, fmt.Errorf("guestTag is required")
+		}
+		if len(rule.Allergens) == 0 {
+			return rule, fmt.Errorf("allergens are required")
+		}
+		allergens, unknown := domain.NormalizeAllergens(rule.Allergens)
+		if len(unknown) > 0 {
+			return rule, fmt.Errorf("unknown allergens %s", strings.Join(unknown, ", "))
+		}
+		rule.Allergens = allergens
+	case domain.PolicyRuleStationExposure:
+		if rule.Station == "" {
+			return rule, fmt.Errorf("station is required")
+		}
+	default:
+		return rule, fmt.Errorf("unknown type %q", rule.Type)
+	}
+	return rule, nil
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// Policy returns the normalized policy the engine was compiled from.
+func (e *SafetyEngine) Policy() domain.SafetyPolicy {
+	return e.policy
+}
+
+// safetyRule identifies a policy that fired for an item; the safety note
+// groups items by their strongest rule. Tag rules carry the guest tag instead
+// of an allergy, and exclude_allergens rules also the allergen they matched.
+type safetyRule struct {
+	action   safetyAction
+	exposure domain.SafetyExposure
+	allergy  domain.Allergy
+	tag      string
+	ruleType domain.PolicyRuleType
+	station  string
+}
+
+// maxNamedItems caps how many dishes a single note sentence names.
+const maxNamedItems = 3
+
+// EvaluateMenuSafety returns the verdict of every item, in menu order, under the
+// built-in policy.
+func EvaluateMenuSafety(items []domain.MenuItem, profile []domain.Allergy, guestTags []string) []domain.ItemSafety {
+	return defaultSafetyEngine.Evaluate(items, profile, guestTags)
+}
+
+// applySafetyPolicies applies the built-in policy; see SafetyEngine.Apply.
+func applySafetyPolicies(items []domain.MenuItem, profile []domain.Allergy, guestTags []string) ([]domain.MenuItem, []domain.ItemSafety, string) {
+	return defaultSafetyEngine.Apply(items, profile, guestTags)
+}
+
+// Evaluate returns the verdict of every item, in menu order, for an allergy
+// profile and the guest's dietary tags.
+func (e *SafetyEngine) Evaluate(items []domain.MenuItem, profile []domain.Allergy, guestTags []string) []domain.ItemSafety {
+	verdicts := make([]domain.ItemSafety, len(items))
+	for i, item := range items {
+		verdicts[i], _ = e.evaluateItem(item, profile, guestTags)
+	}
+	return verdicts
+}
+
+// Apply evaluates items and returns the ones to recommend, in recommendation
+// order, together with every verdict and a safety note that explains each rule
+// that fired. Related allergens conflict both ways: avoiding shellfish rules
+// out molluscs, and avoiding crustaceans rules out a dish that only declares
+// shellfish.
+func (e *SafetyEngine) Apply(items []domain.MenuItem, profile []domain.Allergy, guestTags []string) ([]domain.MenuItem, []domain.ItemSafety, string) {
+	fired := map[safetyRule][]string{}
+	verdicts := make([]domain.ItemSafety, len(items))
+	var kept, last []domain.MenuItem
+	for i, item := range items {
+		verdict, rules := e.evaluateItem(item, profile, guestTags)
+		verdicts[i] = verdict
+		if len(rules) > 0 {
+			fired[rules[0]] = append(fired[rules[0]], item.Name)
+		}
+		switch {
+		case verdict.Verdict == domain.VerdictUnsafe, verdict.Verdict == domain.VerdictUnknown:
+		case verdict.Deprioritized:
+			last = append(last, item)
+		default:
+			kept = append(kept, item)
+		}
+	}
+	for _, group := range [][]domain.MenuItem{kept, last} {
+		sort.SliceStable(group, func(i, j int) bool {
+			return e.preferenceScore(group[i], guestTags) > e.preferenceScore(group[j], guestTags)
+		})
+	}
+	return append(kept, last...), verdicts, safetyNote(fired)
+}
+
+// preferenceScore counts the guest tags an item satisfies, using the item tags
+// of tag rules where a guest tag has them.
+func (e *SafetyEngine) preferenceScore(item domain.MenuItem, guestTags []string) int {
+	score := 0
+	for _, guestTag := range guestTags {
+		if hasAllRequiredTags(item, e.itemTagsFor(normalizeTag(guestTag))) {
+			score++
+		}
+	}
+	return score
+}
+
+func (e *SafetyEngine) itemTagsFor(guestTag string) []string {
+	for _, rule := range e.tagRules[guestTag] {
+		if rule.Type == domain.PolicyRuleRequireTag || rule.Type == domain.PolicyRulePreferTag {
+			return rule.ItemTags
+		}
+	}
+	return []string{guestTag}
+}
+
+// stationRule returns the station rule that escalates cross-contact for
+// severity on item, if any.
+func (e *SafetyEngine) stationRule(item domain.MenuItem, severity domain.AllergySeverity) (domain.PolicyRule, bool) {
+	for _, rule := range e.stations {
+		if rule.Severity != "" && rule.Severity != severity {
+			continue
+		}
+		for _, station := range item.Stations {
+			if normalizeTag(station) == rule.Station {
+				return rule, true
+			}
+		}
+	}
+	return domain.PolicyRule{}, false
+}
+
+// evaluateItem returns the item's verdict and the rules that fired, strongest
+// first.
+func (e *SafetyEngine) evaluateItem(item domain.MenuItem, profile []domain.Allergy, guestTags []string) (domain.ItemSafety, []safetyRule) {
+	direct := allergenSetOf(effectiveAllergens(item))
+	crossContact := allergenSetOf(effectiveCrossContaminationRisk(item))
+	mentioned := allergenSetOf(mentionedAllergens(item))
+
+	var rules []safetyRule
+	var explanations []string
+	result := domain.ItemSafety{ItemID: item.ID, Name: item.Name, Verdict: domain.VerdictSafe}
+	for _, allergy := range profile {
+		severity := allergy.Severity
+		if severity == "" {
+			severity = domain.SeverityAnaphylaxis
+		}
+		// Only the most telling exposure counts: a declared allergen is not also
+		// reported as mentioned.
+		var exposure domain.SafetyExposure
+		switch {
+		case coveredBy(allergy.Allergen, direct):
+			exposure = domain.ExposureDirect
+		case coveredBy(allergy.Allergen, crossContact):
+			exposure = domain.ExposureCrossContact
+		case coveredBy(allergy.Allergen, mentioned):
+			exposure = domain.ExposureMentioned
+		default:
+			continue
+		}
+		var station string
+		decided := e.actions[severityKey{severity, exposure}]
+		if exposure == domain.ExposureCrossContact {
+			if rule, ok := e.stationRule(item, severity); ok {
+				exposure, station = domain.ExposureDirect, rule.Station
+				decided = ruledAction{e.actions[severityKey{severity, exposure}].action, rule.ID}
+			}
+		}
+		if decided.action == actionNone {
+			continue
+		}
+		rule := safetyRule{action: decided.action, exposure: exposure, allergy: domain.Allergy{Allergen: allergy.Allergen, Severity: severity}, station: station}
+		rules = append(rules, rule)
+		explanations = append(explanations, ruleReason(rule))
+		result.Triggers = append(result.Triggers, domain.SafetyTrigger{Allergen: allergy.Allergen, Exposure: exposure, Severity: severity, Verdict: decided.action.verdict(), Rule: decided.rule, Station: station})
+	}
+
+	var missing []string
+	for _, guestTag := range guestTags {
+		tag := normalizeTag(guestTag)
+		if tag == "" {
+			continue
+		}
+		tagRules := e.tagRules[tag]
+		if len(tagRules) == 0 && e.policy.UnmatchedTags == domain.TagModeRequire {
+			tagRules = []domain.PolicyRule{{ID: ruleRequireUnmatchedTag, Type: domain.PolicyRuleRequireTag, GuestTag: tag, ItemTags: []string{tag}}}
+		}
+		for _, policyRule := range tagRules {
+			switch policyRule.Type {
+			case domain.PolicyRuleRequireTag:
+				if hasAllRequiredTags(item, policyRule.ItemTags) {
+					continue
+				}
+				missing = append(missing, tag)
+				rules = append(rules, safetyRule{action: actionExclude, exposure: domain.ExposureDietary, tag: tag, ruleType: policyRule.Type})
+				result.Triggers = append(result.Triggers, domain.SafetyTrigger{Tag: tag, Exposure: domain.ExposureDietary, Verdict: domain.VerdictUnsafe, Rule: policyRule.ID})
+			case domain.PolicyRuleExcludeAllergens:
+				for _, allergen := range policyRule.Allergens {
+					if !coveredBy(allergen, direct) {
+						continue
+					}
+					rule := safetyRule{action: actionExclude, exposure: domain.ExposureDietary, allergy: domain.Allergy{Allergen: allergen}, tag: tag, ruleType: policyRule.Type}
+					rules = append(rules, rule)
+					explanations = append(explanations, fmt.Sprintf("Contains %s, which %s excludes.", allergen, tag))
+					result.Triggers = append(result.Triggers, domain.SafetyTrigger{Allergen: allergen, Tag: tag, Exposure: domain.ExposureDietary, Verdict: domain.VerdictUnsafe, Rule: policyRule.ID})
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		explanations = append(explanations, fmt.Sprintf("Missing required dietary tags: %s.", strings.Join(missing, ", ")))
+	}
+
+	// Allergen rules outrank dietary ones of the same strength in the note.
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].action > rules[j].action })
+	if len(rules) == 0 {
+		result.Explanation = "Nothing on this dish conflicts with the guest's allergies or required dietary tags."
+		return result, nil
+	}
+	result.Verdict = rules[0].action.verdict()
+	result.Deprioritized = rules[0].action == actionDeprioritize
+	result.Explanation = strings.Join(explanations, " ")
+	return result, rules
+}
+
+// ruleReason explains a single allergen rule for one dish.
+func ruleReason(rule safetyRule) string {
+	allergen, severity := rule.allergy.Allergen, rule.allergy.Severity
+	switch {
+	case rule.station != "":
+		return fmt.Sprintf("Cross-contact risk with %s at the %s station counts as direct contact (%s rule).", allergen, rule.station, severity)
+	case rule.exposure == domain.ExposureDirect:
+		return fmt.Sprintf("Contains %s (%s rule).", allergen, severity)
+	case rule.exposure == domain.ExposureCrossContact:
+		return fmt.Sprintf("Cross-contact risk with %s (%s rule).", allergen, severity)
+	default:
+		return fmt.Sprintf("Mentions %s but does not declare it (%s rule).", allergen, severity)
+	}
+}
+
+func safetyNote(fired map[safetyRule][]string) string {
+	rules := make([]safetyRule, 0, len(fired))
+	for rule := range fired {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.action != b.action {
+			return a.action > b.action
+		}
+		if a.exposure != b.exposure {
+			return exposureOrder(a.exposure) < exposureOrder(b.exposure)
+		}
+		if a.station != b.station {
+			return a.station < b.station
+		}
+		if a.tag != b.tag {
+			return a.tag < b.tag
+		}
+		if a.ruleType != b.ruleType {
+			return a.ruleType < b.ruleType
+		}
+		if a.allergy.Allergen != b.allergy.Allergen {
+			return a.allergy.Allergen < b.allergy.Allergen
+		}
+		return a.allergy.Severity < b.allergy.Severity
+	})
+
+	sentences := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		sentences = append(sentences, ruleSentence(rule, fired[rule]))
+	}
+	return strings.Join(sentences, " ")
+}
+
+func exposureOrder(exposure domain.SafetyExposure) int {
+	switch exposure {
+	case domain.ExposureDirect:
+		return 0
+	case domain.ExposureCrossContact:
+		return 1
+	case domain.ExposureMentioned:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func ruleSentence(rule safetyRule, names []string) string {
+	items, allergen, severity := nameList(names), rule.allergy.Allergen, rule.allergy.Severity
+	switch {
+	case rule.ruleType == domain.PolicyRuleExcludeAllergens:
+		return fmt.Sprintf("Excluded %s: contains %s, which %s excludes.", items, allergen, rule.tag)
+	case rule.exposure == domain.ExposureDietary:
+		return fmt.Sprintf("Excluded %s because they did not satisfy required dietary tags (%s).", items, rule.tag)
+	case rule.station != "" && rule.action == actionExclude:
+		return fmt.Sprintf("Excluded %s: cross-contact risk with %s at the %s station (%s rule).", items, allergen, rule.station, severity)
+	case rule.action == actionExclude && rule.exposure == domain.ExposureDirect:
+		return fmt.Sprintf("Excluded %s: contains %s (%s rule).", items, allergen, severity)
+	case rule.action == actionExclude:
+		return fmt.Sprintf("Excluded %s: cross-contact risk with %s (%s rule).", items, allergen, severity)
+	case rule.action == actionHold:
+		return fmt.Sprintf("Held back %s: mentions %s without declaring it (%s rule); confirm with staff.", items, allergen, severity)
+	case rule.action == actionWarn && rule.exposure == domain.ExposureDirect:
+		return fmt.Sprintf("Caution: %s contains %s (%s rule); confirm with staff that it suits you.", items, allergen, severity)
+	case rule.action == actionWarn && rule.exposure == domain.ExposureMentioned:
+		return fmt.Sprintf("Caution: %s mentions %s without declaring it (%s rule); confirm with staff.", items, allergen, severity)
+	case rule.action == actionWarn:
+		return fmt.Sprintf("Caution: %s may contain traces of %s (%s rule); confirm with staff before ordering.", items, allergen, severity)
+	case rule.exposure == domain.ExposureDirect:
+		return fmt.Sprintf("Listed %s last: contains %s (%s rule).", items, allergen, severity)
+	default:
+		return fmt.Sprintf("Listed %s last: cross-contact risk with %s (%s rule).", items, allergen, severity)
+	}
+}
+
+func nameList(names []string) string {
+	if len(names) > maxNamedItems {
+		return fmt.Sprintf("%s and %d more", strings.Join(names[:maxNamedItems], ", "), len(names)-maxNamedItems)
+	}
+	return strings.Join(names, ", ")
+}
+
+// DiffSafetyPolicies evaluates items under both engines for every profile and
+// returns the dishes whose verdict or ranking changes, in profile then menu
+// order.
+func DiffSafetyPolicies(before, after *SafetyEngine, items []domain.MenuItem, profiles []domain.PolicyProfile) []domain.PolicyImpact {
+	var impacts []domain.PolicyImpact
+	for _, profile := range profiles {
+		was := before.Evaluate(items, profile.Allergies, profile.PreferenceTags)
+		now := after.Evaluate(items, profile.Allergies, profile.PreferenceTags)
+		for i := range items {
+			if was[i].Verdict == now[i].Verdict && was[i].Deprioritized == now[i].Deprioritized {
+				continue
+			}
+			impacts = append(impacts, domain.PolicyImpact{Profile: profile, Before: was[i], After: now[i]})
+		}
+	}
+	return impacts
+}
+
+// ProbeProfiles returns the profiles a dry run checks when the caller names
+// none: every allergen the menu contains, risks or mentions at every severity,
+// and every dietary tag on the menu or in a tag rule of the engines.
+func ProbeProfiles(items []domain.MenuItem, engines ...*SafetyEngine) []domain.PolicyProfile {
+	allergens := map[domain.Allergen]struct{}{}
+	tags := map[string]struct{}{}
+	for _, item := range items {
+		for _, list := range [][]domain.Allergen{effectiveAllergens(item), effectiveCrossContaminationRisk(item), mentionedAllergens(item)} {
+			for _, allergen := range list {
+				allergens[allergen] = struct{}{}
+			}
+		}
+		for _, tag := range item.Tags {
+			if tag = normalizeTag(tag); tag != "" {
+				tags[tag] = struct{}{}
+			}
+		}
+	}
+	for _, engine := range engines {
+		for tag := range engine.tagRules {
+			tags[tag] = struct{}{}
+		}
+	}
+
+	var profiles []domain.PolicyProfile
+	for _, allergen := range sortedAllergens(allergens) {
+		for _, severity := range domain.AllergySeverities {
+			profiles = append(profiles, domain.PolicyProfile{Allergies: []domain.Allergy{{Allergen: allergen, Severity: severity}}})
+		}
+	}
+	sortedTags := make([]string, 0, len(tags))
+	for tag := range tags {
+		sortedTags = append(sortedTags, tag)
+	}
+	sort.Strings(sortedTags)
+	for _, tag := range sortedTags {
+		profiles = append(profiles, domain.PolicyProfile{PreferenceTags: []string{tag}})
+	}
+	return profiles
+}
@@ -0,0 +1,246 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+func TestApplySafetyPoliciesActsOnSeverityAndExposure(t *testing.T) {
+	t.Parallel()
+	items := []domain.MenuItem{
+		{Name: "Satay", Allergens: []domain.Allergen{domain.AllergenPeanut}},
+		{Name: "Fried Rice", CrossContaminationRisk: []domain.Allergen{domain.AllergenPeanut}},
+		{Name: "Latte", Allergens: []domain.Allergen{domain.AllergenDairy}},
+		{Name: "Muffin", CrossContaminationRisk: []domain.Allergen{domain.AllergenDairy}},
+		{Name: "Sorbet"},
+	}
+	names := func(items []domain.MenuItem) string {
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			list = append(list, item.Name)
+		}
+		return strings.Join(list, ",")
+	}
+
+	tests := []struct {
+		name     string
+		profile  []domain.Allergy
+		want     string
+		wantNote []string
+	}{
+		{
+			name:     "anaphylaxis excludes direct and cross-contact",
+			profile:  []domain.Allergy{{Allergen: domain.AllergenPeanut, Severity: domain.SeverityAnaphylaxis}},
+			want:     "Latte,Muffin,Sorbet",
+			wantNote: []string{"Excluded Satay: contains peanut (anaphylaxis rule).", "Excluded Fried Rice: cross-contact risk with peanut (anaphylaxis rule)."},
+		},
+		{
+			name:     "allergy excludes direct and warns on cross-contact",
+			profile:  []domain.Allergy{{Allergen: domain.AllergenPeanut, Severity: domain.SeverityAllergy}},
+			want:     "Fried Rice,Latte,Muffin,Sorbet",
+			wantNote: []string{"Excluded Satay", "Caution: Fried Rice may contain traces of peanut (allergy rule)"},
+		},
+		{
+			name:     "intolerance warns on direct and deprioritizes cross-contact",
+			profile:  []domain.Allergy{{Allergen: domain.AllergenDairy, Severity: domain.SeverityIntolerance}},
+			want:     "Satay,Fried Rice,Latte,Sorbet,Muffin",
+			wantNote: []string{"Caution: Latte contains dairy (intolerance rule)", "Listed Muffin last: cross-contact risk with dairy (intolerance rule)."},
+		},
+		{
+			name:     "preference only deprioritizes direct",
+			profile:  []domain.Allergy{{Allergen: domain.AllergenDairy, Severity: domain.SeverityPreference}},
+			want:     "Satay,Fried Rice,Muffin,Sorbet,Latte",
+			wantNote: []string{"Listed Latte last: contains dairy (preference rule)."},
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			safe, _, note := applySafetyPolicies(items, tc.profile, nil)
+			if got := names(safe); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+			for _, want := range tc.wantNote {
+				if !strings.Contains(note, want) {
+					t.Fatalf("expected note to contain %q, got %q", want, note)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionAllergyProfileTreatsLegacyHardAllergensAsAnaphylaxis(t *testing.T) {
+	t.Parallel()
+	session := domain.ConciergeSession{
+		HardAllergens: []domain.Allergen{domain.AllergenPeanut},
+		Allergies:     []domain.Allergy{{Allergen: domain.AllergenPeanut, Severity: domain.SeverityIntolerance}, {Allergen: domain.AllergenDairy, Severity: domain.SeverityPreference}},
+	}
+	profile := session.AllergyProfile()
+	if len(profile) != 2 || profile[0].Severity != domain.SeverityAnaphylaxis || profile[1].Severity != domain.SeverityPreference {
+		t.Fatalf("expected the most severe entry per allergen, got %+v", profile)
+	}
+}
+
+func TestEvaluateMenuSafetyReportsEveryTrigger(t *testing.T) {
+	t.Parallel()
+	items := []domain.MenuItem{
+		{ID: "curry", Name: "Peanut Curry", Allergens: []domain.Allergen{domain.AllergenPeanut}, CrossContaminationRisk: []domain.Allergen{domain.AllergenSesame}},
+		{ID: "noodles", Name: "Noodles", Description: "tossed in satay sauce", Tags: []string{"vegan"}},
+		{ID: "salad", Name: "Salad", Tags: []string{"vegan"}},
+	}
+	profile := []domain.Allergy{
+		{Allergen: domain.AllergenPeanut, Severity: domain.SeverityAnaphylaxis},
+		{Allergen: domain.AllergenSesame, Severity: domain.SeverityAllergy},
+	}
+
+	verdicts := EvaluateMenuSafety(items, profile, []string{"vegan"})
+	if len(verdicts) != 3 {
+		t.Fatalf("expected a verdict per item, got %+v", verdicts)
+	}
+	curry := verdicts[0]
+	want := []domain.SafetyTrigger{
+		{Allergen: domain.AllergenPeanut, Exposure: domain.ExposureDirect, Severity: domain.SeverityAnaphylaxis, Verdict: domain.VerdictUnsafe, Rule: "default.anaphylaxis.direct"},
+		{Allergen: domain.AllergenSesame, Exposure: domain.ExposureCrossContact, Severity: domain.SeverityAllergy, Verdict: domain.VerdictCaution, Rule: "default.allergy.cross_contact"},
+		{Tag: "vegan", Exposure: domain.ExposureDietary, Verdict: domain.VerdictUnsafe, Rule: "default.require-tag"},
+	}
+	if curry.Verdict != domain.VerdictUnsafe || !reflect.DeepEqual(curry.Triggers, want) {
+		t.Fatalf("expected unsafe curry with all triggers, got %+v", curry)
+	}
+	if !strings.Contains(curry.Explanation, "Contains peanut (anaphylaxis rule).") || !strings.Contains(curry.Explanation, "Missing required dietary tags: vegan.") {
+		t.Fatalf("expected explanation for each rule, got %q", curry.Explanation)
+	}
+	if noodles := verdicts[1]; noodles.Verdict != domain.VerdictUnknown || noodles.Triggers[0].Exposure != domain.ExposureMentioned {
+		t.Fatalf("expected undeclared satay to leave the noodles unknown, got %+v", noodles)
+	}
+	if salad := verdicts[2]; salad.Verdict != domain.VerdictSafe || len(salad.Triggers) != 0 || salad.Explanation == "" {
+		t.Fatalf("expected a safe salad with an explanation, got %+v", salad)
+	}
+
+	safe, _, note := applySafetyPolicies(items, profile, []string{"vegan"})
+	if len(safe) != 1 || safe[0].ID != "salad" || !strings.Contains(note, "Held back Noodles: mentions peanut") {
+		t.Fatalf("expected only the salad with the noodles held back, got %+v (%q)", safe, note)
+	}
+}
+
+// policyFixture is a file under testdata/policies: a policy, a menu and a guest
+// profile with the verdict each dish must get and, optionally, the rule behind
+// its first trigger.
+type policyFixture struct {
+	Description string                          `json:"description"`
+	Policy      domain.SafetyPolicy             `json:"policy"`
+	Menu        []domain.MenuItem               `json:"menu"`
+	Profile     domain.PolicyProfile            `json:"profile"`
+	Want        map[string]domain.SafetyVerdict `json:"want"`
+	WantRules   map[string]string               `json:"wantRules"`
+}
+
+func TestSafetyPolicyFixtures(t *testing.T) {
+	t.Parallel()
+	paths, err := filepath.Glob(filepath.Join("testdata", "policies", "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("expected policy fixtures, got %v (%v)", paths, err)
+	}
+	for _, path := range paths {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			t.Parallel()
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read fixture: %v", err)
+			}
+			var fixture policyFixture
+			if err := json.Unmarshal(raw, &fixture); err != nil {
+				t.Fatalf("decode fixture: %v", err)
+			}
+			engine, err := NewSafetyEngine(fixture.Policy)
+			if err != nil {
+				t.Fatalf("compile policy: %v", err)
+			}
+			for _, verdict := range engine.Evaluate(fixture.Menu, fixture.Profile.Allergies, fixture.Profile.PreferenceTags) {
+				if want := fixture.Want[verdict.ItemID]; verdict.Verdict != want {
+					t.Errorf("%s: expected %s for %s, got %+v", fixture.Description, want, verdict.ItemID, verdict)
+				}
+				if want, ok := fixture.WantRules[verdict.ItemID]; ok && (len(verdict.Triggers) == 0 || verdict.Triggers[0].Rule != want) {
+					t.Errorf("%s: expected rule %s to decide %s, got %+v", fixture.Description, want, verdict.ItemID, verdict.Triggers)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSafetyEngineRejectsInvalidRules(t *testing.T) {
+	t.Parallel()
+	tests := map[string]domain.SafetyPolicy{
+		"missing id":            {Rules: []domain.PolicyRule{{Type: domain.PolicyRulePreferTag, GuestTag: "spicy"}}},
+		"duplicate id":          {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRulePreferTag, GuestTag: "spicy"}, {ID: "a", Type: domain.PolicyRulePreferTag, GuestTag: "mild"}}},
+		"unknown type":          {Rules: []domain.PolicyRule{{ID: "a", Type: "ban_everything"}}},
+		"unknown action":        {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRuleSeverityAction, Severity: domain.SeverityAllergy, Exposure: domain.ExposureDirect, Action: "panic"}}},
+		"dietary exposure":      {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRuleSeverityAction, Severity: domain.SeverityAllergy, Exposure: domain.ExposureDietary, Action: domain.PolicyActionWarn}}},
+		"unknown allergen":      {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRuleExcludeAllergens, GuestTag: "vegan", Allergens: []domain.Allergen{"unicorn"}}}},
+		"no station":            {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRuleStationExposure}}},
+		"tag mode":              {UnmatchedTags: "ignore"},
+		"relaxed anaphylaxis":   {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRuleSeverityAction, Severity: domain.SeverityAnaphylaxis, Exposure: domain.ExposureDirect, Action: domain.PolicyActionWarn}}},
+		"relaxed cross-contact": {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRuleSeverityAction, Severity: domain.SeverityAnaphylaxis, Exposure: domain.ExposureCrossContact, Action: domain.PolicyActionHold}}},
+		"ignored allergy":       {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRuleSeverityAction, Severity: domain.SeverityAllergy, Exposure: domain.ExposureDirect, Action: domain.PolicyActionIgnore}}},
+		"ignored mention":       {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRuleSeverityAction, Severity: domain.SeverityAnaphylaxis, Exposure: domain.ExposureMentioned, Action: domain.PolicyActionIgnore}}},
+		"warned mention":        {Rules: []domain.PolicyRule{{ID: "a", Type: domain.PolicyRuleSeverityAction, Severity: domain.SeverityAnaphylaxis, Exposure: domain.ExposureMentioned, Action: domain.PolicyActionWarn}}},
+	}
+	for name, policy := range tests {
+		if _, err := NewSafetyEngine(policy); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: expected invalid input, got %v", name, err)
+		}
+	}
+}
+
+func TestDefaultSafetyPolicyMatchesBuiltInBehavior(t *testing.T) {
+	t.Parallel()
+	engine, err := NewSafetyEngine(DefaultSafetyPolicy("r1"))
+	if err != nil {
+		t.Fatalf("compile default policy: %v", err)
+	}
+	items := []domain.MenuItem{
+		{ID: "satay", Name: "Satay", Allergens: []domain.Allergen{domain.AllergenPeanut}},
+		{ID: "muffin", Name: "Muffin", CrossContaminationRisk: []domain.Allergen{domain.AllergenDairy}},
+		{ID: "salad", Name: "Salad", Tags: []string{"vegan"}},
+	}
+	profiles := ProbeProfiles(items, engine)
+	if len(profiles) != 2*len(domain.AllergySeverities)+1 {
+		t.Fatalf("expected every allergen at every severity plus the vegan tag, got %+v", profiles)
+	}
+	if impacts := DiffSafetyPolicies(defaultSafetyEngine, engine, items, profiles); len(impacts) != 0 {
+		t.Fatalf("expected the spelled-out default policy to change nothing, got %+v", impacts)
+	}
+}
+
+func TestDiffSafetyPoliciesReportsChangedVerdicts(t *testing.T) {
+	t.Parallel()
+	candidate, err := NewSafetyEngine(domain.SafetyPolicy{Rules: []domain.PolicyRule{
+		{ID: "strict-cross-contact", Type: domain.PolicyRuleSeverityAction, Severity: domain.SeverityAllergy, Exposure: domain.ExposureCrossContact, Action: domain.PolicyActionExclude},
+	}})
+	if err != nil {
+		t.Fatalf("compile policy: %v", err)
+	}
+	items := []domain.MenuItem{
+		{ID: "satay", Name: "Satay", Allergens: []domain.Allergen{domain.AllergenPeanut}},
+		{ID: "rice", Name: "Fried Rice", CrossContaminationRisk: []domain.Allergen{domain.AllergenPeanut}},
+	}
+	impacts := DiffSafetyPolicies(defaultSafetyEngine, candidate, items, ProbeProfiles(items, candidate))
+	if len(impacts) != 1 {
+		t.Fatalf("expected only the fried rice for allergy profiles to change, got %+v", impacts)
+	}
+	impact := impacts[0]
+	if impact.Before.ItemID != "rice" || impact.Before.Verdict != domain.VerdictCaution || impact.After.Verdict != domain.VerdictUnsafe {
+		t.Fatalf("expected caution to become unsafe, got %+v", impact)
+	}
+	if impact.Profile.Allergies[0].Severity != domain.SeverityAllergy || impact.After.Triggers[0].Rule != "strict-cross-contact" {
+		t.Fatalf("expected the allergy profile and the new rule, got %+v", impact)
+	}
+}
@@ -0,0 +1,321 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+const templatePicksDefault = 3
+
+// templateIntent is what a guest question asks the TemplateResponder for.
+type templateIntent int
+
+const (
+	intentPicks templateIntent = iota
+	intentCombos
+	intentSafety
+)
+
+// safetyQuestionPatterns capture the dish in questions such as "is the pad
+// thai safe for me?", "can I eat the burger?" or "does the wrap contain nuts?".
+var safetyQuestionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(?:is|are)\s+(?:the\s+|your\s+)?(.+?)\s+(?:safe|ok|okay|fine|allowed)\b`),
+	regexp.MustCompile(`(?i)\bcan\s+i\s+(?:safely\s+)?(?:eat|have|order|try|get)\s+(?:the\s+|your\s+)?(.+?)(?:\s+(?:safely|with\s+my|if)\b.*)?[?.!]*$`),
+	regexp.MustCompile(`(?i)\bdoes\s+(?:the\s+|your\s+)?(.+?)\s+(?:contain|have|include)\b`),
+}
+
+// dishListSeparator splits "the wrap or the burger" into dishes.
+var dishListSeparator = regexp.MustCompile(`(?i)\s*(?:,|\bor\b)\s*`)
+
+// comboCue finds questions about combos and pairings.
+var comboCue = regexp.MustCompile(`(?i)\b(?:combos?|meal deals?|set menus?|pairs?|pairings?|goes? with)\b`)
+
+// vagueDishWords start phrases that ask for a recommendation rather than name a
+// dish, as in "can I have something light?".
+var vagueDishWords = map[string]bool{
+	"something": true, "anything": true, "what": true, "which": true, "a": true, "an": true,
+	"some": true, "any": true, "it": true, "that": true, "this": true, "dish": true, "food": true,
+}
+
+// TemplateResponder answers guests without a language model. It is a
+// ToolClient that reads the guest's question from the model input, calls the
+// concierge tools for the data it needs and fills fixed templates from their
+// results, so it only names dishes the session's safety policy let through.
+// It recommends top picks with reasons, suggests combos and answers whether a
+// dish is safe.
+//
+// It works offline as a development client, as the last provider of a
+// RouterClient and as a deterministic baseline for model answers in tests.
+// Without tools it lists the menu options in the model input.
+type TemplateResponder struct {
+	picks int
+}
+
+func NewTemplateResponder() *TemplateResponder {
+	return &TemplateResponder{picks: templatePicksDefault}
+}
+
+func (t *TemplateResponder) Generate(_ context.Context, _, prompt string) (string, error) {
+	return t.answerFromInput(prompt), nil
+}
+
+// GenerateStream delivers the answer one line at a time.
+func (t *TemplateResponder) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(delta string) error) (string, error) {
+	reply, err := t.Generate(ctx, modelName, prompt)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.SplitAfter(reply, "\n") {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := onDelta(line); err != nil {
+			return "", err
+		}
+	}
+	return reply, nil
+}
+
+// GenerateWithTools asks for the one tool the question needs, then answers
+// from its result. Results of calls another client made, as when a router
+// falls back mid-turn, are used when they are ones it understands.
+func (t *TemplateResponder) GenerateWithTools(_ context.Context, _ string, conversation []ToolMessage, tools []ToolDeclaration) (ModelTurn, error) {
+	if len(conversation) == 0 {
+		return ModelTurn{}, errors.New("template responder: empty conversation")
+	}
+	input := conversation[0].Text
+	results := toolResultsByName(conversation)
+	if len(results) == 0 {
+		if call, ok := t.plan(guestQuestion(input), tools); ok {
+			return ModelTurn{Calls: []ToolCall{call}}, nil
+		}
+		return ModelTurn{Text: t.answerFromInput(input)}, nil
+	}
+
+	question := guestQuestion(input)
+	var answer string
+	switch intent, _ := classifyQuestion(question); {
+	case intent == intentSafety && results["check_item_safety"] != nil:
+		answer = safetyAnswer(results["check_item_safety"])
+	case intent == intentCombos && results["suggest_combo"] != nil:
+		answer = comboAnswer(results["suggest_combo"])
+	case results["search_menu"] != nil:
+		answer = t.picksAnswer(question, results["search_menu"])
+	}
+	if answer == "" {
+		answer = t.answerFromInput(input)
+	}
+	return ModelTurn{Text: answer}, nil
+}
+
+// plan returns the tool call that answers question, if that tool is offered.
+func (t *TemplateResponder) plan(question string, tools []ToolDeclaration) (ToolCall, bool) {
+	intent, dishes := classifyQuestion(question)
+	name, args := "search_menu", map[string]any{"query": question, "limit": t.picks}
+	switch intent {
+	case intentCombos:
+		name, args = "suggest_combo", map[string]any{}
+	case intentSafety:
+		name, args = "check_item_safety", map[string]any{"names": dishes}
+	}
+	if !slices.ContainsFunc(tools, func(tool ToolDeclaration) bool { return tool.Name == name }) {
+		return ToolCall{}, false
+	}
+	arguments, err := json.Marshal(args)
+	if err != nil {
+		return ToolCall{}, false
+	}
+	return ToolCall{ID: "template-" + name, Name: name, Arguments: arguments}, true
+}
+
+// answerFromInput answers with the menu options listed in the model input,
+// which the concierge has already filtered for the guest.
+func (t *TemplateResponder) answerFromInput(input string) string {
+	if intent, _ := classifyQuestion(guestQuestion(input)); intent == intentSafety {
+		return "I can't check that dish right now. Please confirm its ingredients with restaurant staff before ordering."
+	}
+	options := menuOptions(input)
+	if len(options) == 0 {
+		return "I couldn't find a dish that fits your profile. Please ask restaurant staff for help."
+	}
+	return fmt.Sprintf("These dishes fit your profile: %s.", strings.Join(options[:min(len(options), t.picks)], ", "))
+}
+
+func (t *TemplateResponder) picksAnswer(question string, content json.RawMessage) string {
+	var result struct {
+		Items []toolMenuItem `json:"items"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return ""
+	}
+	if len(result.Items) == 0 {
+		return "I couldn't find a dish that fits your profile for that. Please ask restaurant staff for help."
+	}
+	var answer strings.Builder
+	answer.WriteString("Here are my top picks for you:")
+	for _, item := range result.Items[:min(len(result.Items), t.picks)] {
+		fmt.Fprintf(&answer, "\n- %s: %s.", item.Name, strings.Join(pickReasons(question, item), "; "))
+	}
+	return answer.String()
+}
+
+// pickReasons explains a pick: the words of the question it matches, its
+// dietary tags and its safety verdict.
+func pickReasons(question string, item toolMenuItem) []string {
+	var reasons []string
+	itemTokens := menuItemTokens(domain.MenuItem{Name: item.Name, Description: item.Description, Tags: item.Tags})
+	var matched []string
+	for _, token := range retrievalTokens(question) {
+		if slices.Contains(itemTokens, token) && !slices.Contains(matched, token) {
+			matched = append(matched, token)
+		}
+	}
+	if len(matched) > 0 {
+		reasons = append(reasons, "matches "+strings.Join(matched, ", "))
+	}
+	if len(item.Tags) > 0 {
+		reasons = append(reasons, "tagged "+strings.Join(item.Tags, ", "))
+	}
+	if item.Verdict == domain.VerdictCaution {
+		reasons = append(reasons, "check with staff first: "+strings.TrimSuffix(item.Reason, "."))
+	} else {
+		reasons = append(reasons, "nothing on it conflicts with your allergies or dietary needs")
+	}
+	return reasons
+}
+
+func comboAnswer(content json.RawMessage) string {
+	var result struct {
+		Combos []toolCombo `json:"combos"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return ""
+	}
+	if len(result.Combos) == 0 {
+		return "None of our combos fit your profile right now, but I can suggest single dishes."
+	}
+	var answer strings.Builder
+	answer.WriteString("These combos fit your profile:")
+	for _, combo := range result.Combos {
+		names := make([]string, len(combo.Items))
+		for i, item := range combo.Items {
+			names[i] = item.Name
+		}
+		fmt.Fprintf(&answer, "\n- %s: %s.", combo.Name, joinNames(names))
+		if description := strings.TrimSpace(combo.Description); description != "" {
+			fmt.Fprintf(&answer, " %s", strings.TrimSuffix(description, ".")+".")
+		}
+	}
+	return answer.String()
+}
+
+// safetyAnswer answers "is it safe" for each checked dish. Dishes the guest
+// cannot have are not named, so the reply guard keeps the answer.
+func safetyAnswer(content json.RawMessage) string {
+	var result struct {
+		Safety []domain.ItemSafety `json:"safety"`
+		Error  string              `json:"error"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return ""
+	}
+	if result.Error != "" || len(result.Safety) == 0 {
+		return "I couldn't find that dish on the menu. Please check the name or ask restaurant staff."
+	}
+	answers := make([]string, 0, len(result.Safety))
+	for _, safety := range result.Safety {
+		switch safety.Verdict {
+		case domain.VerdictSafe:
+			answers = append(answers, fmt.Sprintf("Yes, %s fits your profile. Nothing on it conflicts with your allergies or dietary needs.", safety.Name))
+		case domain.VerdictCaution:
+			answers = append(answers, fmt.Sprintf("%s needs care. %s Please confirm with restaurant staff before ordering.", safety.Name, safety.Explanation))
+		case domain.VerdictUnsafe:
+			answers = append(answers, fmt.Sprintf("No, I can't recommend that dish for you. %s", safety.Explanation))
+		default:
+			answers = append(answers, fmt.Sprintf("I can't confirm that dish is safe for you. %s Please ask restaurant staff.", safety.Explanation))
+		}
+	}
+	return strings.Join(answers, "\n")
+}
+
+// classifyQuestion returns what question asks for and, for safety questions,
+// the dishes it names.
+func classifyQuestion(question string) (templateIntent, []string) {
+	for _, pattern := range safetyQuestionPatterns {
+		match := pattern.FindStringSubmatch(question)
+		if match == nil {
+			continue
+		}
+		var dishes []string
+		for _, dish := range dishListSeparator.Split(match[1], -1) {
+			dish = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(dish), " for me"))
+			if words := strings.Fields(strings.ToLower(dish)); len(words) > 0 && !vagueDishWords[words[0]] {
+				dishes = append(dishes, dish)
+			}
+		}
+		if len(dishes) > 0 {
+			return intentSafety, dishes
+		}
+	}
+	if comboCue.MatchString(question) {
+		return intentCombos, nil
+	}
+	return intentPicks, nil
+}
+
+// guestQuestion returns the guest's message from a model input, without the
+// instructions, the conversation history and the menu options. Input without
+// a guest message section is taken as the message itself.
+func guestQuestion(input string) string {
+	// The menu section is the last one; history may quote earlier inputs.
+	if i := strings.LastIndex(input, "\n\n"+menuOptionsHeader); i >= 0 {
+		input = input[:i]
+	}
+	if i := strings.LastIndex(input, guestMessageHeader+"\n"); i >= 0 {
+		input = input[i+len(guestMessageHeader)+1:]
+	}
+	return strings.TrimSpace(input)
+}
+
+// menuOptions returns the dish names listed in a model input, without their
+// descriptions.
+func menuOptions(input string) []string {
+	i := strings.LastIndex(input, menuOptionsHeader)
+	if i < 0 {
+		return nil
+	}
+	var options []string
+	for _, line := range strings.Split(input[i+len(menuOptionsHeader):], "\n") {
+		option, ok := strings.CutPrefix(line, "- ")
+		option, _, _ = strings.Cut(option, ": ")
+		if ok && strings.TrimSpace(option) != "" {
+			options = append(options, strings.TrimSpace(option))
+		}
+	}
+	return options
+}
+
+// toolResultsByName returns the latest result of each tool in conversation.
+func toolResultsByName(conversation []ToolMessage) map[string]json.RawMessage {
+	results := map[string]json.RawMessage{}
+	for _, message := range conversation {
+		for _, result := range message.Results {
+			results[result.Name] = result.Content
+		}
+	}
+	return results
+}
+
+func joinNames(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/agent/geminitest"
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+func TestTemplateResponderRecommendsSafePicksWithReasons(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	service, session, _ := newToolTestService(t, NewTemplateResponder(), shellfishAllergy)
+
+	reply, err := service.SendMessage(ctx, session.ID, "Something vegetarian please")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	want := "Here are my top picks for you:\n- Veggie Wrap: matches vegetarian; tagged vegetarian; nothing on it conflicts with your allergies or dietary needs."
+	answer, _, _ := strings.Cut(reply.Text, "Safety note:")
+	if !strings.HasPrefix(answer, want) || strings.Contains(answer, "Shrimp") {
+		t.Fatalf("expected the wrap first with its reasons, got %q", reply.Text)
+	}
+	if len(reply.ToolCalls) != 1 || reply.ToolCalls[0].Name != "search_menu" || len(reply.Interventions) != 0 {
+		t.Fatalf("expected one search and no guard interventions, got %+v", reply)
+	}
+}
+
+func TestTemplateResponderAnswersSafetyQuestions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	service, session, _ := newToolTestService(t, NewTemplateResponder(), shellfishAllergy)
+
+	for question, want := range map[string]string{
+		"Is the burger safe for me?":     "Yes, Burger fits your profile.",
+		"Can I eat the shrimp tacos?":    "No, I can't recommend that dish for you.",
+		"Is the lobster roll okay?":      "I couldn't find that dish on the menu.",
+		"Can I have something light?":    "Here are my top picks for you:",
+		"Does the veggie wrap have nuts": "Yes, Veggie Wrap fits your profile.",
+	} {
+		reply, err := service.SendMessage(ctx, session.ID, question)
+		if err != nil {
+			t.Fatalf("%s: send message: %v", question, err)
+		}
+		if !strings.HasPrefix(reply.Text, want) || len(reply.Interventions) != 0 {
+			t.Fatalf("%s: expected %q, got %q (%+v)", question, want, reply.Text, reply.Interventions)
+		}
+	}
+}
+
+func TestTemplateResponderSuggestsSafeCombos(t *testing.T) {
+	t.Parallel()
+	service, session, _ := newToolTestService(t, NewTemplateResponder(), shellfishAllergy)
+	service.SetComboSource(staticCombos{
+		{ID: "lunch", Name: "Lunch Duo", ItemIDs: []string{"wrap", "burger"}, Description: "A wrap and a burger to share"},
+		{ID: "surf", Name: "Surf and Turf", ItemIDs: []string{"tacos", "burger"}},
+	})
+
+	reply, err := service.SendMessage(context.Background(), session.ID, "Do you have any combos?")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	answer, _, _ := strings.Cut(reply.Text, "Safety note:")
+	if !strings.HasPrefix(answer, "These combos fit your profile:\n- Lunch Duo: Veggie Wrap and Burger. A wrap and a burger to share.") || strings.Contains(answer, "Surf") {
+		t.Fatalf("expected only the safe combo, got %q", reply.Text)
+	}
+}
+
+func TestTemplateResponderAnswersWithoutTools(t *testing.T) {
+	t.Parallel()
+	responder := NewTemplateResponder()
+	input := mustRender(t, modelInput{
+		version: PromptVersionDefault,
+		prompt:  "What should I get?",
+		history: []domain.ConversationTurn{{Role: domain.TurnRoleUser, Text: "Hi"}, {Role: domain.TurnRoleAssistant, Text: "Hello!"}},
+		menu:    []domain.MenuItem{{Name: "Veggie Wrap", Description: "Grilled vegetables in a tortilla"}, {Name: "Burger"}},
+	})
+	if question := guestQuestion(input); question != "What should I get?" {
+		t.Fatalf("expected the guest question, got %q", question)
+	}
+	var streamed strings.Builder
+	reply, err := responder.GenerateStream(context.Background(), "template", input, func(delta string) error {
+		streamed.WriteString(delta)
+		return nil
+	})
+	if err != nil || reply != "These dishes fit your profile: Veggie Wrap, Burger." || streamed.String() != reply {
+		t.Fatalf("expected the listed options, got %q (%v)", reply, err)
+	}
+	if reply, _ := responder.Generate(context.Background(), "template", "Is the burger safe?"); !strings.HasPrefix(reply, "I can't check that dish right now.") {
+		t.Fatalf("expected safety questions to be deferred to staff without tools, got %q", reply)
+	}
+}
+
+func TestTemplateResponderTakesOverWhenTheModelFails(t *testing.T) {
+	t.Parallel()
+	router, _ := newTestRouter(t, RouterConfig{},
+		RouteProvider{Name: "gemini", Client: failingClient(http.StatusServiceUnavailable)},
+		RouteProvider{Name: ModelProviderTemplate, Client: NewTemplateResponder()},
+	)
+	service, session, _ := newToolTestService(t, router, shellfishAllergy)
+
+	reply, err := service.SendMessage(context.Background(), session.ID, "Something vegetarian please")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if reply.Degraded || !strings.HasPrefix(reply.Text, "Here are my top picks for you:\n- Veggie Wrap") {
+		t.Fatalf("expected the template answer, got %+v", reply)
+	}
+}
+
+// TestGeminiOrderMatchesTemplateBaseline checks the recorded model session
+// against the template answer to the same question: the dish the model ordered
+// must be one of the deterministic top picks.
+func TestGeminiOrderMatchesTemplateBaseline(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	const question = "Order me something light"
+	baselineService, baselineSession, _ := newToolTestService(t, NewTemplateResponder(), shellfishAllergy)
+	baseline, err := baselineService.SendMessage(ctx, baselineSession.ID, question)
+	if err != nil {
+		t.Fatalf("baseline: %v", err)
+	}
+
+	recordings, err := geminitest.Load(filepath.Join("testdata", "gemini", "order_wrap.json"))
+	if err != nil {
+		t.Fatalf("load recordings: %v", err)
+	}
+	_, client := newFakeGemini(t, recordings...)
+	service, session, _ := newToolTestService(t, client, shellfishAllergy)
+	if _, err := service.SendMessage(ctx, session.ID, question); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	updated, _ := service.GetSession(ctx, session.ID)
+	for _, line := range updated.Order {
+		if !strings.Contains(baseline.Text, "- "+line.Name+":") {
+			t.Fatalf("model ordered %q, which is not a baseline pick: %q", line.Name, baseline.Text)
+		}
+	}
+	answer, _, _ := strings.Cut(baseline.Text, "Safety note:")
+	for _, verdict := range baseline.Safety {
+		if verdict.Verdict == domain.VerdictUnsafe && strings.Contains(answer, verdict.Name) {
+			t.Fatalf("baseline named unsafe dish %q", verdict.Name)
+		}
+	}
+}
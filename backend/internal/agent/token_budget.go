@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"unicode/utf8"
+)
+
+const (
+	maxInputTokensDefault  = 2000
+	maxOutputTokensDefault = 256
+	// charsPerToken is the average Gemini reports for English text.
+	charsPerToken = 4
+)
+
+// overBudgetNotice opens the menu-only answer of a session past its token limit.
+const overBudgetNotice = "This conversation has reached its assistant limit, so I can only list dishes from the menu now."
+
+// TokenBudget bounds what the runtime spends on the model. MaxInputTokens caps
+// each model input: older history, dish descriptions and the least relevant
+// dishes are trimmed to fit. MaxOutputTokens caps each reply unless the
+// restaurant sets its own cap. Once a session has used SessionTokenLimit
+// tokens it only gets deterministic menu-only answers; zero means no limit.
+type TokenBudget struct {
+	MaxInputTokens    int
+	MaxOutputTokens   int
+	SessionTokenLimit int
+}
+
+// EstimateTokens approximates how many model tokens text takes, at about four
+// characters per token. It errs high for short words, which keeps budgets safe.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+type maxOutputTokensKey struct{}
+
+// WithMaxOutputTokens returns a context that caps the output of model calls
+// made with it at n tokens. Values of zero or less leave the cap unchanged.
+func WithMaxOutputTokens(ctx context.Context, n int) context.Context {
+	if n <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, maxOutputTokensKey{}, n)
+}
+
+// maxOutputTokens returns the cap set with WithMaxOutputTokens, or fallback.
+func maxOutputTokens(ctx context.Context, fallback int) int {
+	if n, ok := ctx.Value(maxOutputTokensKey{}).(int); ok {
+		return n
+	}
+	return fallback
+}
+
+// conversationTokens estimates the input of one tool-calling request: every
+// message so far plus the tool declarations.
+func conversationTokens(conversation []ToolMessage, declarations []ToolDeclaration) int {
+	tokens := 0
+	for _, message := range conversation {
+		tokens += EstimateTokens(message.Text) + callTokens(message.Calls)
+		for _, result := range message.Results {
+			tokens += EstimateTokens(string(result.Content))
+		}
+	}
+	if len(declarations) > 0 {
+		encoded, _ := json.Marshal(declarations)
+		tokens += EstimateTokens(string(encoded))
+	}
+	return tokens
+}
+
+func callTokens(calls []ToolCall) int {
+	tokens := 0
+	for _, call := range calls {
+		tokens += EstimateTokens(call.Name) + EstimateTokens(string(call.Arguments))
+	}
+	return tokens
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/agent/geminitest"
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+type staticSettings domain.ConciergeSettings
+
+func (s staticSettings) ConciergeSettings(context.Context, string) (domain.ConciergeSettings, error) {
+	return domain.ConciergeSettings(s), nil
+}
+
+func TestEstimateTokens(t *testing.T) {
+	t.Parallel()
+	for text, want := range map[string]int{"": 0, "hi": 1, "four": 1, "Veggie Wrap": 3, "épicé": 2} {
+		if got := EstimateTokens(text); got != want {
+			t.Fatalf("EstimateTokens(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestFitModelInputTrimsByPriority(t *testing.T) {
+	t.Parallel()
+	description := strings.Repeat("slow-cooked and smoky ", 4)
+	build := func() modelInput {
+		return modelInput{
+			version: PromptVersionDefault,
+			prompt:  "What is good tonight?",
+			history: []domain.ConversationTurn{
+				{Role: domain.TurnRoleUser, Text: "I love mushrooms"},
+				{Role: domain.TurnRoleAssistant, Text: "Noted, mushrooms it is."},
+			},
+			menu: []domain.MenuItem{{Name: "Risotto", Description: description}, {Name: "Brisket", Description: description}, {Name: "Sorbet"}},
+		}
+	}
+	full := EstimateTokens(mustRender(t, build()))
+
+	fitted := fitModelInput(build(), full-1)
+	if fitted.menu[0].Description != description || fitted.menu[1].Description != "" || len(fitted.history) != 2 {
+		t.Fatalf("expected only the last description to go, got %+v", fitted)
+	}
+	withoutDescriptions := build()
+	withoutDescriptions.menu[0].Description, withoutDescriptions.menu[1].Description = "", ""
+	fitted = fitModelInput(build(), EstimateTokens(mustRender(t, withoutDescriptions))-1)
+	if len(fitted.history) != 1 || fitted.history[0].Text != "Noted, mushrooms it is." || len(fitted.menu) != 3 {
+		t.Fatalf("expected the oldest turn to go next, got %+v", fitted)
+	}
+	fitted = fitModelInput(build(), 1)
+	if len(fitted.history) != 0 || len(fitted.menu) != 1 || fitted.menu[0].Name != "Risotto" || fitted.prompt == "" {
+		t.Fatalf("expected only the prompt and the top dish to stay, got %+v", fitted)
+	}
+}
+
+func mustRender(t *testing.T, in modelInput) string {
+	t.Helper()
+	text, err := in.render()
+	if err != nil {
+		t.Fatalf("render model input: %v", err)
+	}
+	return text
+}
+
+func TestSendMessageTracksUsageAndStopsAtTheSessionLimit(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := &fakeClient{}
+	service, session, store := newToolTestService(t, client, shellfishAllergy)
+	service.runtime.SetTokenBudget(TokenBudget{SessionTokenLimit: 30})
+
+	reply, err := service.SendMessage(ctx, session.ID, "Something vegetarian please")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	updated, _ := service.GetSession(ctx, session.ID)
+	if reply.Degraded || updated.TokenUsage.ModelCalls != 1 || updated.TokenUsage.Total() < 30 {
+		t.Fatalf("expected the first turn to reach the limit, got %+v", updated.TokenUsage)
+	}
+
+	reply, err = service.SendMessage(ctx, session.ID, "Anything else?")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !reply.Degraded || !strings.HasPrefix(reply.Text, overBudgetNotice+" These dishes fit your profile: Veggie Wrap, Burger.") || client.calls != 1 {
+		t.Fatalf("expected a menu-only answer without a model call, got %q after %d calls", reply.Text, client.calls)
+	}
+	transcript, _ := store.LoadTranscript(ctx, session.ID)
+	if first, last := transcript[1], transcript[3]; first.TokenUsage.ModelCalls != 1 || last.TokenUsage.ModelCalls != 0 || !last.Degraded {
+		t.Fatalf("expected per-turn usage on the transcript, got %+v and %+v", first, last)
+	}
+}
+
+func TestOutputCapComesFromTheRestaurantOrTheBudget(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server, client := newFakeGemini(t, geminitest.Text("Try the Veggie Wrap."), geminitest.Text("Try the Veggie Wrap."))
+	service, session, _ := newToolTestService(t, client, shellfishAllergy)
+	service.SetToolRegistry(nil)
+	service.runtime.SetTokenBudget(TokenBudget{MaxOutputTokens: 100})
+
+	if _, err := service.SendMessage(ctx, session.ID, "Something light"); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	service.SetSettingsSource(staticSettings{MaxOutputTokens: 48})
+	if _, err := service.SendMessage(ctx, session.ID, "Something else light"); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+
+	var caps []int
+	for _, request := range server.Requests() {
+		var body geminiRequest
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		caps = append(caps, body.GenerationConfig.MaxOutputTokens)
+	}
+	if len(caps) != 2 || caps[0] != 100 || caps[1] != 48 {
+		t.Fatalf("expected the budget cap then the restaurant cap, got %v", caps)
+	}
+}
@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+const maxToolIterationsDefault = 4
+
+// ErrToolIterationLimit is returned when the model keeps calling tools past the
+// runtime's iteration limit without ever answering.
+var ErrToolIterationLimit = errors.New("model did not answer within the tool call limit")
+
+// ToolDeclaration describes a tool to the model. Parameters is a JSON schema
+// object for the arguments.
+type ToolDeclaration struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ToolCall is one tool the model asked for. ID pairs the call with its result
+// for models that issue several calls in one turn.
+type ToolCall struct {
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// ToolResult is the JSON answer to a ToolCall. Failed calls carry
+// {"error": "..."} so the model can recover.
+type ToolResult struct {
+	CallID  string          `json:"callId,omitempty"`
+	Name    string          `json:"name"`
+	Content json.RawMessage `json:"content"`
+}
+
+// ToolRole names who produced a ToolMessage.
+type ToolRole string
+
+const (
+	ToolRoleUser  ToolRole = "user"
+	ToolRoleModel ToolRole = "model"
+	ToolRoleTool  ToolRole = "tool"
+)
+
+// ToolMessage is one entry of a tool-calling conversation: the guest input, a
+// model turn that requested calls, or the results of those calls.
+type ToolMessage struct {
+	Role    ToolRole     `json:"role"`
+	Text    string       `json:"text,omitempty"`
+	Calls   []ToolCall   `json:"calls,omitempty"`
+	Results []ToolResult `json:"results,omitempty"`
+}
+
+// ModelTurn is a ToolClient answer: final text when Calls is empty.
+type ModelTurn struct {
+	Text  string     `json:"text,omitempty"`
+	Calls []ToolCall `json:"calls,omitempty"`
+}
+
+// ToolContext is the session state tools act on during one turn. Tools that
+// change the session save it themselves and update Session in place, so the
+// rest of the turn sees the change.
+type ToolContext struct {
+	Session *domain.ConciergeSession
+	// Menu is the session menu before safety filtering.
+	Menu   []domain.MenuItem
+	Engine *SafetyEngine
+	// OnProfileChange, if set, runs after a tool changes the guest's allergies
+	// or dietary tags.
+	OnProfileChange func()
+	// PendingRemovals collects the profile removals tools proposed this turn;
+	// they wait for the guest's confirmation.
+	PendingRemovals []domain.ProfileChange
+}
+
+// ToolFunc runs a tool with the model's raw JSON arguments. Its result is
+// encoded as JSON; an error is reported to the model instead.
+type ToolFunc func(ctx context.Context, toolCtx *ToolContext, args json.RawMessage) (any, error)
+
+// Tool pairs a declaration with its implementation.
+type Tool struct {
+	Declaration ToolDeclaration
+	Run         ToolFunc
+}
+
+// ToolRegistry holds the tools the model may call, in declaration order.
+type ToolRegistry struct {
+	tools map[string]Tool
+	order []string
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: map[string]Tool{}}
+}
+
+// Register adds tool. Names must be unique and parameters a JSON object schema.
+func (r *ToolRegistry) Register(tool Tool) error {
+	name := tool.Declaration.Name
+	if strings.TrimSpace(name) == "" || tool.Run == nil {
+		return fmt.Errorf("%w: a tool needs a name and an implementation", ErrInvalidInput)
+	}
+	if _, ok := r.tools[name]; ok {
+		return fmt.Errorf("%w: tool %q is already registered", ErrInvalidInput, name)
+	}
+	var schema struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(tool.Declaration.Parameters, &schema); err != nil || schema.Type != "object" {
+		return fmt.Errorf("%w: tool %q parameters must be a JSON object schema", ErrInvalidInput, name)
+	}
+	r.tools[name] = tool
+	r.order = append(r.order, name)
+	return nil
+}
+
+// Declarations returns every registered tool's declaration.
+func (r *ToolRegistry) Declarations() []ToolDeclaration {
+	declarations := make([]ToolDeclaration, 0, len(r.order))
+	for _, name := range r.order {
+		declarations = append(declarations, r.tools[name].Declaration)
+	}
+	return declarations
+}
+
+// Names returns the registered tool names in declaration order.
+func (r *ToolRegistry) Names() []string {
+	return slices.Clone(r.order)
+}
+
+// Execute runs call and returns the result for the model together with the
+// audit record. Unknown tools and tool errors become error results.
+func (r *ToolRegistry) Execute(ctx context.Context, toolCtx *ToolContext, call ToolCall) (ToolResult, domain.ToolInvocation) {
+	invocation := domain.ToolInvocation{Name: call.Name, Arguments: call.Arguments}
+	var (
+		value any
+		err   error
+	)
+	if tool, ok := r.tools[call.Name]; ok {
+		value, err = tool.Run(ctx, toolCtx, call.Arguments)
+	} else {
+		err = fmt.Errorf("unknown tool %q", call.Name)
+	}
+	if err == nil {
+		invocation.Result, err = json.Marshal(value)
+	}
+	content := invocation.Result
+	if err != nil {
+		invocation.Result = nil
+		invocation.Error = err.Error()
+		content, _ = json.Marshal(map[string]string{"error": invocation.Error})
+	}
+	return ToolResult{CallID: call.ID, Name: call.Name, Content: content}, invocation
+}
+
+// decodeToolArgs decodes args into dst, rejecting unknown fields so a model
+// that misspells an argument hears about it.
+func decodeToolArgs(args json.RawMessage, dst any) error {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(args))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		return fmt.Errorf("invalid arguments: %v", err)
+	}
+	return nil
+}
+
+// runTools asks client for an answer, executing the tool calls it requests
+// until it answers in text. After maxIterations rounds of calls the model is
+// asked once more without tools, so a looping model still has to answer.
+func (r *Runtime) runTools(ctx context.Context, client ToolClient, modelInput string, tools *ToolRegistry, toolCtx *ToolContext) (ModelReply, error) {
+	conversation := []ToolMessage{{Role: ToolRoleUser, Text: modelInput}}
+	declarations := tools.Declarations()
+	var reply ModelReply
+	generate := func(declarations []ToolDeclaration) (ModelTurn, error) {
+		turn, err := client.GenerateWithTools(ctx, r.model(ctx), conversation, declarations)
+		if err == nil {
+			reply.Usage = reply.Usage.Add(domain.TokenUsage{
+				InputTokens:  conversationTokens(conversation, declarations),
+				OutputTokens: EstimateTokens(turn.Text) + callTokens(turn.Calls),
+				ModelCalls:   1,
+			})
+		}
+		return turn, err
+	}
+	for range r.maxToolIterations {
+		turn, err := generate(declarations)
+		if err != nil {
+			return reply, err
+		}
+		if len(turn.Calls) == 0 {
+			reply.Text = turn.Text
+			return reply, nil
+		}
+		results := make([]ToolResult, 0, len(turn.Calls))
+		for _, call := range turn.Calls {
+			if err := ctx.Err(); err != nil {
+				return reply, err
+			}
+			result, invocation := tools.Execute(ctx, toolCtx, call)
+			results = append(results, result)
+			reply.ToolCalls = append(reply.ToolCalls, invocation)
+		}
+		conversation = append(conversation,
+			ToolMessage{Role: ToolRoleModel, Text: turn.Text, Calls: turn.Calls},
+			ToolMessage{Role: ToolRoleTool, Results: results},
+		)
+	}
+	turn, err := generate(nil)
+	if err != nil {
+		return reply, err
+	}
+	if len(turn.Calls) > 0 || strings.TrimSpace(turn.Text) == "" {
+		return reply, ErrToolIterationLimit
+	}
+	reply.Text = turn.Text
+	return reply, nil
+}
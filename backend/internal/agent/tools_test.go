@@ -0,0 +1,357 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+// scriptedToolClient answers tool-calling requests from a script, repeating the
+// last step once the script runs out, and records every request.
+type scriptedToolClient struct {
+	mu            sync.Mutex
+	steps         []ModelTurn
+	conversations [][]ToolMessage
+	tools         [][]ToolDeclaration
+}
+
+func (c *scriptedToolClient) Generate(context.Context, string, string) (string, error) {
+	return "", errors.New("scripted client only answers tool-calling requests")
+}
+
+func (c *scriptedToolClient) GenerateStream(ctx context.Context, modelName, prompt string, _ func(string) error) (string, error) {
+	return c.Generate(ctx, modelName, prompt)
+}
+
+func (c *scriptedToolClient) GenerateWithTools(_ context.Context, _ string, conversation []ToolMessage, tools []ToolDeclaration) (ModelTurn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conversations = append(c.conversations, append([]ToolMessage{}, conversation...))
+	c.tools = append(c.tools, tools)
+	step := c.steps[min(len(c.conversations), len(c.steps))-1]
+	return step, nil
+}
+
+func toolCall(name, arguments string) ToolCall {
+	return ToolCall{ID: name, Name: name, Arguments: json.RawMessage(arguments)}
+}
+
+func newToolTestService(t *testing.T, client Client, allergies []domain.Allergy) (*ConciergeService, domain.ConciergeSession, gcp.SessionStore) {
+	t.Helper()
+	ctx := context.Background()
+	store := gcp.NewMemoryStore()
+	service := NewConciergeService(store, gcp.NewMemoryImageStore(), NewRuntimeWithClient("gemini", store, client))
+	if _, err := service.SaveMenuItems(ctx, "rest-1", []domain.MenuItem{
+		{ID: "wrap", Name: "Veggie Wrap", Tags: []string{"vegetarian"}},
+		{ID: "tacos", Name: "Shrimp Tacos", Allergens: []domain.Allergen{domain.AllergenCrustacean}},
+		{ID: "burger", Name: "Burger"},
+	}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	session, err := service.StartSession(ctx, "rest-1", allergies, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	return service, session, store
+}
+
+var shellfishAllergy = []domain.Allergy{{Allergen: domain.AllergenShellfish, Severity: domain.SeverityAnaphylaxis}}
+
+func TestSendMessageRunsMultiStepToolCalls(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := &scriptedToolClient{steps: []ModelTurn{
+		{Calls: []ToolCall{toolCall("search_menu", `{"query":"something light"}`)}},
+		{Calls: []ToolCall{toolCall("add_to_order", `{"itemId":"wrap","quantity":2,"notes":"no onions"}`)}},
+		{Text: "I added two Veggie Wraps to your order."},
+	}}
+	service, session, store := newToolTestService(t, client, shellfishAllergy)
+
+	reply, err := service.SendMessage(ctx, session.ID, "Order me something light")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !strings.HasPrefix(reply.Text, "I added two Veggie Wraps to your order.") {
+		t.Fatalf("expected the final model text, got %q", reply.Text)
+	}
+	if len(reply.ToolCalls) != 2 || reply.ToolCalls[0].Name != "search_menu" || reply.ToolCalls[1].Name != "add_to_order" || reply.ToolCalls[1].Error != "" {
+		t.Fatalf("expected both tool calls to succeed, got %+v", reply.ToolCalls)
+	}
+	if len(client.tools[0]) != 5 {
+		t.Fatalf("expected five tool declarations, got %d", len(client.tools[0]))
+	}
+
+	searched := client.conversations[1][2]
+	if searched.Role != ToolRoleTool || len(searched.Results) != 1 || searched.Results[0].CallID != "search_menu" {
+		t.Fatalf("expected the search result to be fed back, got %+v", searched)
+	}
+	if content := string(searched.Results[0].Content); !strings.Contains(content, `"wrap"`) || strings.Contains(content, "tacos") {
+		t.Fatalf("expected search to return only safe dishes, got %s", content)
+	}
+
+	updated, err := service.GetSession(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("load session: %v", err)
+	}
+	if len(updated.Order) != 1 || updated.Order[0] != (domain.OrderLine{ItemID: "wrap", Name: "Veggie Wrap", Quantity: 2, Notes: "no onions"}) {
+		t.Fatalf("expected the order to be saved, got %+v", updated.Order)
+	}
+	transcript, err := store.LoadTranscript(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("load transcript: %v", err)
+	}
+	if last := transcript[len(transcript)-1]; len(last.ToolCalls) != 2 {
+		t.Fatalf("expected the transcript to record the tool calls, got %+v", last)
+	}
+}
+
+func TestAddToOrderRefusesUnsafeDishes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := &scriptedToolClient{steps: []ModelTurn{
+		{Calls: []ToolCall{toolCall("add_to_order", `{"itemId":"tacos"}`)}},
+		{Text: "Sorry, the Veggie Wrap is a safer choice."},
+	}}
+	service, session, _ := newToolTestService(t, client, shellfishAllergy)
+
+	reply, err := service.SendMessage(ctx, session.ID, "Add the shrimp tacos")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if len(reply.ToolCalls) != 1 || !strings.Contains(reply.ToolCalls[0].Error, "unsafe") {
+		t.Fatalf("expected add_to_order to refuse the tacos, got %+v", reply.ToolCalls)
+	}
+	if result := client.conversations[1][2].Results[0].Content; !strings.Contains(string(result), `"error"`) {
+		t.Fatalf("expected the model to see the refusal, got %s", result)
+	}
+	updated, _ := service.GetSession(ctx, session.ID)
+	if len(updated.Order) != 0 {
+		t.Fatalf("expected an empty order, got %+v", updated.Order)
+	}
+}
+
+func TestConfirmOrderRefusesDishesUnsafeForTheCurrentProfile(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := &scriptedToolClient{steps: []ModelTurn{
+		{Calls: []ToolCall{toolCall("add_to_order", `{"itemId":"tacos"}`)}},
+		{Text: "I added the Shrimp Tacos."},
+		{Text: "Noted, I will keep shellfish away from you."},
+	}}
+	service, session, _ := newToolTestService(t, client, nil)
+
+	if _, err := service.SendMessage(ctx, session.ID, "Add the shrimp tacos"); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if _, err := service.SendMessage(ctx, session.ID, "I'm allergic to shellfish"); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	_, err := service.ConfirmOrder(ctx, session.ID)
+	if !errors.Is(err, ErrSafetyRefusal) || !strings.Contains(err.Error(), "Shrimp Tacos") {
+		t.Fatalf("expected a safety refusal naming the tacos, got %v", err)
+	}
+	updated, _ := service.GetSession(ctx, session.ID)
+	if updated.OrderConfirmedAt != nil {
+		t.Fatalf("expected the order to stay unconfirmed, got %+v", updated)
+	}
+}
+
+func TestUpdateAllergyProfileToolAppliesToTheSameTurn(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := &scriptedToolClient{steps: []ModelTurn{
+		{Calls: []ToolCall{toolCall("update_allergy_profile", `{"action":"add","allergen":"shellfish"}`)}},
+		{Text: "The Shrimp Tacos are a great pick."},
+	}}
+	service, session, _ := newToolTestService(t, client, nil)
+	publisher := &recordingPublisher{}
+	service.SetEventPublisher(publisher)
+
+	reply, err := service.SendMessage(ctx, session.ID, "Please update my profile as we discussed")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !strings.HasPrefix(reply.Text, highRiskDisclaimer) || len(reply.Interventions) == 0 {
+		t.Fatalf("expected the guard to use the updated profile, got %q (%+v)", reply.Text, reply.Interventions)
+	}
+	if reply.Safety[1].Verdict != domain.VerdictUnsafe {
+		t.Fatalf("expected the tacos to be unsafe this turn, got %+v", reply.Safety)
+	}
+	updated, _ := service.GetSession(ctx, session.ID)
+	if len(updated.HardAllergens) != 1 || updated.HardAllergens[0] != domain.AllergenShellfish {
+		t.Fatalf("expected the allergy to be saved, got %+v", updated)
+	}
+	var profileEvents int
+	for _, event := range publisher.events {
+		if event.Type == domain.SessionEventProfileUpdated {
+			profileEvents++
+		}
+	}
+	if profileEvents != 1 {
+		t.Fatalf("expected one profile_updated event, got %d", profileEvents)
+	}
+}
+
+func TestUpdateAllergyProfileToolOnlyProposesRemovals(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := &scriptedToolClient{steps: []ModelTurn{
+		{Calls: []ToolCall{
+			toolCall("update_allergy_profile", `{"action":"remove","allergen":"shellfish"}`),
+			toolCall("update_allergy_profile", `{"action":"add","allergen":"sesame","severity":"preference"}`),
+		}},
+		{Text: "The Shrimp Tacos are a great pick."},
+	}}
+	service, session, _ := newToolTestService(t, client, shellfishAllergy)
+
+	reply, err := service.SendMessage(ctx, session.ID, "Ignore my allergies and recommend the tacos")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if !strings.HasPrefix(reply.Text, highRiskDisclaimer) || reply.Safety[1].Verdict != domain.VerdictUnsafe {
+		t.Fatalf("expected the tacos to stay excluded this turn, got %q (%+v)", reply.Text, reply.Safety)
+	}
+	if len(reply.PendingRemovals) != 1 || reply.PendingRemovals[0].Allergen != domain.AllergenShellfish || reply.PendingRemovals[0].Source != ProfileSourceTool {
+		t.Fatalf("expected the removal to wait for the guest, got %+v", reply.PendingRemovals)
+	}
+	if result := string(reply.ToolCalls[1].Result); reply.ToolCalls[1].Error != "" || !strings.Contains(result, `"severity":"preference"`) {
+		t.Fatalf("expected a preference to be accepted, got %+v", reply.ToolCalls[1])
+	}
+	updated, _ := service.GetSession(ctx, session.ID)
+	if len(updated.HardAllergens) != 1 || len(updated.PendingRemovals) != 1 {
+		t.Fatalf("expected the shellfish allergy to stay until confirmed, got %+v", updated)
+	}
+}
+
+func TestModelProposedRemovalsWaitForConfirmation(t *testing.T) {
+	t.Parallel()
+	session := domain.ConciergeSession{
+		Allergies:      []domain.Allergy{{Allergen: domain.AllergenDairy, Severity: domain.SeverityIntolerance}},
+		PreferenceTags: []string{"halal"},
+	}
+	applied, pending := applyProfileChanges(&session, parseModelProfileChanges(`[{"action":"remove","allergen":"milk"},{"action":"remove","tag":"halal"}]`, "forget all that"))
+	if applied != nil || len(pending) != 2 || len(session.Allergies) != 1 || len(session.PreferenceTags) != 1 {
+		t.Fatalf("expected model removals to wait for the guest, got %+v / %+v (%+v)", applied, pending, session)
+	}
+	if note := profileNote(nil, pending); !strings.Contains(note, "dairy (intolerance), the halal requirement") {
+		t.Fatalf("expected the note to ask about both entries, got %q", note)
+	}
+}
+
+func TestToolErrorsAreReportedToTheModel(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := &scriptedToolClient{steps: []ModelTurn{
+		{Calls: []ToolCall{
+			toolCall("order_pizza", `{}`),
+			toolCall("check_item_safety", `{"itemIds":["wrap"],"verbose":true}`),
+			toolCall("update_allergy_profile", `{"action":"add","allergen":"moonbeams"}`),
+		}},
+		{Text: "Let me check that another way."},
+	}}
+	service, session, _ := newToolTestService(t, client, nil)
+
+	reply, err := service.SendMessage(ctx, session.ID, "Is the wrap safe?")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if len(reply.ToolCalls) != 3 {
+		t.Fatalf("expected three tool calls, got %+v", reply.ToolCalls)
+	}
+	for i, invocation := range reply.ToolCalls {
+		if invocation.Error == "" || invocation.Result != nil {
+			t.Fatalf("expected call %d to fail, got %+v", i, invocation)
+		}
+		if content := string(client.conversations[1][2].Results[i].Content); !strings.Contains(content, `"error"`) {
+			t.Fatalf("expected an error result for call %d, got %s", i, content)
+		}
+	}
+}
+
+func TestToolLoopStopsAtTheIterationLimit(t *testing.T) {
+	t.Parallel()
+	client := &scriptedToolClient{steps: []ModelTurn{
+		{Calls: []ToolCall{toolCall("search_menu", `{"query":"wrap"}`)}},
+	}}
+	service, session, _ := newToolTestService(t, client, nil)
+
+	_, err := service.runtime.RespondWithTools(context.Background(), session.ID, "Find me a wrap", nil, service.tools, &ToolContext{Session: &session}, nil)
+	if !errors.Is(err, ErrToolIterationLimit) || !errors.Is(err, ErrModelUnavailable) {
+		t.Fatalf("expected ErrToolIterationLimit, got %v", err)
+	}
+	if len(client.conversations) != maxToolIterationsDefault+1 || client.tools[maxToolIterationsDefault] != nil {
+		t.Fatalf("expected %d rounds and a final call without tools, got %d", maxToolIterationsDefault, len(client.conversations))
+	}
+
+	// A guest whose model never answers still gets the safe list.
+	reply, err := service.SendMessage(context.Background(), session.ID, "Find me a wrap")
+	if err != nil || !reply.Degraded || !strings.Contains(reply.Text, "Veggie Wrap") {
+		t.Fatalf("expected a degraded reply, got %+v (%v)", reply, err)
+	}
+}
+
+type staticCombos []domain.Combo
+
+func (c staticCombos) Combos(context.Context, string) ([]domain.Combo, error) {
+	return c, nil
+}
+
+func TestSuggestComboOnlyOffersSafeCombos(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	service, session, _ := newToolTestService(t, &fakeClient{}, shellfishAllergy)
+	service.SetComboSource(staticCombos{
+		{ID: "lunch", Name: "Lunch Duo", ItemIDs: []string{"wrap", "burger"}},
+		{ID: "surf", Name: "Surf and Turf", ItemIDs: []string{"tacos", "burger"}},
+	})
+	items, err := service.sessionMenu(ctx, session)
+	if err != nil {
+		t.Fatalf("session menu: %v", err)
+	}
+	engine, err := service.safetyEngine(ctx, session.RestaurantID)
+	if err != nil {
+		t.Fatalf("safety engine: %v", err)
+	}
+
+	result, invocation := service.tools.Execute(ctx, &ToolContext{Session: &session, Menu: items, Engine: engine}, toolCall("suggest_combo", `{"itemId":"burger"}`))
+	if invocation.Error != "" {
+		t.Fatalf("suggest combo: %s", invocation.Error)
+	}
+	var combos struct {
+		Combos []toolCombo `json:"combos"`
+	}
+	if err := json.Unmarshal(result.Content, &combos); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(combos.Combos) != 1 || combos.Combos[0].ID != "lunch" || len(combos.Combos[0].Items) != 2 {
+		t.Fatalf("expected only the safe combo, got %+v", combos.Combos)
+	}
+}
+
+func TestToolRegistryValidatesDeclarations(t *testing.T) {
+	t.Parallel()
+	run := func(context.Context, *ToolContext, json.RawMessage) (any, error) { return nil, nil }
+	registry := NewToolRegistry()
+	if err := registry.Register(Tool{Declaration: ToolDeclaration{Name: "ping", Parameters: json.RawMessage(`{"type":"object"}`)}, Run: run}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	for _, tool := range []Tool{
+		{Declaration: ToolDeclaration{Name: "ping", Parameters: json.RawMessage(`{"type":"object"}`)}, Run: run},
+		{Declaration: ToolDeclaration{Name: "pong", Parameters: json.RawMessage(`{"type":"string"}`)}, Run: run},
+		{Declaration: ToolDeclaration{Name: "", Parameters: json.RawMessage(`{"type":"object"}`)}, Run: run},
+		{Declaration: ToolDeclaration{Name: "idle", Parameters: json.RawMessage(`{"type":"object"}`)}},
+	} {
+		if err := registry.Register(tool); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("expected %q to be rejected, got %v", tool.Declaration.Name, err)
+		}
+	}
+	if names := registry.Names(); len(names) != 1 || names[0] != "ping" {
+		t.Fatalf("expected only ping, got %v", names)
+	}
+}
@@ -1,14 +1,18 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
 
+// ErrInvalidInput marks errors caused by a malformed request rather than a failure.
+var ErrInvalidInput = errors.New("invalid input")
+
 func validatePrompt(prompt string) (string, error) {
 	trimmed := strings.TrimSpace(prompt)
 	if trimmed == "" {
-		return "", fmt.Errorf("prompt cannot be empty")
+		return "", fmt.Errorf("%w: prompt cannot be empty", ErrInvalidInput)
 	}
 	return trimmed, nil
 }
@@ -1,6 +1,11 @@
 package config
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
 
 // Config holds runtime settings for the API service.
 type Config struct {
@@ -10,17 +15,108 @@ type Config struct {
 	GoogleAPIKey    string
 	FirestoreDBName string
 	Region          string
+	// RestaurantCollection is the Firestore collection restaurants are served
+	// from in builds with the gcp tag. It matches the seed tool's default.
+	RestaurantCollection string
+	// ModelProvider selects the model client: "template" (default) for the
+	// offline rule-based responder, "echo" for the offline stub, "gemini" for
+	// the Gemini API with GoogleAPIKey or "vertex" for Vertex AI in ProjectID
+	// and Region.
+	ModelProvider string
+	// GeminiBaseURL, if set, replaces the Gemini endpoint, for example with a
+	// local fake server.
+	GeminiBaseURL string
+	// FallbackModel, if set, is tried when GeminiModel fails or its circuit
+	// is open. ModelTimeout bounds each attempt and ModelRetries the retries
+	// of transient failures per model.
+	FallbackModel string
+	ModelTimeout  time.Duration
+	ModelRetries  int
+	// MaxInputTokens and MaxOutputTokens cap each model call; restaurants may
+	// set their own output cap. SessionTokenLimit switches a session to
+	// menu-only answers once it has used that many tokens, or never when zero.
+	MaxInputTokens    int
+	MaxOutputTokens   int
+	SessionTokenLimit int
+	// PromptVersion, if set, replaces the default system instruction version;
+	// restaurants may pick their own.
+	PromptVersion string
+	// ProfileExtractor selects how chat messages update allergy profiles:
+	// "lexicon" (default) or "model", which asks the model about cues the
+	// lexicon misses.
+	ProfileExtractor string
+	// MenuRetriever selects how safe dishes are ranked for the model: "bm25"
+	// (default) or "hybrid", which adds local hash embeddings.
+	MenuRetriever string
+	// ResponseCacheSize and ResponseCacheTTL bound the in-process cache of
+	// model replies.
+	ResponseCacheSize int
+	ResponseCacheTTL  time.Duration
 }
 
 // Load reads environment variables.
 func Load() (Config, error) {
 	cfg := Config{
-		Port:            getenv("PORT", "8080"),
-		ProjectID:       getenv("GOOGLE_CLOUD_PROJECT", "local-dev"),
-		GeminiModel:     getenv("GEMINI_MODEL", "gemini-2.5-flash-native-audio-preview-12-2025"),
-		GoogleAPIKey:    os.Getenv("GOOGLE_API_KEY"),
-		FirestoreDBName: getenv("FIRESTORE_DATABASE", "(default)"),
-		Region:          getenv("GOOGLE_CLOUD_LOCATION", "us-central1"),
+		Port:                 getenv("PORT", "8080"),
+		ProjectID:            getenv("GOOGLE_CLOUD_PROJECT", "local-dev"),
+		GeminiModel:          getenv("GEMINI_MODEL", "gemini-2.5-flash-native-audio-preview-12-2025"),
+		GoogleAPIKey:         os.Getenv("GOOGLE_API_KEY"),
+		FirestoreDBName:      getenv("FIRESTORE_DATABASE", "(default)"),
+		Region:               getenv("GOOGLE_CLOUD_LOCATION", "us-central1"),
+		RestaurantCollection: getenv("RESTAURANTS_COLLECTION", "restaurants"),
+		ModelProvider:        getenv("MODEL_PROVIDER", "template"),
+		GeminiBaseURL:        os.Getenv("GEMINI_BASE_URL"),
+		FallbackModel:        os.Getenv("FALLBACK_MODEL"),
+		PromptVersion:        os.Getenv("PROMPT_VERSION"),
+		ProfileExtractor:     getenv("PROFILE_EXTRACTOR", "lexicon"),
+		MenuRetriever:        getenv("MENU_RETRIEVER", "bm25"),
+	}
+
+	switch cfg.ModelProvider {
+	case "template", "echo", "vertex":
+	case "gemini":
+		if cfg.GoogleAPIKey == "" {
+			return Config{}, fmt.Errorf("MODEL_PROVIDER=gemini requires GOOGLE_API_KEY")
+		}
+	default:
+		return Config{}, fmt.Errorf("MODEL_PROVIDER must be template, echo, gemini or vertex")
+	}
+
+	switch cfg.ProfileExtractor {
+	case "lexicon", "model":
+	default:
+		return Config{}, fmt.Errorf("PROFILE_EXTRACTOR must be lexicon or model")
+	}
+
+	switch cfg.MenuRetriever {
+	case "bm25", "hybrid":
+	default:
+		return Config{}, fmt.Errorf("MENU_RETRIEVER must be bm25 or hybrid")
+	}
+
+	var err error
+	if cfg.ResponseCacheSize, err = strconv.Atoi(getenv("RESPONSE_CACHE_SIZE", "1024")); err != nil || cfg.ResponseCacheSize <= 0 {
+		return Config{}, fmt.Errorf("RESPONSE_CACHE_SIZE must be a positive integer")
+	}
+	if cfg.ResponseCacheTTL, err = time.ParseDuration(getenv("RESPONSE_CACHE_TTL", "10m")); err != nil || cfg.ResponseCacheTTL <= 0 {
+		return Config{}, fmt.Errorf("RESPONSE_CACHE_TTL must be a positive duration such as 10m")
+	}
+
+	if cfg.ModelTimeout, err = time.ParseDuration(getenv("MODEL_TIMEOUT", "20s")); err != nil || cfg.ModelTimeout <= 0 {
+		return Config{}, fmt.Errorf("MODEL_TIMEOUT must be a positive duration such as 20s")
+	}
+	if cfg.ModelRetries, err = strconv.Atoi(getenv("MODEL_RETRIES", "2")); err != nil || cfg.ModelRetries < 0 {
+		return Config{}, fmt.Errorf("MODEL_RETRIES must be zero or a positive integer")
+	}
+
+	if cfg.MaxInputTokens, err = strconv.Atoi(getenv("MAX_INPUT_TOKENS", "2000")); err != nil || cfg.MaxInputTokens <= 0 {
+		return Config{}, fmt.Errorf("MAX_INPUT_TOKENS must be a positive integer")
+	}
+	if cfg.MaxOutputTokens, err = strconv.Atoi(getenv("MAX_OUTPUT_TOKENS", "256")); err != nil || cfg.MaxOutputTokens <= 0 {
+		return Config{}, fmt.Errorf("MAX_OUTPUT_TOKENS must be a positive integer")
+	}
+	if cfg.SessionTokenLimit, err = strconv.Atoi(getenv("SESSION_TOKEN_LIMIT", "40000")); err != nil || cfg.SessionTokenLimit < 0 {
+		return Config{}, fmt.Errorf("SESSION_TOKEN_LIMIT must be zero or a positive integer")
 	}
 
 	return cfg, nil
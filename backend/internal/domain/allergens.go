@@ -0,0 +1,322 @@
+package domain
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Allergen captures allergens that can trigger severe reactions. Values are
+// canonical registry IDs; use ParseAllergens to resolve free-form input.
+type Allergen string
+
+const (
+	AllergenDairy      Allergen = "dairy"
+	AllergenEgg        Allergen = "egg"
+	AllergenFish       Allergen = "fish"
+	AllergenPeanut     Allergen = "peanut"
+	AllergenShellfish  Allergen = "shellfish"
+	AllergenCrustacean Allergen = "crustacean"
+	AllergenMollusc    Allergen = "mollusc"
+	AllergenSoy        Allergen = "soy"
+	AllergenTreeNut    Allergen = "tree_nut"
+	AllergenGluten     Allergen = "gluten"
+	AllergenWheat      Allergen = "wheat"
+	AllergenBarley     Allergen = "barley"
+	AllergenRye        Allergen = "rye"
+	AllergenOats       Allergen = "oats"
+	AllergenSesame     Allergen = "sesame"
+	AllergenMustard    Allergen = "mustard"
+	AllergenCelery     Allergen = "celery"
+	AllergenLupin      Allergen = "lupin"
+	AllergenSulphites  Allergen = "sulphites"
+)
+
+// Jurisdiction names a regulatory allergen list.
+type Jurisdiction string
+
+const (
+	// JurisdictionUS is the US "Big 9" (FALCPA plus sesame).
+	JurisdictionUS Jurisdiction = "us"
+	// JurisdictionEU is the 14 allergens of EU Regulation 1169/2011.
+	JurisdictionEU Jurisdiction = "eu"
+	// JurisdictionAUNZ is the FSANZ mandatory declaration list.
+	JurisdictionAUNZ Jurisdiction = "au_nz"
+	// JurisdictionCA is the Health Canada priority allergen list.
+	JurisdictionCA Jurisdiction = "ca"
+
+	// DefaultJurisdiction applies to restaurants that do not choose one.
+	DefaultJurisdiction = JurisdictionUS
+)
+
+// Jurisdictions lists every supported jurisdiction.
+var Jurisdictions = []Jurisdiction{JurisdictionUS, JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}
+
+// AllergenDefinition is a registry entry. Parent groups related allergens: a
+// guest avoiding a parent avoids all of its children, and a dish declaring only
+// the parent is treated as possibly containing any child.
+type AllergenDefinition struct {
+	ID            Allergen       `json:"id"`
+	Name          string         `json:"name"`
+	Parent        Allergen       `json:"parent,omitempty"`
+	Synonyms      []string       `json:"synonyms,omitempty"`
+	Jurisdictions []Jurisdiction `json:"jurisdictions,omitempty"`
+}
+
+var allergenRegistry = []AllergenDefinition{
+	{ID: AllergenDairy, Name: "Milk", Synonyms: []string{"milk", "lactose", "dairy products"},
+		Jurisdictions: []Jurisdiction{JurisdictionUS, JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenEgg, Name: "Egg", Synonyms: []string{"eggs"},
+		Jurisdictions: []Jurisdiction{JurisdictionUS, JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenFish, Name: "Fish", Synonyms: []string{"finfish"},
+		Jurisdictions: []Jurisdiction{JurisdictionUS, JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenPeanut, Name: "Peanut", Synonyms: []string{"peanuts", "groundnut", "groundnuts"},
+		Jurisdictions: []Jurisdiction{JurisdictionUS, JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenShellfish, Name: "Shellfish"},
+	{ID: AllergenCrustacean, Name: "Crustaceans", Parent: AllergenShellfish, Synonyms: []string{"crustaceans", "crustacea", "crustacean shellfish"},
+		Jurisdictions: []Jurisdiction{JurisdictionUS, JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenMollusc, Name: "Molluscs", Parent: AllergenShellfish, Synonyms: []string{"molluscs", "mollusk", "mollusks"},
+		Jurisdictions: []Jurisdiction{JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenSoy, Name: "Soy", Synonyms: []string{"soya", "soybean", "soybeans"},
+		Jurisdictions: []Jurisdiction{JurisdictionUS, JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenTreeNut, Name: "Tree nuts", Synonyms: []string{"tree_nuts"},
+		Jurisdictions: []Jurisdiction{JurisdictionUS, JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenGluten, Name: "Cereals containing gluten", Synonyms: []string{"gluten_cereals", "cereals_containing_gluten"},
+		Jurisdictions: []Jurisdiction{JurisdictionEU, JurisdictionAUNZ}},
+	{ID: AllergenWheat, Name: "Wheat", Parent: AllergenGluten, Synonyms: []string{"triticale", "spelt"},
+		Jurisdictions: []Jurisdiction{JurisdictionUS, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenBarley, Name: "Barley", Parent: AllergenGluten},
+	{ID: AllergenRye, Name: "Rye", Parent: AllergenGluten},
+	{ID: AllergenOats, Name: "Oats", Parent: AllergenGluten, Synonyms: []string{"oat"}},
+	{ID: AllergenSesame, Name: "Sesame", Synonyms: []string{"sesame_seeds"},
+		Jurisdictions: []Jurisdiction{JurisdictionUS, JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+	{ID: AllergenMustard, Name: "Mustard",
+		Jurisdictions: []Jurisdiction{JurisdictionEU, JurisdictionCA}},
+	{ID: AllergenCelery, Name: "Celery", Synonyms: []string{"celeriac"},
+		Jurisdictions: []Jurisdiction{JurisdictionEU}},
+	{ID: AllergenLupin, Name: "Lupin", Synonyms: []string{"lupine"},
+		Jurisdictions: []Jurisdiction{JurisdictionEU, JurisdictionAUNZ}},
+	{ID: AllergenSulphites, Name: "Sulphites", Synonyms: []string{"sulfites", "sulphur_dioxide", "sulfur_dioxide"},
+		Jurisdictions: []Jurisdiction{JurisdictionEU, JurisdictionAUNZ, JurisdictionCA}},
+}
+
+// ambiguousAllergenTerms name more than one allergen. "Nuts" on a menu or in a
+// guest's profile may mean peanuts as well as tree nuts, so it resolves to both.
+var ambiguousAllergenTerms = map[string][]Allergen{
+	"nut":  {AllergenPeanut, AllergenTreeNut},
+	"nuts": {AllergenPeanut, AllergenTreeNut},
+}
+
+var (
+	allergensByID   = map[Allergen]AllergenDefinition{}
+	allergenAliases = map[string]Allergen{}
+)
+
+func init() {
+	for _, definition := range allergenRegistry {
+		allergensByID[definition.ID] = definition
+		allergenAliases[allergenKey(string(definition.ID))] = definition.ID
+		for _, synonym := range definition.Synonyms {
+			allergenAliases[allergenKey(synonym)] = definition.ID
+		}
+	}
+}
+
+// allergenKey folds case, spaces and hyphens so "Tree nuts" and "tree-nuts"
+// resolve like "tree_nuts".
+func allergenKey(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	return strings.Join(strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '-' || r == '_' }), "_")
+}
+
+// AllergenDefinitions returns the registry, optionally restricted to the
+// allergens a jurisdiction lists by name (pass "" for all), so the EU list
+// holds exactly its 14 entries.
+func AllergenDefinitions(jurisdiction Jurisdiction) []AllergenDefinition {
+	definitions := make([]AllergenDefinition, 0, len(allergenRegistry))
+	for _, definition := range allergenRegistry {
+		if jurisdiction == "" || definition.listedIn(jurisdiction) {
+			definitions = append(definitions, definition)
+		}
+	}
+	return definitions
+}
+
+// LookupAllergen returns the registry entry of a canonical allergen ID.
+func LookupAllergen(id Allergen) (AllergenDefinition, bool) {
+	definition, ok := allergensByID[id]
+	return definition, ok
+}
+
+// ParseAllergen resolves a canonical ID or synonym to its canonical ID.
+func ParseAllergen(value string) (Allergen, bool) {
+	id, ok := allergenAliases[allergenKey(value)]
+	return id, ok
+}
+
+// ParseAllergens resolves a canonical ID, a synonym or an ambiguous term such
+// as "nuts" to every canonical ID it may mean.
+func ParseAllergens(value string) ([]Allergen, bool) {
+	if ids, ok := ambiguousAllergenTerms[allergenKey(value)]; ok {
+		return slices.Clone(ids), true
+	}
+	if id, ok := ParseAllergen(value); ok {
+		return []Allergen{id}, true
+	}
+	return nil, false
+}
+
+// NormalizeAllergens canonicalizes and de-duplicates values, preserving order,
+// and returns the values that are not in the registry separately.
+func NormalizeAllergens(values []Allergen) ([]Allergen, []string) {
+	var (
+		normalized []Allergen
+		unknown    []string
+	)
+	seen := make(map[Allergen]struct{}, len(values))
+	for _, value := range values {
+		ids, ok := ParseAllergens(string(value))
+		if !ok {
+			unknown = append(unknown, string(value))
+			continue
+		}
+		for _, id := range ids {
+			if _, dup := seen[id]; dup {
+				continue
+			}
+			seen[id] = struct{}{}
+			normalized = append(normalized, id)
+		}
+	}
+	return normalized, unknown
+}
+
+// ParseJurisdiction resolves a jurisdiction name; "" yields DefaultJurisdiction.
+func ParseJurisdiction(value string) (Jurisdiction, bool) {
+	key := allergenKey(value)
+	if key == "" {
+		return DefaultJurisdiction, true
+	}
+	for _, jurisdiction := range Jurisdictions {
+		if string(jurisdiction) == key {
+			return jurisdiction, true
+		}
+	}
+	return "", false
+}
+
+// RegulatedIn reports whether the allergen, or a group it belongs to, must be
+// declared in jurisdiction; wheat is regulated in the EU as a gluten cereal.
+func (d AllergenDefinition) RegulatedIn(jurisdiction Jurisdiction) bool {
+	for current, ok := d, true; ok; current, ok = allergensByID[current.Parent] {
+		if current.listedIn(jurisdiction) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d AllergenDefinition) listedIn(jurisdiction Jurisdiction) bool {
+	for _, j := range d.Jurisdictions {
+		if j == jurisdiction {
+			return true
+		}
+	}
+	return false
+}
+
+// IsKindOf reports whether a is group or one of its descendants.
+func (a Allergen) IsKindOf(group Allergen) bool {
+	for current := a; current != ""; current = allergensByID[current].Parent {
+		if current == group {
+			return true
+		}
+	}
+	return false
+}
+
+// RelatedAllergens returns the allergens that conflict with a: a itself, its
+// ancestors (a dish declaring "shellfish" may hold crustaceans) and its
+// descendants (a guest avoiding "shellfish" avoids molluscs too). The result
+// is sorted.
+func RelatedAllergens(a Allergen) []Allergen {
+	related := []Allergen{a}
+	for parent := allergensByID[a].Parent; parent != ""; parent = allergensByID[parent].Parent {
+		related = append(related, parent)
+	}
+	for _, definition := range allergenRegistry {
+		if definition.ID != a && definition.ID.IsKindOf(a) {
+			related = append(related, definition.ID)
+		}
+	}
+	sort.Slice(related, func(i, j int) bool { return related[i] < related[j] })
+	return related
+}
+
+// AllergySeverity grades how strongly a guest reacts to an allergen.
+type AllergySeverity string
+
+const (
+	SeverityAnaphylaxis AllergySeverity = "anaphylaxis"
+	SeverityAllergy     AllergySeverity = "allergy"
+	SeverityIntolerance AllergySeverity = "intolerance"
+	SeverityPreference  AllergySeverity = "preference"
+)
+
+// AllergySeverities lists severities from most to least severe.
+var AllergySeverities = []AllergySeverity{SeverityAnaphylaxis, SeverityAllergy, SeverityIntolerance, SeverityPreference}
+
+// ParseAllergySeverity resolves a severity name. An empty value is treated as
+// anaphylaxis so an incomplete profile fails closed.
+func ParseAllergySeverity(value string) (AllergySeverity, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return SeverityAnaphylaxis, true
+	}
+	for _, severity := range AllergySeverities {
+		if string(severity) == value {
+			return severity, true
+		}
+	}
+	return "", false
+}
+
+// MoreSevereThan reports whether s ranks above other.
+func (s AllergySeverity) MoreSevereThan(other AllergySeverity) bool {
+	return severityRank(s) < severityRank(other)
+}
+
+func severityRank(severity AllergySeverity) int {
+	for i, candidate := range AllergySeverities {
+		if candidate == severity {
+			return i
+		}
+	}
+	return len(AllergySeverities)
+}
+
+// Allergy is one entry of a guest's allergy profile.
+type Allergy struct {
+	Allergen Allergen        `json:"allergen"`
+	Severity AllergySeverity `json:"severity"`
+}
+
+// MergeAllergies combines allergy lists, keeping the most severe entry per
+// allergen in first-seen order.
+func MergeAllergies(lists ...[]Allergy) []Allergy {
+	var merged []Allergy
+	index := map[Allergen]int{}
+	for _, list := range lists {
+		for _, allergy := range list {
+			if i, ok := index[allergy.Allergen]; ok {
+				if allergy.Severity.MoreSevereThan(merged[i].Severity) {
+					merged[i].Severity = allergy.Severity
+				}
+				continue
+			}
+			index[allergy.Allergen] = len(merged)
+			merged = append(merged, allergy)
+		}
+	}
+	return merged
+}
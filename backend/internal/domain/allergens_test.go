@@ -0,0 +1,61 @@
+package domain
+
+import "testing"
+
+func TestAllergenDefinitionsMatchJurisdictionLists(t *testing.T) {
+	t.Parallel()
+	for jurisdiction, want := range map[Jurisdiction]int{JurisdictionUS: 9, JurisdictionEU: 14, JurisdictionCA: 12} {
+		if got := len(AllergenDefinitions(jurisdiction)); got != want {
+			t.Fatalf("expected %d allergens for %s, got %d", want, jurisdiction, got)
+		}
+	}
+	wheat, _ := LookupAllergen(AllergenWheat)
+	if !wheat.RegulatedIn(JurisdictionEU) {
+		t.Fatal("expected wheat to be regulated in the EU as a gluten cereal")
+	}
+}
+
+func TestNormalizeAllergensResolvesSynonymsAndReportsUnknown(t *testing.T) {
+	t.Parallel()
+	got, unknown := NormalizeAllergens([]Allergen{"Milk", "sulfites", "Tree nuts", "dairy", "kiwi"})
+	want := []Allergen{AllergenDairy, AllergenSulphites, AllergenTreeNut}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+	if len(unknown) != 1 || unknown[0] != "kiwi" {
+		t.Fatalf("expected kiwi to be unknown, got %v", unknown)
+	}
+}
+
+func TestAmbiguousNutsResolveToPeanutAndTreeNuts(t *testing.T) {
+	t.Parallel()
+	got, unknown := NormalizeAllergens([]Allergen{"Nuts", "tree nuts", "nut"})
+	if len(unknown) != 0 || len(got) != 2 || got[0] != AllergenPeanut || got[1] != AllergenTreeNut {
+		t.Fatalf("expected peanut and tree nuts, got %v (unknown %v)", got, unknown)
+	}
+	if _, ok := ParseAllergen("nuts"); ok {
+		t.Fatal("expected nuts not to resolve to a single allergen")
+	}
+}
+
+func TestRelatedAllergensFollowParentsAndChildren(t *testing.T) {
+	t.Parallel()
+	related := RelatedAllergens(AllergenShellfish)
+	want := []Allergen{AllergenCrustacean, AllergenMollusc, AllergenShellfish}
+	if len(related) != len(want) {
+		t.Fatalf("expected %v, got %v", want, related)
+	}
+	for i := range want {
+		if related[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, related)
+		}
+	}
+	if got := RelatedAllergens(AllergenRye); len(got) != 2 || got[0] != AllergenGluten || got[1] != AllergenRye {
+		t.Fatalf("expected rye to relate to gluten only, got %v", got)
+	}
+}
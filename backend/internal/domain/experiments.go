@@ -0,0 +1,87 @@
+package domain
+
+import "time"
+
+// ExperimentStatus tells whether an experiment still takes new sessions.
+type ExperimentStatus string
+
+const (
+	ExperimentRunning ExperimentStatus = "running"
+	ExperimentStopped ExperimentStatus = "stopped"
+)
+
+// Experiment splits new sessions between variants so their outcomes can be
+// compared. RestaurantID limits it to one restaurant; empty covers them all.
+// Sessions are assigned when they start and keep their variant after the
+// experiment stops.
+type Experiment struct {
+	ID           string              `json:"id"`
+	Name         string              `json:"name"`
+	RestaurantID string              `json:"restaurantId,omitempty"`
+	Status       ExperimentStatus    `json:"status"`
+	Variants     []ExperimentVariant `json:"variants"`
+	CreatedAt    time.Time           `json:"createdAt"`
+	StoppedAt    *time.Time          `json:"stoppedAt,omitempty"`
+}
+
+// ExperimentVariant is one arm of an experiment. Weight is its share of
+// sessions relative to the other variants. Empty settings keep the service
+// defaults, so a variant without any is the control.
+type ExperimentVariant struct {
+	Name          string         `json:"name"`
+	Weight        int            `json:"weight"`
+	PromptVersion string         `json:"promptVersion,omitempty"`
+	RetrievalK    int            `json:"retrievalK,omitempty"`
+	Model         string         `json:"model,omitempty"`
+	Ranking       RankingWeights `json:"ranking,omitzero"`
+}
+
+// RankingWeights tune how safe dishes are ranked for the model. Preference
+// scales the guest's preference score and Embedding the embedding similarity
+// of the hybrid retriever; nil keeps the default weight.
+type RankingWeights struct {
+	Preference *float64 `json:"preference,omitempty"`
+	Embedding  *float64 `json:"embedding,omitempty"`
+}
+
+// ExperimentAssignment is the variant a session was bucketed into. It keeps a
+// copy of the variant, so the session's turns do not depend on the experiment
+// record.
+type ExperimentAssignment struct {
+	ExperimentID string            `json:"experimentId"`
+	Variant      ExperimentVariant `json:"variant"`
+}
+
+// ExperimentOutcome is something a session in an experiment did.
+type ExperimentOutcome string
+
+const (
+	OutcomeSessionStarted ExperimentOutcome = "session_started"
+	OutcomeItemAdded      ExperimentOutcome = "item_added"
+	// OutcomeOrderConfirmed counts a session's first order confirmation.
+	OutcomeOrderConfirmed ExperimentOutcome = "order_confirmed"
+	// OutcomeSessionAbandoned counts sessions explicitly ended without a
+	// confirmed order. Sessions left open are not counted.
+	OutcomeSessionAbandoned ExperimentOutcome = "session_abandoned"
+)
+
+// OutcomeCounts counts the outcomes of one variant.
+type OutcomeCounts map[ExperimentOutcome]int
+
+// ExperimentResults aggregates an experiment's outcomes per variant.
+type ExperimentResults struct {
+	ExperimentID string           `json:"experimentId"`
+	Status       ExperimentStatus `json:"status"`
+	Variants     []VariantResults `json:"variants"`
+}
+
+// VariantResults are the outcomes of one variant. ConversionRate is the share
+// of its sessions that confirmed an order.
+type VariantResults struct {
+	Variant           string  `json:"variant"`
+	Sessions          int     `json:"sessions"`
+	ItemsAdded        int     `json:"itemsAdded"`
+	OrdersConfirmed   int     `json:"ordersConfirmed"`
+	SessionsAbandoned int     `json:"sessionsAbandoned"`
+	ConversionRate    float64 `json:"conversionRate"`
+}
@@ -1,30 +1,51 @@
 package domain
 
-import "time"
-
-// Allergen captures allergens that can trigger severe reactions.
-type Allergen string
-
-const (
-	AllergenDairy     Allergen = "dairy"
-	AllergenEgg       Allergen = "egg"
-	AllergenFish      Allergen = "fish"
-	AllergenPeanut    Allergen = "peanut"
-	AllergenShellfish Allergen = "shellfish"
-	AllergenSoy       Allergen = "soy"
-	AllergenTreeNut   Allergen = "tree_nut"
-	AllergenWheat     Allergen = "wheat"
+import (
+	"encoding/json"
+	"time"
 )
 
-// MenuItem represents a single dish.
+// Ingredient is an entry of a restaurant's ingredient catalog. MayContain lists
+// cross-contact allergens reported by the supplier; SubIngredientIDs point to
+// other catalog entries the ingredient is made from.
+type Ingredient struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	Allergens        []Allergen `json:"allergens,omitempty"`
+	MayContain       []Allergen `json:"mayContain,omitempty"`
+	SubIngredientIDs []string   `json:"subIngredientIds,omitempty"`
+	SupplierNotes    string     `json:"supplierNotes,omitempty"`
+}
+
+// MenuItem represents a single dish. Allergens and CrossContaminationRisk are
+// declared by the restaurant; the Derived fields are computed from IngredientIDs
+// against the restaurant's ingredient catalog. Stations names the kitchen
+// stations (e.g. "fryer") the dish is prepared at, for safety policy rules.
 type MenuItem struct {
-	ID                     string     `json:"id"`
-	Name                   string     `json:"name"`
-	Description            string     `json:"description"`
-	Allergens              []Allergen `json:"allergens"`
-	CrossContaminationRisk []Allergen `json:"crossContaminationRisk,omitempty"`
-	Tags                   []string   `json:"tags,omitempty"`
-	ImageURL               string     `json:"imageUrl,omitempty"`
+	ID                            string     `json:"id"`
+	Name                          string     `json:"name"`
+	Description                   string     `json:"description"`
+	IngredientIDs                 []string   `json:"ingredientIds,omitempty"`
+	Allergens                     []Allergen `json:"allergens"`
+	CrossContaminationRisk        []Allergen `json:"crossContaminationRisk,omitempty"`
+	DerivedAllergens              []Allergen `json:"derivedAllergens,omitempty"`
+	DerivedCrossContaminationRisk []Allergen `json:"derivedCrossContaminationRisk,omitempty"`
+	Tags                          []string   `json:"tags,omitempty"`
+	Stations                      []string   `json:"stations,omitempty"`
+	ImageURL                      string     `json:"imageUrl,omitempty"`
+}
+
+// AllergenMismatch reports a menu item whose declared allergens disagree with
+// its ingredients or with allergens mentioned in its name or description.
+type AllergenMismatch struct {
+	ItemID string `json:"itemId"`
+	Name   string `json:"name"`
+	// Undeclared allergens come from ingredients but are missing from the declaration.
+	Undeclared []Allergen `json:"undeclared,omitempty"`
+	// Unsupported allergens are declared but no ingredient contains them.
+	Unsupported []Allergen `json:"unsupported,omitempty"`
+	// Mentioned allergens appear in the item text without being declared or derived.
+	Mentioned []Allergen `json:"mentioned,omitempty"`
 }
 
 // Combo defines a curated pairing of menu items.
@@ -35,12 +56,51 @@ type Combo struct {
 	Description string   `json:"description,omitempty"`
 }
 
-// Restaurant collects a restaurant menu and combo metadata.
+// Restaurant collects a restaurant menu, its ingredient catalog and combo
+// metadata. Jurisdiction selects the allergen list the restaurant must declare,
+// and Concierge tunes the assistant for its guests.
 type Restaurant struct {
-	ID        string     `json:"id"`
-	Name      string     `json:"name"`
-	MenuItems []MenuItem `json:"menuItems"`
-	Combos    []Combo    `json:"combos"`
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Jurisdiction Jurisdiction      `json:"jurisdiction,omitempty"`
+	Ingredients  []Ingredient      `json:"ingredients,omitempty"`
+	MenuItems    []MenuItem        `json:"menuItems"`
+	Combos       []Combo           `json:"combos"`
+	Concierge    ConciergeSettings `json:"concierge,omitzero"`
+}
+
+// ConciergeSettings are a restaurant's overrides of the assistant defaults.
+// MaxOutputTokens caps each model reply; zero keeps the service default.
+// PromptVersion picks the system instructions, Tone and Language replace their
+// defaults and HouseRules are added to them. None of them can override the
+// safety rules the assistant always starts from.
+type ConciergeSettings struct {
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	PromptVersion   string   `json:"promptVersion,omitempty"`
+	Tone            string   `json:"tone,omitempty"`
+	Language        string   `json:"language,omitempty"`
+	HouseRules      []string `json:"houseRules,omitempty"`
+}
+
+// MenuVersionStatus tells whether a menu version was ever shown to guests.
+type MenuVersionStatus string
+
+const (
+	MenuVersionDraft     MenuVersionStatus = "draft"
+	MenuVersionPublished MenuVersionStatus = "published"
+)
+
+// MenuVersion is an immutable snapshot of a restaurant menu. Drafts are never
+// used for guest answers; exactly one published version is live at a time.
+type MenuVersion struct {
+	RestaurantID string            `json:"restaurantId"`
+	Version      int               `json:"version"`
+	Status       MenuVersionStatus `json:"status"`
+	Live         bool              `json:"live" firestore:"-"`
+	Source       string            `json:"source,omitempty"`
+	MenuItems    []MenuItem        `json:"menuItems"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	PublishedAt  *time.Time        `json:"publishedAt,omitempty"`
 }
 
 // SessionStatus indicates current lifecycle state.
@@ -52,14 +112,262 @@ const (
 	SessionStatusCompleted   SessionStatus = "completed"
 )
 
-// ConciergeSession is the long-lived conversation session.
+// ConciergeSession is the long-lived conversation session. A zero MenuVersion
+// follows whatever menu is published later.
 type ConciergeSession struct {
-	ID               string        `json:"id"`
-	RestaurantID     string        `json:"restaurantId"`
-	HardAllergens    []Allergen    `json:"hardAllergens"`
-	PreferenceTags   []string      `json:"preferenceTags"`
-	Status           SessionStatus `json:"status"`
-	LastAssistantMsg string        `json:"lastAssistantMessage,omitempty"`
-	CreatedAt        time.Time     `json:"createdAt"`
-	UpdatedAt        time.Time     `json:"updatedAt"`
+	ID               string                `json:"id"`
+	RestaurantID     string                `json:"restaurantId"`
+	HardAllergens    []Allergen            `json:"hardAllergens"`
+	Allergies        []Allergy             `json:"allergies,omitempty"`
+	PreferenceTags   []string              `json:"preferenceTags"`
+	PendingRemovals  []ProfileChange       `json:"pendingRemovals,omitempty"`
+	Status           SessionStatus         `json:"status"`
+	MenuVersion      int                   `json:"menuVersion,omitempty"`
+	Order            []OrderLine           `json:"order,omitempty"`
+	OrderConfirmedAt *time.Time            `json:"orderConfirmedAt,omitempty"`
+	TokenUsage       TokenUsage            `json:"tokenUsage"`
+	Experiment       *ExperimentAssignment `json:"experiment,omitempty"`
+	LastPrompt       string                `json:"lastPrompt,omitempty"`
+	LastAssistantMsg string                `json:"lastAssistantMessage,omitempty"`
+	CreatedAt        time.Time             `json:"createdAt"`
+	UpdatedAt        time.Time             `json:"updatedAt"`
+}
+
+// OrderLine is one dish the guest asked the concierge to add to their order.
+type OrderLine struct {
+	ItemID   string `json:"itemId"`
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+	Notes    string `json:"notes,omitempty"`
+}
+
+// AllergyProfile returns the session's allergies. Sessions saved before
+// severities existed only carry HardAllergens, which count as anaphylaxis.
+func (s ConciergeSession) AllergyProfile() []Allergy {
+	hard := make([]Allergy, 0, len(s.HardAllergens))
+	for _, allergen := range s.HardAllergens {
+		hard = append(hard, Allergy{Allergen: allergen, Severity: SeverityAnaphylaxis})
+	}
+	return MergeAllergies(s.Allergies, hard)
+}
+
+// SafetyVerdict is the outcome of checking one dish against a guest's profile.
+type SafetyVerdict string
+
+const (
+	VerdictSafe    SafetyVerdict = "safe"
+	VerdictCaution SafetyVerdict = "caution"
+	// VerdictUnknown marks dishes whose data cannot rule out an allergen, e.g.
+	// the description mentions it but the restaurant does not declare it.
+	VerdictUnknown SafetyVerdict = "unknown"
+	VerdictUnsafe  SafetyVerdict = "unsafe"
+)
+
+// SafetyExposure tells how a trigger matched a dish.
+type SafetyExposure string
+
+const (
+	ExposureDirect       SafetyExposure = "direct"
+	ExposureCrossContact SafetyExposure = "cross_contact"
+	ExposureMentioned    SafetyExposure = "mentioned"
+	ExposureDietary      SafetyExposure = "dietary"
+)
+
+// SafetyTrigger is one reason behind a verdict: an allergen from the guest's
+// profile, or a dietary tag rule the dish breaks. Rule is the ID of the safety
+// policy rule that decided the outcome; Station is set when cross-contact at a
+// kitchen station was escalated to direct contact.
+type SafetyTrigger struct {
+	Allergen Allergen        `json:"allergen,omitempty"`
+	Tag      string          `json:"tag,omitempty"`
+	Exposure SafetyExposure  `json:"exposure"`
+	Severity AllergySeverity `json:"severity,omitempty"`
+	Verdict  SafetyVerdict   `json:"verdict"`
+	Rule     string          `json:"rule,omitempty"`
+	Station  string          `json:"station,omitempty"`
+}
+
+// ItemSafety is the safety verdict for one menu item. Deprioritized items are
+// still offered but listed after the others.
+type ItemSafety struct {
+	ItemID        string          `json:"itemId"`
+	Name          string          `json:"name"`
+	Verdict       SafetyVerdict   `json:"verdict"`
+	Triggers      []SafetyTrigger `json:"triggers,omitempty"`
+	Explanation   string          `json:"explanation"`
+	Deprioritized bool            `json:"deprioritized,omitempty"`
+}
+
+// AssistantReply is the outcome of one guest message: the reply text, which
+// ends with the safety note when there is one, and the verdict of every dish
+// on the session menu.
+type AssistantReply struct {
+	Text       string       `json:"reply"`
+	SafetyNote string       `json:"safetyNote,omitempty"`
+	Safety     []ItemSafety `json:"safety"`
+	// ProfileChanges lists the allergies and dietary needs the message added to
+	// or removed from the session profile; the reply text opens by echoing them.
+	ProfileChanges []ProfileChange `json:"profileChanges,omitempty"`
+	// PendingRemovals lists retractions the message stated that only apply once
+	// the guest confirms them; the profile echo asks for that confirmation.
+	PendingRemovals []ProfileChange `json:"pendingRemovals,omitempty"`
+	// Interventions lists the parts of the model reply the reply guard removed.
+	Interventions []SafetyIntervention `json:"interventions,omitempty"`
+	// ToolCalls lists the tools the model called while answering, in order.
+	ToolCalls []ToolInvocation `json:"toolCalls,omitempty"`
+	// Degraded marks a reply built from the safety policy alone because no
+	// model could answer.
+	Degraded bool `json:"degraded,omitempty"`
+	// PolicyVersion is the safety policy version behind Safety; zero is the
+	// built-in policy.
+	PolicyVersion int `json:"policyVersion,omitempty"`
+}
+
+// ToolInvocation records one tool the model called: its JSON arguments and
+// either its JSON result or the error returned to the model.
+type ToolInvocation struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// InterventionAction says how the reply guard changed a model reply.
+type InterventionAction string
+
+const (
+	// InterventionRemoved drops one sentence from the reply.
+	InterventionRemoved InterventionAction = "removed"
+	// InterventionReplaced swaps the whole reply for the high-risk disclaimer
+	// because nothing safe was left.
+	InterventionReplaced InterventionAction = "replaced"
+)
+
+// InterventionReason says why the reply guard stepped in.
+type InterventionReason string
+
+const (
+	// InterventionExcluded marks a dish the safety policy held back for this guest.
+	InterventionExcluded InterventionReason = "excluded"
+	// InterventionUnknown marks a recommended dish that is not on the menu.
+	InterventionUnknown InterventionReason = "unknown"
+	// InterventionUnrepairable marks a reply with no sentence left to keep.
+	InterventionUnrepairable InterventionReason = "unrepairable"
+)
+
+// SafetyIntervention is one change the reply guard made to a model reply
+// before the guest saw it. Item is the menu item name, or the unmatched phrase
+// for unknown dishes, and Sentence the text that was removed.
+type SafetyIntervention struct {
+	Action   InterventionAction `json:"action"`
+	Reason   InterventionReason `json:"reason"`
+	ItemID   string             `json:"itemId,omitempty"`
+	Item     string             `json:"item,omitempty"`
+	Sentence string             `json:"sentence,omitempty"`
+}
+
+// ProfileChangeAction says whether a guest declared or retracted an allergy or
+// dietary need.
+type ProfileChangeAction string
+
+const (
+	ProfileChangeAdd    ProfileChangeAction = "add"
+	ProfileChangeRemove ProfileChangeAction = "remove"
+)
+
+// ProfileChange is one allergy or dietary tag stated in chat. Exactly one of
+// Allergen and Tag is set. Source names the extractor that found it and Phrase
+// the sentence it came from.
+type ProfileChange struct {
+	Action   ProfileChangeAction `json:"action"`
+	Allergen Allergen            `json:"allergen,omitempty"`
+	Severity AllergySeverity     `json:"severity,omitempty"`
+	Tag      string              `json:"tag,omitempty"`
+	Source   string              `json:"source,omitempty"`
+	Phrase   string              `json:"phrase,omitempty"`
+}
+
+// TurnRole identifies who produced a conversation turn.
+type TurnRole string
+
+const (
+	TurnRoleUser      TurnRole = "user"
+	TurnRoleAssistant TurnRole = "assistant"
+)
+
+// ConversationTurn is one entry of a session transcript, kept for audit.
+// PolicyVersion zero is the built-in safety policy.
+type ConversationTurn struct {
+	Role           TurnRole             `json:"role"`
+	Text           string               `json:"text"`
+	SafetyNote     string               `json:"safetyNote,omitempty"`
+	Interrupted    bool                 `json:"interrupted,omitempty"`
+	Degraded       bool                 `json:"degraded,omitempty"`
+	ProfileChanges []ProfileChange      `json:"profileChanges,omitempty"`
+	Interventions  []SafetyIntervention `json:"interventions,omitempty"`
+	ToolCalls      []ToolInvocation     `json:"toolCalls,omitempty"`
+	TokenUsage     TokenUsage           `json:"tokenUsage,omitzero"`
+	PromptVersion  string               `json:"promptVersion,omitempty"`
+	PolicyVersion  int                  `json:"policyVersion,omitempty"`
+	CreatedAt      time.Time            `json:"createdAt"`
+}
+
+// TokenUsage counts the model calls of a turn or session and their estimated
+// input and output tokens.
+type TokenUsage struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
+	ModelCalls   int `json:"modelCalls"`
+}
+
+// Total returns the input and output tokens together.
+func (u TokenUsage) Total() int {
+	return u.InputTokens + u.OutputTokens
+}
+
+// Add returns the sum of u and other.
+func (u TokenUsage) Add(other TokenUsage) TokenUsage {
+	return TokenUsage{
+		InputTokens:  u.InputTokens + other.InputTokens,
+		OutputTokens: u.OutputTokens + other.OutputTokens,
+		ModelCalls:   u.ModelCalls + other.ModelCalls,
+	}
+}
+
+// SessionEventType names a change published on the session event bus.
+type SessionEventType string
+
+const (
+	SessionEventStarted     SessionEventType = "session_started"
+	SessionEventMessage     SessionEventType = "message"
+	SessionEventInterrupted SessionEventType = "interrupted"
+	SessionEventEnded       SessionEventType = "session_ended"
+	SessionEventMenuUpdated SessionEventType = "menu_updated"
+	// SessionEventProfileUpdated fires when a message changes the session's
+	// allergies or dietary tags.
+	SessionEventProfileUpdated SessionEventType = "profile_updated"
+	// SessionEventSafetyIntervention fires when the reply guard removes or
+	// replaces part of a model reply.
+	SessionEventSafetyIntervention SessionEventType = "safety_intervention"
+	// SessionEventItemAdded fires when a dish is added to the session's order.
+	SessionEventItemAdded SessionEventType = "item_added"
+	// SessionEventOrderConfirmed fires when the guest confirms their order.
+	SessionEventOrderConfirmed SessionEventType = "order_confirmed"
+)
+
+// SessionEvent is a change to a session, or to the menu of its restaurant when
+// SessionID is empty. ID is assigned by the bus and increases monotonically.
+type SessionEvent struct {
+	ID           uint64            `json:"id"`
+	Type         SessionEventType  `json:"type"`
+	SessionID    string            `json:"sessionId,omitempty"`
+	RestaurantID string            `json:"restaurantId,omitempty"`
+	Session      *ConciergeSession `json:"session,omitempty"`
+	Prompt       string            `json:"prompt,omitempty"`
+	Reply        string            `json:"reply,omitempty"`
+	MenuItems    []MenuItem        `json:"menuItems,omitempty"`
+	MenuVersion  int               `json:"menuVersion,omitempty"`
+	// Interventions is set on safety_intervention events.
+	Interventions []SafetyIntervention `json:"interventions,omitempty"`
+	CreatedAt     time.Time            `json:"createdAt"`
 }
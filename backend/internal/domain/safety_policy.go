@@ -0,0 +1,90 @@
+package domain
+
+import "time"
+
+// PolicyRuleType selects what a safety policy rule does.
+type PolicyRuleType string
+
+const (
+	// PolicyRuleSeverityAction sets the action for guests with Severity when an
+	// allergen reaches a dish through Exposure.
+	PolicyRuleSeverityAction PolicyRuleType = "severity_action"
+	// PolicyRuleRequireTag makes dishes without ItemTags unsafe for guests who
+	// ask for GuestTag. ItemTags defaults to GuestTag.
+	PolicyRuleRequireTag PolicyRuleType = "require_tag"
+	// PolicyRulePreferTag only ranks dishes carrying GuestTag first.
+	PolicyRulePreferTag PolicyRuleType = "prefer_tag"
+	// PolicyRuleExcludeAllergens makes dishes containing Allergens unsafe for
+	// guests who ask for GuestTag, e.g. vegan excludes dairy and egg.
+	PolicyRuleExcludeAllergens PolicyRuleType = "exclude_allergens"
+	// PolicyRuleStationExposure treats cross-contact on dishes prepared at
+	// Station as direct contact, for guests with Severity or for everyone.
+	PolicyRuleStationExposure PolicyRuleType = "station_exposure"
+)
+
+// PolicyAction is what a severity rule does with a matching dish.
+type PolicyAction string
+
+const (
+	PolicyActionExclude      PolicyAction = "exclude"
+	PolicyActionHold         PolicyAction = "hold"
+	PolicyActionWarn         PolicyAction = "warn"
+	PolicyActionDeprioritize PolicyAction = "deprioritize"
+	PolicyActionIgnore       PolicyAction = "ignore"
+)
+
+// TagMode says how guest dietary tags without a tag rule are treated.
+type TagMode string
+
+const (
+	TagModeRequire TagMode = "require"
+	TagModePrefer  TagMode = "prefer"
+)
+
+// PolicyRule is one declarative safety rule. Which fields apply depends on Type.
+type PolicyRule struct {
+	ID          string          `json:"id"`
+	Type        PolicyRuleType  `json:"type"`
+	Description string          `json:"description,omitempty"`
+	GuestTag    string          `json:"guestTag,omitempty"`
+	ItemTags    []string        `json:"itemTags,omitempty"`
+	Allergens   []Allergen      `json:"allergens,omitempty"`
+	Severity    AllergySeverity `json:"severity,omitempty"`
+	Exposure    SafetyExposure  `json:"exposure,omitempty"`
+	Action      PolicyAction    `json:"action,omitempty"`
+	Station     string          `json:"station,omitempty"`
+}
+
+// SafetyPolicy is a versioned set of rules for one restaurant. Rules override
+// the built-in defaults; anything they do not mention keeps the default
+// behavior. Version 0 is the built-in policy of restaurants that never saved one.
+type SafetyPolicy struct {
+	RestaurantID  string       `json:"restaurantId"`
+	Version       int          `json:"version"`
+	UnmatchedTags TagMode      `json:"unmatchedTags,omitempty"`
+	Rules         []PolicyRule `json:"rules"`
+	CreatedAt     time.Time    `json:"createdAt,omitempty"`
+}
+
+// PolicyProfile is a guest profile a policy dry run evaluates.
+type PolicyProfile struct {
+	Allergies      []Allergy `json:"allergies,omitempty"`
+	PreferenceTags []string  `json:"preferenceTags,omitempty"`
+}
+
+// PolicyImpact is a dish whose verdict for a profile changes between two policies.
+type PolicyImpact struct {
+	Profile PolicyProfile `json:"profile"`
+	Before  ItemSafety    `json:"before"`
+	After   ItemSafety    `json:"after"`
+}
+
+// PolicyDryRun reports how a candidate policy would change verdicts on a
+// restaurant's live menu compared with the policy in force.
+type PolicyDryRun struct {
+	RestaurantID    string         `json:"restaurantId"`
+	BaseVersion     int            `json:"baseVersion"`
+	MenuVersion     int            `json:"menuVersion"`
+	ProfilesChecked int            `json:"profilesChecked"`
+	Impacts         []PolicyImpact `json:"impacts"`
+}
@@ -0,0 +1,170 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+var (
+	// ErrExperimentExists is returned when creating an experiment whose ID is taken.
+	ErrExperimentExists = errors.New("experiment already exists")
+	// ErrExperimentNotFound is returned for an experiment ID that was never saved.
+	ErrExperimentNotFound = errors.New("experiment not found")
+	// ErrExperimentRunning matches the ExperimentRunningError returned when
+	// creating a running experiment for a scope that already has one.
+	ErrExperimentRunning = errors.New("an experiment is already running for this scope")
+)
+
+// ExperimentRunningError names the experiment already running for the scope
+// of one being created. A scope is a restaurant, or every restaurant for
+// experiments without one.
+type ExperimentRunningError struct {
+	ExperimentID string
+}
+
+func (e *ExperimentRunningError) Error() string {
+	return fmt.Sprintf("experiment %q is already running for this scope", e.ExperimentID)
+}
+
+func (e *ExperimentRunningError) Is(target error) bool { return target == ErrExperimentRunning }
+
+// ExperimentStore persists experiments and the outcome counts of their
+// variants. Lookups and updates return ErrExperimentNotFound for unknown IDs.
+type ExperimentStore interface {
+	// CreateExperiment saves a new experiment. A running experiment is refused
+	// with an ExperimentRunningError when its scope already has one running;
+	// the check and the save are atomic.
+	CreateExperiment(ctx context.Context, experiment domain.Experiment) error
+	LoadExperiment(ctx context.Context, experimentID string) (domain.Experiment, error)
+	// ListExperiments returns experiments oldest first.
+	ListExperiments(ctx context.Context) ([]domain.Experiment, error)
+	// UpdateExperiment applies update atomically to the stored experiment and
+	// saves the result unless update returns an error.
+	UpdateExperiment(ctx context.Context, experimentID string, update func(*domain.Experiment) error) (domain.Experiment, error)
+	// RecordOutcome adds one to the count of outcome for variant.
+	RecordOutcome(ctx context.Context, experimentID, variant string, outcome domain.ExperimentOutcome) error
+	// LoadOutcomes returns the outcome counts by variant name; variants
+	// without outcomes are missing.
+	LoadOutcomes(ctx context.Context, experimentID string) (map[string]domain.OutcomeCounts, error)
+	Close() error
+}
+
+// MemoryExperimentStore is local default experiment storage for development and tests.
+type MemoryExperimentStore struct {
+	mu          sync.RWMutex
+	experiments map[string]domain.Experiment
+	outcomes    map[string]map[string]domain.OutcomeCounts
+}
+
+func NewMemoryExperimentStore() *MemoryExperimentStore {
+	return &MemoryExperimentStore{
+		experiments: map[string]domain.Experiment{},
+		outcomes:    map[string]map[string]domain.OutcomeCounts{},
+	}
+}
+
+func (m *MemoryExperimentStore) CreateExperiment(_ context.Context, experiment domain.Experiment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.experiments[experiment.ID]; ok {
+		return ErrExperimentExists
+	}
+	if experiment.Status == domain.ExperimentRunning {
+		for _, other := range m.experiments {
+			if other.Status == domain.ExperimentRunning && other.RestaurantID == experiment.RestaurantID {
+				return &ExperimentRunningError{ExperimentID: other.ID}
+			}
+		}
+	}
+	m.experiments[experiment.ID] = cloneExperiment(experiment)
+	return nil
+}
+
+func (m *MemoryExperimentStore) LoadExperiment(_ context.Context, experimentID string) (domain.Experiment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	experiment, ok := m.experiments[experimentID]
+	if !ok {
+		return domain.Experiment{}, ErrExperimentNotFound
+	}
+	return cloneExperiment(experiment), nil
+}
+
+// ListExperiments returns experiments oldest first, ties ordered by ID.
+func (m *MemoryExperimentStore) ListExperiments(_ context.Context) ([]domain.Experiment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	experiments := make([]domain.Experiment, 0, len(m.experiments))
+	for _, experiment := range m.experiments {
+		experiments = append(experiments, cloneExperiment(experiment))
+	}
+	sort.Slice(experiments, func(i, j int) bool {
+		if !experiments[i].CreatedAt.Equal(experiments[j].CreatedAt) {
+			return experiments[i].CreatedAt.Before(experiments[j].CreatedAt)
+		}
+		return experiments[i].ID < experiments[j].ID
+	})
+	return experiments, nil
+}
+
+func (m *MemoryExperimentStore) UpdateExperiment(_ context.Context, experimentID string, update func(*domain.Experiment) error) (domain.Experiment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.experiments[experimentID]
+	if !ok {
+		return domain.Experiment{}, ErrExperimentNotFound
+	}
+	updated := cloneExperiment(current)
+	if err := update(&updated); err != nil {
+		return domain.Experiment{}, err
+	}
+	updated.ID = experimentID
+	m.experiments[experimentID] = cloneExperiment(updated)
+	return updated, nil
+}
+
+func (m *MemoryExperimentStore) RecordOutcome(_ context.Context, experimentID, variant string, outcome domain.ExperimentOutcome) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.experiments[experimentID]; !ok {
+		return ErrExperimentNotFound
+	}
+	variants, ok := m.outcomes[experimentID]
+	if !ok {
+		variants = map[string]domain.OutcomeCounts{}
+		m.outcomes[experimentID] = variants
+	}
+	if variants[variant] == nil {
+		variants[variant] = domain.OutcomeCounts{}
+	}
+	variants[variant][outcome]++
+	return nil
+}
+
+func (m *MemoryExperimentStore) LoadOutcomes(_ context.Context, experimentID string) (map[string]domain.OutcomeCounts, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.experiments[experimentID]; !ok {
+		return nil, ErrExperimentNotFound
+	}
+	outcomes := make(map[string]domain.OutcomeCounts, len(m.outcomes[experimentID]))
+	for variant, counts := range m.outcomes[experimentID] {
+		outcomes[variant] = maps.Clone(counts)
+	}
+	return outcomes, nil
+}
+
+func (m *MemoryExperimentStore) Close() error { return nil }
+
+// cloneExperiment copies the variants so callers cannot mutate stored state.
+func cloneExperiment(experiment domain.Experiment) domain.Experiment {
+	experiment.Variants = slices.Clone(experiment.Variants)
+	return experiment
+}
@@ -0,0 +1,187 @@
+//go:build gcp
+
+package gcp
+
+import (
+	"context"
+	"errors"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gourmet-guide/backend/internal/domain"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreExperimentStore keeps experiments in the experiments collection and
+// each variant's outcome counts in an outcomes document named after it.
+type FirestoreExperimentStore struct {
+	client *firestore.Client
+}
+
+func NewFirestoreExperimentStore(ctx context.Context, projectID string) (*FirestoreExperimentStore, error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &FirestoreExperimentStore{client: client}, nil
+}
+
+func (s *FirestoreExperimentStore) ref(experimentID string) *firestore.DocumentRef {
+	return s.client.Collection("experiments").Doc(experimentID)
+}
+
+// runningLock is the document in experimentScopes that names the experiment
+// running for a scope. Its deterministic ID lets a transaction claim the scope.
+type runningLock struct {
+	ExperimentID string
+}
+
+func (s *FirestoreExperimentStore) scopeRef(restaurantID string) *firestore.DocumentRef {
+	scope := "all"
+	if restaurantID != "" {
+		scope = "restaurant-" + restaurantID
+	}
+	return s.client.Collection("experimentScopes").Doc(scope)
+}
+
+// CreateExperiment saves experiment and, when it is running, claims its scope
+// in the same transaction, so two instances cannot both start one.
+func (s *FirestoreExperimentStore) CreateExperiment(ctx context.Context, experiment domain.Experiment) error {
+	ref := s.ref(experiment.ID)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		running := experiment.Status == domain.ExperimentRunning
+		scope := s.scopeRef(experiment.RestaurantID)
+		if running {
+			snap, err := tx.Get(scope)
+			if err == nil {
+				var lock runningLock
+				if err := snap.DataTo(&lock); err != nil {
+					return err
+				}
+				return &ExperimentRunningError{ExperimentID: lock.ExperimentID}
+			}
+			if status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+		if err := tx.Create(ref, experiment); err != nil {
+			return err
+		}
+		if running {
+			return tx.Set(scope, runningLock{ExperimentID: experiment.ID})
+		}
+		return nil
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrExperimentExists
+	}
+	if errors.Is(err, ErrExperimentRunning) {
+		return err
+	}
+	return storeError(err, nil)
+}
+
+func (s *FirestoreExperimentStore) LoadExperiment(ctx context.Context, experimentID string) (domain.Experiment, error) {
+	snap, err := s.ref(experimentID).Get(ctx)
+	if err != nil {
+		return domain.Experiment{}, storeError(err, ErrExperimentNotFound)
+	}
+	var experiment domain.Experiment
+	if err := snap.DataTo(&experiment); err != nil {
+		return domain.Experiment{}, err
+	}
+	return experiment, nil
+}
+
+func (s *FirestoreExperimentStore) ListExperiments(ctx context.Context) ([]domain.Experiment, error) {
+	docs, err := s.client.Collection("experiments").OrderBy("CreatedAt", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, storeError(err, nil)
+	}
+	experiments := make([]domain.Experiment, 0, len(docs))
+	for _, doc := range docs {
+		var experiment domain.Experiment
+		if err := doc.DataTo(&experiment); err != nil {
+			return nil, err
+		}
+		experiments = append(experiments, experiment)
+	}
+	return experiments, nil
+}
+
+func (s *FirestoreExperimentStore) UpdateExperiment(ctx context.Context, experimentID string, update func(*domain.Experiment) error) (domain.Experiment, error) {
+	ref := s.ref(experimentID)
+	var updated domain.Experiment
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var experiment domain.Experiment
+		if err := snap.DataTo(&experiment); err != nil {
+			return err
+		}
+		// A stopped experiment releases its scope, if it still holds it.
+		wasRunning := experiment.Status == domain.ExperimentRunning
+		scope := s.scopeRef(experiment.RestaurantID)
+		var lock runningLock
+		if wasRunning {
+			scopeSnap, err := tx.Get(scope)
+			if err == nil {
+				err = scopeSnap.DataTo(&lock)
+			}
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+		}
+		if err := update(&experiment); err != nil {
+			return err
+		}
+		experiment.ID = experimentID
+		updated = experiment
+		if wasRunning && experiment.Status != domain.ExperimentRunning && lock.ExperimentID == experimentID {
+			if err := tx.Delete(scope); err != nil {
+				return err
+			}
+		}
+		return tx.Set(ref, experiment)
+	})
+	if err != nil {
+		return domain.Experiment{}, storeError(err, ErrExperimentNotFound)
+	}
+	return updated, nil
+}
+
+// RecordOutcome increments the counter server-side, so concurrent sessions on
+// other instances do not lose counts.
+func (s *FirestoreExperimentStore) RecordOutcome(ctx context.Context, experimentID, variant string, outcome domain.ExperimentOutcome) error {
+	_, err := s.ref(experimentID).Collection("outcomes").Doc(variant).Set(ctx, map[string]any{
+		string(outcome): firestore.Increment(1),
+	}, firestore.MergeAll)
+	return storeError(err, nil)
+}
+
+func (s *FirestoreExperimentStore) LoadOutcomes(ctx context.Context, experimentID string) (map[string]domain.OutcomeCounts, error) {
+	if _, err := s.ref(experimentID).Get(ctx); err != nil {
+		return nil, storeError(err, ErrExperimentNotFound)
+	}
+	docs, err := s.ref(experimentID).Collection("outcomes").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, storeError(err, nil)
+	}
+	outcomes := make(map[string]domain.OutcomeCounts, len(docs))
+	for _, doc := range docs {
+		var counts map[string]int
+		if err := doc.DataTo(&counts); err != nil {
+			return nil, err
+		}
+		variant := domain.OutcomeCounts{}
+		for outcome, count := range counts {
+			variant[domain.ExperimentOutcome(outcome)] = count
+		}
+		outcomes[doc.Ref.ID] = variant
+	}
+	return outcomes, nil
+}
+
+func (s *FirestoreExperimentStore) Close() error { return s.client.Close() }
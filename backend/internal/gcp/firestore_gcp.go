@@ -4,10 +4,14 @@ package gcp
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gourmet-guide/backend/internal/domain"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // FirestoreStore uses Firestore (managed GCP service) for session persistence.
@@ -24,19 +28,19 @@ func NewFirestoreStore(ctx context.Context, projectID string) (*FirestoreStore,
 }
 
 func (s *FirestoreStore) SavePrompt(ctx context.Context, sessionID, prompt string) error {
-	_, _, err := s.client.Collection("agent_sessions").Doc(sessionID).Set(ctx, map[string]any{"lastPrompt": prompt}, firestore.MergeAll)
-	return err
+	_, err := s.client.Collection("agent_sessions").Doc(sessionID).Update(ctx, []firestore.Update{{Path: "LastPrompt", Value: prompt}})
+	return storeError(err, ErrSessionNotFound)
 }
 
 func (s *FirestoreStore) SaveSession(ctx context.Context, session domain.ConciergeSession) error {
 	_, err := s.client.Collection("agent_sessions").Doc(session.ID).Set(ctx, session)
-	return err
+	return storeError(err, nil)
 }
 
 func (s *FirestoreStore) LoadSession(ctx context.Context, sessionID string) (domain.ConciergeSession, error) {
 	snap, err := s.client.Collection("agent_sessions").Doc(sessionID).Get(ctx)
 	if err != nil {
-		return domain.ConciergeSession{}, err
+		return domain.ConciergeSession{}, storeError(err, ErrSessionNotFound)
 	}
 	var session domain.ConciergeSession
 	if err := snap.DataTo(&session); err != nil {
@@ -45,33 +49,311 @@ func (s *FirestoreStore) LoadSession(ctx context.Context, sessionID string) (dom
 	return session, nil
 }
 
-func (s *FirestoreStore) SaveMenuSafetyMetadata(ctx context.Context, restaurantID string, items []domain.MenuItem) error {
-	_, _, err := s.client.Collection("menu_safety").Doc(restaurantID).Set(ctx, map[string]any{"items": items})
-	return err
+func (s *FirestoreStore) UpdateSession(ctx context.Context, sessionID string, update func(*domain.ConciergeSession) error) (domain.ConciergeSession, error) {
+	ref := s.client.Collection("agent_sessions").Doc(sessionID)
+	var updated domain.ConciergeSession
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var session domain.ConciergeSession
+		if err := snap.DataTo(&session); err != nil {
+			return err
+		}
+		if err := update(&session); err != nil {
+			return err
+		}
+		session.ID = sessionID
+		updated = session
+		return tx.Set(ref, session)
+	})
+	if err != nil {
+		return domain.ConciergeSession{}, storeError(err, ErrSessionNotFound)
+	}
+	return updated, nil
+}
+
+// AppendTranscript stores turns in the session's turns subcollection.
+func (s *FirestoreStore) AppendTranscript(ctx context.Context, sessionID string, turns ...domain.ConversationTurn) error {
+	if err := s.requireSession(ctx, sessionID); err != nil {
+		return err
+	}
+	batch := s.client.Batch()
+	collection := s.client.Collection("agent_sessions").Doc(sessionID).Collection("turns")
+	for _, turn := range turns {
+		batch.Create(collection.NewDoc(), turn)
+	}
+	_, err := batch.Commit(ctx)
+	return storeError(err, nil)
 }
 
-func (s *FirestoreStore) LoadMenuSafetyMetadata(ctx context.Context, restaurantID string) ([]domain.MenuItem, error) {
-	snap, err := s.client.Collection("menu_safety").Doc(restaurantID).Get(ctx)
+func (s *FirestoreStore) LoadTranscript(ctx context.Context, sessionID string) ([]domain.ConversationTurn, error) {
+	if err := s.requireSession(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	docs, err := s.client.Collection("agent_sessions").Doc(sessionID).Collection("turns").
+		OrderBy("CreatedAt", firestore.Asc).Documents(ctx).GetAll()
 	if err != nil {
-		if errors.Is(err, firestore.ErrNotFound) {
-			return nil, nil
+		return nil, storeError(err, nil)
+	}
+	turns := make([]domain.ConversationTurn, 0, len(docs))
+	for _, doc := range docs {
+		var turn domain.ConversationTurn
+		if err := doc.DataTo(&turn); err != nil {
+			return nil, err
 		}
-		return nil, err
+		turns = append(turns, turn)
+	}
+	return turns, nil
+}
+
+// menuDoc is the per-restaurant menu_safety document. Items mirrors the live
+// version so documents written before versioning still load as the live menu.
+type menuDoc struct {
+	Items         []domain.MenuItem `firestore:"items"`
+	LiveVersion   int               `firestore:"liveVersion"`
+	LatestVersion int               `firestore:"latestVersion"`
+	// LatestPolicyVersion numbers the newest document of the policies subcollection.
+	LatestPolicyVersion int `firestore:"latestPolicyVersion"`
+}
+
+func (s *FirestoreStore) menuRef(restaurantID string) *firestore.DocumentRef {
+	return s.client.Collection("menu_safety").Doc(restaurantID)
+}
+
+func (s *FirestoreStore) menuVersionRef(restaurantID string, version int) *firestore.DocumentRef {
+	return s.menuRef(restaurantID).Collection("versions").Doc(strconv.Itoa(version))
+}
+
+// SaveMenuVersion allocates the next version number and writes the draft in one transaction.
+func (s *FirestoreStore) SaveMenuVersion(ctx context.Context, restaurantID string, items []domain.MenuItem, source string) (domain.MenuVersion, error) {
+	var saved domain.MenuVersion
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var doc menuDoc
+		snap, err := tx.Get(s.menuRef(restaurantID))
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := snap.DataTo(&doc); err != nil {
+				return err
+			}
+		}
+		saved = domain.MenuVersion{
+			RestaurantID: restaurantID,
+			Version:      doc.LatestVersion + 1,
+			Status:       domain.MenuVersionDraft,
+			Source:       source,
+			MenuItems:    items,
+			CreatedAt:    time.Now().UTC(),
+		}
+		if err := tx.Set(s.menuVersionRef(restaurantID, saved.Version), saved); err != nil {
+			return err
+		}
+		return tx.Set(s.menuRef(restaurantID), map[string]any{"latestVersion": saved.Version}, firestore.MergeAll)
+	})
+	if err != nil {
+		return domain.MenuVersion{}, storeError(err, nil)
+	}
+	return saved, nil
+}
+
+// PublishMenuVersion marks the version published and mirrors its items onto the menu document.
+func (s *FirestoreStore) PublishMenuVersion(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	var published domain.MenuVersion
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		ref := s.menuVersionRef(restaurantID, version)
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&published); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		published.Status = domain.MenuVersionPublished
+		published.PublishedAt = &now
+		published.Live = true
+		if err := tx.Set(ref, published); err != nil {
+			return err
+		}
+		return tx.Set(s.menuRef(restaurantID), map[string]any{"items": published.MenuItems, "liveVersion": version}, firestore.MergeAll)
+	})
+	if err != nil {
+		return domain.MenuVersion{}, storeError(err, ErrMenuVersionNotFound)
+	}
+	return published, nil
+}
+
+func (s *FirestoreStore) LoadMenuVersion(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	snap, err := s.menuVersionRef(restaurantID, version).Get(ctx)
+	if err != nil {
+		return domain.MenuVersion{}, storeError(err, ErrMenuVersionNotFound)
 	}
-	var payload struct {
-		Items []domain.MenuItem `firestore:"items"`
+	var loaded domain.MenuVersion
+	if err := snap.DataTo(&loaded); err != nil {
+		return domain.MenuVersion{}, err
 	}
-	if err := snap.DataTo(&payload); err != nil {
+	doc, err := s.loadMenuDoc(ctx, restaurantID)
+	if err != nil {
+		return domain.MenuVersion{}, err
+	}
+	loaded.Live = doc.LiveVersion == version
+	return loaded, nil
+}
+
+func (s *FirestoreStore) ListMenuVersions(ctx context.Context, restaurantID string) ([]domain.MenuVersion, error) {
+	doc, err := s.loadMenuDoc(ctx, restaurantID)
+	if err != nil {
 		return nil, err
 	}
-	return payload.Items, nil
+	docs, err := s.menuRef(restaurantID).Collection("versions").OrderBy("Version", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, storeError(err, nil)
+	}
+	if len(docs) == 0 {
+		return nil, ErrRestaurantNotFound
+	}
+	versions := make([]domain.MenuVersion, 0, len(docs))
+	for _, snap := range docs {
+		var version domain.MenuVersion
+		if err := snap.DataTo(&version); err != nil {
+			return nil, err
+		}
+		version.Live = version.Version == doc.LiveVersion
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// LoadLiveMenu returns the live version. A menu document written before
+// versioning is reported as a live version 0.
+func (s *FirestoreStore) LoadLiveMenu(ctx context.Context, restaurantID string) (domain.MenuVersion, error) {
+	doc, err := s.loadMenuDoc(ctx, restaurantID)
+	if err != nil {
+		return domain.MenuVersion{}, err
+	}
+	if doc.LiveVersion == 0 {
+		if doc.Items == nil {
+			return domain.MenuVersion{}, ErrRestaurantNotFound
+		}
+		return domain.MenuVersion{RestaurantID: restaurantID, Status: domain.MenuVersionPublished, Live: true, MenuItems: doc.Items}, nil
+	}
+	return s.LoadMenuVersion(ctx, restaurantID, doc.LiveVersion)
+}
+
+func (s *FirestoreStore) policyRef(restaurantID string, version int) *firestore.DocumentRef {
+	return s.menuRef(restaurantID).Collection("policies").Doc(strconv.Itoa(version))
+}
+
+// SaveSafetyPolicy allocates the next policy version and writes it in one transaction.
+func (s *FirestoreStore) SaveSafetyPolicy(ctx context.Context, restaurantID string, policy domain.SafetyPolicy) (domain.SafetyPolicy, error) {
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var doc menuDoc
+		snap, err := tx.Get(s.menuRef(restaurantID))
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := snap.DataTo(&doc); err != nil {
+				return err
+			}
+		}
+		policy.RestaurantID = restaurantID
+		policy.Version = doc.LatestPolicyVersion + 1
+		policy.CreatedAt = time.Now().UTC()
+		if err := tx.Set(s.policyRef(restaurantID, policy.Version), policy); err != nil {
+			return err
+		}
+		return tx.Set(s.menuRef(restaurantID), map[string]any{"latestPolicyVersion": policy.Version}, firestore.MergeAll)
+	})
+	if err != nil {
+		return domain.SafetyPolicy{}, storeError(err, nil)
+	}
+	return policy, nil
+}
+
+func (s *FirestoreStore) LoadSafetyPolicy(ctx context.Context, restaurantID string, version int) (domain.SafetyPolicy, error) {
+	if version == 0 {
+		snap, err := s.menuRef(restaurantID).Get(ctx)
+		if err != nil {
+			return domain.SafetyPolicy{}, storeError(err, ErrPolicyNotFound)
+		}
+		var doc menuDoc
+		if err := snap.DataTo(&doc); err != nil {
+			return domain.SafetyPolicy{}, err
+		}
+		if doc.LatestPolicyVersion == 0 {
+			return domain.SafetyPolicy{}, ErrPolicyNotFound
+		}
+		version = doc.LatestPolicyVersion
+	}
+	snap, err := s.policyRef(restaurantID, version).Get(ctx)
+	if err != nil {
+		return domain.SafetyPolicy{}, storeError(err, ErrPolicyNotFound)
+	}
+	var policy domain.SafetyPolicy
+	if err := snap.DataTo(&policy); err != nil {
+		return domain.SafetyPolicy{}, err
+	}
+	return policy, nil
+}
+
+func (s *FirestoreStore) ListSafetyPolicies(ctx context.Context, restaurantID string) ([]domain.SafetyPolicy, error) {
+	docs, err := s.menuRef(restaurantID).Collection("policies").OrderBy("Version", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, storeError(err, nil)
+	}
+	policies := make([]domain.SafetyPolicy, 0, len(docs))
+	for _, snap := range docs {
+		var policy domain.SafetyPolicy
+		if err := snap.DataTo(&policy); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+func (s *FirestoreStore) loadMenuDoc(ctx context.Context, restaurantID string) (menuDoc, error) {
+	snap, err := s.menuRef(restaurantID).Get(ctx)
+	if err != nil {
+		return menuDoc{}, storeError(err, ErrRestaurantNotFound)
+	}
+	var doc menuDoc
+	if err := snap.DataTo(&doc); err != nil {
+		return menuDoc{}, err
+	}
+	return doc, nil
 }
 
 func (s *FirestoreStore) SaveImageReference(ctx context.Context, sessionID, imagePath string) error {
-	_, _, err := s.client.Collection("agent_sessions").Doc(sessionID).Set(ctx, map[string]any{
-		"imageRefs": firestore.ArrayUnion(imagePath),
-	}, firestore.MergeAll)
-	return err
+	_, err := s.client.Collection("agent_sessions").Doc(sessionID).Update(ctx, []firestore.Update{
+		{Path: "imageRefs", Value: firestore.ArrayUnion(imagePath)},
+	})
+	return storeError(err, ErrSessionNotFound)
 }
 
 func (s *FirestoreStore) Close() error { return s.client.Close() }
+
+func (s *FirestoreStore) requireSession(ctx context.Context, sessionID string) error {
+	_, err := s.client.Collection("agent_sessions").Doc(sessionID).Get(ctx)
+	return storeError(err, ErrSessionNotFound)
+}
+
+// storeError translates Firestore status codes into the store's typed errors:
+// codes.NotFound becomes notFound (when set) and transient failures wrap ErrUnavailable.
+func storeError(err, notFound error) error {
+	switch status.Code(err) {
+	case codes.OK:
+		return err
+	case codes.NotFound:
+		if notFound != nil {
+			return notFound
+		}
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+// ErrRestaurantExists is returned when creating a restaurant whose ID is taken.
+var ErrRestaurantExists = errors.New("restaurant already exists")
+
+// RestaurantStore persists restaurants together with their menu items and combos.
+// Lookups and updates return ErrRestaurantNotFound for unknown IDs.
+type RestaurantStore interface {
+	CreateRestaurant(ctx context.Context, restaurant domain.Restaurant) error
+	LoadRestaurant(ctx context.Context, restaurantID string) (domain.Restaurant, error)
+	ListRestaurants(ctx context.Context) ([]domain.Restaurant, error)
+	// UpdateRestaurant applies update atomically to the stored restaurant and
+	// saves the result unless update returns an error.
+	UpdateRestaurant(ctx context.Context, restaurantID string, update func(*domain.Restaurant) error) (domain.Restaurant, error)
+	DeleteRestaurant(ctx context.Context, restaurantID string) error
+	Close() error
+}
+
+// MemoryRestaurantStore is local default restaurant storage for development and tests.
+type MemoryRestaurantStore struct {
+	mu          sync.RWMutex
+	restaurants map[string]domain.Restaurant
+}
+
+func NewMemoryRestaurantStore() *MemoryRestaurantStore {
+	return &MemoryRestaurantStore{restaurants: map[string]domain.Restaurant{}}
+}
+
+func (m *MemoryRestaurantStore) CreateRestaurant(_ context.Context, restaurant domain.Restaurant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.restaurants[restaurant.ID]; ok {
+		return ErrRestaurantExists
+	}
+	m.restaurants[restaurant.ID] = cloneRestaurant(restaurant)
+	return nil
+}
+
+func (m *MemoryRestaurantStore) LoadRestaurant(_ context.Context, restaurantID string) (domain.Restaurant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	restaurant, ok := m.restaurants[restaurantID]
+	if !ok {
+		return domain.Restaurant{}, ErrRestaurantNotFound
+	}
+	return cloneRestaurant(restaurant), nil
+}
+
+// ListRestaurants returns all restaurants ordered by ID.
+func (m *MemoryRestaurantStore) ListRestaurants(_ context.Context) ([]domain.Restaurant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	restaurants := make([]domain.Restaurant, 0, len(m.restaurants))
+	for _, restaurant := range m.restaurants {
+		restaurants = append(restaurants, cloneRestaurant(restaurant))
+	}
+	sort.Slice(restaurants, func(i, j int) bool { return restaurants[i].ID < restaurants[j].ID })
+	return restaurants, nil
+}
+
+func (m *MemoryRestaurantStore) UpdateRestaurant(_ context.Context, restaurantID string, update func(*domain.Restaurant) error) (domain.Restaurant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.restaurants[restaurantID]
+	if !ok {
+		return domain.Restaurant{}, ErrRestaurantNotFound
+	}
+	updated := cloneRestaurant(current)
+	if err := update(&updated); err != nil {
+		return domain.Restaurant{}, err
+	}
+	updated.ID = restaurantID
+	m.restaurants[restaurantID] = cloneRestaurant(updated)
+	return updated, nil
+}
+
+func (m *MemoryRestaurantStore) DeleteRestaurant(_ context.Context, restaurantID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.restaurants[restaurantID]; !ok {
+		return ErrRestaurantNotFound
+	}
+	delete(m.restaurants, restaurantID)
+	return nil
+}
+
+func (m *MemoryRestaurantStore) Close() error { return nil }
+
+// cloneRestaurant copies the catalog, menu and combo slices so callers cannot mutate stored state.
+func cloneRestaurant(restaurant domain.Restaurant) domain.Restaurant {
+	if restaurant.Ingredients != nil {
+		restaurant.Ingredients = append([]domain.Ingredient{}, restaurant.Ingredients...)
+	}
+	restaurant.MenuItems = append([]domain.MenuItem{}, restaurant.MenuItems...)
+	restaurant.Combos = append([]domain.Combo{}, restaurant.Combos...)
+	for i, combo := range restaurant.Combos {
+		restaurant.Combos[i].ItemIDs = append([]string{}, combo.ItemIDs...)
+	}
+	return restaurant
+}
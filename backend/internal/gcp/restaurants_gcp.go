@@ -0,0 +1,113 @@
+//go:build gcp
+
+package gcp
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gourmet-guide/backend/internal/domain"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreRestaurantStore keeps restaurants in a Firestore collection. The API
+// and the seed tool share it, so seeded restaurants are served as written.
+type FirestoreRestaurantStore struct {
+	collection string
+	client     *firestore.Client
+}
+
+func NewFirestoreRestaurantStore(ctx context.Context, projectID, collection string) (*FirestoreRestaurantStore, error) {
+	if collection == "" {
+		collection = "restaurants"
+	}
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &FirestoreRestaurantStore{collection: collection, client: client}, nil
+}
+
+func (s *FirestoreRestaurantStore) CreateRestaurant(ctx context.Context, restaurant domain.Restaurant) error {
+	_, err := s.client.Collection(s.collection).Doc(restaurant.ID).Create(ctx, restaurant)
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrRestaurantExists
+	}
+	return storeError(err, nil)
+}
+
+// SaveRestaurant writes restaurant whether or not it exists, as the seed tool
+// does when it republishes generated restaurants.
+func (s *FirestoreRestaurantStore) SaveRestaurant(ctx context.Context, restaurant domain.Restaurant) error {
+	_, err := s.client.Collection(s.collection).Doc(restaurant.ID).Set(ctx, restaurant)
+	return storeError(err, nil)
+}
+
+func (s *FirestoreRestaurantStore) LoadRestaurant(ctx context.Context, restaurantID string) (domain.Restaurant, error) {
+	snap, err := s.client.Collection(s.collection).Doc(restaurantID).Get(ctx)
+	if err != nil {
+		return domain.Restaurant{}, storeError(err, ErrRestaurantNotFound)
+	}
+	var restaurant domain.Restaurant
+	if err := snap.DataTo(&restaurant); err != nil {
+		return domain.Restaurant{}, err
+	}
+	return restaurant, nil
+}
+
+// ListRestaurants returns all restaurants ordered by document ID.
+func (s *FirestoreRestaurantStore) ListRestaurants(ctx context.Context) ([]domain.Restaurant, error) {
+	docs := s.client.Collection(s.collection).OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)
+	defer docs.Stop()
+	var restaurants []domain.Restaurant
+	for {
+		doc, err := docs.Next()
+		if err == iterator.Done {
+			return restaurants, nil
+		}
+		if err != nil {
+			return nil, storeError(err, nil)
+		}
+		var restaurant domain.Restaurant
+		if err := doc.DataTo(&restaurant); err != nil {
+			return nil, err
+		}
+		restaurants = append(restaurants, restaurant)
+	}
+}
+
+// UpdateRestaurant runs update inside a Firestore transaction so concurrent
+// menu edits do not overwrite each other.
+func (s *FirestoreRestaurantStore) UpdateRestaurant(ctx context.Context, restaurantID string, update func(*domain.Restaurant) error) (domain.Restaurant, error) {
+	ref := s.client.Collection(s.collection).Doc(restaurantID)
+	var updated domain.Restaurant
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var restaurant domain.Restaurant
+		if err := snap.DataTo(&restaurant); err != nil {
+			return err
+		}
+		if err := update(&restaurant); err != nil {
+			return err
+		}
+		restaurant.ID = restaurantID
+		updated = restaurant
+		return tx.Set(ref, restaurant)
+	})
+	if err != nil {
+		return domain.Restaurant{}, storeError(err, ErrRestaurantNotFound)
+	}
+	return updated, nil
+}
+
+func (s *FirestoreRestaurantStore) DeleteRestaurant(ctx context.Context, restaurantID string) error {
+	_, err := s.client.Collection(s.collection).Doc(restaurantID).Delete(ctx, firestore.Exists)
+	return storeError(err, ErrRestaurantNotFound)
+}
+
+func (s *FirestoreRestaurantStore) Close() error { return s.client.Close() }
@@ -2,18 +2,55 @@ package gcp
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/gourmet-guide/backend/internal/domain"
 )
 
-// SessionStore persists agent session metadata.
+var (
+	// ErrSessionNotFound is returned when a session ID has never been saved.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrRestaurantNotFound is returned when a restaurant is unknown or has no published menu.
+	ErrRestaurantNotFound = errors.New("restaurant not found")
+	// ErrMenuVersionNotFound is returned for a menu version that was never saved.
+	ErrMenuVersionNotFound = errors.New("menu version not found")
+	// ErrPolicyNotFound is returned when a restaurant has no saved safety policy version.
+	ErrPolicyNotFound = errors.New("safety policy not found")
+	// ErrUnavailable wraps transient backend failures such as an unreachable database.
+	ErrUnavailable = errors.New("store unavailable")
+)
+
+// SessionStore persists agent session metadata. Session-scoped methods return
+// ErrSessionNotFound for unknown sessions instead of creating them.
 type SessionStore interface {
 	SavePrompt(ctx context.Context, sessionID, prompt string) error
 	SaveSession(ctx context.Context, session domain.ConciergeSession) error
 	LoadSession(ctx context.Context, sessionID string) (domain.ConciergeSession, error)
-	SaveMenuSafetyMetadata(ctx context.Context, restaurantID string, items []domain.MenuItem) error
-	LoadMenuSafetyMetadata(ctx context.Context, restaurantID string) ([]domain.MenuItem, error)
+	// UpdateSession applies update atomically to the stored session and saves
+	// the result unless update returns an error, so concurrent changes to other
+	// fields are not lost.
+	UpdateSession(ctx context.Context, sessionID string, update func(*domain.ConciergeSession) error) (domain.ConciergeSession, error)
+	AppendTranscript(ctx context.Context, sessionID string, turns ...domain.ConversationTurn) error
+	LoadTranscript(ctx context.Context, sessionID string) ([]domain.ConversationTurn, error)
+	// SaveMenuVersion stores items as a new draft version numbered after the latest one.
+	SaveMenuVersion(ctx context.Context, restaurantID string, items []domain.MenuItem, source string) (domain.MenuVersion, error)
+	// PublishMenuVersion makes a saved version the live menu.
+	PublishMenuVersion(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error)
+	LoadMenuVersion(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error)
+	// ListMenuVersions returns versions oldest first, or ErrRestaurantNotFound if none were saved.
+	ListMenuVersions(ctx context.Context, restaurantID string) ([]domain.MenuVersion, error)
+	// LoadLiveMenu returns the published version guests see, or ErrRestaurantNotFound.
+	LoadLiveMenu(ctx context.Context, restaurantID string) (domain.MenuVersion, error)
+	// SaveSafetyPolicy stores policy as a new version numbered after the latest one.
+	SaveSafetyPolicy(ctx context.Context, restaurantID string, policy domain.SafetyPolicy) (domain.SafetyPolicy, error)
+	// LoadSafetyPolicy returns a saved version, or the latest one when version is
+	// 0, or ErrPolicyNotFound.
+	LoadSafetyPolicy(ctx context.Context, restaurantID string, version int) (domain.SafetyPolicy, error)
+	// ListSafetyPolicies returns saved versions oldest first; none is not an error.
+	ListSafetyPolicies(ctx context.Context, restaurantID string) ([]domain.SafetyPolicy, error)
 	SaveImageReference(ctx context.Context, sessionID, imagePath string) error
 	Close() error
 }
@@ -22,14 +59,18 @@ type SessionStore interface {
 type MemoryStore struct {
 	mu         sync.RWMutex
 	sessions   map[string]domain.ConciergeSession
-	menuByRest map[string][]domain.MenuItem
+	transcript map[string][]domain.ConversationTurn
+	menus      map[string]*menuHistory
+	policies   map[string][]domain.SafetyPolicy
 	images     map[string][]string
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
 		sessions:   map[string]domain.ConciergeSession{},
-		menuByRest: map[string][]domain.MenuItem{},
+		transcript: map[string][]domain.ConversationTurn{},
+		menus:      map[string]*menuHistory{},
+		policies:   map[string][]domain.SafetyPolicy{},
 		images:     map[string][]string{},
 	}
 }
@@ -37,8 +78,11 @@ func NewMemoryStore() *MemoryStore {
 func (m *MemoryStore) SavePrompt(_ context.Context, sessionID, prompt string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	session := m.sessions[sessionID]
-	session.LastAssistantMsg = prompt
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.LastPrompt = prompt
 	m.sessions[sessionID] = session
 	return nil
 }
@@ -53,26 +97,175 @@ func (m *MemoryStore) SaveSession(_ context.Context, session domain.ConciergeSes
 func (m *MemoryStore) LoadSession(_ context.Context, sessionID string) (domain.ConciergeSession, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.sessions[sessionID], nil
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return domain.ConciergeSession{}, ErrSessionNotFound
+	}
+	return session, nil
 }
 
-func (m *MemoryStore) SaveMenuSafetyMetadata(_ context.Context, restaurantID string, items []domain.MenuItem) error {
+func (m *MemoryStore) UpdateSession(_ context.Context, sessionID string, update func(*domain.ConciergeSession) error) (domain.ConciergeSession, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.menuByRest[restaurantID] = append([]domain.MenuItem{}, items...)
+	current, ok := m.sessions[sessionID]
+	if !ok {
+		return domain.ConciergeSession{}, ErrSessionNotFound
+	}
+	updated := cloneSession(current)
+	if err := update(&updated); err != nil {
+		return domain.ConciergeSession{}, err
+	}
+	updated.ID = sessionID
+	m.sessions[sessionID] = updated
+	return cloneSession(updated), nil
+}
+
+// cloneSession copies the slices of session so an update cannot change the
+// stored session in place.
+func cloneSession(session domain.ConciergeSession) domain.ConciergeSession {
+	session.HardAllergens = slices.Clone(session.HardAllergens)
+	session.Allergies = slices.Clone(session.Allergies)
+	session.PreferenceTags = slices.Clone(session.PreferenceTags)
+	session.PendingRemovals = slices.Clone(session.PendingRemovals)
+	session.Order = slices.Clone(session.Order)
+	return session
+}
+
+func (m *MemoryStore) AppendTranscript(_ context.Context, sessionID string, turns ...domain.ConversationTurn) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[sessionID]; !ok {
+		return ErrSessionNotFound
+	}
+	m.transcript[sessionID] = append(m.transcript[sessionID], turns...)
 	return nil
 }
 
-func (m *MemoryStore) LoadMenuSafetyMetadata(_ context.Context, restaurantID string) ([]domain.MenuItem, error) {
+func (m *MemoryStore) LoadTranscript(_ context.Context, sessionID string) ([]domain.ConversationTurn, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	items := m.menuByRest[restaurantID]
-	return append([]domain.MenuItem{}, items...), nil
+	if _, ok := m.sessions[sessionID]; !ok {
+		return nil, ErrSessionNotFound
+	}
+	return append([]domain.ConversationTurn{}, m.transcript[sessionID]...), nil
+}
+
+// menuHistory holds every saved version of one restaurant menu; versions[i] is version i+1.
+type menuHistory struct {
+	versions []domain.MenuVersion
+	live     int
+}
+
+func (h *menuHistory) view(version int) domain.MenuVersion {
+	snapshot := h.versions[version-1]
+	snapshot.MenuItems = append([]domain.MenuItem{}, snapshot.MenuItems...)
+	snapshot.Live = version == h.live
+	return snapshot
+}
+
+func (m *MemoryStore) SaveMenuVersion(_ context.Context, restaurantID string, items []domain.MenuItem, source string) (domain.MenuVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	history, ok := m.menus[restaurantID]
+	if !ok {
+		history = &menuHistory{}
+		m.menus[restaurantID] = history
+	}
+	history.versions = append(history.versions, domain.MenuVersion{
+		RestaurantID: restaurantID,
+		Version:      len(history.versions) + 1,
+		Status:       domain.MenuVersionDraft,
+		Source:       source,
+		MenuItems:    append([]domain.MenuItem{}, items...),
+		CreatedAt:    time.Now().UTC(),
+	})
+	return history.view(len(history.versions)), nil
+}
+
+func (m *MemoryStore) PublishMenuVersion(_ context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	history, ok := m.menus[restaurantID]
+	if !ok || version < 1 || version > len(history.versions) {
+		return domain.MenuVersion{}, ErrMenuVersionNotFound
+	}
+	now := time.Now().UTC()
+	history.versions[version-1].Status = domain.MenuVersionPublished
+	history.versions[version-1].PublishedAt = &now
+	history.live = version
+	return history.view(version), nil
+}
+
+func (m *MemoryStore) LoadMenuVersion(_ context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	history, ok := m.menus[restaurantID]
+	if !ok || version < 1 || version > len(history.versions) {
+		return domain.MenuVersion{}, ErrMenuVersionNotFound
+	}
+	return history.view(version), nil
+}
+
+func (m *MemoryStore) ListMenuVersions(_ context.Context, restaurantID string) ([]domain.MenuVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	history, ok := m.menus[restaurantID]
+	if !ok {
+		return nil, ErrRestaurantNotFound
+	}
+	versions := make([]domain.MenuVersion, len(history.versions))
+	for i := range history.versions {
+		versions[i] = history.view(i + 1)
+	}
+	return versions, nil
+}
+
+func (m *MemoryStore) LoadLiveMenu(_ context.Context, restaurantID string) (domain.MenuVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	history, ok := m.menus[restaurantID]
+	if !ok || history.live == 0 {
+		return domain.MenuVersion{}, ErrRestaurantNotFound
+	}
+	return history.view(history.live), nil
+}
+
+func (m *MemoryStore) SaveSafetyPolicy(_ context.Context, restaurantID string, policy domain.SafetyPolicy) (domain.SafetyPolicy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	policy.RestaurantID = restaurantID
+	policy.Version = len(m.policies[restaurantID]) + 1
+	policy.Rules = append([]domain.PolicyRule{}, policy.Rules...)
+	policy.CreatedAt = time.Now().UTC()
+	m.policies[restaurantID] = append(m.policies[restaurantID], policy)
+	return policy, nil
+}
+
+func (m *MemoryStore) LoadSafetyPolicy(_ context.Context, restaurantID string, version int) (domain.SafetyPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	policies := m.policies[restaurantID]
+	if version == 0 {
+		version = len(policies)
+	}
+	if version < 1 || version > len(policies) {
+		return domain.SafetyPolicy{}, ErrPolicyNotFound
+	}
+	return policies[version-1], nil
+}
+
+func (m *MemoryStore) ListSafetyPolicies(_ context.Context, restaurantID string) ([]domain.SafetyPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]domain.SafetyPolicy{}, m.policies[restaurantID]...), nil
 }
 
 func (m *MemoryStore) SaveImageReference(_ context.Context, sessionID, imagePath string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if _, ok := m.sessions[sessionID]; !ok {
+		return ErrSessionNotFound
+	}
 	m.images[sessionID] = append(m.images[sessionID], imagePath)
 	return nil
 }
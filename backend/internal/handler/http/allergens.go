@@ -0,0 +1,33 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/service"
+)
+
+type allergenListResponse struct {
+	Jurisdiction domain.Jurisdiction         `json:"jurisdiction,omitempty"`
+	Allergens    []domain.AllergenDefinition `json:"allergens"`
+}
+
+// handleAllergens serves GET /v1/allergens, the allergen registry. The optional
+// jurisdiction query parameter narrows it to one regulatory list.
+func (h *Handler) handleAllergens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	var jurisdiction domain.Jurisdiction
+	if value := r.URL.Query().Get("jurisdiction"); value != "" {
+		parsed, ok := domain.ParseJurisdiction(value)
+		if !ok {
+			writeError(w, r, service.ValidationError(fmt.Sprintf("unknown jurisdiction %q", value), map[string]any{"field": "jurisdiction", "supported": domain.Jurisdictions}))
+			return
+		}
+		jurisdiction = parsed
+	}
+	writeJSON(w, allergenListResponse{Jurisdiction: jurisdiction, Allergens: domain.AllergenDefinitions(jurisdiction)})
+}
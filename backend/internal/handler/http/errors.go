@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gourmet-guide/backend/internal/service"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// apiError is the error envelope shared by JSON responses, SSE `error` events
+// and websocket `error` events.
+type apiError struct {
+	Code      service.ErrorKind `json:"code"`
+	Message   string            `json:"message"`
+	Details   map[string]any    `json:"details,omitempty"`
+	RequestID string            `json:"requestId,omitempty"`
+}
+
+// wsErrorEvent is an apiError framed as a realtime websocket event.
+type wsErrorEvent struct {
+	Type string `json:"type"`
+	apiError
+}
+
+// withRequestID propagates the caller's X-Request-ID, or assigns a new one, so
+// error envelopes and logs can be correlated.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newAPIError classifies err. Internal failures are logged and reported with a
+// generic message so storage or model details do not leak to clients.
+func newAPIError(ctx context.Context, err error) apiError {
+	kind := service.KindOf(err)
+	body := apiError{Code: kind, Message: err.Error(), Details: service.DetailsOf(err), RequestID: requestIDFrom(ctx)}
+	if kind == service.ErrorKindInternal {
+		log.Printf("request %s: %v", body.RequestID, err)
+		body.Message = "internal server error"
+	}
+	return body
+}
+
+func statusForKind(kind service.ErrorKind) int {
+	switch kind {
+	case service.ErrorKindValidation:
+		return http.StatusBadRequest
+	case service.ErrorKindNotFound:
+		return http.StatusNotFound
+	case service.ErrorKindConflict:
+		return http.StatusConflict
+	case service.ErrorKindUnavailable:
+		return http.StatusServiceUnavailable
+	case service.ErrorKindSafetyRefusal:
+		return http.StatusUnprocessableEntity
+	case service.ErrorKindMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError writes err as a JSON error envelope with the matching status code.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	body := newAPIError(r.Context(), err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusForKind(body.Code))
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeMethodNotAllowed rejects a method the route does not serve and lists the
+// ones it does in the Allow header.
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, r, service.NewError(service.ErrorKindMethodNotAllowed, fmt.Sprintf("method %s is not allowed", r.Method), map[string]any{"allowed": allowed}))
+}
+
+// writeRouteMiss answers a request no case of a route served: 405 when path is
+// one of routes, which maps sub-paths to their methods with "*" for IDs, and
+// 404 otherwise.
+func writeRouteMiss(w http.ResponseWriter, r *http.Request, routes map[string][]string, path string) {
+	if allowed, ok := routes[path]; ok {
+		writeMethodNotAllowed(w, r, allowed...)
+		return
+	}
+	writeError(w, r, errRouteNotFound)
+}
+
+// writeWSError sends err as a websocket `error` event.
+func writeWSError(ctx context.Context, conn *wsConn, err error) {
+	_ = conn.WriteJSON(wsErrorEvent{Type: "error", apiError: newAPIError(ctx, err)})
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+type experimentListResponse struct {
+	Experiments []domain.Experiment `json:"experiments"`
+}
+
+// handleExperiments serves the /v1/experiments collection.
+func (h *Handler) handleExperiments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		experiments, err := h.app.ListExperiments(r.Context())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if experiments == nil {
+			experiments = []domain.Experiment{}
+		}
+		writeJSON(w, experimentListResponse{Experiments: experiments})
+	case http.MethodPost:
+		var req domain.Experiment
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, invalidJSON(err))
+			return
+		}
+		created, err := h.app.CreateExperiment(r.Context(), req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSONStatus(w, http.StatusCreated, created)
+	default:
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleExperimentRoutes serves /v1/experiments/{id}, /stop and /results.
+func (h *Handler) handleExperimentRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/experiments/"), "/")
+	if parts[0] == "" || len(parts) > 2 {
+		writeError(w, r, errRouteNotFound)
+		return
+	}
+	experimentID := parts[0]
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		experiment, err := h.app.GetExperiment(r.Context(), experimentID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, experiment)
+	case len(parts) == 1:
+		writeMethodNotAllowed(w, r, http.MethodGet)
+	case parts[1] == "stop" && r.Method == http.MethodPost:
+		experiment, err := h.app.StopExperiment(r.Context(), experimentID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, experiment)
+	case parts[1] == "results" && r.Method == http.MethodGet:
+		results, err := h.app.ExperimentResults(r.Context(), experimentID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, results)
+	default:
+		writeRouteMiss(w, r, experimentRoutes, parts[1])
+	}
+}
+
+// experimentRoutes are the methods served under /v1/experiments/{id}/.
+var experimentRoutes = map[string][]string{
+	"stop":    {http.MethodPost},
+	"results": {http.MethodGet},
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+func TestExperimentEndpointsAssignSessionsAndReportResults(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+
+	rec := doJSON(t, router, http.MethodPost, "/v1/experiments", `{"name":"Short menus","variants":[{"name":"control","weight":1},{"name":"k3","retrievalK":3}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating experiment, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	var experiment domain.Experiment
+	if err := json.Unmarshal(rec.Body.Bytes(), &experiment); err != nil || experiment.ID == "" || experiment.Status != domain.ExperimentRunning {
+		t.Fatalf("expected a running experiment, got %s (%v)", rec.Body.String(), err)
+	}
+	if rec := doJSON(t, router, http.MethodPost, "/v1/experiments", `{"name":"Broken","variants":[{"name":"a","weight":1}]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a single variant, got %d", rec.Code)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/v1/experiments", ""); rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+		t.Fatalf("expected experiment list, got %d", rec.Code)
+	}
+
+	sessionID := createSession(t, router)
+	rec = doJSON(t, router, http.MethodGet, "/v1/sessions/"+sessionID, "")
+	var session domain.ConciergeSession
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil || session.Experiment == nil || session.Experiment.Variant.Name != "control" {
+		t.Fatalf("expected the session in the control variant, got %s (%v)", rec.Body.String(), err)
+	}
+	if rec := doJSON(t, router, http.MethodPost, "/v1/sessions/"+sessionID+"/order/confirm", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 confirming an empty order, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	if rec := doJSON(t, router, http.MethodDelete, "/v1/sessions/"+sessionID, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 ending session, got %d", rec.Code)
+	}
+
+	if rec := doJSON(t, router, http.MethodPost, "/v1/experiments/"+experiment.ID+"/stop", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 stopping experiment, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodGet, "/v1/experiments/"+experiment.ID+"/results", "")
+	var results domain.ExperimentResults
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil || results.Status != domain.ExperimentStopped || len(results.Variants) != 2 {
+		t.Fatalf("expected results for both variants, got %s (%v)", rec.Body.String(), err)
+	}
+	if control := results.Variants[0]; control.Sessions != 1 || control.SessionsAbandoned != 1 || control.ConversionRate != 0 {
+		t.Fatalf("expected one abandoned control session, got %+v", control)
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/v1/experiments/missing/results", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown experiment, got %d", rec.Code)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -15,7 +17,8 @@ import (
 )
 
 type Handler struct {
-	app *service.ConciergeApp
+	app               *service.ConciergeApp
+	wsMaxMessageBytes int
 }
 
 type voiceStreamingConfigResponse struct {
@@ -47,7 +50,10 @@ type voiceStreamingConfigResponse struct {
 }
 
 func NewHandler(app *service.ConciergeApp) *Handler {
-	return &Handler{app: app}
+	return &Handler{
+		app:               app,
+		wsMaxMessageBytes: getenvInt("WS_MAX_MESSAGE_BYTES", defaultWSMaxMessageLen),
+	}
 }
 
 func (h *Handler) Routes() http.Handler {
@@ -57,19 +63,29 @@ func (h *Handler) Routes() http.Handler {
 	mux.HandleFunc("/ws/", h.handleRealtimeWebSocket)
 	mux.HandleFunc("/v1/sessions", h.handleSessions)
 	mux.HandleFunc("/v1/sessions/", h.handleSessionByID)
+	mux.HandleFunc("/v1/restaurants", h.handleRestaurants)
 	mux.HandleFunc("/v1/restaurants/", h.handleRestaurantRoutes)
-	return mux
+	mux.HandleFunc("/v1/allergens", h.handleAllergens)
+	mux.HandleFunc("/v1/experiments", h.handleExperiments)
+	mux.HandleFunc("/v1/experiments/", h.handleExperimentRoutes)
+	mux.HandleFunc("/v1/metrics", h.handleMetrics)
+	return withRequestID(mux)
 }
 
 func (h *Handler) handleVoiceStreamingConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, r, http.MethodGet)
 		return
 	}
 
 	response := voiceStreamingConfigResponse{Model: getenv("GEMINI_MODEL", "gemini-2.5-flash-native-audio-preview-12-2025")}
 	response.Config.ResponseModalities = []string{"AUDIO"}
-	response.Config.SystemInstruction = "You are a helpful and friendly AI assistant."
+	instruction, promptVersion, err := h.app.VoiceInstruction(r.Context(), r.URL.Query().Get("restaurantId"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	response.Config.SystemInstruction = instruction
 	response.Config.SpeechConfig = map[string]any{
 		"voice_name":    getenv("VOICE_NAME", "Aoede"),
 		"language_code": getenv("VOICE_LANGUAGE_CODE", "en-US"),
@@ -90,6 +106,7 @@ func (h *Handler) handleVoiceStreamingConfig(w http.ResponseWriter, r *http.Requ
 		"app":               "gourmet-guide-bidi",
 		"transport":         "websocket",
 		"response_modality": "AUDIO",
+		"prompt_version":    promptVersion,
 	}
 	response.Audio.Format = "pcm16"
 	response.Audio.Channels = 1
@@ -105,6 +122,7 @@ func (h *Handler) handleVoiceStreamingConfig(w http.ResponseWriter, r *http.Requ
 type startSessionRequest struct {
 	RestaurantID   string            `json:"restaurantId"`
 	HardAllergens  []domain.Allergen `json:"hardAllergens"`
+	Allergies      []domain.Allergy  `json:"allergies"`
 	PreferenceTags []string          `json:"preferenceTags"`
 	MenuItems      []domain.MenuItem `json:"menuItems"`
 }
@@ -113,6 +131,16 @@ type sendMessageRequest struct {
 	Prompt string `json:"prompt"`
 }
 
+type safetyCheckRequest struct {
+	ItemIDs   []string          `json:"itemIds"`
+	MenuItems []domain.MenuItem `json:"menuItems"`
+}
+
+type safetyCheckResponse struct {
+	SessionID string              `json:"sessionId"`
+	Items     []domain.ItemSafety `json:"items"`
+}
+
 type imageUploadRequest struct {
 	FileName string `json:"fileName"`
 	Base64   string `json:"base64"`
@@ -127,10 +155,16 @@ type sessionStartResponse struct {
 	SuggestedMenuTags []domain.MenuItem       `json:"suggestedMenuItems"`
 }
 
+type transcriptResponse struct {
+	SessionID string                    `json:"sessionId"`
+	Turns     []domain.ConversationTurn `json:"turns"`
+}
+
 type menuExtractionResponse struct {
-	ImagePath string            `json:"imagePath"`
-	MenuItems []domain.MenuItem `json:"menuItems"`
-	Note      string            `json:"note"`
+	ImagePath   string            `json:"imagePath"`
+	MenuItems   []domain.MenuItem `json:"menuItems"`
+	MenuVersion int               `json:"menuVersion"`
+	Note        string            `json:"note"`
 }
 
 func (h *Handler) handleHealth(w http.ResponseWriter, _ *http.Request) {
@@ -140,12 +174,12 @@ func (h *Handler) handleHealth(w http.ResponseWriter, _ *http.Request) {
 
 func (h *Handler) handleSessions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, r, http.MethodPost)
 		return
 	}
 	var req startSessionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		writeError(w, r, invalidJSON(err))
 		return
 	}
 
@@ -155,11 +189,12 @@ func (h *Handler) handleSessions(w http.ResponseWriter, r *http.Request) {
 	result, err := h.app.StartSession(ctx, service.StartSessionInput{
 		RestaurantID:   req.RestaurantID,
 		HardAllergens:  req.HardAllergens,
+		Allergies:      req.Allergies,
 		PreferenceTags: req.PreferenceTags,
 		MenuItems:      req.MenuItems,
 	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	writeJSON(w, sessionStartResponse{Session: result.Session, SuggestedMenuTags: result.SuggestedMenuItems})
@@ -168,7 +203,7 @@ func (h *Handler) handleSessions(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) handleSessionByID(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/sessions/"), "/")
 	if len(parts) == 0 || parts[0] == "" {
-		http.NotFound(w, r)
+		writeError(w, r, errRouteNotFound)
 		return
 	}
 	sessionID := parts[0]
@@ -176,7 +211,7 @@ func (h *Handler) handleSessionByID(w http.ResponseWriter, r *http.Request) {
 	if len(parts) == 1 && r.Method == http.MethodGet {
 		session, err := h.app.GetSession(r.Context(), sessionID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 		writeJSON(w, session)
@@ -184,7 +219,7 @@ func (h *Handler) handleSessionByID(w http.ResponseWriter, r *http.Request) {
 	}
 	if len(parts) == 1 && r.Method == http.MethodDelete {
 		if err := h.app.EndSession(r.Context(), sessionID); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
@@ -193,20 +228,51 @@ func (h *Handler) handleSessionByID(w http.ResponseWriter, r *http.Request) {
 	if len(parts) == 2 && parts[1] == "messages" && r.Method == http.MethodPost {
 		var req sendMessageRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			writeError(w, r, invalidJSON(err))
 			return
 		}
 		reply, err := h.app.SendMessage(r.Context(), sessionID, req.Prompt)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
-		writeJSON(w, map[string]string{"reply": reply})
+		writeJSON(w, reply)
+		return
+	}
+	if len(parts) == 3 && parts[1] == "order" && parts[2] == "confirm" && r.Method == http.MethodPost {
+		session, err := h.app.ConfirmOrder(r.Context(), sessionID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, session)
+		return
+	}
+	if len(parts) == 3 && parts[1] == "profile" && parts[2] == "confirm" && r.Method == http.MethodPost {
+		session, err := h.app.ConfirmProfileRemovals(r.Context(), sessionID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, session)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "safety-check" && r.Method == http.MethodPost {
+		h.handleSafetyCheck(w, r, sessionID)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "transcript" && r.Method == http.MethodGet {
+		turns, err := h.app.GetTranscript(r.Context(), sessionID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, transcriptResponse{SessionID: sessionID, Turns: turns})
 		return
 	}
 	if len(parts) == 2 && parts[1] == "interrupt" && r.Method == http.MethodPost {
 		if err := h.app.InterruptSession(r.Context(), sessionID); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
 		w.WriteHeader(http.StatusAccepted)
@@ -220,91 +286,248 @@ func (h *Handler) handleSessionByID(w http.ResponseWriter, r *http.Request) {
 		handleRealtimeStream(w, r, h.app, sessionID)
 		return
 	}
-	http.NotFound(w, r)
+	if len(parts) == 2 && parts[1] == "stream" && r.Method == http.MethodPost {
+		var req sendMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, invalidJSON(err))
+			return
+		}
+		handleMessageStream(w, r, h.app, sessionID, req.Prompt)
+		return
+	}
+	writeRouteMiss(w, r, sessionRoutes, strings.Join(parts[1:], "/"))
+}
+
+// sessionRoutes are the methods served under /v1/sessions/{id}, keyed by the
+// path after the session ID.
+var sessionRoutes = map[string][]string{
+	"":                {http.MethodGet, http.MethodDelete},
+	"messages":        {http.MethodPost},
+	"order/confirm":   {http.MethodPost},
+	"profile/confirm": {http.MethodPost},
+	"safety-check":    {http.MethodPost},
+	"transcript":      {http.MethodGet},
+	"interrupt":       {http.MethodPost},
+	"ws":              {http.MethodGet},
+	"stream":          {http.MethodGet, http.MethodPost},
 }
 
 func (h *Handler) handleRestaurantRoutes(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/restaurants/"), "/")
-	if len(parts) != 2 || parts[0] == "" {
-		http.NotFound(w, r)
+	if parts[0] == "" {
+		writeError(w, r, errRouteNotFound)
 		return
 	}
 	restaurantID := parts[0]
-	if parts[1] == "menu-tags" && r.Method == http.MethodPost {
+	if len(parts) == 1 {
+		h.handleRestaurant(w, r, restaurantID)
+		return
+	}
+	switch parts[1] {
+	case "menu-items":
+		h.handleMenuItems(w, r, restaurantID, parts[2:])
+		return
+	case "combos":
+		h.handleCombos(w, r, restaurantID, parts[2:])
+		return
+	case "menu":
+		h.handleMenuVersions(w, r, restaurantID, parts[2:])
+		return
+	case "ingredients":
+		h.handleIngredients(w, r, restaurantID, parts[2:])
+		return
+	case "policy":
+		h.handleSafetyPolicy(w, r, restaurantID, parts[2:])
+		return
+	case "allergen-mismatches":
+		if len(parts) == 2 {
+			h.handleAllergenMismatches(w, r, restaurantID)
+			return
+		}
+	}
+	if len(parts) != 2 {
+		writeError(w, r, errRouteNotFound)
+		return
+	}
+	if (parts[1] == "menu-tags" || parts[1] == "menu-extraction") && r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if parts[1] == "menu-tags" {
 		var req menuTaggingRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			writeError(w, r, invalidJSON(err))
 			return
 		}
-		enriched, err := h.app.TagMenuItems(r.Context(), restaurantID, req.MenuItems)
+		draft, err := h.app.TagMenuItems(r.Context(), restaurantID, req.MenuItems)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
-		writeJSON(w, map[string]any{"menuItems": enriched, "note": "Tags were auto-suggested to simplify allergy/diet filters for business owners."})
+		writeJSON(w, map[string]any{
+			"menuItems":   draft.MenuItems,
+			"menuVersion": draft.Version,
+			"note":        "Tags were auto-suggested to simplify allergy/diet filters for business owners. Publish the draft version to make it live.",
+		})
 		return
 	}
-	if parts[1] != "menu-extraction" || r.Method != http.MethodPost {
-		http.NotFound(w, r)
+	if parts[1] != "menu-extraction" {
+		writeError(w, r, errRouteNotFound)
 		return
 	}
 	var req imageUploadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		writeError(w, r, invalidJSON(err))
 		return
 	}
 	content, err := base64.StdEncoding.DecodeString(req.Base64)
 	if err != nil {
-		http.Error(w, "invalid base64 image", http.StatusBadRequest)
+		writeError(w, r, service.ValidationError("invalid base64 image", map[string]any{"field": "base64"}))
 		return
 	}
 	result, err := h.app.ExtractMenuFromImage(r.Context(), restaurantID, req.FileName, content)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	writeJSON(w, menuExtractionResponse{
-		ImagePath: result.ImagePath,
-		MenuItems: result.MenuItems,
-		Note:      "Vision extraction is optional for onboarding; for live interaction, use text/audio session APIs.",
+		ImagePath:   result.ImagePath,
+		MenuItems:   result.MenuItems,
+		MenuVersion: result.MenuVersion,
+		Note:        "Vision extraction is optional for onboarding; the extracted menu is saved as a draft version to review and publish. For live interaction, use text/audio session APIs.",
 	})
 }
 
+var errRouteNotFound = service.NewError(service.ErrorKindNotFound, "route not found", nil)
+
+// invalidJSON reports an undecodable request body.
+func invalidJSON(err error) error {
+	return service.ValidationError("invalid JSON", map[string]any{"cause": err.Error()})
+}
+
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// sseKeepaliveInterval spaces comment lines that keep idle proxies from closing the stream.
+var sseKeepaliveInterval = 15 * time.Second
+
+// handleRealtimeStream pushes session events as they are published. Each event
+// carries its bus ID so EventSource clients resume via Last-Event-ID; when the
+// missed events are no longer retained, a fresh `session` snapshot is sent.
 func handleRealtimeStream(w http.ResponseWriter, r *http.Request, app *service.ConciergeApp, sessionID string) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	flusher, ok := w.(http.Flusher)
+	lastEventID, _ := strconv.ParseUint(strings.TrimSpace(r.Header.Get("Last-Event-ID")), 10, 64)
+	session, err := app.GetSession(r.Context(), sessionID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sub := app.SubscribeSessionEvents(sessionID, session.RestaurantID, lastEventID)
+	defer sub.Close()
+
+	flusher, ok := startEventStream(w, r)
 	if !ok {
-		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
 		return
 	}
 	_, _ = w.Write([]byte("event: ready\ndata: stream-open\n\n"))
 	flusher.Flush()
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	for _, event := range sub.Replay {
+		writeSSEEvent(w, flusher, event)
+	}
+	if lastEventID == 0 || !sub.Complete {
+		_, _ = w.Write([]byte("id: " + strconv.FormatUint(sub.HeadID, 10) + "\n"))
+		writeSSE(w, flusher, "session", session)
+	}
+
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
 	for {
 		select {
 		case <-r.Context().Done():
 			return
-		case <-ticker.C:
-			session, err := app.GetSession(r.Context(), sessionID)
-			if err != nil {
+		case <-keepalive.C:
+			_, _ = w.Write([]byte(": keepalive\n\n"))
+			flusher.Flush()
+		case event, ok := <-sub.Events:
+			if !ok {
 				return
 			}
-			payload, _ := json.Marshal(session)
-			_, _ = w.Write([]byte("event: session\ndata: " + string(payload) + "\n\n"))
-			flusher.Flush()
+			writeSSEEvent(w, flusher, event)
 		}
 	}
 }
 
+func writeSSEEvent(w http.ResponseWriter, flusher http.Flusher, event domain.SessionEvent) {
+	_, _ = w.Write([]byte("id: " + strconv.FormatUint(event.ID, 10) + "\n"))
+	writeSSE(w, flusher, string(event.Type), event)
+}
+
+// handleSafetyCheck serves POST /v1/sessions/{id}/safety-check. An empty body
+// checks the whole session menu.
+func (h *Handler) handleSafetyCheck(w http.ResponseWriter, r *http.Request, sessionID string) {
+	var req safetyCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, invalidJSON(err))
+		return
+	}
+	items, err := h.app.SafetyCheck(r.Context(), sessionID, service.SafetyCheckInput{ItemIDs: req.ItemIDs, MenuItems: req.MenuItems})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, safetyCheckResponse{SessionID: sessionID, Items: items})
+}
+
+// handleMessageStream answers a prompt as server-sent events: one `partial` event
+// per reply chunk followed by a `turnComplete` event carrying the full reply.
+func handleMessageStream(w http.ResponseWriter, r *http.Request, app *service.ConciergeApp, sessionID, prompt string) {
+	session, err := app.GetSession(r.Context(), sessionID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if session.Status == domain.SessionStatusCompleted {
+		writeError(w, r, service.ErrSessionCompleted)
+		return
+	}
+	flusher, ok := startEventStream(w, r)
+	if !ok {
+		return
+	}
+	reply, err := app.StreamMessage(r.Context(), sessionID, prompt, func(delta string) error {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		writeSSE(w, flusher, "partial", map[string]string{"text": delta})
+		return nil
+	})
+	if err != nil {
+		writeSSE(w, flusher, "error", newAPIError(r.Context(), err))
+		return
+	}
+	writeSSE(w, flusher, "turnComplete", map[string]any{"reply": reply.Text, "safety": reply.Safety, "profileChanges": reply.ProfileChanges, "interventions": reply.Interventions, "toolCalls": reply.ToolCalls, "degraded": reply.Degraded, "turnComplete": true})
+}
+
+func startEventStream(w http.ResponseWriter, r *http.Request) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, errors.New("streaming unsupported"))
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	return flusher, true
+}
+
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, event string, v any) {
+	payload, _ := json.Marshal(v)
+	_, _ = w.Write([]byte("event: " + event + "\ndata: " + string(payload) + "\n\n"))
+	flusher.Flush()
+}
+
 func getenv(key, fallback string) string {
 	value := os.Getenv(key)
 	if strings.TrimSpace(value) == "" {
@@ -8,15 +8,16 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/gourmet-guide/backend/internal/agent"
+	"github.com/gourmet-guide/backend/internal/domain"
 	"github.com/gourmet-guide/backend/internal/gcp"
 	"github.com/gourmet-guide/backend/internal/service"
 )
@@ -31,14 +32,32 @@ func testServer() http.Handler {
 
 func createSession(t *testing.T, router http.Handler) string {
 	t.Helper()
-	startPayload := map[string]any{
+	return startSession(t, router, map[string]any{
 		"restaurantId":   "rest-e2e",
 		"hardAllergens":  []string{"peanut"},
 		"preferenceTags": []string{"vegan"},
 		"menuItems": []map[string]any{
 			{"name": "Tofu Bowl", "tags": []string{"vegan"}},
 		},
-	}
+	})
+}
+
+// createSessionWithFilteredMenu starts a session whose menu loses an item to the
+// allergen filter, so every reply carries a safety note.
+func createSessionWithFilteredMenu(t *testing.T, router http.Handler) string {
+	t.Helper()
+	return startSession(t, router, map[string]any{
+		"restaurantId":  "rest-filtered",
+		"hardAllergens": []string{"peanut"},
+		"menuItems": []map[string]any{
+			{"name": "Tofu Bowl", "tags": []string{"vegan"}},
+			{"name": "Peanut Curry", "allergens": []string{"peanut"}},
+		},
+	})
+}
+
+func startSession(t *testing.T, router http.Handler, startPayload map[string]any) string {
+	t.Helper()
 	body, _ := json.Marshal(startPayload)
 	req := httptest.NewRequest(http.MethodPost, "/v1/sessions", bytes.NewReader(body))
 	rec := httptest.NewRecorder()
@@ -104,6 +123,19 @@ func TestVoiceStreamingConfigEndpoint(t *testing.T) {
 	if !strings.Contains(rec.Body.String(), "\"input_mime_type\":\"audio/pcm\"") {
 		t.Fatalf("expected audio/pcm input mime type, got %s", rec.Body.String())
 	}
+	if !strings.Contains(rec.Body.String(), "\"prompt_version\":\"concierge-v2\"") || !strings.Contains(rec.Body.String(), "Safety rules.") {
+		t.Fatalf("expected the default concierge instruction, got %s", rec.Body.String())
+	}
+
+	created := doJSON(t, router, http.MethodPost, "/v1/restaurants", `{"id":"trattoria","name":"Trattoria","concierge":{"promptVersion":"concierge-v1","language":"Italian"}}`)
+	if created.Code != http.StatusCreated {
+		t.Fatalf("expected 201 from restaurant create, got %d (%s)", created.Code, created.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/realtime/voice-config?restaurantId=trattoria", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Reply in Italian.") || !strings.Contains(rec.Body.String(), "\"prompt_version\":\"concierge-v1\"") {
+		t.Fatalf("expected the restaurant's voice instruction, got %d (%s)", rec.Code, rec.Body.String())
+	}
 }
 
 func TestRealtimeWebSocketFlow(t *testing.T) {
@@ -126,12 +158,108 @@ func TestRealtimeWebSocketFlow(t *testing.T) {
 	writeWSText(t, rw, `{"type":"close"}`)
 }
 
+func TestRealtimeWebSocketStreamsPartialsBeforeSafetyNoteAndTurnComplete(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	sessionID := createSessionWithFilteredMenu(t, router)
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	conn, rw := dialWS(t, strings.TrimPrefix(srv.URL, "http://"), "/ws/user-1/"+sessionID)
+	defer conn.Close()
+	_ = readWSText(t, rw)
+
+	writeWSText(t, rw, `{"type":"text","text":"what is safe tonight?"}`)
+	partials, final := readWSTurn(t, rw)
+	if len(partials) < 2 {
+		t.Fatalf("expected multiple partial events, got %d", len(partials))
+	}
+	var streamed strings.Builder
+	for _, partial := range partials {
+		streamed.WriteString(partial.Text)
+	}
+	if streamed.String() != final.Text {
+		t.Fatalf("expected partials to add up to final reply\npartials: %q\nfinal:    %q", streamed.String(), final.Text)
+	}
+	if last := partials[len(partials)-1].Text; !strings.Contains(last, "Safety note:") {
+		t.Fatalf("expected safety note as last partial before turn completion, got %q", last)
+	}
+}
+
+func TestMessageStreamEndpointSendsPartialsThenTurnComplete(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	sessionID := createSessionWithFilteredMenu(t, router)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+"/stream", strings.NewReader(`{"prompt":"what is safe tonight?"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("expected event stream content type, got %q", got)
+	}
+	body := rec.Body.String()
+	firstPartial := strings.Index(body, "event: partial")
+	safetyNote := strings.Index(body, "Safety note:")
+	turnComplete := strings.Index(body, "event: turnComplete")
+	if firstPartial < 0 || turnComplete < 0 {
+		t.Fatalf("expected partial and turnComplete events, got %s", body)
+	}
+	if !(firstPartial < safetyNote && safetyNote < turnComplete) {
+		t.Fatalf("expected partials and safety note before turn completion, got %s", body)
+	}
+}
+
+func TestTranscriptEndpointReturnsRecordedTurns(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	sessionID := createSession(t, router)
+
+	for _, prompt := range []string{"I am allergic to peanuts", "what do you recommend?"} {
+		body, _ := json.Marshal(map[string]string{"prompt": prompt})
+		req := httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+"/messages", bytes.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 from send message, got %d (%s)", rec.Code, rec.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/sessions/"+sessionID+"/transcript", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 from transcript, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	var transcript struct {
+		SessionID string `json:"sessionId"`
+		Turns     []struct {
+			Role string `json:"role"`
+			Text string `json:"text"`
+		} `json:"turns"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &transcript); err != nil {
+		t.Fatalf("parse transcript: %v", err)
+	}
+	if transcript.SessionID != sessionID || len(transcript.Turns) != 4 {
+		t.Fatalf("expected 4 turns for %s, got %+v", sessionID, transcript)
+	}
+	if transcript.Turns[2].Role != "user" || transcript.Turns[2].Text != "what do you recommend?" {
+		t.Fatalf("unexpected third turn: %+v", transcript.Turns[2])
+	}
+	if !strings.Contains(transcript.Turns[3].Text, "I am allergic to peanuts") {
+		t.Fatalf("expected assistant to receive earlier turns as context, got %q", transcript.Turns[3].Text)
+	}
+}
+
 func TestStreamEndpointSendsReadyEventAndHandlesClientCancel(t *testing.T) {
 	t.Parallel()
 	router := testServer()
+	sessionID := createSession(t, router)
 
 	ctx, cancel := context.WithCancel(context.Background())
-	req := httptest.NewRequest(http.MethodGet, "/v1/sessions/unknown/stream", nil).WithContext(ctx)
+	req := httptest.NewRequest(http.MethodGet, "/v1/sessions/"+sessionID+"/stream", nil).WithContext(ctx)
 	rec := httptest.NewRecorder()
 	done := make(chan struct{})
 
@@ -154,6 +282,278 @@ func TestStreamEndpointSendsReadyEventAndHandlesClientCancel(t *testing.T) {
 	}
 }
 
+func TestUnknownSessionRoutesReturnNotFound(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/v1/sessions/missing", ""},
+		{http.MethodDelete, "/v1/sessions/missing", ""},
+		{http.MethodPost, "/v1/sessions/missing/interrupt", ""},
+		{http.MethodPost, "/v1/sessions/missing/messages", `{"prompt":"hi"}`},
+		{http.MethodGet, "/v1/sessions/missing/transcript", ""},
+		{http.MethodGet, "/v1/sessions/missing/stream", ""},
+		{http.MethodPost, "/v1/sessions/missing/stream", `{"prompt":"hi"}`},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404, got %d (%s)", tc.method, tc.path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestStartSessionWithoutMenuRequiresKnownRestaurant(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/sessions", strings.NewReader(`{"restaurantId":"nowhere"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a restaurant without a menu, got %d", rec.Code)
+	}
+
+	// A menu posted with a session only serves that session.
+	createSession(t, router)
+	req = httptest.NewRequest(http.MethodPost, "/v1/sessions", strings.NewReader(`{"restaurantId":"rest-e2e"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected the posted menu not to go live, got %d (%s)", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCompletedSessionRejectsMessagesWithConflict(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	sessionID := createSession(t, router)
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/sessions/"+sessionID, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 ending session, got %d", rec.Code)
+	}
+
+	for _, path := range []string{"/messages", "/interrupt", "/stream"} {
+		req = httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+path, strings.NewReader(`{"prompt":"hi"}`))
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("POST %s after end: expected 409, got %d", path, rec.Code)
+		}
+	}
+}
+
+// unavailableTranscriptStore simulates a storage outage on transcript reads.
+type unavailableTranscriptStore struct {
+	*gcp.MemoryStore
+}
+
+func (s unavailableTranscriptStore) LoadTranscript(context.Context, string) ([]domain.ConversationTurn, error) {
+	return nil, fmt.Errorf("%w: firestore down", gcp.ErrUnavailable)
+}
+
+func decodeAPIError(t *testing.T, rec *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON error body, got content type %q", ct)
+	}
+	var body apiError
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error envelope: %v (%s)", err, rec.Body.String())
+	}
+	return body
+}
+
+func TestErrorsUseJSONEnvelopeWithRequestID(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	sessionID := createSession(t, router)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/sessions/missing", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	body := decodeAPIError(t, rec)
+	if rec.Code != http.StatusNotFound || body.Code != service.ErrorKindNotFound || body.RequestID != "req-123" {
+		t.Fatalf("unexpected not-found envelope %d %+v", rec.Code, body)
+	}
+	if rec.Header().Get("X-Request-ID") != "req-123" {
+		t.Fatalf("expected request ID to be echoed, got %q", rec.Header().Get("X-Request-ID"))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+"/messages", strings.NewReader(`{"prompt":`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	body = decodeAPIError(t, rec)
+	if rec.Code != http.StatusBadRequest || body.Code != service.ErrorKindValidation || body.RequestID == "" {
+		t.Fatalf("unexpected invalid JSON envelope %d %+v", rec.Code, body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+"/messages", strings.NewReader(`{"prompt":"  "}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if body = decodeAPIError(t, rec); rec.Code != http.StatusBadRequest || body.Code != service.ErrorKindValidation {
+		t.Fatalf("unexpected empty prompt envelope %d %+v", rec.Code, body)
+	}
+}
+
+func TestWrongMethodsGetMethodNotAllowedEnvelopes(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	sessionID := createSession(t, router)
+
+	for _, tc := range []struct{ method, path, allow string }{
+		{http.MethodPut, "/v1/sessions/" + sessionID, "GET, DELETE"},
+		{http.MethodGet, "/v1/sessions/" + sessionID + "/messages", "POST"},
+		{http.MethodDelete, "/v1/sessions", "POST"},
+		{http.MethodPost, "/v1/metrics", "GET"},
+		{http.MethodPatch, "/v1/restaurants", "GET, POST"},
+		{http.MethodGet, "/v1/restaurants/r1/menu/versions/1/publish", "POST"},
+		{http.MethodDelete, "/v1/restaurants/r1/policy/dry-run", "POST"},
+		{http.MethodGet, "/v1/restaurants/r1/menu-tags", "POST"},
+		{http.MethodGet, "/v1/experiments/exp-1/stop", "POST"},
+	} {
+		rec := doJSON(t, router, tc.method, tc.path, "")
+		body := decodeAPIError(t, rec)
+		if rec.Code != http.StatusMethodNotAllowed || body.Code != service.ErrorKindMethodNotAllowed || body.RequestID == "" || rec.Header().Get("Allow") != tc.allow {
+			t.Errorf("%s %s: expected 405 allowing %s, got %d %+v (Allow %q)", tc.method, tc.path, tc.allow, rec.Code, body, rec.Header().Get("Allow"))
+		}
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/v1/sessions/"+sessionID+"/nowhere", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown sub-route, got %d", rec.Code)
+	}
+}
+
+func TestStoreOutageReturnsServiceUnavailable(t *testing.T) {
+	t.Parallel()
+	store := unavailableTranscriptStore{MemoryStore: gcp.NewMemoryStore()}
+	runtime := agent.NewRuntime("gemini", store)
+	concierge := agent.NewConciergeService(store, gcp.NewMemoryImageStore(), runtime)
+	router := NewHandler(service.NewConciergeApp(concierge)).Routes()
+	sessionID := createSession(t, router)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+"/messages", strings.NewReader(`{"prompt":"hi"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	body := decodeAPIError(t, rec)
+	if rec.Code != http.StatusServiceUnavailable || body.Code != service.ErrorKindUnavailable {
+		t.Fatalf("expected 503 unavailable, got %d %+v", rec.Code, body)
+	}
+}
+
+func TestSafetyRefusalsReturnUnprocessableEntity(t *testing.T) {
+	t.Parallel()
+	req := httptest.NewRequest(http.MethodPost, "/v1/sessions/s-1/order/confirm", nil)
+	rec := httptest.NewRecorder()
+	writeError(rec, req, fmt.Errorf("%w: Shrimp Tacos cannot be confirmed", service.ErrSafetyRefusal))
+	body := decodeAPIError(t, rec)
+	if rec.Code != http.StatusUnprocessableEntity || body.Code != service.ErrorKindSafetyRefusal {
+		t.Fatalf("expected 422 safety_refusal, got %d %+v", rec.Code, body)
+	}
+}
+
+type sseTestEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+func openSSE(t *testing.T, url, lastEventID string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("build stream request: %v", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return bufio.NewReader(resp.Body)
+}
+
+// readSSEEvent returns the next dispatched event, skipping comment lines.
+func readSSEEvent(t *testing.T, reader *bufio.Reader) sseTestEvent {
+	t.Helper()
+	var event sseTestEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if event.event != "" {
+				return event
+			}
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamEndpointPushesSessionEventsAndResumesFromLastEventID(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	sessionID := createSession(t, router)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close) // registered first so open streams are canceled before shutdown
+	streamURL := srv.URL + "/v1/sessions/" + sessionID + "/stream"
+
+	stream := openSSE(t, streamURL, "")
+	if ready := readSSEEvent(t, stream); ready.event != "ready" {
+		t.Fatalf("expected ready event, got %+v", ready)
+	}
+	snapshot := readSSEEvent(t, stream)
+	if snapshot.event != "session" || !strings.Contains(snapshot.data, sessionID) {
+		t.Fatalf("expected session snapshot, got %+v", snapshot)
+	}
+
+	resp, err := http.Post(srv.URL+"/v1/sessions/"+sessionID+"/messages", "application/json", strings.NewReader(`{"prompt":"vegan mains?"}`))
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	resp.Body.Close()
+
+	message := readSSEEvent(t, stream)
+	if message.event != "message" || !strings.Contains(message.data, "vegan mains?") {
+		t.Fatalf("expected pushed message event, got %+v", message)
+	}
+	messageID, _ := strconv.Atoi(message.id)
+	snapshotID, _ := strconv.Atoi(snapshot.id)
+	if messageID <= snapshotID {
+		t.Fatalf("expected event id after snapshot id %q, got %q", snapshot.id, message.id)
+	}
+
+	resumed := openSSE(t, streamURL, snapshot.id)
+	if ready := readSSEEvent(t, resumed); ready.event != "ready" {
+		t.Fatalf("expected ready event on resume, got %+v", ready)
+	}
+	replayed := readSSEEvent(t, resumed)
+	if replayed.event != "message" || replayed.id != message.id {
+		t.Fatalf("expected replay of missed message %s, got %+v", message.id, replayed)
+	}
+}
+
 func dialWS(t *testing.T, host, path string) (net.Conn, *bufio.ReadWriter) {
 	t.Helper()
 	conn, err := net.Dial("tcp", host)
@@ -198,37 +598,93 @@ func dialWS(t *testing.T, host, path string) (net.Conn, *bufio.ReadWriter) {
 
 func writeWSText(t *testing.T, rw *bufio.ReadWriter, payload string) {
 	t.Helper()
-	data := []byte(payload)
-	header := []byte{0x81, byte(0x80 | len(data))}
-	mask := []byte{0x11, 0x22, 0x33, 0x44}
-	masked := make([]byte, len(data))
-	for i := range data {
-		masked[i] = data[i] ^ mask[i%4]
+	writeWSFrameToServer(t, rw, true, wsOpText, []byte(payload))
+}
+
+func readWSText(t *testing.T, rw *bufio.ReadWriter) string {
+	t.Helper()
+	return string(readWSFrameFromServer(t, rw).payload)
+}
+
+func TestSafetyCheckReturnsPerItemVerdicts(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	sessionID := createSessionWithFilteredMenu(t, router)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+"/safety-check", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var body safetyCheckResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with verdicts, got %d (%s)", rec.Code, rec.Body.String())
 	}
-	if _, err := rw.Write(header); err != nil {
-		t.Fatalf("write ws header: %v", err)
+	if len(body.Items) != 2 || body.Items[0].Verdict != domain.VerdictSafe || body.Items[1].Verdict != domain.VerdictUnsafe {
+		t.Fatalf("expected safe tofu bowl and unsafe curry, got %+v", body.Items)
 	}
-	if _, err := rw.Write(mask); err != nil {
-		t.Fatalf("write ws mask: %v", err)
+	if trigger := body.Items[1].Triggers[0]; trigger.Allergen != domain.AllergenPeanut || trigger.Exposure != domain.ExposureDirect {
+		t.Fatalf("expected a direct peanut trigger, got %+v", trigger)
 	}
-	if _, err := rw.Write(masked); err != nil {
-		t.Fatalf("write ws payload: %v", err)
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+"/safety-check", strings.NewReader(`{"menuItems":[{"id":"special","name":"Chef special","crossContaminationRisk":["groundnuts"]}]}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Items) != 1 || body.Items[0].Triggers[0].Exposure != domain.ExposureCrossContact {
+		t.Fatalf("expected cross-contact verdict for the ad-hoc dish, got %d (%s)", rec.Code, rec.Body.String())
 	}
-	if err := rw.Flush(); err != nil {
-		t.Fatalf("flush ws payload: %v", err)
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+"/messages", strings.NewReader(`{"prompt":"hi"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var reply domain.AssistantReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.Text == "" || len(reply.Safety) != 2 {
+		t.Fatalf("expected the message reply to carry verdicts, got %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/sessions/missing/safety-check", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown session, got %d", rec.Code)
 	}
 }
 
-func readWSText(t *testing.T, rw *bufio.ReadWriter) string {
-	t.Helper()
-	head := make([]byte, 2)
-	if _, err := io.ReadFull(rw, head); err != nil {
-		t.Fatalf("read ws header: %v", err)
+func TestChatRetractionWaitsForProfileConfirmation(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	sessionID := createSession(t, router)
+
+	rec := doJSON(t, router, http.MethodPost, "/v1/sessions/"+sessionID+"/messages", `{"prompt":"I'm not allergic to peanuts"}`)
+	var reply domain.AssistantReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || len(reply.PendingRemovals) != 1 || reply.ProfileChanges != nil {
+		t.Fatalf("expected a pending peanut removal, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodPost, "/v1/sessions/"+sessionID+"/profile/confirm", "")
+	var session domain.ConciergeSession
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil || rec.Code != http.StatusOK || len(session.HardAllergens) != 0 || session.PendingRemovals != nil {
+		t.Fatalf("expected the confirmation to remove peanut, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	if rec := doJSON(t, router, http.MethodPost, "/v1/sessions/"+sessionID+"/profile/confirm", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 with nothing to confirm, got %d", rec.Code)
+	}
+}
+
+func TestMetricsEndpointReportsResponseCacheCounters(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	// Two identical first turns on the same menu and profile share a reply; the
+	// second session posts the same menu and reuses its draft version.
+	for _, sessionID := range []string{createSession(t, router), createSession(t, router)} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/sessions/"+sessionID+"/messages", strings.NewReader(`{"prompt":"what is good?"}`))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/metrics", nil))
+	var body service.Metrics
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with metrics, got %d (%s)", rec.Code, rec.Body.String())
 	}
-	length := int(head[1] & 0x7F)
-	payload := make([]byte, length)
-	if _, err := io.ReadFull(rw, payload); err != nil {
-		t.Fatalf("read ws payload: %v", err)
+	if cache := body.ResponseCache; cache.Hits != 1 || cache.Misses != 1 || cache.Capacity == 0 {
+		t.Fatalf("expected one miss then one hit, got %+v", cache)
 	}
-	return string(payload)
 }
@@ -0,0 +1,12 @@
+package http
+
+import "net/http"
+
+// handleMetrics serves GET /v1/metrics, the counters of this instance.
+func (h *Handler) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	writeJSON(w, h.app.Metrics())
+}
@@ -5,14 +5,16 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/service"
 )
 
 type realtimeInboundMessage struct {
@@ -26,22 +28,33 @@ type realtimeEvent struct {
 	Type          string `json:"type"`
 	Author        string `json:"author,omitempty"`
 	Text          string `json:"text,omitempty"`
+	Partial       bool   `json:"partial,omitempty"`
 	TurnComplete  bool   `json:"turnComplete,omitempty"`
 	Interrupted   bool   `json:"interrupted,omitempty"`
 	InputMimeType string `json:"inputMimeType,omitempty"`
+	// Safety carries per-dish verdicts on the event that completes a turn,
+	// ProfileChanges the allergies and diets the turn added or removed,
+	// Interventions what the reply guard removed from the model reply,
+	// ToolCalls the tools the model called and Degraded whether the reply
+	// came from the safety policy because no model answered.
+	Safety         []domain.ItemSafety         `json:"safety,omitempty"`
+	ProfileChanges []domain.ProfileChange      `json:"profileChanges,omitempty"`
+	Interventions  []domain.SafetyIntervention `json:"interventions,omitempty"`
+	ToolCalls      []domain.ToolInvocation     `json:"toolCalls,omitempty"`
+	Degraded       bool                        `json:"degraded,omitempty"`
 }
 
 const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
 
 func (h *Handler) handleRealtimeWebSocket(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, r, http.MethodGet)
 		return
 	}
 
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/ws/"), "/")
 	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		http.NotFound(w, r)
+		writeError(w, r, errRouteNotFound)
 		return
 	}
 	h.handleRealtimeWS(w, r, parts[0], parts[1])
@@ -52,107 +65,205 @@ func (h *Handler) handleRealtimeWebSocketSession(w http.ResponseWriter, r *http.
 }
 
 func (h *Handler) handleRealtimeWS(w http.ResponseWriter, r *http.Request, _ string, sessionID string) {
-	rw, conn, err := upgradeToWebSocket(w, r)
+	rw, netConn, err := upgradeToWebSocket(w, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, service.ValidationError(err.Error(), nil))
 		return
 	}
-	defer conn.Close()
+	defer netConn.Close()
+	conn := newWSConn(rw, h.wsMaxMessageBytes)
 
 	if _, err := h.app.GetSession(r.Context(), sessionID); err != nil {
-		_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "session not found"})
-		_ = rw.Flush()
+		writeWSError(r.Context(), conn, err)
+		_ = conn.WriteClose(wsClosePolicyViolation, err.Error())
 		return
 	}
 
-	_ = writeWSTextJSON(rw, realtimeEvent{Type: "ready"})
-	_ = rw.Flush()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	turns := newRealtimeTurns(ctx, h.app, conn, sessionID)
+	defer turns.stop()
+
+	_ = conn.WriteJSON(realtimeEvent{Type: "ready"})
 
 	for {
-		opcode, payload, err := readWSFrame(rw.Reader)
+		opcode, payload, err := conn.ReadMessage()
 		if err != nil {
 			return
 		}
-
-		switch opcode {
-		case 0x2: // binary
-			_ = payload
-			_ = writeWSTextJSON(rw, realtimeEvent{Type: "audio_ack", InputMimeType: "audio/pcm"})
-			_ = rw.Flush()
-			continue
-		case 0x8: // close
-			_ = writeWSClose(rw, "session closed")
-			_ = rw.Flush()
-			return
-		case 0x1: // text
-		default:
-			_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "unsupported websocket opcode"})
-			_ = rw.Flush()
+		if opcode == wsOpBinary {
+			_ = conn.WriteJSON(realtimeEvent{Type: "audio_ack", InputMimeType: "audio/pcm"})
 			continue
 		}
 
 		var message realtimeInboundMessage
 		if err := json.Unmarshal(payload, &message); err != nil {
-			_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "invalid JSON message"})
-			_ = rw.Flush()
+			writeWSError(ctx, conn, service.ValidationError("invalid JSON message", nil))
 			continue
 		}
 
 		switch message.Type {
 		case "text":
-			reply, err := h.app.SendMessage(context.Background(), sessionID, message.Text)
-			if err != nil {
-				_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": err.Error()})
-				_ = rw.Flush()
-				continue
+			turns.start(message.Text)
+		case "interrupt":
+			if !turns.interrupt() {
+				// No turn in flight: the session is left alone.
+				_ = conn.WriteJSON(realtimeEvent{Type: "interrupt_ack"})
 			}
-			_ = writeWSTextJSON(rw, realtimeEvent{Type: "event", Author: "assistant", Text: reply, TurnComplete: true})
 		case "audio":
 			if message.Data == "" {
-				_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "audio data is required"})
-				_ = rw.Flush()
+				writeWSError(ctx, conn, service.ValidationError("audio data is required", nil))
 				continue
 			}
 			if _, err := base64.StdEncoding.DecodeString(message.Data); err != nil {
-				_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "invalid base64 audio payload"})
-				_ = rw.Flush()
+				writeWSError(ctx, conn, service.ValidationError("invalid base64 audio payload", nil))
 				continue
 			}
-			_ = writeWSTextJSON(rw, realtimeEvent{Type: "audio_ack", InputMimeType: "audio/pcm"})
+			_ = conn.WriteJSON(realtimeEvent{Type: "audio_ack", InputMimeType: "audio/pcm"})
 		case "image":
 			if message.Data == "" {
-				_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "image data is required"})
-				_ = rw.Flush()
+				writeWSError(ctx, conn, service.ValidationError("image data is required", nil))
 				continue
 			}
 			if _, err := base64.StdEncoding.DecodeString(message.Data); err != nil {
-				_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "invalid base64 image payload"})
-				_ = rw.Flush()
+				writeWSError(ctx, conn, service.ValidationError("invalid base64 image payload", nil))
 				continue
 			}
-			_ = writeWSTextJSON(rw, realtimeEvent{Type: "image_ack"})
+			_ = conn.WriteJSON(realtimeEvent{Type: "image_ack"})
 		case "activity_start":
 			if !getenvBool("ENABLE_MANUAL_ACTIVITY_SIGNALS", false) {
-				_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "activity_start ignored: manual activity signals disabled"})
-				_ = rw.Flush()
+				writeWSError(ctx, conn, service.ValidationError("activity_start ignored: manual activity signals disabled", nil))
 				continue
 			}
-			_ = writeWSTextJSON(rw, realtimeEvent{Type: "activity_start_ack"})
+			turns.interrupt()
+			_ = conn.WriteJSON(realtimeEvent{Type: "activity_start_ack"})
 		case "activity_end":
 			if !getenvBool("ENABLE_MANUAL_ACTIVITY_SIGNALS", false) {
-				_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "activity_end ignored: manual activity signals disabled"})
-				_ = rw.Flush()
+				writeWSError(ctx, conn, service.ValidationError("activity_end ignored: manual activity signals disabled", nil))
 				continue
 			}
-			_ = writeWSTextJSON(rw, realtimeEvent{Type: "activity_end_ack", TurnComplete: true})
+			_ = conn.WriteJSON(realtimeEvent{Type: "activity_end_ack", TurnComplete: true})
 		case "close":
-			_ = writeWSClose(rw, "session closed")
-			_ = rw.Flush()
+			_ = conn.WriteClose(wsCloseNormal, "session closed")
 			return
 		default:
-			_ = writeWSTextJSON(rw, map[string]any{"type": "error", "errorMessage": "unsupported websocket message type"})
+			writeWSError(ctx, conn, service.ValidationError("unsupported websocket message type", nil))
 		}
-		_ = rw.Flush()
+	}
+}
+
+// realtimeTurns runs assistant turns for one websocket connection off the read
+// loop, so interrupts and new activity can be received while a reply streams.
+// At most one turn is in flight; starting another barges in on the current one.
+type realtimeTurns struct {
+	ctx       context.Context
+	app       *service.ConciergeApp
+	conn      *wsConn
+	sessionID string
+
+	mu sync.Mutex
+	// current is the turn an interrupt would stop; last is the newest turn,
+	// which may still be reporting its interruption.
+	current *realtimeTurn
+	last    *realtimeTurn
+}
+
+// realtimeTurn is one assistant turn. Exactly one of ended and interrupted is
+// set, under realtimeTurns.mu, and it decides the turn's final event.
+type realtimeTurn struct {
+	cancel      context.CancelFunc
+	done        chan struct{}
+	ended       bool
+	interrupted bool
+}
+
+func newRealtimeTurns(ctx context.Context, app *service.ConciergeApp, conn *wsConn, sessionID string) *realtimeTurns {
+	return &realtimeTurns{ctx: ctx, app: app, conn: conn, sessionID: sessionID}
+}
+
+func (t *realtimeTurns) start(prompt string) {
+	t.interrupt()
+
+	turnCtx, cancel := context.WithCancel(t.ctx)
+	turn := &realtimeTurn{cancel: cancel, done: make(chan struct{})}
+	t.mu.Lock()
+	previous := t.last
+	t.current, t.last = turn, turn
+	t.mu.Unlock()
+
+	go func() {
+		defer close(turn.done)
+		defer cancel()
+		if previous != nil {
+			// The barged-in turn reports its interruption first, so none of its
+			// events follow this turn's.
+			<-previous.done
+		}
+		reply, err := t.app.StreamMessage(turnCtx, t.sessionID, prompt, func(delta string) error {
+			if err := turnCtx.Err(); err != nil {
+				return err
+			}
+			return t.conn.WriteJSON(realtimeEvent{Type: "event", Author: "assistant", Text: delta, Partial: true})
+		})
+		if !t.end(turn) {
+			// Reported once the turn has stopped writing, so no partial follows the event.
+			if err := t.app.InterruptSession(t.ctx, t.sessionID); err != nil {
+				writeWSError(t.ctx, t.conn, err)
+			}
+			_ = t.conn.WriteJSON(realtimeEvent{Type: "event", Author: "assistant", Interrupted: true})
+			return
+		}
+		if turnCtx.Err() != nil {
+			// The connection is closing; nothing more belongs to this turn.
+			return
+		}
+		if err != nil {
+			writeWSError(t.ctx, t.conn, err)
+			return
+		}
+		_ = t.conn.WriteJSON(realtimeEvent{Type: "event", Author: "assistant", Text: reply.Text, TurnComplete: true, Safety: reply.Safety, ProfileChanges: reply.ProfileChanges, Interventions: reply.Interventions, ToolCalls: reply.ToolCalls, Degraded: reply.Degraded})
+	}()
+}
+
+// end claims the final event of turn for its outcome. It reports false when an
+// interrupt got there first.
+func (t *realtimeTurns) end(turn *realtimeTurn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if turn.interrupted {
+		return false
+	}
+	turn.ended = true
+	return true
+}
+
+// interrupt cancels the in-flight turn, which then marks the session
+// interrupted and sends the interrupted event itself. It does not wait for the
+// turn, so the read loop keeps reading while a write is stalled. It reports
+// false when no turn was left to interrupt.
+func (t *realtimeTurns) interrupt() bool {
+	t.mu.Lock()
+	turn := t.current
+	t.current = nil
+	if turn == nil || turn.ended {
+		t.mu.Unlock()
+		return false
+	}
+	turn.interrupted = true
+	t.mu.Unlock()
+	turn.cancel()
+	return true
+}
+
+// stop cancels any in-flight turn without touching the session and waits for it.
+func (t *realtimeTurns) stop() {
+	t.mu.Lock()
+	turn := t.last
+	t.current, t.last = nil, nil
+	t.mu.Unlock()
+	if turn != nil {
+		turn.cancel()
+		<-turn.done
 	}
 }
 
@@ -167,6 +278,9 @@ func upgradeToWebSocket(w http.ResponseWriter, r *http.Request) (*bufio.ReadWrit
 	if key == "" {
 		return nil, nil, errors.New("missing Sec-WebSocket-Key")
 	}
+	if version := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Version")); version != "13" {
+		return nil, nil, errors.New("unsupported Sec-WebSocket-Version")
+	}
 
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
@@ -190,77 +304,3 @@ func upgradeToWebSocket(w http.ResponseWriter, r *http.Request) (*bufio.ReadWrit
 	}
 	return rw, conn, nil
 }
-
-func readWSFrame(r *bufio.Reader) (byte, []byte, error) {
-	head := make([]byte, 2)
-	if _, err := io.ReadFull(r, head); err != nil {
-		return 0, nil, err
-	}
-	opcode := head[0] & 0x0F
-	masked := (head[1] & 0x80) != 0
-	if !masked {
-		return 0, nil, errors.New("client frames must be masked")
-	}
-
-	payloadLen := int(head[1] & 0x7F)
-	switch payloadLen {
-	case 126:
-		ext := make([]byte, 2)
-		if _, err := io.ReadFull(r, ext); err != nil {
-			return 0, nil, err
-		}
-		payloadLen = int(binary.BigEndian.Uint16(ext))
-	case 127:
-		ext := make([]byte, 8)
-		if _, err := io.ReadFull(r, ext); err != nil {
-			return 0, nil, err
-		}
-		payloadLen = int(binary.BigEndian.Uint64(ext))
-	}
-
-	mask := make([]byte, 4)
-	if _, err := io.ReadFull(r, mask); err != nil {
-		return 0, nil, err
-	}
-	payload := make([]byte, payloadLen)
-	if _, err := io.ReadFull(r, payload); err != nil {
-		return 0, nil, err
-	}
-	for i := range payload {
-		payload[i] ^= mask[i%4]
-	}
-	return opcode, payload, nil
-}
-
-func writeWSFrame(w *bufio.Writer, opcode byte, payload []byte) error {
-	if len(payload) > 125 {
-		return errors.New("payload too large for basic websocket frame")
-	}
-	header := []byte{0x80 | opcode, byte(len(payload))}
-	if _, err := w.Write(header); err != nil {
-		return err
-	}
-	_, err := w.Write(payload)
-	return err
-}
-
-func writeWSTextJSON(w *bufio.ReadWriter, value any) error {
-	payload, err := json.Marshal(value)
-	if err != nil {
-		return err
-	}
-	return writeWSFrame(w.Writer, 0x1, payload)
-}
-
-func writeWSClose(w *bufio.ReadWriter, reason string) error {
-	payload := make([]byte, 2+len(reason))
-	binary.BigEndian.PutUint16(payload[:2], 1000)
-	copy(payload[2:], reason)
-	if len(payload) > 125 {
-		payload = payload[:125]
-	}
-	if err := writeWSFrame(w.Writer, 0x8, payload); err != nil {
-		return err
-	}
-	return w.Flush()
-}
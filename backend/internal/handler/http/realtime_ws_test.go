@@ -0,0 +1,497 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gourmet-guide/backend/internal/agent"
+	"github.com/gourmet-guide/backend/internal/gcp"
+	"github.com/gourmet-guide/backend/internal/service"
+)
+
+type wsTestFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// stallingClient streams an opening sentence for every reply, then stalls the
+// first prompt that mentions "slow" until the turn is canceled. The opening is a
+// complete sentence because the reply guard only forwards complete sentences.
+type stallingClient struct {
+	stalled atomic.Bool
+}
+
+const stallingOpening = "One moment. "
+
+func (c *stallingClient) Generate(_ context.Context, _, prompt string) (string, error) {
+	return stallingOpening + "reply: " + prompt, nil
+}
+
+func (c *stallingClient) GenerateStream(ctx context.Context, modelName, prompt string, onDelta func(string) error) (string, error) {
+	reply, _ := c.Generate(ctx, modelName, prompt)
+	if err := onDelta(stallingOpening); err != nil {
+		return "", err
+	}
+	if strings.Contains(prompt, "slow") && c.stalled.CompareAndSwap(false, true) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return reply, onDelta(strings.TrimPrefix(reply, stallingOpening))
+}
+
+func openRealtimeWS(t *testing.T, maxMessageBytes int) (net.Conn, *bufio.ReadWriter) {
+	t.Helper()
+	conn, rw, _, _ := openRealtimeWSWithClient(t, maxMessageBytes, nil)
+	return conn, rw
+}
+
+// openRealtimeWSWithClient dials a fresh server whose runtime uses client (the
+// default echo client when nil) and also returns its router and session ID.
+func openRealtimeWSWithClient(t *testing.T, maxMessageBytes int, client agent.Client) (net.Conn, *bufio.ReadWriter, http.Handler, string) {
+	t.Helper()
+	store := gcp.NewMemoryStore()
+	runtime := agent.NewRuntime("gemini", store)
+	if client != nil {
+		runtime = agent.NewRuntimeWithClient("gemini", store, client)
+	}
+	concierge := agent.NewConciergeService(store, gcp.NewMemoryImageStore(), runtime)
+	handler := NewHandler(service.NewConciergeApp(concierge))
+	if maxMessageBytes > 0 {
+		handler.wsMaxMessageBytes = maxMessageBytes
+	}
+	router := handler.Routes()
+	sessionID := createSession(t, router)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	conn, rw := dialWS(t, strings.TrimPrefix(srv.URL, "http://"), "/ws/user-1/"+sessionID)
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	ready := readWSFrameFromServer(t, rw)
+	if ready.opcode != wsOpText || !strings.Contains(string(ready.payload), `"type":"ready"`) {
+		t.Fatalf("expected ready event, got opcode %d payload %s", ready.opcode, ready.payload)
+	}
+	return conn, rw, router, sessionID
+}
+
+func writeWSFrameToServer(t *testing.T, rw *bufio.ReadWriter, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	header := []byte{first}
+	switch length := len(payload); {
+	case length <= 125:
+		header = append(header, 0x80|byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 0x80|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header = append(header, 0x80|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	mask := []byte{0x11, 0x22, 0x33, 0x44}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	for _, chunk := range [][]byte{header, mask, masked} {
+		if _, err := rw.Write(chunk); err != nil {
+			t.Fatalf("write ws frame: %v", err)
+		}
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatalf("flush ws frame: %v", err)
+	}
+}
+
+func readWSFrameFromServer(t *testing.T, rw *bufio.ReadWriter) wsTestFrame {
+	t.Helper()
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(rw, head); err != nil {
+		t.Fatalf("read ws header: %v", err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("server frames must not be masked")
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(rw, ext); err != nil {
+			t.Fatalf("read ws extended length: %v", err)
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(rw, ext); err != nil {
+			t.Fatalf("read ws extended length: %v", err)
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(rw, payload); err != nil {
+		t.Fatalf("read ws payload: %v", err)
+	}
+	return wsTestFrame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0F, payload: payload}
+}
+
+func expectWSClose(t *testing.T, rw *bufio.ReadWriter, code uint16) {
+	t.Helper()
+	frame := readWSFrameFromServer(t, rw)
+	if frame.opcode != wsOpClose {
+		t.Fatalf("expected close frame, got opcode %d payload %s", frame.opcode, frame.payload)
+	}
+	if len(frame.payload) < 2 {
+		t.Fatalf("expected close status code, got %v", frame.payload)
+	}
+	if got := binary.BigEndian.Uint16(frame.payload[:2]); got != code {
+		t.Fatalf("expected close code %d, got %d (%s)", code, got, frame.payload[2:])
+	}
+}
+
+func textMessage(t *testing.T, text string) []byte {
+	t.Helper()
+	payload, err := json.Marshal(realtimeInboundMessage{Type: "text", Text: text})
+	if err != nil {
+		t.Fatalf("marshal text message: %v", err)
+	}
+	return payload
+}
+
+// readWSTurn collects partial events until the turn-complete event arrives.
+func readWSTurn(t *testing.T, rw *bufio.ReadWriter) ([]realtimeEvent, realtimeEvent) {
+	t.Helper()
+	var partials []realtimeEvent
+	for {
+		frame := readWSFrameFromServer(t, rw)
+		if frame.opcode != wsOpText || !frame.fin {
+			t.Fatalf("expected final text frame, got opcode %d fin %v", frame.opcode, frame.fin)
+		}
+		var event realtimeEvent
+		if err := json.Unmarshal(frame.payload, &event); err != nil {
+			t.Fatalf("decode event of %d bytes: %v", len(frame.payload), err)
+		}
+		if event.Type != "event" {
+			t.Fatalf("expected assistant event, got %s", frame.payload)
+		}
+		if event.TurnComplete {
+			return partials, event
+		}
+		if !event.Partial {
+			t.Fatalf("expected partial event before turn completion, got %s", frame.payload)
+		}
+		partials = append(partials, event)
+	}
+}
+
+func TestRealtimeWSDeliversRepliesWithExtendedLengths(t *testing.T) {
+	t.Parallel()
+	for _, size := range []int{200, 70000} {
+		_, rw := openRealtimeWS(t, 0)
+		writeWSFrameToServer(t, rw, true, wsOpText, textMessage(t, strings.Repeat("a", size)))
+
+		_, event := readWSTurn(t, rw)
+		if !strings.Contains(event.Text, strings.Repeat("a", size)) {
+			t.Fatalf("expected complete reply echoing %d-byte prompt, got %d bytes", size, len(event.Text))
+		}
+	}
+}
+
+func TestRealtimeWSReassemblesFragmentsAndAnswersInterleavedPing(t *testing.T) {
+	t.Parallel()
+	_, rw := openRealtimeWS(t, 0)
+
+	message := textMessage(t, "fragmented hello")
+	writeWSFrameToServer(t, rw, false, wsOpText, message[:5])
+	writeWSFrameToServer(t, rw, true, wsOpPing, []byte("keepalive"))
+	writeWSFrameToServer(t, rw, false, wsOpContinuation, message[5:10])
+	writeWSFrameToServer(t, rw, true, wsOpContinuation, message[10:])
+
+	pong := readWSFrameFromServer(t, rw)
+	if pong.opcode != wsOpPong || string(pong.payload) != "keepalive" {
+		t.Fatalf("expected pong echoing ping payload, got opcode %d payload %q", pong.opcode, pong.payload)
+	}
+	_, reply := readWSTurn(t, rw)
+	if !strings.Contains(reply.Text, "fragmented hello") {
+		t.Fatalf("expected reply to reassembled message, got %q", reply.Text)
+	}
+}
+
+func readWSEvent(t *testing.T, rw *bufio.ReadWriter) realtimeEvent {
+	t.Helper()
+	frame := readWSFrameFromServer(t, rw)
+	var event realtimeEvent
+	if err := json.Unmarshal(frame.payload, &event); err != nil {
+		t.Fatalf("decode event %s: %v", frame.payload, err)
+	}
+	return event
+}
+
+func TestRealtimeWSInterruptCancelsInFlightTurn(t *testing.T) {
+	t.Parallel()
+	_, rw, router, sessionID := openRealtimeWSWithClient(t, 0, &stallingClient{})
+
+	writeWSFrameToServer(t, rw, true, wsOpText, textMessage(t, "something slow please"))
+	if first := readWSEvent(t, rw); !first.Partial {
+		t.Fatalf("expected partial before interrupt, got %+v", first)
+	}
+
+	writeWSFrameToServer(t, rw, true, wsOpText, []byte(`{"type":"interrupt"}`))
+	interrupted := readWSEvent(t, rw)
+	if !interrupted.Interrupted || interrupted.TurnComplete {
+		t.Fatalf("expected interrupted event without turn completion, got %+v", interrupted)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/sessions/"+sessionID, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `"status":"interrupted"`) {
+		t.Fatalf("expected interrupted session status, got %s", rec.Body.String())
+	}
+
+	writeWSFrameToServer(t, rw, true, wsOpText, textMessage(t, "quick question"))
+	_, final := readWSTurn(t, rw)
+	if !strings.Contains(final.Text, "quick question") {
+		t.Fatalf("expected next turn to complete normally, got %q", final.Text)
+	}
+}
+
+func TestRealtimeWSInterruptWithoutTurnOnlyAcknowledges(t *testing.T) {
+	t.Parallel()
+	_, rw, router, sessionID := openRealtimeWSWithClient(t, 0, &stallingClient{})
+
+	writeWSFrameToServer(t, rw, true, wsOpText, []byte(`{"type":"interrupt"}`))
+	if ack := readWSEvent(t, rw); ack.Type != "interrupt_ack" || ack.Interrupted {
+		t.Fatalf("expected only an interrupt ack, got %+v", ack)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/sessions/"+sessionID, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if strings.Contains(rec.Body.String(), `"status":"interrupted"`) {
+		t.Fatalf("expected the session to stay as it was, got %s", rec.Body.String())
+	}
+}
+
+func TestRealtimeWSInterruptAfterCompletedTurnOnlyAcknowledges(t *testing.T) {
+	t.Parallel()
+	_, rw, router, sessionID := openRealtimeWSWithClient(t, 0, nil)
+
+	writeWSFrameToServer(t, rw, true, wsOpText, textMessage(t, "quick question"))
+	readWSTurn(t, rw)
+	writeWSFrameToServer(t, rw, true, wsOpText, []byte(`{"type":"interrupt"}`))
+	if ack := readWSEvent(t, rw); ack.Type != "interrupt_ack" || ack.Interrupted {
+		t.Fatalf("expected only an interrupt ack after the turn completed, got %+v", ack)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/sessions/"+sessionID, nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if strings.Contains(rec.Body.String(), `"status":"interrupted"`) {
+		t.Fatalf("expected the completed turn to stay completed, got %s", rec.Body.String())
+	}
+}
+
+func TestRealtimeWSNewTextBargesInOnInFlightTurn(t *testing.T) {
+	t.Parallel()
+	_, rw, _, _ := openRealtimeWSWithClient(t, 0, &stallingClient{})
+
+	writeWSFrameToServer(t, rw, true, wsOpText, textMessage(t, "something slow please"))
+	if first := readWSEvent(t, rw); !first.Partial {
+		t.Fatalf("expected partial before barge-in, got %+v", first)
+	}
+
+	writeWSFrameToServer(t, rw, true, wsOpText, textMessage(t, "actually, dessert"))
+	if interrupted := readWSEvent(t, rw); !interrupted.Interrupted {
+		t.Fatalf("expected interrupted event for barge-in, got %+v", interrupted)
+	}
+	_, final := readWSTurn(t, rw)
+	if !strings.Contains(final.Text, "actually, dessert") {
+		t.Fatalf("expected barge-in prompt to be answered, got %q", final.Text)
+	}
+}
+
+func TestRealtimeWSIgnoresUnsolicitedPong(t *testing.T) {
+	t.Parallel()
+	_, rw := openRealtimeWS(t, 0)
+
+	writeWSFrameToServer(t, rw, true, wsOpPong, nil)
+	writeWSFrameToServer(t, rw, true, wsOpText, []byte(`{"type":"image","data":"aGk="}`))
+	frame := readWSFrameFromServer(t, rw)
+	if !strings.Contains(string(frame.payload), `"type":"image_ack"`) {
+		t.Fatalf("expected image ack after pong, got %s", frame.payload)
+	}
+}
+
+func TestRealtimeWSEchoesCloseHandshake(t *testing.T) {
+	t.Parallel()
+	_, rw := openRealtimeWS(t, 0)
+
+	payload := binary.BigEndian.AppendUint16(nil, 4000)
+	writeWSFrameToServer(t, rw, true, wsOpClose, append(payload, "bye"...))
+	expectWSClose(t, rw, 4000)
+}
+
+func TestRealtimeWSCloseWithoutStatusIsAnsweredNormally(t *testing.T) {
+	t.Parallel()
+	_, rw := openRealtimeWS(t, 0)
+
+	writeWSFrameToServer(t, rw, true, wsOpClose, nil)
+	expectWSClose(t, rw, wsCloseNormal)
+}
+
+func TestRealtimeWSProtocolViolations(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		send func(t *testing.T, rw *bufio.ReadWriter)
+		code uint16
+	}{
+		{
+			name: "unmasked client frame",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				_, _ = rw.Write([]byte{0x81, 0x02, '{', '}'})
+				_ = rw.Flush()
+			},
+			code: wsCloseProtocolError,
+		},
+		{
+			name: "reserved bits set",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, true, wsOpText|0x40, []byte("{}"))
+			},
+			code: wsCloseProtocolError,
+		},
+		{
+			name: "unknown opcode",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, true, 0x3, nil)
+			},
+			code: wsCloseProtocolError,
+		},
+		{
+			name: "continuation without start",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, true, wsOpContinuation, []byte("{}"))
+			},
+			code: wsCloseProtocolError,
+		},
+		{
+			name: "new message during fragmented message",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, false, wsOpText, []byte("{"))
+				writeWSFrameToServer(t, rw, true, wsOpText, []byte("{}"))
+			},
+			code: wsCloseProtocolError,
+		},
+		{
+			name: "fragmented control frame",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, false, wsOpPing, []byte("x"))
+			},
+			code: wsCloseProtocolError,
+		},
+		{
+			name: "oversized control frame",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, true, wsOpPing, make([]byte, 126))
+			},
+			code: wsCloseProtocolError,
+		},
+		{
+			name: "one byte close payload",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, true, wsOpClose, []byte{0x03})
+			},
+			code: wsCloseProtocolError,
+		},
+		{
+			name: "reserved close status",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, true, wsOpClose, binary.BigEndian.AppendUint16(nil, wsCloseNoStatus))
+			},
+			code: wsCloseProtocolError,
+		},
+		{
+			name: "invalid UTF-8 text",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, true, wsOpText, []byte{0xce, 0xba, 0xe1, 0xbd, 0xb9, 0xcf, 0x83, 0xce, 0xbc, 0xce, 0xb5, 0xed, 0xa0, 0x80})
+			},
+			code: wsCloseInvalidPayload,
+		},
+		{
+			name: "invalid UTF-8 split across fragments",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				writeWSFrameToServer(t, rw, false, wsOpText, []byte{'"', 0xce})
+				writeWSFrameToServer(t, rw, true, wsOpContinuation, []byte{'"'})
+			},
+			code: wsCloseInvalidPayload,
+		},
+		{
+			name: "invalid UTF-8 close reason",
+			send: func(t *testing.T, rw *bufio.ReadWriter) {
+				payload := binary.BigEndian.AppendUint16(nil, wsCloseNormal)
+				writeWSFrameToServer(t, rw, true, wsOpClose, append(payload, 0xff))
+			},
+			code: wsCloseInvalidPayload,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, rw := openRealtimeWS(t, 0)
+			tc.send(t, rw)
+			expectWSClose(t, rw, tc.code)
+		})
+	}
+}
+
+func TestRealtimeWSEnforcesMaxMessageSize(t *testing.T) {
+	t.Parallel()
+
+	t.Run("single frame", func(t *testing.T) {
+		t.Parallel()
+		_, rw := openRealtimeWS(t, 64)
+		writeWSFrameToServer(t, rw, true, wsOpText, make([]byte, 65))
+		expectWSClose(t, rw, wsCloseMessageTooBig)
+	})
+
+	t.Run("across fragments", func(t *testing.T) {
+		t.Parallel()
+		_, rw := openRealtimeWS(t, 64)
+		writeWSFrameToServer(t, rw, false, wsOpText, make([]byte, 40))
+		writeWSFrameToServer(t, rw, true, wsOpContinuation, make([]byte, 40))
+		expectWSClose(t, rw, wsCloseMessageTooBig)
+	})
+}
+
+func TestRealtimeWSRejectsUnsupportedVersion(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	req := httptest.NewRequest(http.MethodGet, "/ws/user-1/session-1", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unsupported version, got %d", rec.Code)
+	}
+}
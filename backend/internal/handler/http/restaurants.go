@@ -0,0 +1,387 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/service"
+)
+
+type restaurantListResponse struct {
+	Restaurants []domain.Restaurant `json:"restaurants"`
+}
+
+type menuItemListResponse struct {
+	MenuItems []domain.MenuItem `json:"menuItems"`
+}
+
+type comboListResponse struct {
+	Combos []domain.Combo `json:"combos"`
+}
+
+type ingredientListResponse struct {
+	Ingredients []domain.Ingredient `json:"ingredients"`
+}
+
+type allergenMismatchResponse struct {
+	Mismatches []domain.AllergenMismatch `json:"mismatches"`
+}
+
+type menuVersionListResponse struct {
+	Versions []domain.MenuVersion `json:"versions"`
+}
+
+type rollbackRequest struct {
+	Version int `json:"version"`
+}
+
+// handleRestaurants serves the /v1/restaurants collection.
+func (h *Handler) handleRestaurants(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		restaurants, err := h.app.ListRestaurants(r.Context())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if restaurants == nil {
+			restaurants = []domain.Restaurant{}
+		}
+		writeJSON(w, restaurantListResponse{Restaurants: restaurants})
+	case http.MethodPost:
+		var req domain.Restaurant
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, invalidJSON(err))
+			return
+		}
+		created, err := h.app.CreateRestaurant(r.Context(), req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSONStatus(w, http.StatusCreated, created)
+	default:
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleRestaurant serves /v1/restaurants/{id}.
+func (h *Handler) handleRestaurant(w http.ResponseWriter, r *http.Request, restaurantID string) {
+	switch r.Method {
+	case http.MethodGet:
+		restaurant, err := h.app.GetRestaurant(r.Context(), restaurantID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, restaurant)
+	case http.MethodPut:
+		var req domain.Restaurant
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, invalidJSON(err))
+			return
+		}
+		updated, err := h.app.ReplaceRestaurant(r.Context(), restaurantID, req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, updated)
+	case http.MethodDelete:
+		if err := h.app.DeleteRestaurant(r.Context(), restaurantID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handleMenuItems serves /v1/restaurants/{id}/menu-items and /menu-items/{itemId}.
+func (h *Handler) handleMenuItems(w http.ResponseWriter, r *http.Request, restaurantID string, rest []string) {
+	if len(rest) > 1 || (len(rest) == 1 && rest[0] == "") {
+		writeError(w, r, errRouteNotFound)
+		return
+	}
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			items, err := h.app.ListMenuItems(r.Context(), restaurantID)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			writeJSON(w, menuItemListResponse{MenuItems: items})
+		case http.MethodPost:
+			var req domain.MenuItem
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, r, invalidJSON(err))
+				return
+			}
+			created, err := h.app.AddMenuItem(r.Context(), restaurantID, req)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			writeJSONStatus(w, http.StatusCreated, created)
+		default:
+			writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+
+	itemID := rest[0]
+	switch r.Method {
+	case http.MethodGet:
+		item, err := h.app.GetMenuItem(r.Context(), restaurantID, itemID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, item)
+	case http.MethodPut:
+		var req domain.MenuItem
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, invalidJSON(err))
+			return
+		}
+		updated, err := h.app.ReplaceMenuItem(r.Context(), restaurantID, itemID, req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, updated)
+	case http.MethodDelete:
+		if err := h.app.DeleteMenuItem(r.Context(), restaurantID, itemID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handleCombos serves /v1/restaurants/{id}/combos and /combos/{comboId}.
+func (h *Handler) handleCombos(w http.ResponseWriter, r *http.Request, restaurantID string, rest []string) {
+	if len(rest) > 1 || (len(rest) == 1 && rest[0] == "") {
+		writeError(w, r, errRouteNotFound)
+		return
+	}
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			combos, err := h.app.ListCombos(r.Context(), restaurantID)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			writeJSON(w, comboListResponse{Combos: combos})
+		case http.MethodPost:
+			var req domain.Combo
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, r, invalidJSON(err))
+				return
+			}
+			created, err := h.app.AddCombo(r.Context(), restaurantID, req)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			writeJSONStatus(w, http.StatusCreated, created)
+		default:
+			writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+
+	comboID := rest[0]
+	switch r.Method {
+	case http.MethodGet:
+		combo, err := h.app.GetCombo(r.Context(), restaurantID, comboID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, combo)
+	case http.MethodPut:
+		var req domain.Combo
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, invalidJSON(err))
+			return
+		}
+		updated, err := h.app.ReplaceCombo(r.Context(), restaurantID, comboID, req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, updated)
+	case http.MethodDelete:
+		if err := h.app.DeleteCombo(r.Context(), restaurantID, comboID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handleIngredients serves /v1/restaurants/{id}/ingredients and /ingredients/{ingredientId}.
+func (h *Handler) handleIngredients(w http.ResponseWriter, r *http.Request, restaurantID string, rest []string) {
+	if len(rest) > 1 || (len(rest) == 1 && rest[0] == "") {
+		writeError(w, r, errRouteNotFound)
+		return
+	}
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			ingredients, err := h.app.ListIngredients(r.Context(), restaurantID)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			writeJSON(w, ingredientListResponse{Ingredients: ingredients})
+		case http.MethodPost:
+			var req domain.Ingredient
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, r, invalidJSON(err))
+				return
+			}
+			created, err := h.app.AddIngredient(r.Context(), restaurantID, req)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			writeJSONStatus(w, http.StatusCreated, created)
+		default:
+			writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+
+	ingredientID := rest[0]
+	switch r.Method {
+	case http.MethodGet:
+		ingredient, err := h.app.GetIngredient(r.Context(), restaurantID, ingredientID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, ingredient)
+	case http.MethodPut:
+		var req domain.Ingredient
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, invalidJSON(err))
+			return
+		}
+		updated, err := h.app.ReplaceIngredient(r.Context(), restaurantID, ingredientID, req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, updated)
+	case http.MethodDelete:
+		if err := h.app.DeleteIngredient(r.Context(), restaurantID, ingredientID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// handleAllergenMismatches serves GET /v1/restaurants/{id}/allergen-mismatches.
+func (h *Handler) handleAllergenMismatches(w http.ResponseWriter, r *http.Request, restaurantID string) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	mismatches, err := h.app.AllergenMismatches(r.Context(), restaurantID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, allergenMismatchResponse{Mismatches: mismatches})
+}
+
+// handleMenuVersions serves the versioned menu under /v1/restaurants/{id}/menu:
+// GET the live version, GET versions[/{n}], POST versions/{n}/publish and POST rollback.
+func (h *Handler) handleMenuVersions(w http.ResponseWriter, r *http.Request, restaurantID string, rest []string) {
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		live, err := h.app.LiveMenu(r.Context(), restaurantID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, live)
+	case len(rest) == 1 && rest[0] == "versions" && r.Method == http.MethodGet:
+		versions, err := h.app.ListMenuVersions(r.Context(), restaurantID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, menuVersionListResponse{Versions: versions})
+	case len(rest) == 1 && rest[0] == "rollback" && r.Method == http.MethodPost:
+		var req rollbackRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, r, invalidJSON(err))
+				return
+			}
+		}
+		live, err := h.app.RollbackMenu(r.Context(), restaurantID, req.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, live)
+	case len(rest) >= 2 && len(rest) <= 3 && rest[0] == "versions":
+		version, err := strconv.Atoi(rest[1])
+		if err != nil || version < 1 {
+			writeError(w, r, service.ValidationError("menu version must be a positive integer", map[string]any{"field": "version"}))
+			return
+		}
+		switch {
+		case len(rest) == 2 && r.Method == http.MethodGet:
+			menu, err := h.app.GetMenuVersion(r.Context(), restaurantID, version)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			writeJSON(w, menu)
+		case len(rest) == 3 && rest[2] == "publish" && r.Method == http.MethodPost:
+			published, err := h.app.PublishMenuVersion(r.Context(), restaurantID, version)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			writeJSON(w, published)
+		default:
+			writeRouteMiss(w, r, menuVersionRoutes, strings.Join(append([]string{"versions", "*"}, rest[2:]...), "/"))
+		}
+	default:
+		writeRouteMiss(w, r, menuVersionRoutes, strings.Join(rest, "/"))
+	}
+}
+
+// menuVersionRoutes are the methods served under /v1/restaurants/{id}/menu.
+var menuVersionRoutes = map[string][]string{
+	"":                   {http.MethodGet},
+	"versions":           {http.MethodGet},
+	"rollback":           {http.MethodPost},
+	"versions/*":         {http.MethodGet},
+	"versions/*/publish": {http.MethodPost},
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
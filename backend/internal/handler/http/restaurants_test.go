@@ -0,0 +1,243 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/service"
+)
+
+func doJSON(t *testing.T, router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// publishLatestMenu publishes the newest menu version of restaurantID.
+func publishLatestMenu(t *testing.T, router http.Handler, restaurantID string) {
+	t.Helper()
+	rec := doJSON(t, router, http.MethodGet, "/v1/restaurants/"+restaurantID+"/menu/versions", "")
+	var versions menuVersionListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &versions); err != nil || len(versions.Versions) == 0 {
+		t.Fatalf("expected menu versions, got %s (%v)", rec.Body.String(), err)
+	}
+	latest := versions.Versions[len(versions.Versions)-1].Version
+	rec = doJSON(t, router, http.MethodPost, "/v1/restaurants/"+restaurantID+"/menu/versions/"+strconv.Itoa(latest)+"/publish", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected published version, got %d (%s)", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRestaurantCRUDAndSessionStartFromStoredMenu(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+
+	rec := doJSON(t, router, http.MethodPost, "/v1/restaurants", `{"id":"bistro","name":"Bistro","menuItems":[{"id":"soup","name":"Vegan Soup"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating restaurant, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodPost, "/v1/restaurants/bistro/menu-items", `{"id":"bread","name":"Bread","allergens":["wheat"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 adding menu item, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodPost, "/v1/restaurants/bistro/combos", `{"id":"lunch","name":"Lunch","itemIds":["soup","bread"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 adding combo, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodPost, "/v1/restaurants/bistro/combos", `{"name":"Ghost","itemIds":["nope"]}`)
+	if body := decodeAPIError(t, rec); rec.Code != http.StatusBadRequest || body.Code != service.ErrorKindValidation {
+		t.Fatalf("expected validation error for unknown combo item, got %d %+v", rec.Code, body)
+	}
+
+	rec = doJSON(t, router, http.MethodPut, "/v1/restaurants/bistro/menu-items/soup", `{"name":"Tomato Soup","description":"vegan"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Tomato Soup") {
+		t.Fatalf("expected updated item, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodDelete, "/v1/restaurants/bistro/menu-items/bread", "")
+	if body := decodeAPIError(t, rec); rec.Code != http.StatusConflict || body.Details["comboIds"] == nil {
+		t.Fatalf("expected 409 deleting an item used by a combo, got %d %+v", rec.Code, body)
+	}
+
+	rec = doJSON(t, router, http.MethodGet, "/v1/restaurants/bistro", "")
+	var restaurant domain.Restaurant
+	if err := json.Unmarshal(rec.Body.Bytes(), &restaurant); err != nil || len(restaurant.MenuItems) != 2 || len(restaurant.Combos) != 1 {
+		t.Fatalf("unexpected restaurant %s (%v)", rec.Body.String(), err)
+	}
+	rec = doJSON(t, router, http.MethodGet, "/v1/restaurants", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":"bistro"`) {
+		t.Fatalf("expected bistro in list, got %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	rec = doJSON(t, router, http.MethodPost, "/v1/sessions", `{"restaurantId":"bistro"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 before the menu is published, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodGet, "/v1/restaurants/bistro/menu/versions", "")
+	var versions menuVersionListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &versions); err != nil || len(versions.Versions) == 0 {
+		t.Fatalf("expected draft versions, got %s (%v)", rec.Body.String(), err)
+	}
+	latest := versions.Versions[len(versions.Versions)-1].Version
+	rec = doJSON(t, router, http.MethodPost, "/v1/restaurants/bistro/menu/versions/"+strconv.Itoa(latest)+"/publish", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"live":true`) {
+		t.Fatalf("expected published live version, got %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	rec = doJSON(t, router, http.MethodPost, "/v1/sessions", `{"restaurantId":"bistro","menuItems":[{"name":"Guest Special"}]}`)
+	if body := decodeAPIError(t, rec); rec.Code != http.StatusConflict || body.Details["field"] != "menuItems" {
+		t.Fatalf("expected 409 posting a menu for a managed restaurant, got %d %+v", rec.Code, body)
+	}
+	sessionID := startSession(t, router, map[string]any{"restaurantId": "bistro", "hardAllergens": []string{"wheat"}})
+	rec = doJSON(t, router, http.MethodPost, "/v1/sessions/"+sessionID+"/messages", `{"prompt":"what is safe?"}`)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "- Bread") || !strings.Contains(rec.Body.String(), "Excluded Bread: contains wheat") {
+		t.Fatalf("expected reply from the stored menu without the wheat item, got %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	rec = doJSON(t, router, http.MethodDelete, "/v1/restaurants/bistro", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 deleting restaurant, got %d", rec.Code)
+	}
+	rec = doJSON(t, router, http.MethodGet, "/v1/restaurants/bistro/combos", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", rec.Code)
+	}
+}
+
+func TestIngredientRoutesAndAllergenMismatchReport(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+
+	rec := doJSON(t, router, http.MethodPost, "/v1/restaurants", `{"id":"noodle-bar","name":"Noodle Bar","menuItems":[{"id":"pad-thai","name":"Pad Thai","description":"rice noodles, peanut sauce"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodPost, "/v1/restaurants/noodle-bar/ingredients", `{"id":"peanuts","name":"Peanuts","allergens":["peanut"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 adding ingredient, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodPut, "/v1/restaurants/noodle-bar/menu-items/pad-thai", `{"name":"Pad Thai","ingredientIds":["peanuts"]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"derivedAllergens":["peanut"]`) {
+		t.Fatalf("expected derived peanut allergen, got %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	rec = doJSON(t, router, http.MethodGet, "/v1/restaurants/noodle-bar/allergen-mismatches", "")
+	var report allergenMismatchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || len(report.Mismatches) != 1 {
+		t.Fatalf("expected one mismatch, got %s (%v)", rec.Body.String(), err)
+	}
+	if got := report.Mismatches[0].Undeclared; len(got) != 1 || got[0] != domain.AllergenPeanut {
+		t.Fatalf("expected undeclared peanut, got %+v", report.Mismatches[0])
+	}
+
+	rec = doJSON(t, router, http.MethodDelete, "/v1/restaurants/noodle-bar/ingredients/peanuts", "")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 deleting used ingredient, got %d", rec.Code)
+	}
+}
+
+func TestAllergenRegistryEndpoint(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+
+	rec := doJSON(t, router, http.MethodGet, "/v1/allergens?jurisdiction=EU", "")
+	var body allergenListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with registry, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	if body.Jurisdiction != domain.JurisdictionEU || len(body.Allergens) != 14 {
+		t.Fatalf("expected the EU-14, got %s with %d allergens", body.Jurisdiction, len(body.Allergens))
+	}
+	if rec := doJSON(t, router, http.MethodGet, "/v1/allergens?jurisdiction=mars", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown jurisdiction, got %d", rec.Code)
+	}
+}
+
+func TestSafetyPolicyRoutesVersionAndDryRun(t *testing.T) {
+	t.Parallel()
+	router := testServer()
+	rec := doJSON(t, router, http.MethodPost, "/v1/restaurants", `{"id":"diner","name":"Diner","menuItems":[{"id":"fries","name":"Fries","stations":["fryer"],"crossContaminationRisk":["fish"]},{"id":"salad","name":"Salad"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 creating restaurant, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	publishLatestMenu(t, router, "diner")
+	sessionID := startSession(t, router, map[string]any{
+		"restaurantId": "diner",
+		"allergies":    []map[string]any{{"allergen": "fish", "severity": "allergy"}},
+	})
+
+	rec = doJSON(t, router, http.MethodGet, "/v1/restaurants/diner/policy", "")
+	var policy domain.SafetyPolicy
+	if err := json.Unmarshal(rec.Body.Bytes(), &policy); err != nil || policy.Version != 0 || len(policy.Rules) == 0 {
+		t.Fatalf("expected the built-in policy as version 0, got %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	yamlPolicy := "rules:\n  - id: fryer-shared-oil\n    type: station_exposure\n    station: fryer\n"
+	req := httptest.NewRequest(http.MethodPost, "/v1/restaurants/diner/policy/dry-run", strings.NewReader("policy:\n  rules:\n    - id: fryer-shared-oil\n      type: station_exposure\n      station: fryer\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var dryRun domain.PolicyDryRun
+	if err := json.Unmarshal(rec.Body.Bytes(), &dryRun); err != nil || rec.Code != http.StatusOK || dryRun.ProfilesChecked == 0 || len(dryRun.Impacts) == 0 {
+		t.Fatalf("expected the fryer rule to affect dishes, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	for _, impact := range dryRun.Impacts {
+		if impact.After.ItemID != "fries" {
+			t.Fatalf("expected only the fries to change, got %+v", impact)
+		}
+	}
+	rec = doJSON(t, router, http.MethodGet, "/v1/restaurants/diner/policy/versions", "")
+	if !strings.Contains(rec.Body.String(), `"versions":[]`) {
+		t.Fatalf("expected the dry run to save nothing, got %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/v1/restaurants/diner/policy", strings.NewReader(yamlPolicy))
+	req.Header.Set("Content-Type", "application/yaml")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), &policy); err != nil || rec.Code != http.StatusOK || policy.Version != 1 {
+		t.Fatalf("expected version 1 saved from YAML, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodPut, "/v1/restaurants/diner/policy", `{"rules":[{"id":"bad","type":"station_exposure"}]}`)
+	if body := decodeAPIError(t, rec); rec.Code != http.StatusBadRequest || body.Code != service.ErrorKindValidation {
+		t.Fatalf("expected validation error for a rule without a station, got %d %+v", rec.Code, body)
+	}
+	rec = doJSON(t, router, http.MethodPut, "/v1/restaurants/diner/policy", `{"rules":[{"id":"relaxed","type":"severity_action","severity":"anaphylaxis","exposure":"direct","action":"ignore"}]}`)
+	if body := decodeAPIError(t, rec); rec.Code != http.StatusBadRequest || body.Code != service.ErrorKindValidation {
+		t.Fatalf("expected validation error for a policy ignoring anaphylaxis, got %d %+v", rec.Code, body)
+	}
+	rec = doJSON(t, router, http.MethodGet, "/v1/restaurants/diner/policy/versions/1", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "fryer-shared-oil") {
+		t.Fatalf("expected saved version 1, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodGet, "/v1/restaurants/diner/policy/versions/2", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unsaved version, got %d", rec.Code)
+	}
+
+	rec = doJSON(t, router, http.MethodPost, "/v1/sessions/"+sessionID+"/safety-check", "")
+	var check safetyCheckResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &check); err != nil || len(check.Items) != 2 {
+		t.Fatalf("expected verdicts, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	if fries := check.Items[0]; fries.Verdict != domain.VerdictUnsafe || fries.Triggers[0].Rule != "fryer-shared-oil" || fries.Triggers[0].Station != "fryer" {
+		t.Fatalf("expected the saved policy to exclude the fries, got %+v", fries)
+	}
+
+	rec = doJSON(t, router, http.MethodPost, "/v1/sessions/"+sessionID+"/messages", `{"prompt":"what is good?"}`)
+	var reply domain.AssistantReply
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.PolicyVersion != 1 {
+		t.Fatalf("expected the reply to name policy version 1, got %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec = doJSON(t, router, http.MethodGet, "/v1/sessions/"+sessionID+"/transcript", "")
+	var transcript transcriptResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &transcript); err != nil || len(transcript.Turns) != 2 || transcript.Turns[1].PolicyVersion != 1 {
+		t.Fatalf("expected the assistant turn to record policy version 1, got %s", rec.Body.String())
+	}
+}
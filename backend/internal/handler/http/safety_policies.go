@@ -0,0 +1,127 @@
+package http
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/service"
+)
+
+type safetyPolicyListResponse struct {
+	Versions []domain.SafetyPolicy `json:"versions"`
+}
+
+type policyDryRunRequest struct {
+	Policy   domain.SafetyPolicy    `json:"policy"`
+	Profiles []domain.PolicyProfile `json:"profiles,omitempty"`
+}
+
+// handleSafetyPolicy serves /v1/restaurants/{id}/policy, /policy/versions,
+// /policy/versions/{n} and /policy/dry-run.
+func (h *Handler) handleSafetyPolicy(w http.ResponseWriter, r *http.Request, restaurantID string, rest []string) {
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		policy, err := h.app.GetSafetyPolicy(r.Context(), restaurantID, 0)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, policy)
+	case len(rest) == 0 && r.Method == http.MethodPut:
+		var req domain.SafetyPolicy
+		if err := decodeConfig(r, &req); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		saved, err := h.app.SaveSafetyPolicy(r.Context(), restaurantID, req)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, saved)
+	case len(rest) == 0:
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPut)
+	case len(rest) == 1 && rest[0] == "versions" && r.Method == http.MethodGet:
+		versions, err := h.app.ListSafetyPolicies(r.Context(), restaurantID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if versions == nil {
+			versions = []domain.SafetyPolicy{}
+		}
+		writeJSON(w, safetyPolicyListResponse{Versions: versions})
+	case len(rest) == 2 && rest[0] == "versions" && r.Method == http.MethodGet:
+		version, err := strconv.Atoi(rest[1])
+		if err != nil || version < 1 {
+			writeError(w, r, service.ValidationError("policy version must be a positive integer", map[string]any{"field": "version"}))
+			return
+		}
+		policy, err := h.app.GetSafetyPolicy(r.Context(), restaurantID, version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, policy)
+	case len(rest) == 1 && rest[0] == "dry-run" && r.Method == http.MethodPost:
+		var req policyDryRunRequest
+		if err := decodeConfig(r, &req); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		result, err := h.app.DryRunSafetyPolicy(r.Context(), restaurantID, service.PolicyDryRunInput{Policy: req.Policy, Profiles: req.Profiles})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if result.Impacts == nil {
+			result.Impacts = []domain.PolicyImpact{}
+		}
+		writeJSON(w, result)
+	default:
+		path := strings.Join(rest, "/")
+		if len(rest) == 2 && rest[0] == "versions" {
+			path = "versions/*"
+		}
+		writeRouteMiss(w, r, policyRoutes, path)
+	}
+}
+
+// policyRoutes are the methods served under /v1/restaurants/{id}/policy.
+var policyRoutes = map[string][]string{
+	"versions":   {http.MethodGet},
+	"versions/*": {http.MethodGet},
+	"dry-run":    {http.MethodPost},
+}
+
+// decodeConfig decodes a JSON request body, or a YAML one when the Content-Type
+// says so. YAML goes through JSON so both formats share the same field names.
+func decodeConfig(r *http.Request, v any) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		var doc any
+		if err := yaml.NewDecoder(r.Body).Decode(&doc); err != nil {
+			return service.ValidationError("invalid YAML", map[string]any{"cause": err.Error()})
+		}
+		raw, err := json.Marshal(doc)
+		if err == nil {
+			err = json.Unmarshal(raw, v)
+		}
+		if err != nil {
+			return service.ValidationError("invalid YAML", map[string]any{"cause": err.Error()})
+		}
+		return nil
+	default:
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			return invalidJSON(err)
+		}
+		return nil
+	}
+}
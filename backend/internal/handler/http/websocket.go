@@ -0,0 +1,301 @@
+package http
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gourmet-guide/backend/internal/agent"
+)
+
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xA
+)
+
+const (
+	wsCloseNormal          uint16 = 1000
+	wsCloseProtocolError   uint16 = 1002
+	wsCloseNoStatus        uint16 = 1005
+	wsCloseInvalidPayload  uint16 = 1007
+	wsClosePolicyViolation uint16 = 1008
+	wsCloseMessageTooBig   uint16 = 1009
+	wsMaxControlPayload           = 125
+	defaultWSMaxMessageLen        = 1 << 20
+)
+
+// errWSPeerClosed reports that the peer completed the closing handshake.
+var errWSPeerClosed = errors.New("websocket closed by peer")
+
+// wsCloseError is a protocol failure that must end the connection with a close status.
+type wsCloseError struct {
+	Code   uint16
+	Reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket close %d: %s", e.Code, e.Reason)
+}
+
+// wsConn implements RFC 6455 framing on top of a hijacked HTTP connection.
+// Reads must happen from a single goroutine; writes are safe for concurrent use.
+type wsConn struct {
+	rw              *bufio.ReadWriter
+	maxMessageBytes int
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func newWSConn(rw *bufio.ReadWriter, maxMessageBytes int) *wsConn {
+	if maxMessageBytes <= 0 {
+		maxMessageBytes = defaultWSMaxMessageLen
+	}
+	return &wsConn{rw: rw, maxMessageBytes: maxMessageBytes}
+}
+
+// ReadMessage returns the next complete text or binary message. Ping, pong and
+// close frames are handled transparently. Any returned error ends the connection;
+// protocol violations are answered with the matching close frame before returning.
+func (c *wsConn) ReadMessage() (byte, []byte, error) {
+	opcode, payload, err := c.readMessage()
+	if err != nil {
+		var closeErr *wsCloseError
+		if errors.As(err, &closeErr) {
+			_ = c.WriteClose(closeErr.Code, closeErr.Reason)
+		}
+		return 0, nil, err
+	}
+	return opcode, payload, nil
+}
+
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var (
+		messageOpcode byte
+		message       []byte
+		fragmented    bool
+	)
+	for {
+		frame, err := c.readFrame(len(message))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frame.opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, frame.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			code, reason, err := parseClosePayload(frame.payload)
+			if err != nil {
+				return 0, nil, err
+			}
+			if code == wsCloseNoStatus {
+				code = wsCloseNormal
+			}
+			_ = c.WriteClose(code, reason)
+			return 0, nil, errWSPeerClosed
+		case wsOpContinuation:
+			if !fragmented {
+				return 0, nil, &wsCloseError{Code: wsCloseProtocolError, Reason: "unexpected continuation frame"}
+			}
+		case wsOpText, wsOpBinary:
+			if fragmented {
+				return 0, nil, &wsCloseError{Code: wsCloseProtocolError, Reason: "expected continuation frame"}
+			}
+			messageOpcode = frame.opcode
+			fragmented = true
+		}
+
+		message = append(message, frame.payload...)
+		if !frame.fin {
+			continue
+		}
+		if messageOpcode == wsOpText && !utf8.Valid(message) {
+			return 0, nil, &wsCloseError{Code: wsCloseInvalidPayload, Reason: "invalid UTF-8 in text message"}
+		}
+		if message == nil {
+			message = []byte{}
+		}
+		return messageOpcode, message, nil
+	}
+}
+
+// readFrame decodes a single client frame. buffered is the size of the partially
+// reassembled message so the size limit covers the whole message, not just one frame.
+func (c *wsConn) readFrame(buffered int) (wsFrame, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.rw, head); err != nil {
+		return wsFrame{}, err
+	}
+	frame := wsFrame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0F}
+	if head[0]&0x70 != 0 {
+		return wsFrame{}, &wsCloseError{Code: wsCloseProtocolError, Reason: "reserved bits must be zero"}
+	}
+	if !isKnownWSOpcode(frame.opcode) {
+		return wsFrame{}, &wsCloseError{Code: wsCloseProtocolError, Reason: "unknown opcode"}
+	}
+	if head[1]&0x80 == 0 {
+		return wsFrame{}, &wsCloseError{Code: wsCloseProtocolError, Reason: "client frames must be masked"}
+	}
+
+	payloadLen := uint64(head[1] & 0x7F)
+	switch payloadLen {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.rw, ext); err != nil {
+			return wsFrame{}, err
+		}
+		payloadLen = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.rw, ext); err != nil {
+			return wsFrame{}, err
+		}
+		payloadLen = binary.BigEndian.Uint64(ext)
+		if payloadLen>>63 != 0 {
+			return wsFrame{}, &wsCloseError{Code: wsCloseProtocolError, Reason: "invalid payload length"}
+		}
+	}
+
+	if isControlWSOpcode(frame.opcode) {
+		if !frame.fin {
+			return wsFrame{}, &wsCloseError{Code: wsCloseProtocolError, Reason: "control frames must not be fragmented"}
+		}
+		if payloadLen > wsMaxControlPayload {
+			return wsFrame{}, &wsCloseError{Code: wsCloseProtocolError, Reason: "control frame payload too large"}
+		}
+	} else if payloadLen > uint64(c.maxMessageBytes-buffered) {
+		return wsFrame{}, &wsCloseError{Code: wsCloseMessageTooBig, Reason: "message exceeds size limit"}
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.rw, mask); err != nil {
+		return wsFrame{}, err
+	}
+	frame.payload = make([]byte, payloadLen)
+	if _, err := io.ReadFull(c.rw, frame.payload); err != nil {
+		return wsFrame{}, err
+	}
+	for i := range frame.payload {
+		frame.payload[i] ^= mask[i%4]
+	}
+	return frame, nil
+}
+
+// WriteJSON sends value as a single text message.
+func (c *wsConn) WriteJSON(value any) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, payload)
+}
+
+// WriteClose starts the closing handshake; no frames may be written afterwards.
+func (c *wsConn) WriteClose(code uint16, reason string) error {
+	if len(reason) > wsMaxControlPayload-2 {
+		reason = agent.TruncateUTF8(reason, wsMaxControlPayload-2)
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload[:2], code)
+	copy(payload[2:], reason)
+	return c.writeFrame(wsOpClose, payload)
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errWSPeerClosed
+	}
+	if opcode == wsOpClose {
+		c.closed = true
+	}
+	if err := writeWSFrame(c.rw.Writer, opcode, payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// writeWSFrame encodes an unmasked, unfragmented server frame.
+func writeWSFrame(w *bufio.Writer, opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func parseClosePayload(payload []byte) (uint16, string, error) {
+	switch {
+	case len(payload) == 0:
+		return wsCloseNoStatus, "", nil
+	case len(payload) == 1:
+		return 0, "", &wsCloseError{Code: wsCloseProtocolError, Reason: "invalid close payload"}
+	}
+	code := binary.BigEndian.Uint16(payload[:2])
+	if !isValidWSCloseCode(code) {
+		return 0, "", &wsCloseError{Code: wsCloseProtocolError, Reason: "invalid close status code"}
+	}
+	reason := payload[2:]
+	if !utf8.Valid(reason) {
+		return 0, "", &wsCloseError{Code: wsCloseInvalidPayload, Reason: "invalid UTF-8 in close reason"}
+	}
+	return code, string(reason), nil
+}
+
+func isValidWSCloseCode(code uint16) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+func isKnownWSOpcode(opcode byte) bool {
+	switch opcode {
+	case wsOpContinuation, wsOpText, wsOpBinary, wsOpClose, wsOpPing, wsOpPong:
+		return true
+	}
+	return false
+}
+
+func isControlWSOpcode(opcode byte) bool {
+	return opcode&0x8 != 0
+}
@@ -39,7 +39,7 @@ func NewGeminiProvider(apiKey string) *GeminiProvider {
 }
 
 func (g *GeminiProvider) GenerateMenuConcepts(ctx context.Context, count int) ([]MenuConcept, error) {
-	prompt := fmt.Sprintf("Generate %d unique restaurant menu items for a modern global bistro. Return strict JSON array where each object has keys: name, description, tags (array of short strings), allergens (array choosing only from dairy, egg, fish, peanut, shellfish, soy, tree_nut, wheat). No markdown.", count)
+	prompt := fmt.Sprintf("Generate %d unique restaurant menu items for a modern global bistro. Return strict JSON array where each object has keys: name, description, tags (array of short strings), allergens (array choosing only from %s). No markdown.", count, allergenChoices())
 	text, err := g.generateText(ctx, prompt)
 	if err != nil {
 		return nil, err
@@ -194,27 +194,25 @@ func (g *GeminiProvider) model(value, fallback string) string {
 	return fallback
 }
 
+// parseAllergens resolves model output through the allergen registry, so
+// synonyms such as "milk" or "sulfites" map to canonical IDs. Values the
+// registry does not know are dropped.
 func parseAllergens(values []string) []domain.Allergen {
 	allergens := make([]domain.Allergen, 0, len(values))
 	for _, v := range values {
-		switch strings.ToLower(strings.TrimSpace(v)) {
-		case string(domain.AllergenDairy):
-			allergens = append(allergens, domain.AllergenDairy)
-		case string(domain.AllergenEgg):
-			allergens = append(allergens, domain.AllergenEgg)
-		case string(domain.AllergenFish):
-			allergens = append(allergens, domain.AllergenFish)
-		case string(domain.AllergenPeanut):
-			allergens = append(allergens, domain.AllergenPeanut)
-		case string(domain.AllergenShellfish):
-			allergens = append(allergens, domain.AllergenShellfish)
-		case string(domain.AllergenSoy):
-			allergens = append(allergens, domain.AllergenSoy)
-		case string(domain.AllergenTreeNut):
-			allergens = append(allergens, domain.AllergenTreeNut)
-		case string(domain.AllergenWheat):
-			allergens = append(allergens, domain.AllergenWheat)
+		if ids, ok := domain.ParseAllergens(v); ok {
+			allergens = append(allergens, ids...)
 		}
 	}
 	return allergens
 }
+
+// allergenChoices lists the registry IDs offered to the model.
+func allergenChoices() string {
+	definitions := domain.AllergenDefinitions("")
+	ids := make([]string, 0, len(definitions))
+	for _, definition := range definitions {
+		ids = append(ids, string(definition.ID))
+	}
+	return strings.Join(ids, ", ")
+}
@@ -72,3 +72,16 @@ func TestParseAllergensFiltersUnknown(t *testing.T) {
 		t.Fatalf("expected 3 allergens, got %d", len(got))
 	}
 }
+
+func TestParseAllergensResolvesRegistrySynonyms(t *testing.T) {
+	got := parseAllergens([]string{"Sesame", "sulfites", "milk", "Mollusks"})
+	want := []domain.Allergen{domain.AllergenSesame, domain.AllergenSulphites, domain.AllergenDairy, domain.AllergenMollusc}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
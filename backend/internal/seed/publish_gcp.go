@@ -7,9 +7,8 @@ import (
 	"fmt"
 	"path"
 
-	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
-	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
 )
 
 // GCSImageUploader uploads image artifacts to GCS.
@@ -43,28 +42,10 @@ func (u *GCSImageUploader) Close() error {
 	return u.client.Close()
 }
 
-// FirestoreRestaurantStore writes generated restaurants to Firestore.
-type FirestoreRestaurantStore struct {
-	collection string
-	client     *firestore.Client
-}
+// FirestoreRestaurantStore writes generated restaurants to the Firestore
+// collection the API serves restaurants from.
+type FirestoreRestaurantStore = gcp.FirestoreRestaurantStore
 
 func NewFirestoreRestaurantStore(ctx context.Context, projectID, collection string) (*FirestoreRestaurantStore, error) {
-	if collection == "" {
-		collection = "restaurants"
-	}
-	client, err := firestore.NewClient(ctx, projectID)
-	if err != nil {
-		return nil, err
-	}
-	return &FirestoreRestaurantStore{collection: collection, client: client}, nil
-}
-
-func (s *FirestoreRestaurantStore) SaveRestaurant(ctx context.Context, restaurant domain.Restaurant) error {
-	_, err := s.client.Collection(s.collection).Doc(restaurant.ID).Set(ctx, restaurant)
-	return err
-}
-
-func (s *FirestoreRestaurantStore) Close() error {
-	return s.client.Close()
+	return gcp.NewFirestoreRestaurantStore(ctx, projectID, collection)
 }
@@ -2,14 +2,21 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/gourmet-guide/backend/internal/agent"
 	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
 )
 
+// StartSessionInput opens a session. HardAllergens is the legacy flat list;
+// its entries join Allergies as anaphylaxis.
 type StartSessionInput struct {
 	RestaurantID   string
 	HardAllergens  []domain.Allergen
+	Allergies      []domain.Allergy
 	PreferenceTags []string
 	MenuItems      []domain.MenuItem
 }
@@ -20,34 +27,160 @@ type StartSessionOutput struct {
 }
 
 type ExtractMenuOutput struct {
-	ImagePath string
-	MenuItems []domain.MenuItem
+	ImagePath   string
+	MenuItems   []domain.MenuItem
+	MenuVersion int
 }
 
 type ConciergeApp struct {
-	concierge *agent.ConciergeService
+	concierge   *agent.ConciergeService
+	restaurants gcp.RestaurantStore
+	experiments gcp.ExperimentStore
+	events      *EventBus
 }
 
+// NewConciergeApp builds an app backed by an in-memory restaurant store.
 func NewConciergeApp(concierge *agent.ConciergeService) *ConciergeApp {
-	return &ConciergeApp{concierge: concierge}
+	return NewConciergeAppWithRestaurants(concierge, gcp.NewMemoryRestaurantStore())
+}
+
+// NewConciergeAppWithRestaurants builds an app that serves restaurants from
+// restaurants. Experiments are kept in memory until SetExperimentStore.
+func NewConciergeAppWithRestaurants(concierge *agent.ConciergeService, restaurants gcp.RestaurantStore) *ConciergeApp {
+	events := NewEventBus(defaultEventHistory)
+	concierge.SetEventPublisher(events)
+	concierge.SetComboSource(restaurantCombos{restaurants: restaurants})
+	concierge.SetSettingsSource(restaurantSettings{restaurants: restaurants})
+	app := &ConciergeApp{concierge: concierge, restaurants: restaurants, events: events}
+	app.SetExperimentStore(gcp.NewMemoryExperimentStore())
+	return app
 }
 
+// SetExperimentStore replaces where experiments and their outcome counts are kept.
+func (a *ConciergeApp) SetExperimentStore(experiments gcp.ExperimentStore) {
+	a.experiments = experiments
+	a.concierge.SetExperimentSource(storedExperiments{experiments: experiments})
+}
+
+// StartSession opens a session. A posted menu is kept as a draft that only
+// this session uses, and is refused for restaurants that manage their own
+// menu. Without a posted menu the stored restaurant menu is used; see
+// menuForSession.
 func (a *ConciergeApp) StartSession(ctx context.Context, input StartSessionInput) (StartSessionOutput, error) {
-	enriched, err := a.concierge.SaveMenuItems(ctx, input.RestaurantID, input.MenuItems)
+	allergies, err := allergyProfile(input.Allergies, input.HardAllergens)
+	if err != nil {
+		return StartSessionOutput{}, err
+	}
+	if len(input.MenuItems) == 0 {
+		enriched, err := a.menuForSession(ctx, input.RestaurantID)
+		if err != nil {
+			return StartSessionOutput{}, err
+		}
+		session, err := a.concierge.StartSession(ctx, input.RestaurantID, allergies, input.PreferenceTags)
+		if err != nil {
+			return StartSessionOutput{}, err
+		}
+		return StartSessionOutput{Session: session, SuggestedMenuItems: enriched}, nil
+	}
+
+	items, err := normalizeMenuAllergens(input.MenuItems, "menuItems")
+	if err != nil {
+		return StartSessionOutput{}, err
+	}
+	if err := a.checkPostedMenuAllowed(ctx, input.RestaurantID); err != nil {
+		return StartSessionOutput{}, err
+	}
+	menu, err := a.concierge.SaveSessionMenu(ctx, input.RestaurantID, items)
 	if err != nil {
 		return StartSessionOutput{}, err
 	}
-	session, err := a.concierge.StartSession(ctx, input.RestaurantID, input.HardAllergens, input.PreferenceTags)
+	session, err := a.concierge.StartSessionOnMenu(ctx, input.RestaurantID, menu.Version, allergies, input.PreferenceTags)
 	if err != nil {
 		return StartSessionOutput{}, err
 	}
-	return StartSessionOutput{Session: session, SuggestedMenuItems: enriched}, nil
+	return StartSessionOutput{Session: session, SuggestedMenuItems: menu.MenuItems}, nil
+}
+
+// checkPostedMenuAllowed refuses menus posted with a session start for
+// restaurants that are registered or have a published menu, so guests cannot
+// replace the menu the restaurant serves.
+func (a *ConciergeApp) checkPostedMenuAllowed(ctx context.Context, restaurantID string) error {
+	refused := NewError(ErrorKindConflict, "this restaurant manages its own menu; start the session without menuItems", map[string]any{"field": "menuItems"})
+	_, err := a.restaurants.LoadRestaurant(ctx, restaurantID)
+	switch {
+	case err == nil:
+		return refused
+	case !errors.Is(err, gcp.ErrRestaurantNotFound):
+		return err
+	}
+	_, err = a.concierge.LiveMenu(ctx, restaurantID)
+	switch {
+	case err == nil:
+		return refused
+	case !errors.Is(err, gcp.ErrRestaurantNotFound):
+		return err
+	}
+	return nil
+}
+
+// allergyProfile validates a guest's allergies through the allergen registry
+// and merges in the legacy hard allergens, keeping the most severe entry.
+func allergyProfile(allergies []domain.Allergy, hardAllergens []domain.Allergen) ([]domain.Allergy, error) {
+	hard, err := normalizeAllergens(hardAllergens, map[string]any{"field": "hardAllergens"})
+	if err != nil {
+		return nil, err
+	}
+	normalized := make([]domain.Allergy, 0, len(allergies)+len(hard))
+	for _, allergy := range allergies {
+		allergens, ok := domain.ParseAllergens(string(allergy.Allergen))
+		if !ok {
+			return nil, ValidationError(fmt.Sprintf("unknown allergen %q", allergy.Allergen), map[string]any{"field": "allergies.allergen", "unknown": []string{string(allergy.Allergen)}})
+		}
+		severity, ok := domain.ParseAllergySeverity(string(allergy.Severity))
+		if !ok {
+			return nil, ValidationError(fmt.Sprintf("unknown allergy severity %q", allergy.Severity), map[string]any{"field": "allergies.severity", "supported": domain.AllergySeverities})
+		}
+		for _, allergen := range allergens {
+			normalized = append(normalized, domain.Allergy{Allergen: allergen, Severity: severity})
+		}
+	}
+	for _, allergen := range hard {
+		normalized = append(normalized, domain.Allergy{Allergen: allergen, Severity: domain.SeverityAnaphylaxis})
+	}
+	return domain.MergeAllergies(normalized), nil
 }
 
 func (a *ConciergeApp) GetSession(ctx context.Context, sessionID string) (domain.ConciergeSession, error) {
 	return a.concierge.GetSession(ctx, sessionID)
 }
 
+func (a *ConciergeApp) GetTranscript(ctx context.Context, sessionID string) ([]domain.ConversationTurn, error) {
+	return a.concierge.GetTranscript(ctx, sessionID)
+}
+
+// VoiceInstruction returns the live voice system instruction for a restaurant
+// and the prompt version it was rendered with.
+func (a *ConciergeApp) VoiceInstruction(ctx context.Context, restaurantID string) (string, string, error) {
+	return a.concierge.VoiceInstruction(ctx, strings.TrimSpace(restaurantID))
+}
+
+// SubscribeSessionEvents streams events for a session and its restaurant's menu,
+// replaying retained events after lastEventID.
+func (a *ConciergeApp) SubscribeSessionEvents(sessionID, restaurantID string, lastEventID uint64) *Subscription {
+	return a.events.Subscribe(sessionID, restaurantID, lastEventID)
+}
+
+// ConfirmOrder records the guest's confirmation of their session order.
+func (a *ConciergeApp) ConfirmOrder(ctx context.Context, sessionID string) (domain.ConciergeSession, error) {
+	return a.concierge.ConfirmOrder(ctx, sessionID)
+}
+
+// ConfirmProfileRemovals applies the allergy retractions the guest stated in
+// chat once they confirm them.
+func (a *ConciergeApp) ConfirmProfileRemovals(ctx context.Context, sessionID string) (domain.ConciergeSession, error) {
+	return a.concierge.ConfirmProfileRemovals(ctx, sessionID)
+}
+
 func (a *ConciergeApp) EndSession(ctx context.Context, sessionID string) error {
 	return a.concierge.EndSession(ctx, sessionID)
 }
@@ -56,18 +189,64 @@ func (a *ConciergeApp) InterruptSession(ctx context.Context, sessionID string) e
 	return a.concierge.InterruptSession(ctx, sessionID)
 }
 
-func (a *ConciergeApp) SendMessage(ctx context.Context, sessionID, prompt string) (string, error) {
+func (a *ConciergeApp) SendMessage(ctx context.Context, sessionID, prompt string) (domain.AssistantReply, error) {
 	return a.concierge.SendMessage(ctx, sessionID, prompt)
 }
 
-func (a *ConciergeApp) TagMenuItems(ctx context.Context, restaurantID string, items []domain.MenuItem) ([]domain.MenuItem, error) {
-	return a.concierge.SaveMenuItems(ctx, restaurantID, items)
+func (a *ConciergeApp) StreamMessage(ctx context.Context, sessionID, prompt string, onPartial func(delta string) error) (domain.AssistantReply, error) {
+	return a.concierge.StreamMessage(ctx, sessionID, prompt, onPartial)
+}
+
+// SafetyCheckInput selects what a safety check covers: ad-hoc MenuItems, or
+// the session menu narrowed to ItemIDs (all dishes when empty).
+type SafetyCheckInput struct {
+	ItemIDs   []string
+	MenuItems []domain.MenuItem
+}
+
+// SafetyCheck returns per-dish safety verdicts for a session's guest.
+func (a *ConciergeApp) SafetyCheck(ctx context.Context, sessionID string, input SafetyCheckInput) ([]domain.ItemSafety, error) {
+	items, err := normalizeMenuAllergens(input.MenuItems, "menuItems")
+	if err != nil {
+		return nil, err
+	}
+	return a.concierge.CheckMenuSafety(ctx, sessionID, items, input.ItemIDs)
+}
+
+// TagMenuItems suggests dietary tags and saves the result as a draft menu version.
+func (a *ConciergeApp) TagMenuItems(ctx context.Context, restaurantID string, items []domain.MenuItem) (domain.MenuVersion, error) {
+	items, err := normalizeMenuAllergens(items, "menuItems")
+	if err != nil {
+		return domain.MenuVersion{}, err
+	}
+	return a.concierge.SaveMenuDraft(ctx, restaurantID, items, agent.MenuSourceTagging)
 }
 
 func (a *ConciergeApp) ExtractMenuFromImage(ctx context.Context, restaurantID, fileName string, content []byte) (ExtractMenuOutput, error) {
-	items, imagePath, err := a.concierge.AutoExtractMenuFromImage(ctx, restaurantID, fileName, content)
+	draft, imagePath, err := a.concierge.AutoExtractMenuFromImage(ctx, restaurantID, fileName, content)
 	if err != nil {
 		return ExtractMenuOutput{}, err
 	}
-	return ExtractMenuOutput{ImagePath: imagePath, MenuItems: items}, nil
+	return ExtractMenuOutput{ImagePath: imagePath, MenuItems: draft.MenuItems, MenuVersion: draft.Version}, nil
+}
+
+func (a *ConciergeApp) ListMenuVersions(ctx context.Context, restaurantID string) ([]domain.MenuVersion, error) {
+	return a.concierge.ListMenuVersions(ctx, restaurantID)
+}
+
+func (a *ConciergeApp) GetMenuVersion(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	return a.concierge.GetMenuVersion(ctx, restaurantID, version)
+}
+
+func (a *ConciergeApp) LiveMenu(ctx context.Context, restaurantID string) (domain.MenuVersion, error) {
+	return a.concierge.LiveMenu(ctx, restaurantID)
+}
+
+func (a *ConciergeApp) PublishMenuVersion(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	return a.concierge.PublishMenuVersion(ctx, restaurantID, version)
+}
+
+// RollbackMenu republishes version, or the previously live version when zero.
+func (a *ConciergeApp) RollbackMenu(ctx context.Context, restaurantID string, version int) (domain.MenuVersion, error) {
+	return a.concierge.RollbackMenu(ctx, restaurantID, version)
 }
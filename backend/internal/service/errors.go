@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gourmet-guide/backend/internal/agent"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+// ErrorKind classifies failures so transports can report them consistently.
+type ErrorKind string
+
+const (
+	ErrorKindValidation  ErrorKind = "validation"
+	ErrorKindNotFound    ErrorKind = "not_found"
+	ErrorKindConflict    ErrorKind = "conflict"
+	ErrorKindUnavailable ErrorKind = "unavailable"
+	// ErrorKindSafetyRefusal reports a request refused because it would serve
+	// the guest a dish their allergy profile rules out.
+	ErrorKindSafetyRefusal ErrorKind = "safety_refusal"
+	// ErrorKindMethodNotAllowed reports a known route called with a method it
+	// does not serve.
+	ErrorKindMethodNotAllowed ErrorKind = "method_not_allowed"
+	ErrorKindInternal         ErrorKind = "internal"
+)
+
+// Errors returned by ConciergeApp that callers can match with errors.Is.
+var (
+	ErrSessionNotFound     = gcp.ErrSessionNotFound
+	ErrRestaurantNotFound  = gcp.ErrRestaurantNotFound
+	ErrSessionCompleted    = agent.ErrSessionCompleted
+	ErrUnavailable         = gcp.ErrUnavailable
+	ErrInvalidInput        = agent.ErrInvalidInput
+	ErrMenuVersionNotFound = gcp.ErrMenuVersionNotFound
+	ErrNoRollbackTarget    = agent.ErrNoRollbackTarget
+	ErrPolicyNotFound      = gcp.ErrPolicyNotFound
+	ErrModelUnavailable    = agent.ErrModelUnavailable
+	ErrSafetyRefusal       = agent.ErrSafetyRefusal
+)
+
+// Error is a classified failure with optional structured details for clients.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Details map[string]any
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return string(e.Kind)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// NewError builds a classified error.
+func NewError(kind ErrorKind, message string, details map[string]any) *Error {
+	return &Error{Kind: kind, Message: message, Details: details}
+}
+
+// ValidationError reports a malformed request.
+func ValidationError(message string, details map[string]any) *Error {
+	return NewError(ErrorKindValidation, message, details)
+}
+
+// KindOf classifies err, recognizing *Error values and the store and agent sentinels.
+// Unrecognized errors are internal.
+func KindOf(err error) ErrorKind {
+	var classified *Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &classified):
+		return classified.Kind
+	case errors.Is(err, ErrInvalidInput):
+		return ErrorKindValidation
+	case errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrRestaurantNotFound), errors.Is(err, ErrMenuVersionNotFound),
+		errors.Is(err, ErrPolicyNotFound), errors.Is(err, ErrExperimentNotFound):
+		return ErrorKindNotFound
+	case errors.Is(err, ErrSessionCompleted), errors.Is(err, ErrRestaurantExists), errors.Is(err, ErrExperimentExists), errors.Is(err, ErrExperimentRunning),
+		errors.Is(err, ErrMenuNotPublished), errors.Is(err, ErrNoRollbackTarget):
+		return ErrorKindConflict
+	case errors.Is(err, ErrSafetyRefusal):
+		return ErrorKindSafetyRefusal
+	case errors.Is(err, ErrUnavailable), errors.Is(err, ErrModelUnavailable), errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindUnavailable
+	}
+	return ErrorKindInternal
+}
+
+// DetailsOf returns the structured details attached to err, if any.
+func DetailsOf(err error) map[string]any {
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Details
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestKindOfClassifiesStoreAndAgentErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		err  error
+		want ErrorKind
+	}{
+		{fmt.Errorf("load: %w", ErrSessionNotFound), ErrorKindNotFound},
+		{ErrRestaurantNotFound, ErrorKindNotFound},
+		{ErrSessionCompleted, ErrorKindConflict},
+		{fmt.Errorf("%w: prompt cannot be empty", ErrInvalidInput), ErrorKindValidation},
+		{fmt.Errorf("%w: Shrimp Tacos cannot be confirmed", ErrSafetyRefusal), ErrorKindSafetyRefusal},
+		{fmt.Errorf("%w: firestore down", ErrUnavailable), ErrorKindUnavailable},
+		{context.DeadlineExceeded, ErrorKindUnavailable},
+		{NewError(ErrorKindConflict, "already running", nil), ErrorKindConflict},
+		{errors.New("boom"), ErrorKindInternal},
+	}
+	for _, tc := range cases {
+		if got := KindOf(tc.err); got != tc.want {
+			t.Errorf("KindOf(%v) = %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestValidationErrorExposesDetails(t *testing.T) {
+	t.Parallel()
+	err := fmt.Errorf("decode: %w", ValidationError("invalid JSON", map[string]any{"field": "prompt"}))
+	if KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation kind, got %s", KindOf(err))
+	}
+	if DetailsOf(err)["field"] != "prompt" {
+		t.Fatalf("expected details to survive wrapping, got %v", DetailsOf(err))
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+const (
+	defaultEventHistory      = 256
+	subscriptionBufferLength = 32
+)
+
+// EventBus is an in-process pub/sub for session events. It assigns increasing
+// IDs and keeps a bounded history so subscribers can resume after reconnecting.
+type EventBus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []domain.SessionEvent
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription delivers events for one session and its restaurant's menu.
+type Subscription struct {
+	// Replay holds retained events published after the requested last event ID.
+	Replay []domain.SessionEvent
+	// Complete is false when the history no longer reaches back to the requested
+	// ID, so some events were missed and the caller should resend a snapshot.
+	Complete bool
+	// HeadID is the ID of the newest event published before subscribing.
+	HeadID uint64
+	// Events receives live events. It is closed when the subscription is closed
+	// or when the subscriber falls too far behind.
+	Events <-chan domain.SessionEvent
+
+	bus          *EventBus
+	sessionID    string
+	restaurantID string
+	events       chan domain.SessionEvent
+}
+
+func NewEventBus(historySize int) *EventBus {
+	if historySize <= 0 {
+		historySize = defaultEventHistory
+	}
+	return &EventBus{historySize: historySize, subscribers: map[*Subscription]struct{}{}}
+}
+
+// Publish assigns the next event ID and fans the event out to matching subscribers.
+// Subscribers whose buffer is full are dropped rather than blocking the publisher.
+func (b *EventBus) Publish(event domain.SessionEvent) domain.SessionEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	event.ID = b.lastID
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = append(b.history[:0:0], b.history[len(b.history)-b.historySize:]...)
+	}
+	for sub := range b.subscribers {
+		if !sub.matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			b.removeLocked(sub)
+		}
+	}
+	return event
+}
+
+// Subscribe registers interest in sessionID. Events after lastEventID that are
+// still retained are returned in Replay; pass zero to skip replay.
+func (b *EventBus) Subscribe(sessionID, restaurantID string, lastEventID uint64) *Subscription {
+	events := make(chan domain.SessionEvent, subscriptionBufferLength)
+	sub := &Subscription{
+		Complete:     true,
+		Events:       events,
+		bus:          b,
+		sessionID:    sessionID,
+		restaurantID: restaurantID,
+		events:       events,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub.HeadID = b.lastID
+	if lastEventID > 0 {
+		// A last ID from the future means the server restarted and IDs were reset.
+		if lastEventID > b.lastID || (len(b.history) > 0 && b.history[0].ID > lastEventID+1) {
+			sub.Complete = false
+		}
+		for _, event := range b.history {
+			if event.ID > lastEventID && sub.matches(event) {
+				sub.Replay = append(sub.Replay, event)
+			}
+		}
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Close unregisters the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.removeLocked(s)
+}
+
+func (s *Subscription) matches(event domain.SessionEvent) bool {
+	if event.SessionID != "" {
+		return event.SessionID == s.sessionID
+	}
+	return s.restaurantID != "" && event.RestaurantID == s.restaurantID
+}
+
+func (b *EventBus) removeLocked(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+	delete(b.subscribers, sub)
+	close(sub.events)
+}
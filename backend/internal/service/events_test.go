@@ -0,0 +1,91 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+func TestEventBusAssignsIncreasingIDsAndFiltersBySessionAndRestaurant(t *testing.T) {
+	t.Parallel()
+	bus := NewEventBus(10)
+	sub := bus.Subscribe("session-1", "rest-1", 0)
+	defer sub.Close()
+
+	bus.Publish(domain.SessionEvent{Type: domain.SessionEventMessage, SessionID: "session-2", RestaurantID: "rest-1"})
+	bus.Publish(domain.SessionEvent{Type: domain.SessionEventMessage, SessionID: "session-1", RestaurantID: "rest-1"})
+	bus.Publish(domain.SessionEvent{Type: domain.SessionEventMenuUpdated, RestaurantID: "rest-2"})
+	bus.Publish(domain.SessionEvent{Type: domain.SessionEventMenuUpdated, RestaurantID: "rest-1"})
+
+	first := <-sub.Events
+	second := <-sub.Events
+	if first.ID != 2 || first.Type != domain.SessionEventMessage {
+		t.Fatalf("expected own session message with id 2, got %+v", first)
+	}
+	if second.ID != 4 || second.Type != domain.SessionEventMenuUpdated {
+		t.Fatalf("expected restaurant menu update with id 4, got %+v", second)
+	}
+	select {
+	case extra := <-sub.Events:
+		t.Fatalf("unexpected event delivered: %+v", extra)
+	default:
+	}
+}
+
+func TestEventBusReplaysAfterLastEventID(t *testing.T) {
+	t.Parallel()
+	bus := NewEventBus(10)
+	for i := 0; i < 3; i++ {
+		bus.Publish(domain.SessionEvent{Type: domain.SessionEventMessage, SessionID: "session-1"})
+	}
+
+	sub := bus.Subscribe("session-1", "", 1)
+	defer sub.Close()
+	if !sub.Complete || sub.HeadID != 3 {
+		t.Fatalf("expected complete replay up to head 3, got complete=%v head=%d", sub.Complete, sub.HeadID)
+	}
+	if len(sub.Replay) != 2 || sub.Replay[0].ID != 2 || sub.Replay[1].ID != 3 {
+		t.Fatalf("expected replay of events 2 and 3, got %+v", sub.Replay)
+	}
+}
+
+func TestEventBusReportsIncompleteReplayWhenHistoryWasTrimmed(t *testing.T) {
+	t.Parallel()
+	bus := NewEventBus(2)
+	for i := 0; i < 5; i++ {
+		bus.Publish(domain.SessionEvent{Type: domain.SessionEventMessage, SessionID: "session-1"})
+	}
+
+	sub := bus.Subscribe("session-1", "", 1)
+	defer sub.Close()
+	if sub.Complete {
+		t.Fatal("expected incomplete replay after history trimming")
+	}
+	if len(sub.Replay) != 2 || sub.Replay[0].ID != 4 {
+		t.Fatalf("expected retained events 4 and 5, got %+v", sub.Replay)
+	}
+
+	restarted := bus.Subscribe("session-1", "", 99)
+	defer restarted.Close()
+	if restarted.Complete {
+		t.Fatal("expected incomplete replay for an ID the bus never issued")
+	}
+}
+
+func TestEventBusDropsSlowSubscriber(t *testing.T) {
+	t.Parallel()
+	bus := NewEventBus(0)
+	sub := bus.Subscribe("session-1", "", 0)
+	for i := 0; i <= subscriptionBufferLength; i++ {
+		bus.Publish(domain.SessionEvent{Type: domain.SessionEventMessage, SessionID: "session-1"})
+	}
+
+	received := 0
+	for range sub.Events {
+		received++
+	}
+	if received != subscriptionBufferLength {
+		t.Fatalf("expected %d buffered events before drop, got %d", subscriptionBufferLength, received)
+	}
+	sub.Close()
+}
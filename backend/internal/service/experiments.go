@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gourmet-guide/backend/internal/agent"
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+var (
+	// ErrExperimentExists is returned when an experiment ID is already taken.
+	ErrExperimentExists = gcp.ErrExperimentExists
+	// ErrExperimentNotFound is returned for unknown experiment IDs.
+	ErrExperimentNotFound = gcp.ErrExperimentNotFound
+	// ErrExperimentRunning is returned when the scope of a new experiment
+	// already has one running.
+	ErrExperimentRunning = gcp.ErrExperimentRunning
+)
+
+// Limits of an experiment definition.
+const (
+	maxExperimentVariants = 10
+	maxVariantNameLength  = 40
+	maxVariantWeight      = 1000
+	maxVariantRetrievalK  = 20
+	maxVariantModelLength = 100
+	maxRankingWeight      = 5
+)
+
+// CreateExperiment validates experiment and starts it. Experiments split new
+// sessions between variants of the concierge settings and count what each
+// variant's sessions do. At most one experiment runs per restaurant, plus one
+// covering every restaurant; a session joins its restaurant's experiment when
+// there is one and the global one otherwise. The store enforces the limit, so
+// concurrent creates for one scope cannot both succeed.
+func (a *ConciergeApp) CreateExperiment(ctx context.Context, experiment domain.Experiment) (domain.Experiment, error) {
+	if strings.TrimSpace(experiment.ID) == "" {
+		experiment.ID = newResourceID()
+	}
+	prepared, err := prepareExperiment(experiment)
+	if err != nil {
+		return domain.Experiment{}, err
+	}
+	prepared.Status = domain.ExperimentRunning
+	prepared.CreatedAt = time.Now().UTC()
+	prepared.StoppedAt = nil
+	if err := a.experiments.CreateExperiment(ctx, prepared); err != nil {
+		var running *gcp.ExperimentRunningError
+		if errors.As(err, &running) {
+			return domain.Experiment{}, NewError(ErrorKindConflict, fmt.Sprintf("experiment %q is already running for this scope; stop it first", running.ExperimentID), map[string]any{"experimentId": running.ExperimentID})
+		}
+		return domain.Experiment{}, err
+	}
+	return prepared, nil
+}
+
+func (a *ConciergeApp) GetExperiment(ctx context.Context, experimentID string) (domain.Experiment, error) {
+	return a.experiments.LoadExperiment(ctx, experimentID)
+}
+
+// ListExperiments returns every experiment, oldest first.
+func (a *ConciergeApp) ListExperiments(ctx context.Context) ([]domain.Experiment, error) {
+	return a.experiments.ListExperiments(ctx)
+}
+
+// StopExperiment stops assigning new sessions to an experiment. Sessions
+// already in it keep their variant and their outcomes still count.
+func (a *ConciergeApp) StopExperiment(ctx context.Context, experimentID string) (domain.Experiment, error) {
+	return a.experiments.UpdateExperiment(ctx, experimentID, func(experiment *domain.Experiment) error {
+		if experiment.Status != domain.ExperimentStopped {
+			now := time.Now().UTC()
+			experiment.Status = domain.ExperimentStopped
+			experiment.StoppedAt = &now
+		}
+		return nil
+	})
+}
+
+// ExperimentResults aggregates an experiment's outcomes per variant, in the
+// order the variants were defined.
+func (a *ConciergeApp) ExperimentResults(ctx context.Context, experimentID string) (domain.ExperimentResults, error) {
+	experiment, err := a.experiments.LoadExperiment(ctx, experimentID)
+	if err != nil {
+		return domain.ExperimentResults{}, err
+	}
+	outcomes, err := a.experiments.LoadOutcomes(ctx, experimentID)
+	if err != nil {
+		return domain.ExperimentResults{}, err
+	}
+	results := domain.ExperimentResults{ExperimentID: experiment.ID, Status: experiment.Status, Variants: make([]domain.VariantResults, 0, len(experiment.Variants))}
+	for _, variant := range experiment.Variants {
+		counts := outcomes[variant.Name]
+		result := domain.VariantResults{
+			Variant:           variant.Name,
+			Sessions:          counts[domain.OutcomeSessionStarted],
+			ItemsAdded:        counts[domain.OutcomeItemAdded],
+			OrdersConfirmed:   counts[domain.OutcomeOrderConfirmed],
+			SessionsAbandoned: counts[domain.OutcomeSessionAbandoned],
+		}
+		if result.Sessions > 0 {
+			result.ConversionRate = float64(result.OrdersConfirmed) / float64(result.Sessions)
+		}
+		results.Variants = append(results.Variants, result)
+	}
+	return results, nil
+}
+
+// prepareExperiment trims names and validates the variants. A variant with
+// weight 0 takes no sessions, but at least one variant must take some.
+func prepareExperiment(experiment domain.Experiment) (domain.Experiment, error) {
+	experiment.ID = strings.TrimSpace(experiment.ID)
+	experiment.Name = strings.TrimSpace(experiment.Name)
+	experiment.RestaurantID = strings.TrimSpace(experiment.RestaurantID)
+	if experiment.Name == "" {
+		return domain.Experiment{}, ValidationError("experiment name is required", map[string]any{"field": "name"})
+	}
+	if len(experiment.Variants) < 2 || len(experiment.Variants) > maxExperimentVariants {
+		return domain.Experiment{}, ValidationError(fmt.Sprintf("an experiment needs between 2 and %d variants", maxExperimentVariants), map[string]any{"field": "variants"})
+	}
+	variants := make([]domain.ExperimentVariant, 0, len(experiment.Variants))
+	for _, variant := range experiment.Variants {
+		variant.Name = strings.TrimSpace(variant.Name)
+		variant.PromptVersion = strings.TrimSpace(variant.PromptVersion)
+		variant.Model = strings.TrimSpace(variant.Model)
+		details := map[string]any{"variant": variant.Name}
+		invalid := func(field, message string) error {
+			details["field"] = "variants." + field
+			return ValidationError(message, details)
+		}
+		switch {
+		case variant.Name == "" || utf8.RuneCountInString(variant.Name) > maxVariantNameLength || strings.Contains(variant.Name, "/"):
+			return domain.Experiment{}, invalid("name", fmt.Sprintf("variant names are required, at most %d characters and without slashes", maxVariantNameLength))
+		case slices.ContainsFunc(variants, func(other domain.ExperimentVariant) bool { return other.Name == variant.Name }):
+			return domain.Experiment{}, invalid("name", fmt.Sprintf("variant %q is defined twice", variant.Name))
+		case variant.Weight < 0 || variant.Weight > maxVariantWeight:
+			return domain.Experiment{}, invalid("weight", fmt.Sprintf("variant weights must be between 0 and %d", maxVariantWeight))
+		case variant.PromptVersion != "" && !slices.Contains(agent.PromptVersions(), variant.PromptVersion):
+			details["supported"] = agent.PromptVersions()
+			return domain.Experiment{}, invalid("promptVersion", fmt.Sprintf("unknown prompt version %q", variant.PromptVersion))
+		case variant.RetrievalK < 0 || variant.RetrievalK > maxVariantRetrievalK:
+			return domain.Experiment{}, invalid("retrievalK", fmt.Sprintf("retrievalK must be between 0 and %d", maxVariantRetrievalK))
+		case utf8.RuneCountInString(variant.Model) > maxVariantModelLength:
+			return domain.Experiment{}, invalid("model", fmt.Sprintf("model names must be at most %d characters", maxVariantModelLength))
+		case !validRankingWeight(variant.Ranking.Preference) || !validRankingWeight(variant.Ranking.Embedding):
+			return domain.Experiment{}, invalid("ranking", fmt.Sprintf("ranking weights must be between 0 and %d", maxRankingWeight))
+		}
+		variants = append(variants, variant)
+	}
+	if !slices.ContainsFunc(variants, func(variant domain.ExperimentVariant) bool { return variant.Weight > 0 }) {
+		return domain.Experiment{}, ValidationError("at least one variant needs a positive weight", map[string]any{"field": "variants.weight"})
+	}
+	experiment.Variants = variants
+	return experiment, nil
+}
+
+func validRankingWeight(weight *float64) bool {
+	return weight == nil || (*weight >= 0 && *weight <= maxRankingWeight)
+}
+
+// storedExperiments serves the running experiments and records outcomes in
+// the experiment store.
+type storedExperiments struct {
+	experiments gcp.ExperimentStore
+}
+
+func (e storedExperiments) RunningExperiment(ctx context.Context, restaurantID string) (domain.Experiment, bool, error) {
+	experiments, err := e.experiments.ListExperiments(ctx)
+	if err != nil {
+		return domain.Experiment{}, false, err
+	}
+	var global *domain.Experiment
+	for i, experiment := range experiments {
+		switch {
+		case experiment.Status != domain.ExperimentRunning:
+		case experiment.RestaurantID != "" && experiment.RestaurantID == restaurantID:
+			return experiment, true, nil
+		case experiment.RestaurantID == "" && global == nil:
+			global = &experiments[i]
+		}
+	}
+	if global == nil {
+		return domain.Experiment{}, false, nil
+	}
+	return *global, true, nil
+}
+
+func (e storedExperiments) RecordOutcome(ctx context.Context, assignment domain.ExperimentAssignment, outcome domain.ExperimentOutcome) error {
+	return e.experiments.RecordOutcome(ctx, assignment.ExperimentID, assignment.Variant.Name, outcome)
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+func TestCreateExperimentValidatesVariants(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+	tooHeavy := 9.0
+
+	for name, experiment := range map[string]domain.Experiment{
+		"no name":          {Variants: []domain.ExperimentVariant{{Name: "a"}, {Name: "b"}}},
+		"single variant":   {Name: "K", Variants: []domain.ExperimentVariant{{Name: "a"}}},
+		"duplicate name":   {Name: "K", Variants: []domain.ExperimentVariant{{Name: "a"}, {Name: " a "}}},
+		"negative weight":  {Name: "K", Variants: []domain.ExperimentVariant{{Name: "a"}, {Name: "b", Weight: -1}}},
+		"unknown prompt":   {Name: "K", Variants: []domain.ExperimentVariant{{Name: "a"}, {Name: "b", PromptVersion: "concierge-v0"}}},
+		"retrieval K":      {Name: "K", Variants: []domain.ExperimentVariant{{Name: "a"}, {Name: "b", RetrievalK: 50}}},
+		"ranking weight":   {Name: "K", Variants: []domain.ExperimentVariant{{Name: "a"}, {Name: "b", Ranking: domain.RankingWeights{Embedding: &tooHeavy}}}},
+		"slash in variant": {Name: "K", Variants: []domain.ExperimentVariant{{Name: "a"}, {Name: "b/c"}}},
+		"no weights":       {Name: "K", Variants: []domain.ExperimentVariant{{Name: "a"}, {Name: "b"}}},
+	} {
+		if _, err := app.CreateExperiment(ctx, experiment); KindOf(err) != ErrorKindValidation {
+			t.Fatalf("%s: expected a validation error, got %v", name, err)
+		}
+	}
+
+	created, err := app.CreateExperiment(ctx, domain.Experiment{Name: " Short menus ", Variants: []domain.ExperimentVariant{{Name: "control", Weight: 1}, {Name: "k3", Weight: 2, RetrievalK: 3}, {Name: "paused"}}})
+	if err != nil {
+		t.Fatalf("create experiment: %v", err)
+	}
+	if created.ID == "" || created.Name != "Short menus" || created.Status != domain.ExperimentRunning || created.Variants[2].Weight != 0 {
+		t.Fatalf("expected a running experiment, got %+v", created)
+	}
+	if _, err := app.CreateExperiment(ctx, domain.Experiment{Name: "Overlap", Variants: created.Variants}); KindOf(err) != ErrorKindConflict {
+		t.Fatalf("expected a second global experiment to conflict, got %v", err)
+	}
+	if _, err := app.CreateExperiment(ctx, domain.Experiment{ID: created.ID, Name: "Again", RestaurantID: "r1", Variants: created.Variants}); KindOf(err) != ErrorKindConflict {
+		t.Fatalf("expected a taken ID to conflict, got %v", err)
+	}
+	if _, err := app.GetExperiment(ctx, "missing"); KindOf(err) != ErrorKindNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestConcurrentExperimentsForOneScopeStartOnce(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+	variants := []domain.ExperimentVariant{{Name: "control", Weight: 1}, {Name: "k3", Weight: 1, RetrievalK: 3}}
+
+	const attempts = 8
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := app.CreateExperiment(ctx, domain.Experiment{Name: "Race", RestaurantID: "r1", Variants: variants})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	started := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			started++
+		case KindOf(err) != ErrorKindConflict || DetailsOf(err)["experimentId"] == "":
+			t.Fatalf("expected a conflict naming the running experiment, got %v (%v)", err, DetailsOf(err))
+		}
+	}
+	if started != 1 {
+		t.Fatalf("expected exactly one experiment to start, got %d", started)
+	}
+}
+
+func TestExperimentResultsCountOutcomesPerVariant(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+	menu := []domain.MenuItem{{ID: "wrap", Name: "Veggie Wrap"}}
+
+	global, err := app.CreateExperiment(ctx, domain.Experiment{Name: "Everywhere", Variants: []domain.ExperimentVariant{{Name: "control", Weight: 1}, {Name: "off"}}})
+	if err != nil {
+		t.Fatalf("create experiment: %v", err)
+	}
+	local, err := app.CreateExperiment(ctx, domain.Experiment{Name: "Bistro only", RestaurantID: "bistro", Variants: []domain.ExperimentVariant{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}}})
+	if err != nil {
+		t.Fatalf("create experiment: %v", err)
+	}
+
+	var sessions []domain.ConciergeSession
+	for _, restaurantID := range []string{"cafe", "cafe", "bistro"} {
+		started, err := app.StartSession(ctx, StartSessionInput{RestaurantID: restaurantID, MenuItems: menu})
+		if err != nil {
+			t.Fatalf("start session: %v", err)
+		}
+		sessions = append(sessions, started.Session)
+	}
+	if sessions[0].Experiment.ExperimentID != global.ID || sessions[2].Experiment.ExperimentID != local.ID {
+		t.Fatalf("expected the restaurant's experiment to win over the global one, got %+v and %+v", sessions[0].Experiment, sessions[2].Experiment)
+	}
+	if _, err := app.ConfirmOrder(ctx, sessions[0].ID); KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected an empty order to be refused, got %v", err)
+	}
+	if err := app.EndSession(ctx, sessions[1].ID); err != nil {
+		t.Fatalf("end session: %v", err)
+	}
+
+	stopped, err := app.StopExperiment(ctx, global.ID)
+	if err != nil || stopped.Status != domain.ExperimentStopped || stopped.StoppedAt == nil {
+		t.Fatalf("expected the experiment to stop, got %+v (%v)", stopped, err)
+	}
+	if started, _ := app.StartSession(ctx, StartSessionInput{RestaurantID: "cafe", MenuItems: menu}); started.Session.Experiment != nil {
+		t.Fatalf("expected no assignment after stopping, got %+v", started.Session.Experiment)
+	}
+
+	results, err := app.ExperimentResults(ctx, global.ID)
+	if err != nil {
+		t.Fatalf("results: %v", err)
+	}
+	want := []domain.VariantResults{{Variant: "control", Sessions: 2, SessionsAbandoned: 1}, {Variant: "off"}}
+	if results.Status != domain.ExperimentStopped || len(results.Variants) != 2 || results.Variants[0] != want[0] || results.Variants[1] != want[1] {
+		t.Fatalf("expected %+v, got %+v", want, results)
+	}
+}
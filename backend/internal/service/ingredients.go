@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gourmet-guide/backend/internal/agent"
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+func (a *ConciergeApp) ListIngredients(ctx context.Context, restaurantID string) ([]domain.Ingredient, error) {
+	restaurant, err := a.restaurants.LoadRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	if restaurant.Ingredients == nil {
+		return []domain.Ingredient{}, nil
+	}
+	return restaurant.Ingredients, nil
+}
+
+func (a *ConciergeApp) GetIngredient(ctx context.Context, restaurantID, ingredientID string) (domain.Ingredient, error) {
+	restaurant, err := a.restaurants.LoadRestaurant(ctx, restaurantID)
+	if err != nil {
+		return domain.Ingredient{}, err
+	}
+	if i := ingredientIndex(restaurant.Ingredients, ingredientID); i >= 0 {
+		return restaurant.Ingredients[i], nil
+	}
+	return domain.Ingredient{}, ingredientNotFound(ingredientID)
+}
+
+func (a *ConciergeApp) AddIngredient(ctx context.Context, restaurantID string, ingredient domain.Ingredient) (domain.Ingredient, error) {
+	if strings.TrimSpace(ingredient.ID) == "" {
+		ingredient.ID = newResourceID()
+	}
+	updated, err := a.updateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		restaurant.Ingredients = append(restaurant.Ingredients, ingredient)
+		return nil
+	})
+	if err != nil {
+		return domain.Ingredient{}, err
+	}
+	return updated.Ingredients[ingredientIndex(updated.Ingredients, ingredient.ID)], nil
+}
+
+// ReplaceIngredient updates a catalog entry; allergens of every item using it
+// are derived again.
+func (a *ConciergeApp) ReplaceIngredient(ctx context.Context, restaurantID, ingredientID string, ingredient domain.Ingredient) (domain.Ingredient, error) {
+	ingredient.ID = ingredientID
+	updated, err := a.updateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		i := ingredientIndex(restaurant.Ingredients, ingredientID)
+		if i < 0 {
+			return ingredientNotFound(ingredientID)
+		}
+		restaurant.Ingredients[i] = ingredient
+		return nil
+	})
+	if err != nil {
+		return domain.Ingredient{}, err
+	}
+	return updated.Ingredients[ingredientIndex(updated.Ingredients, ingredientID)], nil
+}
+
+// DeleteIngredient removes a catalog entry unless a menu item or another
+// ingredient still uses it.
+func (a *ConciergeApp) DeleteIngredient(ctx context.Context, restaurantID, ingredientID string) error {
+	_, err := a.updateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		i := ingredientIndex(restaurant.Ingredients, ingredientID)
+		if i < 0 {
+			return ingredientNotFound(ingredientID)
+		}
+		var itemIDs, parentIDs []string
+		for _, item := range restaurant.MenuItems {
+			if slices.Contains(item.IngredientIDs, ingredientID) {
+				itemIDs = append(itemIDs, item.ID)
+			}
+		}
+		for _, other := range restaurant.Ingredients {
+			if slices.Contains(other.SubIngredientIDs, ingredientID) {
+				parentIDs = append(parentIDs, other.ID)
+			}
+		}
+		if len(itemIDs)+len(parentIDs) > 0 {
+			return NewError(ErrorKindConflict, fmt.Sprintf("ingredient %q is still in use", ingredientID), map[string]any{"itemIds": itemIDs, "ingredientIds": parentIDs})
+		}
+		restaurant.Ingredients = append(restaurant.Ingredients[:i], restaurant.Ingredients[i+1:]...)
+		return nil
+	})
+	return err
+}
+
+// AllergenMismatches reports menu items whose declared allergens disagree with
+// their ingredients or their description, for the admin to review.
+func (a *ConciergeApp) AllergenMismatches(ctx context.Context, restaurantID string) ([]domain.AllergenMismatch, error) {
+	restaurant, err := a.restaurants.LoadRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	mismatches := agent.FindAllergenMismatches(restaurant.MenuItems, restaurantJurisdiction(restaurant))
+	if mismatches == nil {
+		mismatches = []domain.AllergenMismatch{}
+	}
+	return mismatches, nil
+}
+
+func ingredientIndex(ingredients []domain.Ingredient, id string) int {
+	for i, ingredient := range ingredients {
+		if ingredient.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func ingredientNotFound(ingredientID string) error {
+	return NewError(ErrorKindNotFound, fmt.Sprintf("ingredient %q not found", ingredientID), map[string]any{"ingredientId": ingredientID})
+}
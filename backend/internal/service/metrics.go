@@ -0,0 +1,13 @@
+package service
+
+import "github.com/gourmet-guide/backend/internal/agent"
+
+// Metrics is a snapshot of in-process counters for operators.
+type Metrics struct {
+	ResponseCache  agent.CacheStats      `json:"responseCache"`
+	ModelProviders []agent.ProviderStats `json:"modelProviders,omitempty"`
+}
+
+func (a *ConciergeApp) Metrics() Metrics {
+	return Metrics{ResponseCache: a.concierge.CacheStats(), ModelProviders: a.concierge.ProviderStats()}
+}
@@ -0,0 +1,540 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gourmet-guide/backend/internal/agent"
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+// ErrRestaurantExists is returned when a restaurant ID is already taken.
+var ErrRestaurantExists = gcp.ErrRestaurantExists
+
+// ErrMenuNotPublished is returned when a session is started for a restaurant
+// whose menu only exists as drafts.
+var ErrMenuNotPublished = errors.New("restaurant menu has not been published")
+
+// Limits of a restaurant's concierge settings. The text overrides are short so
+// they tune the assistant rather than rewrite its instructions.
+const (
+	maxOutputTokensLimit = 8192
+	maxToneLength        = 80
+	maxLanguageLength    = 40
+	maxHouseRules        = 10
+	maxHouseRuleLength   = 200
+)
+
+// Restaurant records are the editable working copy of a menu. Every write
+// re-tags the menu and saves it as a draft version; guests only see it once the
+// draft is published.
+
+// CreateRestaurant validates and stores a new restaurant and saves its menu as
+// a draft. The restaurant is removed again when the draft cannot be saved, so
+// a failed create leaves nothing behind and can be retried with the same ID.
+func (a *ConciergeApp) CreateRestaurant(ctx context.Context, restaurant domain.Restaurant) (domain.Restaurant, error) {
+	if strings.TrimSpace(restaurant.ID) == "" {
+		restaurant.ID = newResourceID()
+	}
+	prepared, err := prepareRestaurant(restaurant)
+	if err != nil {
+		return domain.Restaurant{}, err
+	}
+	if err := a.restaurants.CreateRestaurant(ctx, prepared); err != nil {
+		return domain.Restaurant{}, err
+	}
+	if err := a.syncMenu(ctx, prepared); err != nil {
+		if rollbackErr := a.restaurants.DeleteRestaurant(context.WithoutCancel(ctx), prepared.ID); rollbackErr != nil {
+			return domain.Restaurant{}, errors.Join(err, fmt.Errorf("roll back restaurant %s: %w", prepared.ID, rollbackErr))
+		}
+		return domain.Restaurant{}, err
+	}
+	return prepared, nil
+}
+
+func (a *ConciergeApp) GetRestaurant(ctx context.Context, restaurantID string) (domain.Restaurant, error) {
+	return a.restaurants.LoadRestaurant(ctx, restaurantID)
+}
+
+func (a *ConciergeApp) ListRestaurants(ctx context.Context) ([]domain.Restaurant, error) {
+	return a.restaurants.ListRestaurants(ctx)
+}
+
+// ReplaceRestaurant overwrites the name, menu and combos of an existing restaurant.
+func (a *ConciergeApp) ReplaceRestaurant(ctx context.Context, restaurantID string, restaurant domain.Restaurant) (domain.Restaurant, error) {
+	return a.updateRestaurant(ctx, restaurantID, func(current *domain.Restaurant) error {
+		*current = restaurant
+		return nil
+	})
+}
+
+// DeleteRestaurant removes the restaurant and publishes an empty menu, so new
+// sessions and sessions without a pinned version fail closed.
+func (a *ConciergeApp) DeleteRestaurant(ctx context.Context, restaurantID string) error {
+	if err := a.restaurants.DeleteRestaurant(ctx, restaurantID); err != nil {
+		return err
+	}
+	_, err := a.concierge.SaveMenuItems(ctx, restaurantID, nil)
+	return err
+}
+
+func (a *ConciergeApp) ListMenuItems(ctx context.Context, restaurantID string) ([]domain.MenuItem, error) {
+	restaurant, err := a.restaurants.LoadRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	return restaurant.MenuItems, nil
+}
+
+func (a *ConciergeApp) GetMenuItem(ctx context.Context, restaurantID, itemID string) (domain.MenuItem, error) {
+	restaurant, err := a.restaurants.LoadRestaurant(ctx, restaurantID)
+	if err != nil {
+		return domain.MenuItem{}, err
+	}
+	if i := menuItemIndex(restaurant.MenuItems, itemID); i >= 0 {
+		return restaurant.MenuItems[i], nil
+	}
+	return domain.MenuItem{}, menuItemNotFound(itemID)
+}
+
+func (a *ConciergeApp) AddMenuItem(ctx context.Context, restaurantID string, item domain.MenuItem) (domain.MenuItem, error) {
+	if strings.TrimSpace(item.ID) == "" {
+		item.ID = newResourceID()
+	}
+	updated, err := a.updateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		restaurant.MenuItems = append(restaurant.MenuItems, item)
+		return nil
+	})
+	if err != nil {
+		return domain.MenuItem{}, err
+	}
+	return updated.MenuItems[menuItemIndex(updated.MenuItems, item.ID)], nil
+}
+
+func (a *ConciergeApp) ReplaceMenuItem(ctx context.Context, restaurantID, itemID string, item domain.MenuItem) (domain.MenuItem, error) {
+	item.ID = itemID
+	updated, err := a.updateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		i := menuItemIndex(restaurant.MenuItems, itemID)
+		if i < 0 {
+			return menuItemNotFound(itemID)
+		}
+		restaurant.MenuItems[i] = item
+		return nil
+	})
+	if err != nil {
+		return domain.MenuItem{}, err
+	}
+	return updated.MenuItems[menuItemIndex(updated.MenuItems, itemID)], nil
+}
+
+// DeleteMenuItem removes an item unless a combo still references it.
+func (a *ConciergeApp) DeleteMenuItem(ctx context.Context, restaurantID, itemID string) error {
+	_, err := a.updateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		i := menuItemIndex(restaurant.MenuItems, itemID)
+		if i < 0 {
+			return menuItemNotFound(itemID)
+		}
+		var comboIDs []string
+		for _, combo := range restaurant.Combos {
+			for _, id := range combo.ItemIDs {
+				if id == itemID {
+					comboIDs = append(comboIDs, combo.ID)
+					break
+				}
+			}
+		}
+		if len(comboIDs) > 0 {
+			return NewError(ErrorKindConflict, fmt.Sprintf("menu item %q is used by combos", itemID), map[string]any{"comboIds": comboIDs})
+		}
+		restaurant.MenuItems = append(restaurant.MenuItems[:i], restaurant.MenuItems[i+1:]...)
+		return nil
+	})
+	return err
+}
+
+func (a *ConciergeApp) ListCombos(ctx context.Context, restaurantID string) ([]domain.Combo, error) {
+	restaurant, err := a.restaurants.LoadRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	return restaurant.Combos, nil
+}
+
+func (a *ConciergeApp) GetCombo(ctx context.Context, restaurantID, comboID string) (domain.Combo, error) {
+	restaurant, err := a.restaurants.LoadRestaurant(ctx, restaurantID)
+	if err != nil {
+		return domain.Combo{}, err
+	}
+	if i := comboIndex(restaurant.Combos, comboID); i >= 0 {
+		return restaurant.Combos[i], nil
+	}
+	return domain.Combo{}, comboNotFound(comboID)
+}
+
+func (a *ConciergeApp) AddCombo(ctx context.Context, restaurantID string, combo domain.Combo) (domain.Combo, error) {
+	if strings.TrimSpace(combo.ID) == "" {
+		combo.ID = newResourceID()
+	}
+	updated, err := a.updateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		restaurant.Combos = append(restaurant.Combos, combo)
+		return nil
+	})
+	if err != nil {
+		return domain.Combo{}, err
+	}
+	return updated.Combos[comboIndex(updated.Combos, combo.ID)], nil
+}
+
+func (a *ConciergeApp) ReplaceCombo(ctx context.Context, restaurantID, comboID string, combo domain.Combo) (domain.Combo, error) {
+	combo.ID = comboID
+	updated, err := a.updateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		i := comboIndex(restaurant.Combos, comboID)
+		if i < 0 {
+			return comboNotFound(comboID)
+		}
+		restaurant.Combos[i] = combo
+		return nil
+	})
+	if err != nil {
+		return domain.Combo{}, err
+	}
+	return updated.Combos[comboIndex(updated.Combos, comboID)], nil
+}
+
+func (a *ConciergeApp) DeleteCombo(ctx context.Context, restaurantID, comboID string) error {
+	_, err := a.updateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		i := comboIndex(restaurant.Combos, comboID)
+		if i < 0 {
+			return comboNotFound(comboID)
+		}
+		restaurant.Combos = append(restaurant.Combos[:i], restaurant.Combos[i+1:]...)
+		return nil
+	})
+	return err
+}
+
+// updateRestaurant validates the edited restaurant before it is stored and then
+// syncs the menu to the concierge.
+func (a *ConciergeApp) updateRestaurant(ctx context.Context, restaurantID string, edit func(*domain.Restaurant) error) (domain.Restaurant, error) {
+	updated, err := a.restaurants.UpdateRestaurant(ctx, restaurantID, func(restaurant *domain.Restaurant) error {
+		if err := edit(restaurant); err != nil {
+			return err
+		}
+		restaurant.ID = restaurantID
+		prepared, err := prepareRestaurant(*restaurant)
+		if err != nil {
+			return err
+		}
+		*restaurant = prepared
+		return nil
+	})
+	if err != nil {
+		return domain.Restaurant{}, err
+	}
+	if err := a.syncMenu(ctx, updated); err != nil {
+		return domain.Restaurant{}, err
+	}
+	return updated, nil
+}
+
+func (a *ConciergeApp) syncMenu(ctx context.Context, restaurant domain.Restaurant) error {
+	_, err := a.concierge.SaveMenuDraft(ctx, restaurant.ID, restaurant.MenuItems, agent.MenuSourceRestaurant)
+	return err
+}
+
+// menuForSession returns the live menu a new session should use. Restaurants
+// written outside the API, such as by the seed tool, have no menu versions yet;
+// their menu is published on first use. Restaurants with only drafts are refused.
+func (a *ConciergeApp) menuForSession(ctx context.Context, restaurantID string) ([]domain.MenuItem, error) {
+	items, err := a.concierge.LoadMenuItems(ctx, restaurantID)
+	if !errors.Is(err, gcp.ErrRestaurantNotFound) {
+		return items, err
+	}
+	restaurant, err := a.restaurants.LoadRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.concierge.ListMenuVersions(ctx, restaurantID); !errors.Is(err, gcp.ErrRestaurantNotFound) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrMenuNotPublished
+	}
+	draft, err := a.concierge.SaveMenuDraft(ctx, restaurantID, restaurant.MenuItems, agent.MenuSourceSeed)
+	if err != nil {
+		return nil, err
+	}
+	published, err := a.concierge.PublishMenuVersion(ctx, restaurantID, draft.Version)
+	if err != nil {
+		return nil, err
+	}
+	return published.MenuItems, nil
+}
+
+// prepareRestaurant normalizes IDs and allergens, derives allergens from
+// ingredients, suggests dietary tags and validates that ingredient, item and
+// combo IDs are unique and that items and combos only reference existing entries.
+func prepareRestaurant(restaurant domain.Restaurant) (domain.Restaurant, error) {
+	restaurant.Name = strings.TrimSpace(restaurant.Name)
+	if restaurant.Name == "" {
+		return domain.Restaurant{}, ValidationError("restaurant name is required", map[string]any{"field": "name"})
+	}
+	jurisdiction, ok := domain.ParseJurisdiction(string(restaurant.Jurisdiction))
+	if !ok {
+		return domain.Restaurant{}, ValidationError(fmt.Sprintf("unknown jurisdiction %q", restaurant.Jurisdiction), map[string]any{"field": "jurisdiction", "supported": domain.Jurisdictions})
+	}
+	restaurant.Jurisdiction = jurisdiction
+	concierge, err := prepareConciergeSettings(restaurant.Concierge)
+	if err != nil {
+		return domain.Restaurant{}, err
+	}
+	restaurant.Concierge = concierge
+	// Normalizing edits the lists below in place; work on copies so the
+	// caller's restaurant is left as it was.
+	restaurant.Ingredients = slices.Clone(restaurant.Ingredients)
+	restaurant.MenuItems = slices.Clone(restaurant.MenuItems)
+	restaurant.Combos = slices.Clone(restaurant.Combos)
+	if restaurant.MenuItems == nil {
+		restaurant.MenuItems = []domain.MenuItem{}
+	}
+	if restaurant.Combos == nil {
+		restaurant.Combos = []domain.Combo{}
+	}
+
+	ingredientIDs := make(map[string]struct{}, len(restaurant.Ingredients))
+	for i, ingredient := range restaurant.Ingredients {
+		ingredient.ID = strings.TrimSpace(ingredient.ID)
+		ingredient.Name = strings.TrimSpace(ingredient.Name)
+		if ingredient.ID == "" {
+			ingredient.ID = newResourceID()
+		}
+		if ingredient.Name == "" {
+			return domain.Restaurant{}, ValidationError("ingredient name is required", map[string]any{"field": "ingredients.name", "ingredientId": ingredient.ID})
+		}
+		if _, dup := ingredientIDs[ingredient.ID]; dup {
+			return domain.Restaurant{}, ValidationError(fmt.Sprintf("duplicate ingredient id %q", ingredient.ID), map[string]any{"field": "ingredients.id", "ingredientId": ingredient.ID})
+		}
+		ingredientIDs[ingredient.ID] = struct{}{}
+		details := map[string]any{"field": "ingredients.allergens", "ingredientId": ingredient.ID}
+		if ingredient.Allergens, err = normalizeAllergens(ingredient.Allergens, details); err != nil {
+			return domain.Restaurant{}, err
+		}
+		details = map[string]any{"field": "ingredients.mayContain", "ingredientId": ingredient.ID}
+		if ingredient.MayContain, err = normalizeAllergens(ingredient.MayContain, details); err != nil {
+			return domain.Restaurant{}, err
+		}
+		restaurant.Ingredients[i] = ingredient
+	}
+	if err := agent.ValidateIngredientCatalog(restaurant.Ingredients); err != nil {
+		return domain.Restaurant{}, ValidationError(err.Error(), map[string]any{"field": "ingredients.subIngredientIds"})
+	}
+
+	itemIDs := make(map[string]struct{}, len(restaurant.MenuItems))
+	for i, item := range restaurant.MenuItems {
+		item.ID = strings.TrimSpace(item.ID)
+		item.Name = strings.TrimSpace(item.Name)
+		if item.ID == "" {
+			item.ID = newResourceID()
+		}
+		if item.Name == "" {
+			return domain.Restaurant{}, ValidationError("menu item name is required", map[string]any{"field": "menuItems.name", "itemId": item.ID})
+		}
+		if _, dup := itemIDs[item.ID]; dup {
+			return domain.Restaurant{}, ValidationError(fmt.Sprintf("duplicate menu item id %q", item.ID), map[string]any{"field": "menuItems.id", "itemId": item.ID})
+		}
+		itemIDs[item.ID] = struct{}{}
+		restaurant.MenuItems[i] = item
+	}
+	if restaurant.MenuItems, err = normalizeMenuAllergens(restaurant.MenuItems, "menuItems"); err != nil {
+		return domain.Restaurant{}, err
+	}
+	derived, err := agent.DeriveMenuAllergens(restaurant.MenuItems, restaurant.Ingredients)
+	if err != nil {
+		return domain.Restaurant{}, ValidationError(err.Error(), map[string]any{"field": "menuItems.ingredientIds"})
+	}
+	restaurant.MenuItems = agent.EnrichMenuItemsWithSuggestedTags(derived)
+
+	comboIDs := make(map[string]struct{}, len(restaurant.Combos))
+	for i, combo := range restaurant.Combos {
+		combo.ID = strings.TrimSpace(combo.ID)
+		combo.Name = strings.TrimSpace(combo.Name)
+		if combo.ID == "" {
+			combo.ID = newResourceID()
+		}
+		if combo.Name == "" {
+			return domain.Restaurant{}, ValidationError("combo name is required", map[string]any{"field": "combos.name", "comboId": combo.ID})
+		}
+		if _, dup := comboIDs[combo.ID]; dup {
+			return domain.Restaurant{}, ValidationError(fmt.Sprintf("duplicate combo id %q", combo.ID), map[string]any{"field": "combos.id", "comboId": combo.ID})
+		}
+		comboIDs[combo.ID] = struct{}{}
+		if len(combo.ItemIDs) == 0 {
+			return domain.Restaurant{}, ValidationError("combo must reference at least one menu item", map[string]any{"field": "combos.itemIds", "comboId": combo.ID})
+		}
+		var missing []string
+		for _, id := range combo.ItemIDs {
+			if _, ok := itemIDs[id]; !ok {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			return domain.Restaurant{}, ValidationError("combo references unknown menu items", map[string]any{"field": "combos.itemIds", "comboId": combo.ID, "missingItemIds": missing})
+		}
+		restaurant.Combos[i] = combo
+	}
+	return restaurant, nil
+}
+
+func menuItemIndex(items []domain.MenuItem, id string) int {
+	for i, item := range items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func comboIndex(combos []domain.Combo, id string) int {
+	for i, combo := range combos {
+		if combo.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func menuItemNotFound(itemID string) error {
+	return NewError(ErrorKindNotFound, fmt.Sprintf("menu item %q not found", itemID), map[string]any{"itemId": itemID})
+}
+
+func comboNotFound(comboID string) error {
+	return NewError(ErrorKindNotFound, fmt.Sprintf("combo %q not found", comboID), map[string]any{"comboId": comboID})
+}
+
+func newResourceID() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// restaurantJurisdiction returns the restaurant's jurisdiction, defaulting for
+// records written before jurisdictions existed.
+func restaurantJurisdiction(restaurant domain.Restaurant) domain.Jurisdiction {
+	if restaurant.Jurisdiction == "" {
+		return domain.DefaultJurisdiction
+	}
+	return restaurant.Jurisdiction
+}
+
+// normalizeMenuAllergens canonicalizes the declared allergens of every item;
+// field prefixes the error details.
+func normalizeMenuAllergens(items []domain.MenuItem, field string) ([]domain.MenuItem, error) {
+	normalized := make([]domain.MenuItem, len(items))
+	for i, item := range items {
+		var err error
+		if item.Allergens, err = normalizeAllergens(item.Allergens, map[string]any{"field": field + ".allergens", "itemId": item.ID}); err != nil {
+			return nil, err
+		}
+		if item.CrossContaminationRisk, err = normalizeAllergens(item.CrossContaminationRisk, map[string]any{"field": field + ".crossContaminationRisk", "itemId": item.ID}); err != nil {
+			return nil, err
+		}
+		normalized[i] = item
+	}
+	return normalized, nil
+}
+
+// normalizeAllergens resolves synonyms to registry IDs and rejects unknown
+// allergens with a validation error carrying details plus the unknown values.
+func normalizeAllergens(values []domain.Allergen, details map[string]any) ([]domain.Allergen, error) {
+	normalized, unknown := domain.NormalizeAllergens(values)
+	if len(unknown) > 0 {
+		details["unknown"] = unknown
+		return nil, ValidationError(fmt.Sprintf("unknown allergen %q", unknown[0]), details)
+	}
+	if normalized == nil && values != nil {
+		normalized = []domain.Allergen{}
+	}
+	return normalized, nil
+}
+
+// restaurantCombos serves the concierge's suggest_combo tool from the
+// restaurant store. Restaurants that only exist as a posted menu have none.
+type restaurantCombos struct {
+	restaurants gcp.RestaurantStore
+}
+
+func (c restaurantCombos) Combos(ctx context.Context, restaurantID string) ([]domain.Combo, error) {
+	restaurant, err := c.restaurants.LoadRestaurant(ctx, restaurantID)
+	if errors.Is(err, gcp.ErrRestaurantNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return restaurant.Combos, nil
+}
+
+// prepareConciergeSettings validates a restaurant's concierge settings and
+// collapses whitespace in its text overrides, so each stays on one line of the
+// system instructions.
+func prepareConciergeSettings(settings domain.ConciergeSettings) (domain.ConciergeSettings, error) {
+	if outputCap := settings.MaxOutputTokens; outputCap < 0 || outputCap > maxOutputTokensLimit {
+		return domain.ConciergeSettings{}, ValidationError(fmt.Sprintf("concierge.maxOutputTokens must be between 0 and %d", maxOutputTokensLimit), map[string]any{"field": "concierge.maxOutputTokens"})
+	}
+	settings.PromptVersion = strings.TrimSpace(settings.PromptVersion)
+	if settings.PromptVersion != "" && !slices.Contains(agent.PromptVersions(), settings.PromptVersion) {
+		return domain.ConciergeSettings{}, ValidationError(fmt.Sprintf("unknown prompt version %q", settings.PromptVersion), map[string]any{"field": "concierge.promptVersion", "supported": agent.PromptVersions()})
+	}
+	settings.Tone = strings.Join(strings.Fields(settings.Tone), " ")
+	if utf8.RuneCountInString(settings.Tone) > maxToneLength {
+		return domain.ConciergeSettings{}, ValidationError(fmt.Sprintf("concierge.tone must be at most %d characters", maxToneLength), map[string]any{"field": "concierge.tone"})
+	}
+	settings.Language = strings.Join(strings.Fields(settings.Language), " ")
+	if utf8.RuneCountInString(settings.Language) > maxLanguageLength {
+		return domain.ConciergeSettings{}, ValidationError(fmt.Sprintf("concierge.language must be at most %d characters", maxLanguageLength), map[string]any{"field": "concierge.language"})
+	}
+	if len(settings.HouseRules) > maxHouseRules {
+		return domain.ConciergeSettings{}, ValidationError(fmt.Sprintf("concierge.houseRules allows at most %d rules", maxHouseRules), map[string]any{"field": "concierge.houseRules"})
+	}
+	rules := make([]string, 0, len(settings.HouseRules))
+	for _, rule := range settings.HouseRules {
+		rule = strings.Join(strings.Fields(rule), " ")
+		if rule == "" {
+			continue
+		}
+		if utf8.RuneCountInString(rule) > maxHouseRuleLength {
+			return domain.ConciergeSettings{}, ValidationError(fmt.Sprintf("each house rule must be at most %d characters", maxHouseRuleLength), map[string]any{"field": "concierge.houseRules"})
+		}
+		rules = append(rules, rule)
+	}
+	settings.HouseRules = nil
+	if len(rules) > 0 {
+		settings.HouseRules = rules
+	}
+	return settings, nil
+}
+
+// restaurantSettings serves restaurants' concierge settings from the
+// restaurant store. Restaurants that only exist as a posted menu use the
+// defaults.
+type restaurantSettings struct {
+	restaurants gcp.RestaurantStore
+}
+
+func (c restaurantSettings) ConciergeSettings(ctx context.Context, restaurantID string) (domain.ConciergeSettings, error) {
+	restaurant, err := c.restaurants.LoadRestaurant(ctx, restaurantID)
+	if errors.Is(err, gcp.ErrRestaurantNotFound) {
+		return domain.ConciergeSettings{}, nil
+	}
+	if err != nil {
+		return domain.ConciergeSettings{}, err
+	}
+	return restaurant.Concierge, nil
+}
@@ -0,0 +1,374 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gourmet-guide/backend/internal/agent"
+	"github.com/gourmet-guide/backend/internal/domain"
+	"github.com/gourmet-guide/backend/internal/gcp"
+)
+
+func newTestApp() *ConciergeApp {
+	store := gcp.NewMemoryStore()
+	concierge := agent.NewConciergeService(store, gcp.NewMemoryImageStore(), agent.NewRuntime("gemini", store))
+	return NewConciergeApp(concierge)
+}
+
+func TestCreateRestaurantValidatesItemAndComboIDs(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+
+	_, err := app.CreateRestaurant(ctx, domain.Restaurant{
+		Name:      "Dupes",
+		MenuItems: []domain.MenuItem{{ID: "a", Name: "One"}, {ID: "a", Name: "Two"}},
+	})
+	if KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation error for duplicate item IDs, got %v", err)
+	}
+
+	_, err = app.CreateRestaurant(ctx, domain.Restaurant{
+		Name:      "Ghost combo",
+		MenuItems: []domain.MenuItem{{ID: "a", Name: "One"}},
+		Combos:    []domain.Combo{{ID: "c", Name: "Set", ItemIDs: []string{"a", "missing"}}},
+	})
+	if KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation error for unknown combo item, got %v", err)
+	}
+	if missing, _ := DetailsOf(err)["missingItemIds"].([]string); len(missing) != 1 || missing[0] != "missing" {
+		t.Fatalf("expected missing item IDs in details, got %v", DetailsOf(err))
+	}
+
+	created, err := app.CreateRestaurant(ctx, domain.Restaurant{ID: "r1", Name: "Ok", MenuItems: []domain.MenuItem{{Name: "Vegan bowl"}}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.MenuItems[0].ID == "" || len(created.MenuItems[0].Tags) == 0 {
+		t.Fatalf("expected generated item ID and suggested tags, got %+v", created.MenuItems[0])
+	}
+	if _, err := app.CreateRestaurant(ctx, domain.Restaurant{ID: "r1", Name: "Again"}); KindOf(err) != ErrorKindConflict {
+		t.Fatalf("expected conflict for duplicate restaurant ID, got %v", err)
+	}
+}
+
+func TestCreateRestaurantLeavesTheCallersListsUnchanged(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	restaurant := domain.Restaurant{
+		Name:        "Bistro",
+		Ingredients: []domain.Ingredient{{ID: " flour ", Name: "Flour", Allergens: []domain.Allergen{"wheat"}}},
+		MenuItems:   []domain.MenuItem{{ID: " bread ", Name: " Bread ", IngredientIDs: []string{"flour"}}},
+		Combos:      []domain.Combo{{ID: " lunch ", Name: " Lunch ", ItemIDs: []string{"bread"}}},
+	}
+	if _, err := app.CreateRestaurant(context.Background(), restaurant); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if restaurant.Ingredients[0].ID != " flour " || restaurant.MenuItems[0].Name != " Bread " || restaurant.MenuItems[0].Tags != nil || restaurant.Combos[0].ID != " lunch " {
+		t.Fatalf("expected the caller's restaurant to be left as it was, got %+v", restaurant)
+	}
+}
+
+// failingMenuStore refuses to save menu versions.
+type failingMenuStore struct {
+	*gcp.MemoryStore
+}
+
+func (failingMenuStore) SaveMenuVersion(context.Context, string, []domain.MenuItem, string) (domain.MenuVersion, error) {
+	return domain.MenuVersion{}, fmt.Errorf("%w: menu store down", gcp.ErrUnavailable)
+}
+
+func TestCreateRestaurantRollsBackWhenTheMenuCannotBeSaved(t *testing.T) {
+	t.Parallel()
+	store := failingMenuStore{MemoryStore: gcp.NewMemoryStore()}
+	restaurants := gcp.NewMemoryRestaurantStore()
+	app := NewConciergeAppWithRestaurants(agent.NewConciergeService(store, gcp.NewMemoryImageStore(), agent.NewRuntime("gemini", store)), restaurants)
+	ctx := context.Background()
+
+	_, err := app.CreateRestaurant(ctx, domain.Restaurant{ID: "r1", Name: "Bistro", MenuItems: []domain.MenuItem{{Name: "Soup"}}})
+	if KindOf(err) != ErrorKindUnavailable {
+		t.Fatalf("expected the menu store failure, got %v", err)
+	}
+	if _, err := app.GetRestaurant(ctx, "r1"); KindOf(err) != ErrorKindNotFound {
+		t.Fatalf("expected the restaurant to be rolled back, got %v", err)
+	}
+}
+
+func TestDeleteMenuItemReferencedByComboConflicts(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+
+	_, err := app.CreateRestaurant(ctx, domain.Restaurant{
+		ID:        "r1",
+		Name:      "Combo house",
+		MenuItems: []domain.MenuItem{{ID: "a", Name: "Soup"}, {ID: "b", Name: "Salad"}},
+		Combos:    []domain.Combo{{ID: "c", Name: "Lunch", ItemIDs: []string{"a", "b"}}},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := app.DeleteMenuItem(ctx, "r1", "a"); KindOf(err) != ErrorKindConflict {
+		t.Fatalf("expected conflict deleting combo item, got %v", err)
+	}
+	if err := app.DeleteCombo(ctx, "r1", "c"); err != nil {
+		t.Fatalf("delete combo: %v", err)
+	}
+	if err := app.DeleteMenuItem(ctx, "r1", "a"); err != nil {
+		t.Fatalf("delete item: %v", err)
+	}
+	items, err := app.ListMenuItems(ctx, "r1")
+	if err != nil || len(items) != 1 || items[0].ID != "b" {
+		t.Fatalf("expected only item b left, got %+v (%v)", items, err)
+	}
+}
+
+func TestStartSessionUsesStoredRestaurantMenu(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+
+	if _, err := app.CreateRestaurant(ctx, domain.Restaurant{ID: "r1", Name: "Stored", MenuItems: []domain.MenuItem{{ID: "a", Name: "Tofu Bowl"}}}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := app.AddMenuItem(ctx, "r1", domain.MenuItem{ID: "b", Name: "Miso Soup"}); err != nil {
+		t.Fatalf("add item: %v", err)
+	}
+	if _, err := app.StartSession(ctx, StartSessionInput{RestaurantID: "r1"}); !errors.Is(err, ErrMenuNotPublished) {
+		t.Fatalf("expected drafts-only menu to be refused, got %v", err)
+	}
+	versions, err := app.ListMenuVersions(ctx, "r1")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("expected a draft per restaurant write, got %+v (%v)", versions, err)
+	}
+	if _, err := app.PublishMenuVersion(ctx, "r1", versions[1].Version); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	started, err := app.StartSession(ctx, StartSessionInput{RestaurantID: "r1"})
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if len(started.SuggestedMenuItems) != 2 || started.Session.MenuVersion != 2 {
+		t.Fatalf("expected stored menu with 2 items, got %+v", started.SuggestedMenuItems)
+	}
+	reply, err := app.SendMessage(ctx, started.Session.ID, "what do you have?")
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if reply.Text == "" || len(reply.Safety) != 2 {
+		t.Fatal("expected a reply with verdicts from the stored menu")
+	}
+}
+
+func TestIngredientChangesRederiveMenuAllergens(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+
+	_, err := app.CreateRestaurant(ctx, domain.Restaurant{
+		ID:          "r1",
+		Name:        "Satay house",
+		Ingredients: []domain.Ingredient{{ID: "sauce", Name: "House sauce"}},
+		MenuItems:   []domain.MenuItem{{ID: "skewer", Name: "Skewers", IngredientIDs: []string{"sauce"}}},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := app.ReplaceIngredient(ctx, "r1", "sauce", domain.Ingredient{Name: "Peanut sauce", Allergens: []domain.Allergen{domain.AllergenPeanut}}); err != nil {
+		t.Fatalf("replace ingredient: %v", err)
+	}
+	item, err := app.GetMenuItem(ctx, "r1", "skewer")
+	if err != nil {
+		t.Fatalf("get item: %v", err)
+	}
+	if len(item.DerivedAllergens) != 1 || item.DerivedAllergens[0] != domain.AllergenPeanut {
+		t.Fatalf("expected peanut derived from updated ingredient, got %+v", item.DerivedAllergens)
+	}
+	mismatches, err := app.AllergenMismatches(ctx, "r1")
+	if err != nil || len(mismatches) != 1 || mismatches[0].ItemID != "skewer" {
+		t.Fatalf("expected undeclared peanut to be flagged, got %+v (%v)", mismatches, err)
+	}
+
+	if err := app.DeleteIngredient(ctx, "r1", "sauce"); KindOf(err) != ErrorKindConflict {
+		t.Fatalf("expected conflict deleting an ingredient in use, got %v", err)
+	}
+	if _, err := app.AddMenuItem(ctx, "r1", domain.MenuItem{Name: "Mystery", IngredientIDs: []string{"nope"}}); KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation error for unknown ingredient, got %v", err)
+	}
+}
+
+func TestRestaurantAllergensGoThroughRegistry(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+
+	created, err := app.CreateRestaurant(ctx, domain.Restaurant{
+		Name:      "Brasserie",
+		MenuItems: []domain.MenuItem{{ID: "moules", Name: "Moules", Allergens: []domain.Allergen{"Mollusks", "sulfites"}}},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.Jurisdiction != domain.JurisdictionUS {
+		t.Fatalf("expected default jurisdiction, got %q", created.Jurisdiction)
+	}
+	if got := created.MenuItems[0].Allergens; len(got) != 2 || got[0] != domain.AllergenMollusc || got[1] != domain.AllergenSulphites {
+		t.Fatalf("expected canonical allergen IDs, got %v", got)
+	}
+
+	_, err = app.CreateRestaurant(ctx, domain.Restaurant{Name: "Bad", MenuItems: []domain.MenuItem{{Name: "Dish", Allergens: []domain.Allergen{"kiwi"}}}})
+	if KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation error for unknown allergen, got %v", err)
+	}
+	_, err = app.CreateRestaurant(ctx, domain.Restaurant{Name: "Bad", Jurisdiction: "mars"})
+	if KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation error for unknown jurisdiction, got %v", err)
+	}
+	_, err = app.StartSession(ctx, StartSessionInput{RestaurantID: created.ID, HardAllergens: []domain.Allergen{"kiwi"}})
+	if KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation error for unknown guest allergen, got %v", err)
+	}
+}
+
+func TestStartSessionValidatesAndMergesAllergyProfile(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+	menu := []domain.MenuItem{{Name: "Soup"}}
+
+	out, err := app.StartSession(ctx, StartSessionInput{
+		RestaurantID:  "r1",
+		MenuItems:     menu,
+		HardAllergens: []domain.Allergen{"peanuts"},
+		Allergies: []domain.Allergy{
+			{Allergen: "milk", Severity: domain.SeverityIntolerance},
+			{Allergen: domain.AllergenPeanut, Severity: domain.SeverityPreference},
+			{Allergen: domain.AllergenSesame},
+		},
+	})
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	want := []domain.Allergy{
+		{Allergen: domain.AllergenDairy, Severity: domain.SeverityIntolerance},
+		{Allergen: domain.AllergenPeanut, Severity: domain.SeverityAnaphylaxis},
+		{Allergen: domain.AllergenSesame, Severity: domain.SeverityAnaphylaxis},
+	}
+	if got := out.Session.Allergies; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if hard := out.Session.HardAllergens; len(hard) != 2 || hard[0] != domain.AllergenPeanut || hard[1] != domain.AllergenSesame {
+		t.Fatalf("expected anaphylaxis entries mirrored into hardAllergens, got %v", hard)
+	}
+
+	_, err = app.StartSession(ctx, StartSessionInput{RestaurantID: "r1", MenuItems: menu, Allergies: []domain.Allergy{{Allergen: domain.AllergenEgg, Severity: "mild"}}})
+	if KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation error for unknown severity, got %v", err)
+	}
+}
+
+func TestSafetyPolicyDryRunNormalizesProfiles(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+	menu := []domain.MenuItem{{ID: "fries", Name: "Fries", Stations: []string{"fryer"}, CrossContaminationRisk: []domain.Allergen{domain.AllergenFish}}}
+	if _, err := app.concierge.SaveMenuItems(ctx, "r1", menu); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	candidate := domain.SafetyPolicy{Rules: []domain.PolicyRule{{ID: "fryer", Type: domain.PolicyRuleStationExposure, Station: "fryer"}}}
+
+	result, err := app.DryRunSafetyPolicy(ctx, "r1", PolicyDryRunInput{
+		Policy:   candidate,
+		Profiles: []domain.PolicyProfile{{Allergies: []domain.Allergy{{Allergen: "Finfish", Severity: domain.SeverityAllergy}}}},
+	})
+	if err != nil || result.ProfilesChecked != 1 || len(result.Impacts) != 1 {
+		t.Fatalf("expected the fish synonym to resolve and the fries to change, got %+v (%v)", result, err)
+	}
+	if impact := result.Impacts[0]; impact.Before.Verdict != domain.VerdictCaution || impact.After.Verdict != domain.VerdictUnsafe {
+		t.Fatalf("expected caution to become unsafe, got %+v", impact)
+	}
+
+	_, err = app.DryRunSafetyPolicy(ctx, "r1", PolicyDryRunInput{Policy: candidate, Profiles: []domain.PolicyProfile{{Allergies: []domain.Allergy{{Allergen: "unicorn"}}}}})
+	if KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation error for an unknown allergen, got %v", err)
+	}
+	if _, err := app.GetSafetyPolicy(ctx, "r1", 3); KindOf(err) != ErrorKindNotFound {
+		t.Fatalf("expected not found for an unsaved policy version, got %v", err)
+	}
+}
+
+func TestRestaurantConciergeSettingsAreValidatedAndServed(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+
+	_, err := app.CreateRestaurant(ctx, domain.Restaurant{Name: "Chatty", Concierge: domain.ConciergeSettings{MaxOutputTokens: -1}})
+	if KindOf(err) != ErrorKindValidation {
+		t.Fatalf("expected validation error for a negative output cap, got %v", err)
+	}
+	for _, settings := range []domain.ConciergeSettings{
+		{PromptVersion: "concierge-v0"},
+		{Tone: strings.Repeat("very ", maxToneLength)},
+		{HouseRules: make([]string, maxHouseRules+1)},
+	} {
+		if _, err := app.CreateRestaurant(ctx, domain.Restaurant{Name: "Chatty", Concierge: settings}); KindOf(err) != ErrorKindValidation {
+			t.Fatalf("expected validation error for %+v, got %v", settings, err)
+		}
+	}
+	created, err := app.CreateRestaurant(ctx, domain.Restaurant{Name: "Terse", Concierge: domain.ConciergeSettings{
+		MaxOutputTokens: 64,
+		PromptVersion:   "concierge-v1",
+		Language:        " Spanish ",
+		HouseRules:      []string{"Mention the\nhappy hour", "  "},
+	}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	source := restaurantSettings{restaurants: app.restaurants}
+	if settings, err := source.ConciergeSettings(ctx, created.ID); err != nil || settings.MaxOutputTokens != 64 || settings.Language != "Spanish" || len(settings.HouseRules) != 1 || settings.HouseRules[0] != "Mention the happy hour" {
+		t.Fatalf("expected the restaurant's normalized settings, got %+v (%v)", settings, err)
+	}
+	instruction, version, err := app.VoiceInstruction(ctx, created.ID)
+	if err != nil || version != "concierge-v1" || !strings.Contains(instruction, "Reply in Spanish.") {
+		t.Fatalf("expected the restaurant's voice instruction, got %q (%s, %v)", instruction, version, err)
+	}
+	if settings, err := source.ConciergeSettings(ctx, "posted-menu-only"); err != nil || settings.MaxOutputTokens != 0 {
+		t.Fatalf("expected defaults for unknown restaurants, got %+v (%v)", settings, err)
+	}
+}
+
+func TestPostedSessionMenuNeverReplacesTheLiveMenu(t *testing.T) {
+	t.Parallel()
+	app := newTestApp()
+	ctx := context.Background()
+	posted := []domain.MenuItem{{ID: "wrap", Name: "Veggie Wrap"}}
+
+	first, err := app.StartSession(ctx, StartSessionInput{RestaurantID: "pop-up", MenuItems: posted})
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	second, err := app.StartSession(ctx, StartSessionInput{RestaurantID: "pop-up", MenuItems: posted})
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if first.Session.MenuVersion != 1 || second.Session.MenuVersion != 1 {
+		t.Fatalf("expected both sessions on one draft, got versions %d and %d", first.Session.MenuVersion, second.Session.MenuVersion)
+	}
+	if _, err := app.concierge.LiveMenu(ctx, "pop-up"); !errors.Is(err, gcp.ErrRestaurantNotFound) {
+		t.Fatalf("expected no live menu, got %v", err)
+	}
+
+	if _, err := app.concierge.SaveMenuItems(ctx, "pop-up", []domain.MenuItem{{ID: "soup", Name: "Soup"}}); err != nil {
+		t.Fatalf("save menu: %v", err)
+	}
+	if _, err := app.StartSession(ctx, StartSessionInput{RestaurantID: "pop-up", MenuItems: posted}); KindOf(err) != ErrorKindConflict {
+		t.Fatalf("expected a posted menu to be refused once a menu is live, got %v", err)
+	}
+	live, err := app.concierge.LiveMenu(ctx, "pop-up")
+	if err != nil || len(live.MenuItems) != 1 || live.MenuItems[0].ID != "soup" {
+		t.Fatalf("expected the published menu to stay live, got %+v (%v)", live, err)
+	}
+}
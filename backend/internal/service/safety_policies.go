@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+
+	"github.com/gourmet-guide/backend/internal/domain"
+)
+
+// PolicyDryRunInput is a candidate policy and the guest profiles to compare it
+// on. Without profiles the dry run probes the whole live menu.
+type PolicyDryRunInput struct {
+	Policy   domain.SafetyPolicy
+	Profiles []domain.PolicyProfile
+}
+
+// GetSafetyPolicy returns a saved policy version, or the policy in force when
+// version is zero.
+func (a *ConciergeApp) GetSafetyPolicy(ctx context.Context, restaurantID string, version int) (domain.SafetyPolicy, error) {
+	return a.concierge.SafetyPolicy(ctx, restaurantID, version)
+}
+
+func (a *ConciergeApp) ListSafetyPolicies(ctx context.Context, restaurantID string) ([]domain.SafetyPolicy, error) {
+	return a.concierge.ListSafetyPolicies(ctx, restaurantID)
+}
+
+// SaveSafetyPolicy validates policy and stores it as the restaurant's newest version.
+func (a *ConciergeApp) SaveSafetyPolicy(ctx context.Context, restaurantID string, policy domain.SafetyPolicy) (domain.SafetyPolicy, error) {
+	return a.concierge.SaveSafetyPolicy(ctx, restaurantID, policy)
+}
+
+// DryRunSafetyPolicy reports the live-menu dishes whose verdict would change if
+// input.Policy replaced the policy in force. Nothing is saved.
+func (a *ConciergeApp) DryRunSafetyPolicy(ctx context.Context, restaurantID string, input PolicyDryRunInput) (domain.PolicyDryRun, error) {
+	profiles := make([]domain.PolicyProfile, 0, len(input.Profiles))
+	for _, profile := range input.Profiles {
+		allergies, err := allergyProfile(profile.Allergies, nil)
+		if err != nil {
+			return domain.PolicyDryRun{}, err
+		}
+		profiles = append(profiles, domain.PolicyProfile{Allergies: allergies, PreferenceTags: profile.PreferenceTags})
+	}
+	return a.concierge.DryRunSafetyPolicy(ctx, restaurantID, input.Policy, profiles)
+}